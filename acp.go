@@ -0,0 +1,822 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/llm"
+	"github.com/shibayu36/nebula/memory"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// acpProtocolVersion はサポートするAgent Client Protocolのバージョン
+const acpProtocolVersion = 1
+
+// JSON-RPCのエラーコード
+const (
+	acpErrMethodNotFound = -32601
+	acpErrInvalidParams  = -32602
+	acpErrInternal       = -32603
+)
+
+// acpMessage はJSON-RPC 2.0のメッセージ（リクエスト・通知・レスポンス）
+type acpMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *acpError       `json:"error,omitempty"`
+}
+
+// acpError はJSON-RPCのエラー
+type acpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *acpError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// acpConn は改行区切りのJSON-RPCでクライアント（エディタ）とやり取りする
+type acpConn struct {
+	out     io.Writer
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan acpMessage // エージェントから送ったリクエストの応答待ち
+}
+
+// send はメッセージを1行のJSONとして書き込む
+func (c *acpConn) send(message map[string]any) error {
+	message["jsonrpc"] = "2.0"
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.out.Write(append(data, '\n'))
+	return err
+}
+
+// notify はクライアントに通知を送る
+func (c *acpConn) notify(method string, params any) error {
+	return c.send(map[string]any{"method": method, "params": params})
+}
+
+// respond はクライアントからのリクエストに応答する
+func (c *acpConn) respond(id json.RawMessage, result any, err error) error {
+	if err != nil {
+		var rpcErr *acpError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &acpError{Code: acpErrInternal, Message: err.Error()}
+		}
+		return c.send(map[string]any{"id": id, "error": rpcErr})
+	}
+	return c.send(map[string]any{"id": id, "result": result})
+}
+
+// call はクライアントにリクエストを送り、応答をresultにデコードする
+func (c *acpConn) call(ctx context.Context, method string, params, result any) error {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	ch := make(chan acpMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(map[string]any{"id": id, "method": method, "params": params}); err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deliver はクライアントからの応答を待っているcallに渡す
+func (c *acpConn) deliver(message acpMessage) {
+	var id int
+	if err := json.Unmarshal(message.ID, &id); err != nil {
+		return
+	}
+	c.mu.Lock()
+	ch, ok := c.pending[id]
+	c.mu.Unlock()
+	if ok {
+		ch <- message
+	}
+}
+
+// acpServer はACPのエージェント側の実装。memory.Managerが扱える現在のセッションは1つなので、同時に扱うセッションも1つにする
+type acpServer struct {
+	conn            *acpConn
+	cfg             *config.Config
+	client          llm.Client
+	manager         *memory.Manager
+	availableTools  map[string]tools.ToolDefinition
+	languageServers *languageServers // 全セッションで共有する。利用できない場合はnil
+
+	mu      sync.Mutex
+	session *acpSession
+
+	// switching はsession/newの処理を1件ずつにする。マネージャーやカレントディレクトリはプロセスで1つなので
+	switching sync.Mutex
+}
+
+// acpSession はエディタから使われている1つのセッション
+type acpSession struct {
+	id          string
+	agent       *agent
+	mode        agentMode
+	basePrompt  string
+	diagnostics *lspDiagnostics
+
+	mu         sync.Mutex
+	cancel     context.CancelFunc // 実行中のプロンプトを中断する。実行中でなければnil
+	ctx        context.Context    // 実行中のプロンプトのコンテキスト
+	done       chan struct{}      // 実行中のプロンプトが終わると閉じられる
+	closed     bool               // 新しいセッションに切り替えられ、プロンプトを受け付けない
+	toolCallID string             // 実行中のツール呼び出しのID。承認のリクエストに使う
+	started    map[string]bool    // クライアントに通知済みのツール呼び出し
+}
+
+// runACP はAgent Client Protocolを標準入出力で話し、エディタのバックエンドのエージェントとして動作する
+func runACP(args []string) error {
+	fs := flag.NewFlagSet("acp", flag.ExitOnError)
+	fs.Parse(args)
+
+	// 標準出力はプロトコル専用にし、エージェントの表示は標準エラー出力に回す
+	// 標準入力もプロトコルが使うので、端末での確認は応答なし（拒否）として扱われるようにする
+	in, out := os.Stdin, os.Stdout
+	os.Stdout = os.Stderr
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+	os.Stdin = devNull
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	client, err := newLLMClient(cfg)
+	if err != nil {
+		return err
+	}
+	configureTools(cfg)
+
+	manager, err := openManager()
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+	manager.SetIncognito(cfg.Incognito)
+
+	s := &acpServer{
+		conn:            &acpConn{out: out, pending: map[int]chan acpMessage{}},
+		cfg:             cfg,
+		client:          client,
+		manager:         manager,
+		availableTools:  enabledTools(cfg),
+		languageServers: newLanguageServers(cfg),
+	}
+
+	// 書き込みや実行の承認はエディタに求める
+	tools.SetApprover(s.requestApproval)
+	// 標準入力はプロトコルが使うので、読み込み後に変更されたファイルの衝突もクライアントに確認する
+	tools.SetConflictResolver(tools.ApprovalConflictResolver)
+
+	// ファイル変更をスナップショットとして記録する
+	tools.OnFileChange(func(change tools.FileChange) {
+		path, err := filepath.Abs(change.Path)
+		if err != nil {
+			path = change.Path
+		}
+		if err := manager.SaveFileSnapshot(path, change.OldContent, change.NewContent); err != nil {
+			fmt.Printf("Warning: failed to save file snapshot: %v\n", err)
+		}
+		if session := s.currentSession(); session != nil && session.diagnostics != nil {
+			session.diagnostics.Record(change)
+		}
+	})
+	if s.languageServers != nil {
+		defer s.languageServers.Close()
+		tools.SetCodeNavigator(s.languageServers)
+	}
+
+	return s.serve(in)
+}
+
+// serve はクライアントからのメッセージを読み、入力が閉じられるまで処理する
+func (s *acpServer) serve(in io.Reader) error {
+	reader := bufio.NewReader(in)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var message acpMessage
+			if jsonErr := json.Unmarshal(line, &message); jsonErr != nil {
+				fmt.Printf("Warning: ignoring invalid ACP message: %v\n", jsonErr)
+			} else if message.Method == "" {
+				s.conn.deliver(message)
+			} else if message.ID == nil {
+				s.handleNotification(message)
+			} else {
+				// プロンプトの処理中も承認の応答や中断の通知を受け取れるよう、リクエストは並行して処理する
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					result, err := s.handleRequest(message)
+					if err := s.conn.respond(message.ID, result, err); err != nil {
+						fmt.Printf("Warning: failed to send ACP response: %v\n", err)
+					}
+				}()
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			// 入力が閉じられたら実行中のプロンプトを中断して終了する
+			if session := s.currentSession(); session != nil {
+				session.cancelPrompt()
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read ACP message: %w", err)
+		}
+	}
+}
+
+// handleNotification はクライアントからの通知を処理する
+func (s *acpServer) handleNotification(message acpMessage) {
+	switch message.Method {
+	case "session/cancel":
+		var params struct {
+			SessionID string `json:"sessionId"`
+		}
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return
+		}
+		if session, err := s.lookupSession(params.SessionID); err == nil {
+			session.cancelPrompt()
+		}
+	}
+}
+
+// handleRequest はクライアントからのリクエストを処理し、結果を返す
+func (s *acpServer) handleRequest(message acpMessage) (any, error) {
+	switch message.Method {
+	case "initialize":
+		return map[string]any{
+			"protocolVersion": acpProtocolVersion,
+			"agentCapabilities": map[string]any{
+				"loadSession": true,
+				"promptCapabilities": map[string]any{
+					"image":           false,
+					"audio":           false,
+					"embeddedContext": true,
+				},
+			},
+			"authMethods": []any{},
+		}, nil
+	case "authenticate":
+		// APIキーは環境変数で渡すので、認証の手順はない
+		return map[string]any{}, nil
+	case "session/new":
+		var params struct {
+			Cwd string `json:"cwd"`
+		}
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, &acpError{Code: acpErrInvalidParams, Message: err.Error()}
+		}
+		return s.newSession(params.Cwd, "")
+	case "session/load":
+		var params struct {
+			SessionID string `json:"sessionId"`
+			Cwd       string `json:"cwd"`
+		}
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, &acpError{Code: acpErrInvalidParams, Message: err.Error()}
+		}
+		return s.newSession(params.Cwd, params.SessionID)
+	case "session/set_mode":
+		var params struct {
+			SessionID string `json:"sessionId"`
+			ModeID    string `json:"modeId"`
+		}
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, &acpError{Code: acpErrInvalidParams, Message: err.Error()}
+		}
+		return s.setMode(params.SessionID, params.ModeID)
+	case "session/prompt":
+		var params struct {
+			SessionID string            `json:"sessionId"`
+			Prompt    []acpContentBlock `json:"prompt"`
+		}
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, &acpError{Code: acpErrInvalidParams, Message: err.Error()}
+		}
+		return s.prompt(params.SessionID, params.Prompt)
+	default:
+		return nil, &acpError{Code: acpErrMethodNotFound, Message: "method not found: " + message.Method}
+	}
+}
+
+// newSession はcwdをプロジェクトとしてセッションを開始する。sessionIDが指定された場合は既存のセッションを復元し、履歴をクライアントに送る
+func (s *acpServer) newSession(cwd, sessionID string) (any, error) {
+	s.switching.Lock()
+	defer s.switching.Unlock()
+	// 前のセッションのプロンプトがマネージャーやカレントディレクトリを使い終わるまで待ってから切り替える
+	if previous := s.currentSession(); previous != nil {
+		previous.close()
+	}
+
+	if cwd != "" {
+		// ツールは相対パスをカレントディレクトリから解決するので、プロジェクトに移動する
+		if err := os.Chdir(cwd); err != nil {
+			return nil, fmt.Errorf("failed to change directory: %w", err)
+		}
+	}
+
+	modeName := s.cfg.DefaultMode
+	if modeName == "" {
+		modeName = defaultModeName
+	}
+	mode, err := lookupMode(modeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var session *memory.Session
+	var history []openai.ChatCompletionMessage
+	if sessionID != "" {
+		session, err = s.manager.RestoreSession(sessionID, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore session: %w", err)
+		}
+		history, err = loadSessionHistory(s.manager, session)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		model := s.cfg.Model
+		if model == "" {
+			model = defaultModel
+		}
+		projectPath, err := currentProject()
+		if err != nil {
+			return nil, err
+		}
+		session, err = s.manager.StartSession(projectPath, model)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start session: %w", err)
+		}
+	}
+
+	scratchDir, err := newScratchDir(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	tools.SetScratchDir(scratchDir)
+	basePrompt := getSystemPrompt() + scratchPromptExtension(scratchDir)
+
+	modeTools, _ := mode.filterTools(s.availableTools)
+	messages := append([]openai.ChatCompletionMessage{{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: mode.systemPrompt(basePrompt),
+	}}, history...)
+
+	acpSess := &acpSession{
+		id:          session.ID,
+		mode:        mode,
+		basePrompt:  basePrompt,
+		diagnostics: newLSPDiagnostics(s.cfg, s.languageServers),
+		started:     map[string]bool{},
+	}
+	acpSess.agent = &agent{
+		client:      s.client,
+		model:       session.ModelUsed,
+		manager:     s.manager,
+		cfg:         s.cfg,
+		tools:       modeTools,
+		messages:    messages,
+		diagnostics: acpSess.diagnostics,
+		observer:    &acpObserver{server: s, session: acpSess},
+		confirm:     func(prompt string) bool { return s.confirm(acpSess, prompt) },
+	}
+
+	s.mu.Lock()
+	s.session = acpSess
+	s.mu.Unlock()
+
+	// 復元したセッションの会話をエディタに表示する
+	for _, msg := range history {
+		var update string
+		switch msg.Role {
+		case openai.ChatMessageRoleUser:
+			update = "user_message_chunk"
+		case openai.ChatMessageRoleAssistant:
+			update = "agent_message_chunk"
+		}
+		if update == "" || msg.Content == "" {
+			continue
+		}
+		s.sendUpdate(acpSess, map[string]any{
+			"sessionUpdate": update,
+			"content":       map[string]any{"type": "text", "text": msg.Content},
+		})
+	}
+
+	fmt.Printf("ACP session: %s (model: %s)\n", session.ID, session.ModelUsed)
+	result := map[string]any{"modes": acpModes(mode)}
+	if sessionID == "" {
+		result["sessionId"] = session.ID
+	}
+	return result, nil
+}
+
+// acpModes はnebulaのエージェントのモードをACPのセッションモードとして返す
+func acpModes(current agentMode) map[string]any {
+	var available []map[string]any
+	for _, name := range modeNames() {
+		mode := agentModes[name]
+		available = append(available, map[string]any{
+			"id":          mode.Name,
+			"name":        mode.Name,
+			"description": mode.Description,
+		})
+	}
+	return map[string]any{"currentModeId": current.Name, "availableModes": available}
+}
+
+// setMode はセッションのモードを切り替える
+func (s *acpServer) setMode(sessionID, modeID string) (any, error) {
+	session, err := s.lookupSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := lookupMode(modeID)
+	if err != nil {
+		return nil, &acpError{Code: acpErrInvalidParams, Message: err.Error()}
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.cancel != nil {
+		return nil, fmt.Errorf("cannot switch modes while a prompt is running")
+	}
+	session.mode = mode
+	session.agent.tools, _ = mode.filterTools(s.availableTools)
+	session.agent.messages[0].Content = mode.systemPrompt(session.basePrompt)
+	return map[string]any{}, nil
+}
+
+// acpContentBlock はプロンプトに含まれる内容
+type acpContentBlock struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	URI      string `json:"uri,omitempty"`
+	Resource *struct {
+		URI  string `json:"uri"`
+		Text string `json:"text,omitempty"`
+	} `json:"resource,omitempty"`
+}
+
+// acpPromptText はプロンプトの内容をモデルへの入力のテキストにまとめる
+// 添付されたファイルは内容を埋め込み、リンクだけのファイルはパスを伝えてツールで読ませる
+func acpPromptText(blocks []acpContentBlock) string {
+	var parts []string
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			parts = append(parts, block.Text)
+		case "resource_link":
+			parts = append(parts, "Referenced file: "+acpURIPath(block.URI))
+		case "resource":
+			if block.Resource != nil && block.Resource.Text != "" {
+				parts = append(parts, fmt.Sprintf("Contents of %s:\n```\n%s\n```", acpURIPath(block.Resource.URI), block.Resource.Text))
+			}
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// acpURIPath はfile://のURIをファイルパスに変換する。それ以外のURIはそのまま返す
+func acpURIPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Path
+}
+
+// prompt はユーザーの入力を処理し、ターンが終わった理由を返す
+func (s *acpServer) prompt(sessionID string, blocks []acpContentBlock) (any, error) {
+	session, err := s.lookupSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	input := strings.TrimSpace(acpPromptText(blocks))
+	if input == "" {
+		return nil, &acpError{Code: acpErrInvalidParams, Message: "prompt is empty"}
+	}
+
+	session.mu.Lock()
+	if session.closed {
+		session.mu.Unlock()
+		return nil, &acpError{Code: acpErrInvalidParams, Message: "session was replaced by a new session: " + sessionID}
+	}
+	if session.cancel != nil {
+		session.mu.Unlock()
+		return nil, fmt.Errorf("another prompt is already running in this session")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	session.ctx, session.cancel, session.done = ctx, cancel, done
+	session.mu.Unlock()
+
+	defer func() {
+		session.mu.Lock()
+		session.ctx, session.cancel, session.done = nil, nil, nil
+		session.mu.Unlock()
+		cancel()
+		close(done)
+	}()
+
+	session.agent.ctx = ctx
+	err = session.agent.handleUserInput(input)
+	switch {
+	case ctx.Err() != nil:
+		return map[string]any{"stopReason": "cancelled"}, nil
+	case errors.Is(err, errMaxToolCallSteps):
+		return map[string]any{"stopReason": "max_turn_requests"}, nil
+	case err != nil:
+		return nil, err
+	case session.agent.truncated:
+		return map[string]any{"stopReason": "max_tokens"}, nil
+	default:
+		return map[string]any{"stopReason": "end_turn"}, nil
+	}
+}
+
+// currentSession は現在のセッションを返す。セッションがなければnilを返す
+func (s *acpServer) currentSession() *acpSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.session
+}
+
+// lookupSession はIDに対応するセッションを返す
+func (s *acpServer) lookupSession(sessionID string) (*acpSession, error) {
+	session := s.currentSession()
+	if session == nil || session.id != sessionID {
+		return nil, &acpError{Code: acpErrInvalidParams, Message: "unknown session: " + sessionID}
+	}
+	return session, nil
+}
+
+// cancelPrompt は実行中のプロンプトを中断する
+func (session *acpSession) cancelPrompt() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.cancel != nil {
+		session.cancel()
+	}
+}
+
+// close は実行中のプロンプトを中断して終わるのを待ち、以降のプロンプトを受け付けないようにする
+// 中断したプロンプトの会話の保存などが次のセッションに紛れ込まないよう、セッションを切り替える前に呼ぶ
+func (session *acpSession) close() {
+	session.mu.Lock()
+	session.closed = true
+	cancel, done := session.cancel, session.done
+	session.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// promptContext は実行中のプロンプトのコンテキストを返す
+func (session *acpSession) promptContext() context.Context {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.ctx == nil {
+		return context.Background()
+	}
+	return session.ctx
+}
+
+// sendUpdate はセッションの状況をクライアントに通知する
+func (s *acpServer) sendUpdate(session *acpSession, update map[string]any) {
+	if err := s.conn.notify("session/update", map[string]any{"sessionId": session.id, "update": update}); err != nil {
+		fmt.Printf("Warning: failed to send ACP session update: %v\n", err)
+	}
+}
+
+// requestApproval はツールの書き込みや実行の承認をクライアントに求める
+func (s *acpServer) requestApproval(request tools.ApprovalRequest) (bool, error) {
+	session := s.currentSession()
+	if session == nil {
+		return false, fmt.Errorf("ユーザー応答の読み取りに失敗しました")
+	}
+
+	detail := request.Detail
+	for _, warning := range request.Warnings {
+		detail += "\n\n警告: " + warning
+	}
+	toolCall := map[string]any{
+		"toolCallId": session.toolCallID,
+		"title":      request.Title,
+		"kind":       string(request.Kind),
+		"status":     "pending",
+		"content":    []any{acpTextContent("```\n" + detail + "\n```")},
+	}
+	if locations := acpLocations(request.Paths); len(locations) > 0 {
+		toolCall["locations"] = locations
+	}
+	return s.requestPermission(session, toolCall)
+}
+
+// confirm はターンの予算超過などで続行してよいかをクライアントに確認する
+func (s *acpServer) confirm(session *acpSession, prompt string) bool {
+	approved, err := s.requestPermission(session, map[string]any{
+		"toolCallId": fmt.Sprintf("confirm-%s", session.toolCallID),
+		"title":      prompt,
+		"kind":       "other",
+		"status":     "pending",
+	})
+	return err == nil && approved
+}
+
+// requestPermission はsession/request_permissionで許可を求め、許可された場合にtrueを返す
+func (s *acpServer) requestPermission(session *acpSession, toolCall map[string]any) (bool, error) {
+	params := map[string]any{
+		"sessionId": session.id,
+		"toolCall":  toolCall,
+		"options": []map[string]any{
+			{"optionId": "allow", "name": "Allow", "kind": "allow_once"},
+			{"optionId": "reject", "name": "Reject", "kind": "reject_once"},
+		},
+	}
+	var result struct {
+		Outcome struct {
+			Outcome  string `json:"outcome"`
+			OptionID string `json:"optionId"`
+		} `json:"outcome"`
+	}
+	if err := s.conn.call(session.promptContext(), "session/request_permission", params, &result); err != nil {
+		return false, fmt.Errorf("ユーザー応答の読み取りに失敗しました: %v", err)
+	}
+	return result.Outcome.Outcome == "selected" && result.Outcome.OptionID == "allow", nil
+}
+
+// acpObserver はエージェントの応答とツールの実行状況をsession/updateとしてクライアントに送る
+type acpObserver struct {
+	server  *acpServer
+	session *acpSession
+}
+
+func (o *acpObserver) AssistantText(delta string) {
+	o.server.sendUpdate(o.session, map[string]any{
+		"sessionUpdate": "agent_message_chunk",
+		"content":       map[string]any{"type": "text", "text": delta},
+	})
+}
+
+func (o *acpObserver) ToolCallStarted(call openai.ToolCall, tool tools.ToolDefinition) {
+	o.session.mu.Lock()
+	o.session.toolCallID = call.ID
+	o.session.started[call.ID] = true
+	o.session.mu.Unlock()
+
+	o.server.sendUpdate(o.session, acpToolCall(call, tool, "in_progress"))
+}
+
+func (o *acpObserver) ToolCallFinished(call openai.ToolCall, result string) {
+	o.session.mu.Lock()
+	started := o.session.started[call.ID]
+	delete(o.session.started, call.ID)
+	o.session.mu.Unlock()
+
+	status := "completed"
+	var parsed struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal([]byte(result), &parsed) == nil && parsed.Error != "" {
+		status = "failed"
+	}
+
+	// 実行されなかった呼び出し（重複や使えないツールなど）は開始を通知していないので、ここで通知する
+	if !started {
+		update := acpToolCall(call, o.server.availableTools[call.Function.Name], status)
+		update["content"] = []any{acpTextContent(result)}
+		o.server.sendUpdate(o.session, update)
+		return
+	}
+	o.server.sendUpdate(o.session, map[string]any{
+		"sessionUpdate": "tool_call_update",
+		"toolCallId":    call.ID,
+		"status":        status,
+		"content":       []any{acpTextContent(result)},
+	})
+}
+
+// acpToolKinds はツールの種類をACPのtool kindに対応付ける。ない場合はReadOnlyかどうかで決める
+var acpToolKinds = map[string]string{
+	"searchInDirectory": "search",
+	"glob":              "search",
+	"list":              "search",
+	"webFetch":          "fetch",
+	"webSearch":         "fetch",
+	"deleteFile":        "delete",
+	"runCommand":        "execute",
+	"runSnippet":        "execute",
+	"gitCommit":         "execute",
+	"createPullRequest": "execute",
+}
+
+// acpToolCall はツール呼び出しの開始をACPのtool_callとして表す
+func acpToolCall(call openai.ToolCall, tool tools.ToolDefinition, status string) map[string]any {
+	kind, ok := acpToolKinds[call.Function.Name]
+	if !ok {
+		kind = "edit"
+		if tool.ReadOnly {
+			kind = "read"
+		}
+	}
+
+	var rawInput map[string]any
+	json.Unmarshal([]byte(call.Function.Arguments), &rawInput)
+
+	title := call.Function.Name
+	var paths []string
+	if path, ok := rawInput["path"].(string); ok && path != "" {
+		title += ": " + path
+		paths = append(paths, path)
+	} else if command, ok := rawInput["command"].(string); ok && command != "" {
+		title += ": " + command
+	}
+
+	update := map[string]any{
+		"sessionUpdate": "tool_call",
+		"toolCallId":    call.ID,
+		"title":         title,
+		"kind":          kind,
+		"status":        status,
+		"rawInput":      rawInput,
+	}
+	if locations := acpLocations(paths); len(locations) > 0 {
+		update["locations"] = locations
+	}
+	return update
+}
+
+// acpLocations はエディタでファイルを開けるよう、パスを絶対パスのlocationにする
+func acpLocations(paths []string) []map[string]any {
+	var locations []map[string]any
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		locations = append(locations, map[string]any{"path": path})
+	}
+	return locations
+}
+
+// acpTextContent はテキストをtool callのcontentとして表す
+func acpTextContent(text string) map[string]any {
+	return map[string]any{
+		"type":    "content",
+		"content": map[string]any{"type": "text", "text": text},
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/llm"
+	"github.com/shibayu36/nebula/memory"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// defaultMaxToolCallSteps は1ターンでツール呼び出しを繰り返すステップ数の上限のデフォルト
+const defaultMaxToolCallSteps = 5
+
+// errMaxToolCallSteps はツール呼び出しのステップ数が上限に達し、ユーザーが続行しなかったためにターンを打ち切ったことを表す
+var errMaxToolCallSteps = errors.New("maximum tool call steps exceeded")
+
+// defaultModel は--modelが指定されなかった場合に新規セッションで使うモデル
+const defaultModel = openai.GPT5Nano
+
+// agent は対話セッション中のエージェントの状態を保持する
+type agent struct {
+	client      llm.Client
+	model       string
+	manager     *memory.Manager
+	cfg         *config.Config
+	tools       map[string]tools.ToolDefinition // 現在のモードで利用できるツール
+	messages    []openai.ChatCompletionMessage
+	truncated   bool             // 直前の応答が最大トークン数で打ち切られたかどうか
+	snapshots   *turnSnapshotter // 書き込み前の作業ツリーの記録。無効な場合はnil
+	diagnostics *lspDiagnostics  // 編集後の言語サーバーによる診断。設定がない場合はnil
+	costLimit   float64          // セッションの推定コストの現在の上限。続行を選ぶたびに延長する
+	// contextTokens は直前のリクエストと応答のトークン数。閾値を超えると次のターンの前に会話を要約する
+	contextTokens int
+
+	// 以下はエディタ連携（ACP）などで端末以外から使うための設定。nilの場合は端末での動作になる
+	ctx      context.Context   // LLMへのリクエストを中断するためのコンテキスト
+	observer agentObserver     // 応答とツールの実行状況の通知先
+	confirm  func(string) bool // 予算超過時などの続行の確認
+}
+
+// agentObserver は応答のテキストやツールの実行状況を受け取る
+type agentObserver interface {
+	// AssistantText はストリーミングで受信した応答のテキストの差分を受け取る
+	AssistantText(delta string)
+	// ToolCallStarted はツールを実行する直前に呼び出される
+	ToolCallStarted(call openai.ToolCall, tool tools.ToolDefinition)
+	// ToolCallFinished はツールの実行結果を受け取る
+	ToolCallFinished(call openai.ToolCall, result string)
+}
+
+// context はLLMへのリクエストに使うコンテキストを返す
+func (a *agent) context() context.Context {
+	if a.ctx != nil {
+		return a.ctx
+	}
+	return context.Background()
+}
+
+// confirmContinue はユーザーに続行してよいかを確認する
+func (a *agent) confirmContinue(prompt string) bool {
+	if a.confirm != nil {
+		return a.confirm(prompt)
+	}
+	return confirm(prompt)
+}
+
+// handleUserInput はユーザー入力1件を処理し、ツールコールがなくなるまで繰り返し実行する
+// このターンのメッセージはまとめて1つのトランザクションで永続化する。エラーや中断で終わった場合もそれまでの分を保存する
+func (a *agent) handleUserInput(userInput string) (err error) {
+	// コンテキストの上限に近づいていれば、新しい入力を加える前に古い会話を要約する
+	if err := a.compactIfNeeded(); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	a.truncated = false
+
+	var pending []memory.Message
+	defer func() {
+		if saveErr := a.manager.SaveMessages(pending); saveErr != nil && err == nil {
+			err = fmt.Errorf("failed to save messages: %w", saveErr)
+		}
+	}()
+
+	// ユーザーメッセージを履歴に追加
+	userMsg := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: userInput,
+	}
+	a.messages = append(a.messages, userMsg)
+
+	pending = append(pending, memory.Message{Role: "user", Content: userInput, Timestamp: time.Now()})
+
+	// 同一ターン内の重複したツール呼び出しを検出するためのキャッシュ
+	cache := newToolCallCache()
+	// ステップをまたいだツール呼び出しのループを検出する
+	detector := newLoopDetector()
+
+	// このターンでモデルに送るツール
+	turnTools := selectTurnTools(a.cfg.ToolSelection, a.tools, userInput)
+
+	// ステップ数・時間・コストの予算
+	budget := newTurnBudget(a.cfg)
+
+	// このターンで書き込み前のスナップショットを取ったかどうか
+	snapshotTaken := false
+
+	// ツールコールがなくなるまでループ
+	for {
+		// セッション全体の予算を超えていれば、APIを呼び出す前に続行するかを確認する
+		if err := a.checkSessionCost(); err != nil {
+			return err
+		}
+
+		// OpenAI APIにストリーミングで送信し、応答を逐次表示する
+		resp, err := a.streamCompletion(
+			openai.ChatCompletionRequest{
+				Model:    a.model,
+				Messages: elideStaleToolResults(a.messages, toolResultRetentionTurns(a.cfg.ToolResultRetentionTurns)),
+				Tools:    turnTools.schemas,
+			},
+			"Assistant: ",
+		)
+		if err != nil {
+			return err
+		}
+
+		a.contextTokens = resp.Usage.PromptTokens + resp.Usage.CompletionTokens
+		cost := estimateCost(a.model, resp.Usage)
+		budget.AddStep(cost)
+		if err := a.manager.AddCost(cost); err != nil {
+			return fmt.Errorf("failed to record cost: %w", err)
+		}
+
+		responseMessage := resp.Message
+		a.messages = append(a.messages, responseMessage)
+
+		// アシスタントメッセージを永続化の対象に加える
+		assistantRecord := memory.Message{
+			Role:             "assistant",
+			Content:          responseMessage.Content,
+			Timestamp:        time.Now(),
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+		}
+		if len(responseMessage.ToolCalls) > 0 {
+			toolCallsBytes, err := json.Marshal(responseMessage.ToolCalls)
+			if err == nil {
+				toolCallsJSON := string(toolCallsBytes)
+				assistantRecord.ToolCalls = &toolCallsJSON
+			}
+		}
+		pending = append(pending, assistantRecord)
+
+		// ツールコールがない場合は最終応答として終了（内容はストリーミングで表示済み）
+		if len(responseMessage.ToolCalls) == 0 {
+			fmt.Println()
+			a.warnIfTruncated(resp.FinishReason)
+			return nil
+		}
+
+		// ツールコールがある場合の処理
+		fmt.Println("Assistant is using tools...")
+		detector.RecordStep(responseMessage.ToolCalls)
+
+		// 出力上限で打ち切られた場合、ツール引数のJSONも途中で切れている可能性が高い
+		outputTruncated := resp.FinishReason == openai.FinishReasonLength
+
+		for _, toolCall := range responseMessage.ToolCalls {
+			var result string
+			if outputTruncated || !validToolArguments(toolCall.Function.Arguments) {
+				// 不完全な引数では実行せず、モデルに引数の出し直しを求める
+				fmt.Printf("Tool '%s' was not executed because its arguments are incomplete JSON. Asking the assistant to re-emit them.\n", toolCall.Function.Name)
+				result = incompleteArgumentsResult
+			} else if toolCall.Function.Name == requestToolsName {
+				// 選択から外したツールを要求された場合は、以降のステップで使えるようにする
+				result = turnTools.Request(toolCall.Function.Arguments)
+			} else if a.context().Err() != nil {
+				// ターンが中断された後は、残りのツール呼び出しを実行せずに応答だけを返す
+				result = interruptedToolResult
+			} else if cached, ok := cache.Get(toolCall.Function); ok {
+				// 同じ呼び出しが既にあれば実行せずに前回の結果を返し、読み込みの無限ループを防ぐ
+				result = duplicateToolResult(cached)
+			} else if tool, exists := a.tools[toolCall.Function.Name]; exists {
+				if a.observer != nil {
+					a.observer.ToolCallStarted(toolCall, tool)
+				}
+
+				// ターン中の最初の書き込みの前に作業ツリーを記録する
+				if !tool.ReadOnly && a.snapshots != nil && !snapshotTaken {
+					if err := a.snapshots.Take(userInput); err != nil {
+						fmt.Printf("Warning: %v\n", err)
+					}
+					snapshotTaken = true
+				}
+
+				// ツール関数を実行
+				var err error
+				result, err = callTool(a.context(), tool, toolCall.Function.Arguments)
+				if err != nil {
+					result = toolErrorResult(fmt.Sprintf("Tool execution failed: %v", err))
+				}
+				// 大きなディレクトリの一覧などでコンテキストを使い切らないよう、大きすぎる結果は切り詰める
+				result = limitToolResult(result, toolResultMaxTokens(a.cfg.ToolResultMaxTokens))
+
+				if tool.ReadOnly {
+					cache.Put(toolCall.Function, tool, result)
+				} else {
+					cache.Invalidate()
+
+					// 編集したファイルのエラーをツール結果に添えて、ビルドせずに型エラーなどを直せるようにする
+					if a.diagnostics != nil {
+						if report := a.diagnostics.Report(); report != "" {
+							result += "\n\n" + report
+						}
+					}
+				}
+			} else {
+				// 存在しないツールや現在のモードで使えないツールにも応答を返さないと、次のAPI呼び出しが失敗する
+				result = toolErrorResult(fmt.Sprintf("Tool '%s' is not available in the current mode", toolCall.Function.Name))
+			}
+
+			detector.RecordResult(toolCall.Function, result)
+			if a.observer != nil {
+				a.observer.ToolCallFinished(toolCall, result)
+			}
+
+			// ツール実行結果をメッセージ履歴に追加
+			toolMsg := openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    result,
+				ToolCallID: toolCall.ID,
+			}
+			a.messages = append(a.messages, toolMsg)
+
+			// ツール実行結果を永続化の対象に加える
+			toolResult := result
+			pending = append(pending, memory.Message{Role: "tool", Content: result, ToolResults: &toolResult, Timestamp: time.Now()})
+
+			fmt.Printf("Tool '%s' executed with result: %s\n", toolCall.Function.Name, result)
+		}
+
+		// ループに陥っていれば一度だけモデルに是正を促し、それでも続く場合は中断する
+		if reason := detector.Detect(); reason != "" {
+			if detector.warned {
+				return fmt.Errorf("aborted because the agent is stuck in a loop: %s\n%s", reason, detector.Summary())
+			}
+			fmt.Printf("Loop detected: %s. Asking the assistant to change its approach.\n", reason)
+			a.messages = append(a.messages, detector.Warn(reason))
+		}
+
+		// 時間やコストの上限を超えていれば続行するかをユーザーに確認する
+		if reason := budget.Exceeded(); reason != "" {
+			if !a.confirmContinue(fmt.Sprintf("Turn budget exceeded: %s. Continue? (y/N): ", reason)) {
+				return fmt.Errorf("turn stopped: %s", reason)
+			}
+			budget.Extend()
+		}
+
+		// ステップ数の上限に達した場合も、長い作業を途中で打ち切らないよう続行するかを確認する
+		if !budget.StepsRemaining() {
+			if !a.confirmContinue(fmt.Sprintf("Used %d tool call steps (limit: %d). Continue? (y/N): ", budget.steps, budget.stepLimit)) {
+				return fmt.Errorf("%w (limit: %d)", errMaxToolCallSteps, budget.stepLimit)
+			}
+			budget.ExtendSteps()
+		}
+
+		// ループを継続して、ツール実行結果を元に再度APIを呼び出す
+	}
+}
+
+// warnIfTruncated は応答が最大トークン数で打ち切られた場合にユーザーへ警告する
+func (a *agent) warnIfTruncated(finishReason openai.FinishReason) {
+	a.truncated = finishReason == openai.FinishReasonLength
+	if a.truncated {
+		fmt.Println("Warning: the response was cut off because it reached the maximum output length. Type /continue to get the rest.")
+	}
+}
+
+// continueResponse は打ち切られた応答の続きを要求し、直前のアシスタントメッセージに連結する
+func (a *agent) continueResponse() error {
+	if !a.truncated {
+		return fmt.Errorf("the last response was not truncated")
+	}
+
+	// 続きを促す指示は履歴に残さず、このリクエストにだけ付け加える
+	request := append(elideStaleToolResults(a.messages, toolResultRetentionTurns(a.cfg.ToolResultRetentionTurns)), openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: "Your previous response was cut off. Continue exactly where you left off, without repeating anything you already wrote.",
+	})
+	resp, err := a.streamCompletion(
+		openai.ChatCompletionRequest{
+			Model:    a.model,
+			Messages: request,
+		},
+		"Assistant (continued): ",
+	)
+	if err != nil {
+		return err
+	}
+
+	continuation := resp.Message.Content
+	a.messages[len(a.messages)-1].Content += continuation
+	if err := a.manager.AppendToLastAssistantMessage(continuation); err != nil {
+		return fmt.Errorf("failed to save assistant message: %w", err)
+	}
+	if err := a.manager.AddLastAssistantMessageUsage(resp.Usage.PromptTokens, resp.Usage.CompletionTokens); err != nil {
+		return fmt.Errorf("failed to record token usage: %w", err)
+	}
+	if err := a.manager.AddCost(estimateCost(a.model, resp.Usage)); err != nil {
+		return fmt.Errorf("failed to record cost: %w", err)
+	}
+
+	fmt.Println()
+	a.warnIfTruncated(resp.FinishReason)
+	return nil
+}
+
+// incompleteArgumentsResult は引数が不完全なツール呼び出しに返す結果
+const incompleteArgumentsResult = `{"error": "The arguments of this tool call were truncated or are not valid JSON, so the tool was NOT executed. Re-emit the same tool call with complete, valid JSON arguments. If the arguments are very large (for example full file contents), split the work into smaller changes."}`
+
+// interruptedToolResult はユーザーがターンを中断したために実行しなかったツール呼び出しに返す結果
+const interruptedToolResult = `{"error": "The user interrupted this turn, so the tool was NOT executed. Wait for the user's next instruction before retrying it."}`
+
+// toolErrorResult はモデルに返すエラーのツール結果を作る
+// エラーメッセージに引用符や改行が含まれても壊れないよう、JSONはエンコーダーで組み立てる
+func toolErrorResult(message string) string {
+	resultJSON, _ := json.Marshal(map[string]string{"error": message})
+	return string(resultJSON)
+}
+
+// callTool はツールを実行する。ツールの不具合でpanicしてもサーバーやREPLごと落ちないよう、エラーとしてモデルに返す
+func callTool(ctx context.Context, tool tools.ToolDefinition, arguments string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Warning: tool panicked: %v\n%s", r, debug.Stack())
+			result, err = "", fmt.Errorf("ツールの実行中に内部エラーが発生しました: %v", r)
+		}
+	}()
+	return tool.Call(ctx, arguments)
+}
+
+// validToolArguments はツール引数が完全なJSONかどうかを返す。引数なしの呼び出しは有効とみなす
+func validToolArguments(arguments string) bool {
+	trimmed := strings.TrimSpace(arguments)
+	return trimmed == "" || json.Valid([]byte(trimmed))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/shibayu36/nebula/tools"
+)
+
+const (
+	autoGitStage  = "stage"
+	autoGitCommit = "commit"
+)
+
+// autoGit は承認済みのファイル変更をgitに自動で記録する
+type autoGit struct {
+	mode   string
+	branch string
+}
+
+// newAutoGit はモードを検証してautoGitを作成する。modeが空の場合はnilを返す
+func newAutoGit(mode, sessionID string) (*autoGit, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case autoGitStage, autoGitCommit:
+		return &autoGit{mode: mode, branch: "nebula/" + sessionID}, nil
+	default:
+		return nil, fmt.Errorf("unknown auto-git mode: %s (expected %q or %q)", mode, autoGitStage, autoGitCommit)
+	}
+}
+
+// Record はファイル変更をモードに応じてステージまたはコミットする
+func (a *autoGit) Record(change tools.FileChange) {
+	var err error
+	switch a.mode {
+	case autoGitStage:
+		_, err = runGit(nil, "add", "--", change.Path)
+	case autoGitCommit:
+		err = a.commit(change)
+	}
+	if err != nil {
+		fmt.Printf("Warning: auto-git %s failed for %s: %v\n", a.mode, change.Path, err)
+	}
+}
+
+// commit は作業ツリーやユーザーのインデックスに触れずに、専用ブランチへ変更をコミットする
+func (a *autoGit) commit(change tools.FileChange) error {
+	ref := "refs/heads/" + a.branch
+
+	// 専用ブランチがまだなければ現在のHEADから分岐する
+	parent, err := runGit(nil, "rev-parse", "--verify", "-q", ref)
+	if err != nil {
+		parent, err = runGit(nil, "rev-parse", "--verify", "-q", "HEAD")
+		if err != nil {
+			parent = ""
+		}
+	}
+
+	// 一時インデックスを使うことで、ユーザーのステージング状態を変更しない
+	indexDir, err := os.MkdirTemp("", "nebula-index-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary index: %w", err)
+	}
+	defer os.RemoveAll(indexDir)
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(indexDir, "index")}
+
+	if parent != "" {
+		if _, err := runGit(env, "read-tree", parent); err != nil {
+			return err
+		}
+	}
+	if _, err := runGit(env, "add", "--", change.Path); err != nil {
+		return err
+	}
+	tree, err := runGit(env, "write-tree")
+	if err != nil {
+		return err
+	}
+
+	action := "Update"
+	switch {
+	case change.OldContent == nil:
+		action = "Create"
+	case change.NewContent == nil:
+		action = "Delete"
+	}
+	args := []string{"commit-tree", tree, "-m", fmt.Sprintf("nebula: %s %s", action, change.Path)}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	commit, err := runGit(env, args...)
+	if err != nil {
+		return err
+	}
+
+	if _, err := runGit(nil, "update-ref", ref, commit); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runGit はgitコマンドを実行し、前後の空白を除いた標準出力を返す
+func runGit(env []string, args ...string) (string, error) {
+	output, err := runGitRaw(env, args...)
+	return strings.TrimSpace(output), err
+}
+
+// runGitRaw はgitコマンドを実行し、標準出力をそのまま返す
+// パッチのように末尾の空白や空行に意味がある出力に使う
+func runGitRaw(env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
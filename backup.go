@@ -0,0 +1,448 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/memory"
+)
+
+const (
+	// backupDataPrefix はアーカイブの中でデータディレクトリ（データベースやスクラッチディレクトリ）を置く場所
+	backupDataPrefix = "data"
+	// backupConfigPrefix はアーカイブの中で設定ディレクトリ（設定ファイルやプラグイン）を置く場所
+	backupConfigPrefix = "config"
+	// backupDatabaseName はアーカイブの中のデータベースのファイル名
+	backupDatabaseName = "memory.db"
+)
+
+// runBackup はデータディレクトリと設定ディレクトリをまとめたアーカイブを作成・復元する
+func runBackup(args []string) error {
+	usage := errors.New("usage: nebula backup create [--encrypt] <archive> | nebula backup restore [--force] <archive>")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "create":
+		return runBackupCreate(args[1:])
+	case "restore":
+		return runBackupRestore(args[1:])
+	default:
+		return usage
+	}
+}
+
+// backupDirs はバックアップの対象となるデータディレクトリ、データベース、設定ディレクトリのパスを返す
+func backupDirs() (dataDir, dbPath, configDir string, err error) {
+	dbPath, err = databasePath()
+	if err != nil {
+		return "", "", "", err
+	}
+	if memory.IsInMemory(dbPath) {
+		return "", "", "", errors.New("an in-memory database cannot be backed up or restored")
+	}
+	configPath, err := config.Path()
+	if err != nil {
+		return "", "", "", err
+	}
+	return filepath.Dir(dbPath), dbPath, filepath.Dir(configPath), nil
+}
+
+// runBackupCreate はデータベースのスナップショットと、データディレクトリ・設定ディレクトリのファイルをtar.gzにまとめる
+func runBackupCreate(args []string) error {
+	fs := flag.NewFlagSet("backup create", flag.ExitOnError)
+	encrypt := fs.Bool("encrypt", false, "Encrypt the archive with a passphrase from "+backupPassphraseEnv+" or --passphrase-file")
+	passphraseFile := fs.String("passphrase-file", "", "File containing the passphrase for --encrypt")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: nebula backup create [--encrypt] <archive>")
+	}
+	archive, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var passphrase string
+	if *encrypt {
+		if passphrase, err = backupPassphrase(*passphraseFile); err != nil {
+			return err
+		}
+	}
+	dataDir, dbPath, configDir, err := backupDirs()
+	if err != nil {
+		return err
+	}
+
+	// 使用中でも一貫した状態を保存できるよう、データベースはファイルをコピーせずにスナップショットを取る
+	tmpDir, err := os.MkdirTemp("", "nebula-backup")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	snapshot := filepath.Join(tmpDir, backupDatabaseName)
+	manager, err := openManager()
+	if err != nil {
+		return err
+	}
+	err = manager.SnapshotDatabase(snapshot)
+	manager.Close()
+	if err != nil {
+		return err
+	}
+
+	// 途中で失敗しても既存のアーカイブを壊さないよう、一時ファイルに書いてから置き換える
+	tmpArchive := archive + ".tmp"
+	file, err := os.OpenFile(tmpArchive, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(tmpArchive)
+	w := &backupWriter{skip: map[string]bool{archive: true, tmpArchive: true}}
+	if err := w.write(file, passphrase, func() error {
+		if err := w.addFile(snapshot, backupDataPrefix+"/"+backupDatabaseName); err != nil {
+			return err
+		}
+		// データベースのファイル名が異なる場合でも、データディレクトリのmemory.dbがスナップショットと同じ名前で重複しないようにする
+		for _, db := range []string{dbPath, filepath.Join(dataDir, backupDatabaseName)} {
+			for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+				w.skip[db+suffix] = true
+			}
+		}
+		if err := w.addDir(dataDir, backupDataPrefix); err != nil {
+			return err
+		}
+		// Windowsでは設定ファイルがデータディレクトリにあるので、二重に保存しない
+		if configDir == dataDir {
+			return nil
+		}
+		return w.addDir(configDir, backupConfigPrefix)
+	}); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Rename(tmpArchive, archive); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	fmt.Printf("Backed up %d files (%s bytes) from %s and %s to %s\n", w.files, formatCount(int(w.bytes)), dataDir, configDir, archive)
+	if passphrase == "" {
+		fmt.Println("The archive is not encrypted and contains your conversation history and settings; pass --encrypt to protect it with a passphrase.")
+	}
+	for _, skipped := range w.skipped {
+		fmt.Printf("Skipped %s (only regular files and directories are backed up)\n", skipped)
+	}
+	return nil
+}
+
+// backupWriter はファイルをアーカイブに追加する
+type backupWriter struct {
+	tw      *tar.Writer
+	skip    map[string]bool
+	skipped []string
+	files   int
+	bytes   int64
+}
+
+// write はdst にgzip（passphraseがあれば暗号化も）したtarを書き、addで中身を追加する
+func (w *backupWriter) write(dst io.Writer, passphrase string, add func() error) error {
+	var enc *encryptWriter
+	if passphrase != "" {
+		var err error
+		if enc, err = newEncryptWriter(dst, passphrase); err != nil {
+			return err
+		}
+		dst = enc
+	}
+	gz := gzip.NewWriter(dst)
+	w.tw = tar.NewWriter(gz)
+
+	if err := add(); err != nil {
+		return err
+	}
+	if err := w.tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+	return nil
+}
+
+// addDir はdir以下のディレクトリと通常のファイルをprefix以下に追加する。ディレクトリが存在しなければ何もしない
+func (w *backupWriter) addDir(dir, prefix string) error {
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+		if w.skip[p] {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := prefix + "/" + filepath.ToSlash(rel)
+		// 設定ディレクトリがデータディレクトリの中にある場合などに同じファイルを二重に保存しない
+		w.skip[p] = true
+
+		switch {
+		case entry.IsDir():
+			info, err := entry.Info()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", p, err)
+			}
+			return w.writeHeader(info, name+"/")
+		case entry.Type().IsRegular():
+			return w.addFile(p, name)
+		default:
+			w.skipped = append(w.skipped, p)
+			return nil
+		}
+	})
+}
+
+// addFile は通常のファイルをnameとして追加する
+func (w *backupWriter) addFile(p, name string) error {
+	file, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", p, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", p, err)
+	}
+	if err := w.writeHeader(info, name); err != nil {
+		return err
+	}
+	n, err := io.Copy(w.tw, file)
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", p, err)
+	}
+	w.files++
+	w.bytes += n
+	return nil
+}
+
+func (w *backupWriter) writeHeader(info fs.FileInfo, name string) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	// 別のマシンに移すためのものなので、所有者は記録しない
+	header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+	if err := w.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// runBackupRestore はアーカイブのデータベース・データディレクトリ・設定ディレクトリを現在の環境の場所に復元する
+func runBackupRestore(args []string) error {
+	fs := flag.NewFlagSet("backup restore", flag.ExitOnError)
+	force := fs.Bool("force", false, "Overwrite the existing database and config")
+	passphraseFile := fs.String("passphrase-file", "", "File containing the passphrase of an encrypted archive")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: nebula backup restore [--force] <archive>")
+	}
+
+	dataDir, dbPath, configDir, err := backupDirs()
+	if err != nil {
+		return err
+	}
+	if !*force {
+		configPath, err := config.Path()
+		if err != nil {
+			return err
+		}
+		for _, existing := range []string{dbPath, configPath} {
+			if _, err := os.Stat(existing); err == nil {
+				return fmt.Errorf("%s already exists; pass --force to overwrite it with the backup", existing)
+			}
+		}
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+	var src io.Reader = bufio.NewReader(file)
+	header, _ := src.(*bufio.Reader).Peek(len(backupMagic))
+	encrypted := isEncryptedBackup(header)
+	if encrypted {
+		passphrase, err := backupPassphrase(*passphraseFile)
+		if err != nil {
+			return err
+		}
+		if src, err = newDecryptReader(src, passphrase); err != nil {
+			return err
+		}
+	}
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		// 暗号化したアーカイブでは復号のエラーをそのまま伝える
+		if encrypted {
+			return err
+		}
+		return fmt.Errorf("not a nebula backup: %w", err)
+	}
+
+	// アーカイブを最後まで読めた場合だけ置き換えるよう、まず各ファイルを復元先の隣の一時ファイルに書き出す
+	r := &backupRestorer{dataDir: dataDir, dbPath: dbPath, configDir: configDir}
+	defer r.cleanup()
+	if err := r.extract(tar.NewReader(gz)); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	if !r.hasDatabase {
+		return errors.New("the archive does not contain a nebula database")
+	}
+	if err := r.commit(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %d files to %s and %s\n", len(r.pending), dataDir, configDir)
+	return nil
+}
+
+// backupRestorer はアーカイブのファイルを一時ファイルに展開し、すべて読めてから置き換える
+type backupRestorer struct {
+	dataDir     string
+	dbPath      string
+	configDir   string
+	pending     []pendingFile
+	hasDatabase bool
+}
+
+// pendingFile は置き換えを待っている一時ファイル
+type pendingFile struct {
+	tmp, dest string
+}
+
+func (r *backupRestorer) extract(tr *tar.Reader) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		dest, err := r.destination(header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, header.FileInfo().Mode().Perm()|0o700); err != nil {
+				return fmt.Errorf("failed to create %s: %w", dest, err)
+			}
+		case tar.TypeReg:
+			if err := r.extractFile(tr, dest, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+			if dest == r.dbPath {
+				r.hasDatabase = true
+			}
+		default:
+			return fmt.Errorf("unsupported entry in archive: %s", header.Name)
+		}
+	}
+}
+
+// destination はアーカイブの中の名前を復元先のパスにする。ディレクトリの外を指す名前は拒否する
+func (r *backupRestorer) destination(name string) (string, error) {
+	prefix, rel, _ := strings.Cut(strings.TrimSuffix(name, "/"), "/")
+	if rel == "" || !filepath.IsLocal(filepath.FromSlash(rel)) || path.Clean(rel) != rel {
+		return "", fmt.Errorf("unsafe path in archive: %s", name)
+	}
+	switch prefix {
+	case backupDataPrefix:
+		// データベースは現在の環境のデータベースの場所（NEBULA_DB_PATHなど）に復元する
+		if rel == backupDatabaseName {
+			return r.dbPath, nil
+		}
+		return filepath.Join(r.dataDir, filepath.FromSlash(rel)), nil
+	case backupConfigPrefix:
+		return filepath.Join(r.configDir, filepath.FromSlash(rel)), nil
+	default:
+		return "", fmt.Errorf("unexpected path in archive: %s", name)
+	}
+}
+
+func (r *backupRestorer) extractFile(src io.Reader, dest string, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(dest), err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", dest, err)
+	}
+	r.pending = append(r.pending, pendingFile{tmp: tmp.Name(), dest: dest})
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to restore %s: %w", dest, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to restore %s: %w", dest, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", dest, err)
+	}
+	return nil
+}
+
+// commit は一時ファイルを復元先に置き換える
+func (r *backupRestorer) commit() error {
+	// 古いデータベースのWALが新しいデータベースに適用されないよう消しておく
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(r.dbPath + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", r.dbPath+suffix, err)
+		}
+	}
+	for i, file := range r.pending {
+		if err := os.Rename(file.tmp, file.dest); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file.dest, err)
+		}
+		r.pending[i].tmp = ""
+	}
+	return nil
+}
+
+// cleanup は置き換えなかった一時ファイルを消す
+func (r *backupRestorer) cleanup() {
+	for _, file := range r.pending {
+		if file.tmp != "" {
+			os.Remove(file.tmp)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBackupCreateEntries はアーカイブにデータベースのスナップショットが1つだけ入ることを確認する
+func TestBackupCreateEntries(t *testing.T) {
+	tests := []struct {
+		name   string
+		dbName string
+	}{
+		{name: "デフォルトのファイル名", dbName: backupDatabaseName},
+		// NEBULA_DB_PATHで別の名前にした場合、データディレクトリに残ったmemory.dbをスナップショットと重複させない
+		{name: "別のファイル名", dbName: "nebula.sqlite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dataDir := filepath.Join(root, "data")
+			if err := os.MkdirAll(dataDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.dbName != backupDatabaseName {
+				if err := os.WriteFile(filepath.Join(dataDir, backupDatabaseName), []byte("stale"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dataDir, "notes.txt"), []byte("notes"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("NEBULA_DB_PATH", filepath.Join(dataDir, tt.dbName))
+			t.Setenv("NEBULA_CONFIG_PATH", filepath.Join(root, "config", "config.json"))
+
+			archive := filepath.Join(root, "backup.tar.gz")
+			if err := runBackupCreate([]string{archive}); err != nil {
+				t.Fatal(err)
+			}
+
+			counts := map[string]int{}
+			for _, name := range archiveEntryNames(t, archive) {
+				counts[name]++
+			}
+			want := map[string]int{
+				backupDataPrefix + "/" + backupDatabaseName: 1,
+				backupDataPrefix + "/notes.txt":             1,
+			}
+			for name, count := range want {
+				if counts[name] != count {
+					t.Errorf("entry %s appears %d times, want %d (entries: %v)", name, counts[name], count, counts)
+				}
+			}
+			// データベースのファイルやWALをそのままコピーしない
+			for name := range counts {
+				if _, ok := want[name]; !ok && !strings.HasSuffix(name, "/") {
+					t.Errorf("unexpected entry %s", name)
+				}
+			}
+		})
+	}
+}
+
+// archiveEntryNames は暗号化していないバックアップのエントリ名を返す
+func archiveEntryNames(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// backupMagic は暗号化したバックアップの先頭に置く目印
+	backupMagic = "NEBULAENC1\n"
+	// backupSaltSize はパスフレーズから鍵を導出するときのソルトのバイト数
+	backupSaltSize = 16
+	// backupKDFIterations はPBKDF2-SHA256の繰り返し回数
+	backupKDFIterations = 600000
+	// backupChunkSize は1つの暗号化チャンクに入れる平文の最大バイト数
+	backupChunkSize = 64 * 1024
+	// backupPassphraseEnv はバックアップのパスフレーズを渡す環境変数
+	backupPassphraseEnv = "NEBULA_BACKUP_PASSPHRASE"
+)
+
+// errBackupDecrypt はパスフレーズが違うか、アーカイブが壊れているときのエラー
+var errBackupDecrypt = errors.New("failed to decrypt the backup: wrong passphrase or corrupted archive")
+
+// backupPassphrase は--passphrase-fileで指定されたファイル、またはNEBULA_BACKUP_PASSPHRASEからパスフレーズを読む
+// 端末に入力を表示せずに読む手段がないため、対話的には尋ねない
+func backupPassphrase(file string) (string, error) {
+	passphrase := os.Getenv(backupPassphraseEnv)
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("a passphrase is required: set %s or pass --passphrase-file", backupPassphraseEnv)
+	}
+	return passphrase, nil
+}
+
+// newBackupAEAD はパスフレーズとソルトからAES-256-GCMを作る
+func newBackupAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, backupKDFIterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupNonce はチャンクの通し番号からnonceを作る。同じ鍵で同じnonceを使わないよう、鍵はアーカイブごとのソルトから導出する
+func backupNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+// encryptWriter は書き込まれたデータをチャンクごとに暗号化してwに書く
+// 各チャンクは「最後のチャンクか(1バイト)・暗号文の長さ(4バイト)・暗号文」で、最後かどうかを認証対象に含めるため、
+// チャンクの入れ替えや末尾の切り捨ても復号時に検出できる
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+// newEncryptWriter はヘッダー（目印とソルト）を書き、暗号化して書き込むWriterを返す。最後に必ずCloseを呼ぶ
+func newEncryptWriter(w io.Writer, passphrase string) (*encryptWriter, error) {
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := newBackupAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, backupMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	// 最後のチャンクはCloseで書くので、チャンクの大きさを超えた分だけ書き出す
+	for len(e.buf) > backupChunkSize {
+		if err := e.writeChunk(e.buf[:backupChunkSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[backupChunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close は残りのデータを最後のチャンクとして書く。下のWriterは閉じない
+func (e *encryptWriter) Close() error {
+	return e.writeChunk(e.buf, true)
+}
+
+func (e *encryptWriter) writeChunk(plain []byte, final bool) error {
+	flag := []byte{0}
+	if final {
+		flag[0] = 1
+	}
+	sealed := e.aead.Seal(nil, backupNonce(e.aead, e.counter), plain, flag)
+	e.counter++
+
+	header := make([]byte, 5)
+	header[0] = flag[0]
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+	if _, err := e.w.Write(header); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// decryptReader はencryptWriterで暗号化したデータを復号して読むReader
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+// isEncryptedBackup はアーカイブの先頭が暗号化したバックアップの目印かを返す
+func isEncryptedBackup(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(backupMagic))
+}
+
+// newDecryptReader はヘッダーを読み、復号して読むReaderを返す
+func newDecryptReader(r io.Reader, passphrase string) (*decryptReader, error) {
+	header := make([]byte, len(backupMagic)+backupSaltSize)
+	if _, err := io.ReadFull(r, header); err != nil || !isEncryptedBackup(header) {
+		return nil, errors.New("not an encrypted nebula backup")
+	}
+	aead, err := newBackupAEAD(passphrase, header[len(backupMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) readChunk() error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		// 最後のチャンクより前で終わっている
+		return errors.New("the backup is truncated")
+	}
+	if header[0] > 1 {
+		return errBackupDecrypt
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > backupChunkSize+uint32(d.aead.Overhead()) {
+		return errBackupDecrypt
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return errors.New("the backup is truncated")
+	}
+	plain, err := d.aead.Open(nil, backupNonce(d.aead, d.counter), sealed, header[:1])
+	if err != nil {
+		return errBackupDecrypt
+	}
+	d.counter++
+	d.buf = plain
+	if header[0] == 1 {
+		d.done = true
+		if n, _ := d.r.Read(make([]byte, 1)); n > 0 {
+			return errors.New("unexpected data after the end of the backup")
+		}
+	}
+	return nil
+}
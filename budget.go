@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/config"
+)
+
+// modelPrice は100万トークンあたりの料金（USD）
+type modelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// modelPrices はコスト見積もりに使うモデルごとの料金表
+var modelPrices = map[string]modelPrice{
+	openai.GPT5:         {Input: 1.25, Output: 10.00},
+	openai.GPT5Mini:     {Input: 0.25, Output: 2.00},
+	openai.GPT5Nano:     {Input: 0.05, Output: 0.40},
+	openai.GPT4Dot1:     {Input: 2.00, Output: 8.00},
+	openai.GPT4Dot1Mini: {Input: 0.40, Output: 1.60},
+	openai.GPT4Dot1Nano: {Input: 0.10, Output: 0.40},
+	openai.GPT4o:        {Input: 2.50, Output: 10.00},
+	openai.GPT4oMini:    {Input: 0.15, Output: 0.60},
+	openai.O3:           {Input: 2.00, Output: 8.00},
+	openai.O4Mini:       {Input: 1.10, Output: 4.40},
+}
+
+// estimateCost はトークン使用量から料金（USD）を見積もる。料金表にないモデルは0を返す
+func estimateCost(model string, usage openai.Usage) float64 {
+	price, ok := modelPrices[model]
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1_000_000
+}
+
+// turnBudget は1ターンで消費できるステップ数・経過時間・コストを管理する
+type turnBudget struct {
+	maxSteps    int           // 0以下の場合は無制限
+	maxDuration time.Duration // 0の場合は無制限
+	maxCost     float64       // 0の場合は無制限
+
+	steps        int
+	stepLimit    int // 延長した分を含むステップ数の上限
+	startedAt    time.Time
+	cost         float64
+	costBaseline float64 // 延長時点までに消費したコスト
+}
+
+func newTurnBudget(cfg *config.Config) *turnBudget {
+	return &turnBudget{
+		maxSteps:    maxToolCallSteps(cfg.MaxToolCallSteps),
+		maxDuration: time.Duration(cfg.TurnTimeLimitSeconds) * time.Second,
+		maxCost:     cfg.TurnCostLimit,
+		startedAt:   time.Now(),
+		stepLimit:   maxToolCallSteps(cfg.MaxToolCallSteps),
+	}
+}
+
+// maxToolCallSteps は設定から1ターンのステップ数の上限を返す。0以下の場合は無制限
+func maxToolCallSteps(configured int) int {
+	switch {
+	case configured == 0:
+		return defaultMaxToolCallSteps
+	case configured < 0:
+		return 0
+	default:
+		return configured
+	}
+}
+
+// StepsRemaining はまだステップを実行できるかどうかを返す
+func (b *turnBudget) StepsRemaining() bool {
+	return b.maxSteps <= 0 || b.steps < b.stepLimit
+}
+
+// AddStep は1ステップ分の消費を記録する
+func (b *turnBudget) AddStep(cost float64) {
+	b.steps++
+	b.cost += cost
+}
+
+// Exceeded は時間またはコストの上限を超えている場合にその説明を返す
+func (b *turnBudget) Exceeded() string {
+	if b.maxDuration > 0 {
+		if elapsed := time.Since(b.startedAt); elapsed > b.maxDuration {
+			return fmt.Sprintf("elapsed %s exceeds the limit of %s", elapsed.Round(time.Second), b.maxDuration)
+		}
+	}
+	if b.maxCost > 0 && b.cost-b.costBaseline > b.maxCost {
+		return fmt.Sprintf("estimated cost $%.4f exceeds the limit of $%.4f", b.cost-b.costBaseline, b.maxCost)
+	}
+	return ""
+}
+
+// ExtendSteps はステップ数の上限でユーザーが続行を選んだときに、同じステップ数だけ上限を延長する
+func (b *turnBudget) ExtendSteps() {
+	b.stepLimit = b.steps + b.maxSteps
+}
+
+// Extend はユーザーが続行を選んだときに、時間とコストの上限を同じ幅だけ延長する
+func (b *turnBudget) Extend() {
+	b.startedAt = time.Now()
+	b.costBaseline = b.cost
+}
+
+// errSessionBudgetExceeded はセッションの推定コストが上限を超え、ユーザーが続行しなかったことを表す
+var errSessionBudgetExceeded = errors.New("session budget exceeded")
+
+// checkSessionCost はセッションの推定コストが設定の上限を超えていれば続行するかを確認する
+// ツールのループが暴走しても予想外の請求にならないよう、続行した場合も同じ額だけ使ったところで再び確認する
+func (a *agent) checkSessionCost() error {
+	limit := a.cfg.SessionCostLimit
+	session := a.manager.GetCurrentSession()
+	if limit <= 0 || session == nil {
+		return nil
+	}
+	if a.costLimit == 0 {
+		a.costLimit = limit
+	}
+	if session.EstimatedCost <= a.costLimit {
+		return nil
+	}
+
+	reason := fmt.Sprintf("estimated session cost $%.4f exceeds the limit of $%.4f", session.EstimatedCost, a.costLimit)
+	if !a.confirmContinue(fmt.Sprintf("Session budget exceeded: %s. Continue? (y/N): ", reason)) {
+		return fmt.Errorf("%w: %s", errSessionBudgetExceeded, reason)
+	}
+	a.costLimit = session.EstimatedCost + limit
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// changelogMaxCommits はリリースノートの生成に使うコミットの最大数
+const changelogMaxCommits = 500
+
+// changelogMaxBodyBytes はコミットごとにモデルに渡す本文の最大バイト数
+const changelogMaxBodyBytes = 1000
+
+// changelogPrompt はリリースノートを生成するときのシステムプロンプト
+const changelogPrompt = `You write release notes for a software project from its git history.
+Group the changes under these "###" headings, in this order, omitting empty groups: Breaking Changes, Features, Fixes, Performance, Documentation, Internal.
+- Write one bullet per user-visible change, in the imperative mood, and merge commits that describe the same change
+- Keep pull request references such as (#123) when a commit mentions one
+- Put refactoring, CI, dependency bumps and test-only changes under Internal, and leave out reverted changes and merge noise
+Reply with the grouped markdown only: no version heading, no introduction and no closing remarks.`
+
+// runChangelog はコミットの範囲からリリースノートを生成し、承認を得てCHANGELOG.mdに追記する
+func runChangelog(args []string) error {
+	fs := flag.NewFlagSet("changelog", flag.ExitOnError)
+	revRange := fs.String("range", "", "Commit range to summarize (e.g. v1.2.0..HEAD)")
+	version := fs.String("version", "", "Version heading for the new section (default: the end of the range, or \"Unreleased\" for HEAD)")
+	output := fs.String("o", "CHANGELOG.md", "Changelog file to update")
+	modelFlag := fs.String("model", "", "Model to use (default: config model or "+defaultModel+")")
+	fs.Parse(args)
+
+	if *revRange == "" || strings.HasPrefix(*revRange, "-") {
+		return errors.New("--range is required (e.g. --range v1.2.0..HEAD)")
+	}
+	heading := *version
+	if heading == "" {
+		heading = "Unreleased"
+		if _, end, ok := strings.Cut(*revRange, ".."); ok && end != "" && end != "HEAD" {
+			heading = strings.TrimPrefix(end, ".")
+		}
+	}
+
+	commits, err := changelogCommits(*revRange)
+	if err != nil {
+		return err
+	}
+	if commits == "" {
+		return fmt.Errorf("no commits found in %s", *revRange)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	client, err := newLLMClient(cfg)
+	if err != nil {
+		return err
+	}
+	model := resolveModel(*modelFlag, cfg)
+
+	ag := &agent{client: client, model: model, cfg: cfg}
+	resp, err := ag.streamCompletion(openai.ChatCompletionRequest{
+		Model: model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: changelogPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf("Write the release notes for %s from these commits:\n\n%s", heading, commits)},
+		},
+	}, "Release notes:\n")
+	if err != nil {
+		return err
+	}
+	notes := strings.TrimSpace(resp.Message.Content)
+	if notes == "" {
+		return errors.New("the model returned empty release notes")
+	}
+
+	section := fmt.Sprintf("## %s - %s\n\n%s\n", heading, time.Now().Format("2006-01-02"), notes)
+	return writeChangelog(*output, section)
+}
+
+// changelogCommits は範囲内のコミットのハッシュ・件名・本文をモデルに渡す形式で返す
+// マージコミットの本文にはPull Requestのタイトルが入るので、マージコミットも含める
+func changelogCommits(revRange string) (string, error) {
+	out, err := runGit(nil, "log", fmt.Sprintf("--max-count=%d", changelogMaxCommits), "--format=%h%x1f%s%x1f%b%x1e", revRange, "--")
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\x1f", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		fmt.Fprintf(&b, "- %s %s\n", fields[0], fields[1])
+		if len(fields) == 3 {
+			body := strings.TrimSpace(fields[2])
+			if len(body) > changelogMaxBodyBytes {
+				body = strings.ToValidUTF8(body[:changelogMaxBodyBytes], "") + "..."
+			}
+			if body != "" {
+				fmt.Fprintf(&b, "  %s\n", strings.ReplaceAll(body, "\n", "\n  "))
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// writeChangelog は新しいセクションを変更履歴の先頭（最初の"## "見出しの前）に挿入する
+// 書き込みはwriteFile/editFileツールと同じく差分を表示してユーザーの承認を得てから行う
+func writeChangelog(path, section string) error {
+	var toolArgs any
+	write := tools.EditFile
+	content, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		write = tools.WriteFile
+		toolArgs = tools.WriteFileArgs{Path: path, Content: "# Changelog\n\n" + section}
+	case err != nil:
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	default:
+		newContent := insertChangelogSection(string(content), section)
+		toolArgs = tools.EditFileArgs{Path: path, NewContent: &newContent}
+	}
+
+	argsJSON, err := json.Marshal(toolArgs)
+	if err != nil {
+		return err
+	}
+	resultJSON, err := write(context.Background(), string(argsJSON))
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(resultJSON), &result); err != nil {
+		return fmt.Errorf("failed to parse the result of writing %s: %w", path, err)
+	}
+	if !result.Success {
+		return fmt.Errorf("failed to update %s: %s", path, result.Error)
+	}
+	fmt.Printf("Updated %s\n", path)
+	return nil
+}
+
+// insertChangelogSection は既存の変更履歴の最初のバージョンの見出しの前にsectionを挿入する
+// バージョンの見出しがない場合は末尾に追加する
+func insertChangelogSection(content, section string) string {
+	index := -1
+	if strings.HasPrefix(content, "## ") {
+		index = 0
+	} else if i := strings.Index(content, "\n## "); i >= 0 {
+		index = i + 1
+	}
+	if index < 0 {
+		content = strings.TrimRight(content, "\n")
+		if content == "" {
+			return section
+		}
+		return content + "\n\n" + section
+	}
+	return content[:index] + section + "\n" + content[index:]
+}
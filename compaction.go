@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/memory"
+)
+
+const (
+	// defaultCompactThresholdTokens は会話を要約に置き換えるコンテキストのトークン数のデフォルト
+	defaultCompactThresholdTokens = 100000
+	// compactToolResultTokens は要約に渡す会話の中で、ツール結果1件あたりに残すトークン数
+	compactToolResultTokens = 1000
+	// summaryRole は要約をメッセージとして保存するときのrole
+	summaryRole = "summary"
+)
+
+// compactionPrompt は会話の要約を作るときのシステムプロンプト
+const compactionPrompt = `You compact the conversation between a user and a coding agent so that the agent can continue the work with only your summary as context.
+Write a concise but complete summary in markdown with these sections:
+1. "## Goal": what the user asked for overall, including constraints and preferences they stated
+2. "## Progress": what has been done, which files were read or changed (with paths) and key findings, decisions and command results
+3. "## Current state": where the work stands right now, including anything that failed or is in progress
+4. "## Next steps": what remains to be done
+Keep exact file paths, identifiers, error messages and values that later work depends on. Do not invent anything that is not in the conversation.`
+
+// compactThresholdTokens は設定から要約を始めるトークン数を返す。0以下の場合は要約しない
+func compactThresholdTokens(configured int) int {
+	switch {
+	case configured == 0:
+		return defaultCompactThresholdTokens
+	case configured < 0:
+		return 0
+	default:
+		return configured
+	}
+}
+
+// summaryMessage は要約を会話の先頭に置くメッセージにする
+func summaryMessage(summary string) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: "The earlier conversation was compacted into this summary:\n\n" + summary,
+	}
+}
+
+// compactIfNeeded は直前のリクエストのトークン数が閾値に近づいていれば、次のターンの前に会話を要約に置き換える
+func (a *agent) compactIfNeeded() error {
+	threshold := compactThresholdTokens(a.cfg.CompactThresholdTokens)
+	if threshold <= 0 || a.contextTokens < threshold {
+		return nil
+	}
+	fmt.Printf("The conversation is using %d tokens of context; compacting it into a summary...\n", a.contextTokens)
+	return a.compact()
+}
+
+// compact はシステムプロンプト以外の会話を要約1件に置き換え、要約をセッションに保存する
+// 再開したときは最後の要約とそれ以降のメッセージだけを読み込む
+func (a *agent) compact() error {
+	if len(a.messages) <= 1 {
+		return errors.New("there is nothing to compact yet")
+	}
+
+	resp, err := a.client.CreateChatCompletion(a.context(), openai.ChatCompletionRequest{
+		Model: a.model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: compactionPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: compactionTranscript(a.messages[1:])},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to summarize the conversation: %w", err)
+	}
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		return errors.New("failed to summarize the conversation: the model returned an empty summary")
+	}
+	summary := strings.TrimSpace(resp.Choices[0].Message.Content)
+
+	if err := a.manager.AddCost(estimateCost(a.model, resp.Usage)); err != nil {
+		return fmt.Errorf("failed to record cost: %w", err)
+	}
+	err = a.manager.SaveMessages([]memory.Message{{
+		Role:             summaryRole,
+		Content:          summary,
+		Timestamp:        time.Now(),
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to save the summary: %w", err)
+	}
+
+	compacted := len(a.messages) - 1
+	a.messages = []openai.ChatCompletionMessage{a.messages[0], summaryMessage(summary)}
+	a.contextTokens = 0
+	a.truncated = false
+	fmt.Printf("Compacted %d messages into a summary.\n", compacted)
+	return nil
+}
+
+// compactionTranscript は要約するための会話をテキストにする。ツール結果は長くなりやすいので切り詰める
+func compactionTranscript(messages []openai.ChatCompletionMessage) string {
+	var b strings.Builder
+	b.WriteString("Summarize this conversation.\n")
+	for _, msg := range messages {
+		switch msg.Role {
+		case openai.ChatMessageRoleTool:
+			fmt.Fprintf(&b, "\n[tool result]\n%s\n", truncateTextResult(msg.Content, compactToolResultTokens))
+		case openai.ChatMessageRoleAssistant:
+			if strings.TrimSpace(msg.Content) != "" {
+				fmt.Fprintf(&b, "\n[assistant]\n%s\n", msg.Content)
+			}
+			for _, call := range msg.ToolCalls {
+				fmt.Fprintf(&b, "\n[assistant called %s]\n%s\n", call.Function.Name, truncateTextResult(call.Function.Arguments, compactToolResultTokens))
+			}
+		default:
+			fmt.Fprintf(&b, "\n[%s]\n%s\n", msg.Role, msg.Content)
+		}
+	}
+	return b.String()
+}
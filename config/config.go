@@ -0,0 +1,451 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+)
+
+// Config はnebulaの設定ファイル（JSON）の内容を表す構造体
+type Config struct {
+	// Provider はLLMのバックエンド（openai, ollama, openai-compatible, azure）。空の場合はopenai
+	Provider string `json:"provider,omitempty"`
+
+	// BaseURL はAPIのベースURL。ollamaの場合は省略するとhttp://localhost:11434/v1を使う
+	// azureの場合はリソースのエンドポイント（https://<resource>.openai.azure.com/）
+	BaseURL string `json:"base_url,omitempty"`
+
+	// AzureDeployment はazureで使うデプロイメント名。空の場合はモデル名をデプロイメント名として扱う
+	AzureDeployment string `json:"azure_deployment,omitempty"`
+
+	// AzureAPIVersion はazureのAPIバージョン。空の場合は2024-10-21
+	AzureAPIVersion string `json:"azure_api_version,omitempty"`
+
+	// ToolCalling はツール呼び出しの方式（auto, native, prompt）
+	// promptの場合はツールをプロンプトで説明し、応答中の ```tool_call ブロックを呼び出しとして扱う
+	// 空の場合はopenaiならnative、それ以外はネイティブ非対応時にpromptへ切り替えるauto
+	ToolCalling string `json:"tool_calling,omitempty"`
+
+	// MaxRetries はレート制限（429）やサーバーエラー（5xx）、ネットワークエラーでAPIリクエストをやり直す回数
+	// やり直すまでの待ち時間は指数的に伸ばし、Retry-Afterの指定があればそれに従う。0の場合は4回、負の値の場合はやり直さない
+	MaxRetries int `json:"max_retries,omitempty"`
+
+	// Model は新規セッションで使うデフォルトのモデル
+	Model string `json:"model,omitempty"`
+
+	// DefaultMode は起動時のエージェントモード（architect, coder, reviewer）
+	DefaultMode string `json:"default_mode,omitempty"`
+
+	// DisabledTools はモデルに提供しないツール名の一覧
+	DisabledTools []string `json:"disabled_tools,omitempty"`
+
+	// ToolSelection はリクエストごとに送るツールの選び方（"all" または "heuristic"）
+	// heuristicの場合、ユーザー入力から変更の意図が読み取れないターンでは書き込み系ツールを省く
+	ToolSelection string `json:"tool_selection,omitempty"`
+
+	// ToolResultRetentionTurns は直近何ターン分のツール結果をそのままモデルに送るか
+	// 0の場合はデフォルト値、負の値の場合は古いツール結果を省略しない
+	ToolResultRetentionTurns int `json:"tool_result_retention_turns,omitempty"`
+
+	// ToolResultMaxTokens は1つのツール結果としてモデルに渡すトークン数の上限（推定値）。超えた分は切り詰め、省略した件数を結果に含める
+	// 0の場合はデフォルト値（10000）、負の値の場合は上限なし
+	ToolResultMaxTokens int `json:"tool_result_max_tokens,omitempty"`
+
+	// CompactThresholdTokens は会話を要約に置き換えるコンテキストのトークン数。直前のリクエストがこれを超えると、
+	// 次のターンの前にシステムプロンプト以外の会話を要約1件に置き換えて保存する。0の場合は100000、負の値の場合は要約しない
+	CompactThresholdTokens int `json:"compact_threshold_tokens,omitempty"`
+
+	// ReadFileMaxBytes はreadFileが一度に返す内容の上限（バイト）。超えた分は切り詰め、続きの読み方を結果に含める
+	// 0の場合はデフォルト値（256KB）、負の値の場合は上限なし
+	ReadFileMaxBytes int `json:"read_file_max_bytes,omitempty"`
+
+	// EnvAllowlist はinspectEnvツールがマスクせずに値を返してよい環境変数名（PATHやHOMEなどはデフォルトで許可）
+	EnvAllowlist []string `json:"env_allowlist,omitempty"`
+
+	// WebSearch はwebSearchツールで使う検索エンジン。設定しない場合webSearchツールは無効になる
+	WebSearch *WebSearch `json:"web_search,omitempty"`
+
+	// SessionIdleTimeoutMinutes は最後のメッセージからこの時間（分）が経ったセッションを終了したものとして扱う。0の場合は24時間
+	// 異常終了したプロセスが残したセッションを、次回の起動時やnebula serveの実行中に終了させる
+	SessionIdleTimeoutMinutes int `json:"session_idle_timeout_minutes,omitempty"`
+
+	// WritableDirs はプロジェクトのルート（カレントディレクトリ）以外に、ツールでファイルを変更してよいディレクトリ
+	WritableDirs []string `json:"writable_dirs,omitempty"`
+
+	// AutoApprove はファイルの変更やコマンドの実行を確認せずに承認するかどうか（--yesと同じ）
+	AutoApprove bool `json:"auto_approve,omitempty"`
+
+	// ReadOnly は読み取り専用のツールだけを使うかどうか（--read-onlyと同じ）
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// Incognito は新しいセッションでメッセージの内容・ファイルのスナップショット・フィードバックのコメントを保存せず、
+	// トークン数とコストの記録だけを残すかどうか（--incognitoと同じ）
+	Incognito bool `json:"incognito,omitempty"`
+
+	// SnapshotTurns は書き込み系ツールを使うターンの前に作業ツリーを記録し、/restoreで戻せるようにするかどうか
+	SnapshotTurns bool `json:"snapshot_turns,omitempty"`
+
+	// LanguageServers は編集後の診断に使う言語サーバー
+	LanguageServers []LanguageServer `json:"language_servers,omitempty"`
+
+	// DiagnosticsTimeoutSeconds は編集後に言語サーバーの診断を待つ時間の上限（秒）。0の場合は5秒
+	DiagnosticsTimeoutSeconds int `json:"diagnostics_timeout_seconds,omitempty"`
+
+	// MaxToolCallSteps は1ターンでツール呼び出しを繰り返すステップ数の上限。達すると続行するかを確認する
+	// 0の場合はデフォルト値（5）、負の値の場合は無制限
+	MaxToolCallSteps int `json:"max_tool_call_steps,omitempty"`
+
+	// TurnTimeLimitSeconds は1ターンの経過時間の上限（秒）。超えると続行するかを確認する。0の場合は無制限
+	TurnTimeLimitSeconds int `json:"turn_time_limit_seconds,omitempty"`
+
+	// TurnCostLimit は1ターンの推定コストの上限（USD）。超えると続行するかを確認する。0の場合は無制限
+	TurnCostLimit float64 `json:"turn_cost_limit,omitempty"`
+
+	// SessionCostLimit は1セッションの推定コストの上限（USD）。超えるとAPIを呼び出す前に続行するかを確認し、
+	// 続行した場合は同じ額だけ上限を延長する。0の場合は無制限
+	SessionCostLimit float64 `json:"session_cost_limit,omitempty"`
+
+	// Plugins は外部コマンドをツールとして使うプラグイン。ToolsDir()に置いた実行ファイルも自動で読み込む
+	Plugins []Plugin `json:"plugins,omitempty"`
+
+	// Permissions は承認が必要なツールの操作を、尋ねずに許可・拒否するルール。上から順に評価し、最初にマッチしたルールを使う
+	// どのルールにもマッチしない操作は、これまでどおり実行前に確認する
+	Permissions []PermissionRule `json:"permissions,omitempty"`
+
+	// Server はnebula serveの設定
+	Server ServerConfig `json:"server,omitempty"`
+
+	// Webhooks はセッションの開始・終了、ターンの完了、書き込みや実行の承認をJSONでPOSTする先
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+}
+
+// Webhookのイベント名
+const (
+	WebhookSessionStart = "session.start" // セッションを開始・再開した
+	WebhookSessionEnd   = "session.end"   // セッションを終了した
+	WebhookTurnComplete = "turn.complete" // ユーザー入力1件への応答が終わった（中断・エラーを含む）
+	WebhookApproval     = "approval"      // 書き込みや実行を承認・拒否した
+)
+
+// Webhook はイベントを通知するURLと、通知するイベントの設定
+type Webhook struct {
+	// URL はイベントをPOSTするURL。SlackのIncoming Webhookにもそのまま使えるよう、ペイロードには要約のtextを含める
+	URL string `json:"url"`
+
+	// Events は通知するイベント（session.start, session.end, turn.complete, approval）。空の場合はすべて
+	Events []string `json:"events,omitempty"`
+
+	// SecretEnv はペイロードの署名に使う秘密鍵を読む環境変数名
+	// 設定するとHMAC-SHA256をX-Nebula-Signatureヘッダー（sha256=<16進数>）に付ける
+	SecretEnv string `json:"secret_env,omitempty"`
+
+	// IncludeContent がtrueの場合、turn.completeにユーザー入力と応答の本文を含める。incognitoのセッションでは含めない
+	IncludeContent bool `json:"include_content,omitempty"`
+
+	// TimeoutSeconds は1回の送信の時間の上限（秒）。0の場合は10秒
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+// Wants はイベントを通知する設定かを返す
+func (w Webhook) Wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// validate は通知先とイベント名が正しいかを確認する
+func (w Webhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL, got %q", w.URL)
+	}
+	for _, event := range w.Events {
+		switch event {
+		case WebhookSessionStart, WebhookSessionEnd, WebhookTurnComplete, WebhookApproval:
+		default:
+			return fmt.Errorf("unknown event %q (use %s, %s, %s or %s)", event, WebhookSessionStart, WebhookSessionEnd, WebhookTurnComplete, WebhookApproval)
+		}
+	}
+	return nil
+}
+
+// PermissionRule は承認が必要なツールの操作に対するルール
+type PermissionRule struct {
+	// Tool はルールを適用するツール名。空または"*"の場合はすべてのツール
+	Tool string `json:"tool,omitempty"`
+
+	// Kind は操作の種類（"edit"・"delete"・"execute"）。空の場合はすべての種類
+	Kind string `json:"kind,omitempty"`
+
+	// Paths は対象のパスのパターン（例: "src", "docs/**/*.md"）。相対パスはカレントディレクトリからのパスとして扱う
+	// ディレクトリを指定した場合はその下のすべてのファイルにマッチする。空の場合はすべての操作にマッチする
+	// 複数のファイルを変更する操作は、すべてのパスがマッチした場合だけルールを使う
+	Paths []string `json:"paths,omitempty"`
+
+	// Action は"allow"（確認せずに許可）・"deny"（拒否）・"ask"（確認する）のいずれか
+	Action string `json:"action"`
+}
+
+// validate はルールの値が正しいかを確認する
+func (r PermissionRule) validate() error {
+	switch r.Action {
+	case "allow", "deny", "ask":
+	default:
+		return fmt.Errorf("unknown action %q (use allow, deny or ask)", r.Action)
+	}
+	switch r.Kind {
+	case "", "edit", "delete", "execute":
+	default:
+		return fmt.Errorf("unknown kind %q (use edit, delete or execute)", r.Kind)
+	}
+	for _, pattern := range r.Paths {
+		if _, err := path.Match(filepath.ToSlash(pattern), ""); err != nil {
+			return fmt.Errorf("invalid path pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// ServerConfig はnebula serveの設定
+// エージェントは同時に1件ずつ実行し、その間に来たリクエストは待ち行列で待たせる
+// 待ち行列の長さと待つ時間の上限はnebula serveの--max-queueと--queue-timeoutで指定する
+type ServerConfig struct {
+	// Users はサーバーを利用できるユーザー。設定した場合はBearerトークンでの認証が必須になる
+	Users []ServerUser `json:"users,omitempty"`
+
+	// Roles はロール名ごとのツールの利用方針。ユーザーのroleで参照する
+	Roles map[string]ServerRole `json:"roles,omitempty"`
+
+	// Slack を設定すると、SlackのチャンネルやDMからサーバーのプロジェクトでエージェントを使えるようにする
+	Slack *SlackConfig `json:"slack,omitempty"`
+}
+
+// SlackConfig はnebula serveをSlackのボットとして使うための設定
+// SlackアプリのEvent SubscriptionsのURLに/slack/events、InteractivityのURLに/slack/interactionsを指定する
+type SlackConfig struct {
+	// BotTokenEnv はボットトークン（xoxb-）を読む環境変数名。空の場合はSLACK_BOT_TOKEN
+	BotTokenEnv string `json:"bot_token_env,omitempty"`
+
+	// SigningSecretEnv はSlackからのリクエストの署名を検証する秘密鍵を読む環境変数名。空の場合はSLACK_SIGNING_SECRET
+	SigningSecretEnv string `json:"signing_secret_env,omitempty"`
+
+	// Users はSlackのユーザーID（U...）とserver.usersのIDの対応。ロールと予算はserver.usersの設定を使う
+	// 対応のないユーザーのメッセージはエージェントに渡さない
+	Users map[string]string `json:"users,omitempty"`
+
+	// Channels はメンションに応答するチャンネルのID。空の場合はボットを招待したすべてのチャンネル。DMには常に応答する
+	Channels []string `json:"channels,omitempty"`
+
+	// ApprovalTimeoutSeconds はボタンでの承認を待つ時間の上限（秒）。過ぎた操作は実行しない。0の場合は10分
+	ApprovalTimeoutSeconds int `json:"approval_timeout_seconds,omitempty"`
+}
+
+// BotToken はボットトークンを環境変数から読む
+func (s *SlackConfig) BotToken() string {
+	name := s.BotTokenEnv
+	if name == "" {
+		name = "SLACK_BOT_TOKEN"
+	}
+	return os.Getenv(name)
+}
+
+// SigningSecret は署名の秘密鍵を環境変数から読む
+func (s *SlackConfig) SigningSecret() string {
+	name := s.SigningSecretEnv
+	if name == "" {
+		name = "SLACK_SIGNING_SECRET"
+	}
+	return os.Getenv(name)
+}
+
+// ServerRole はロールに許可するツールの利用方針
+type ServerRole struct {
+	// Permissions は読み取り専用のツールに加えて許可する操作（"edit"・"delete"・"execute"）
+	Permissions []string `json:"permissions,omitempty"`
+
+	// DisabledTools はこのロールに提供しないツール名の一覧
+	DisabledTools []string `json:"disabled_tools,omitempty"`
+}
+
+// ServerUser はサーバーを利用するユーザーとその認証情報・予算
+type ServerUser struct {
+	// ID はユーザーの識別子。セッションの所有者としてデータベースに記録する
+	ID string `json:"id"`
+
+	// TokenSHA256 はBearerトークンのSHA-256（16進数）。トークンそのものは設定ファイルに書かない
+	TokenSHA256 string `json:"token_sha256"`
+
+	// Role はServerConfig.Rolesのロール名。空の場合は読み取り専用のツールだけを使える
+	Role string `json:"role,omitempty"`
+
+	// MonthlyBudget は1か月（UTCの暦月）の推定コストの上限（USD）。0の場合は無制限
+	MonthlyBudget float64 `json:"monthly_budget,omitempty"`
+}
+
+// Plugin は外部コマンドをツールとして公開するための宣言
+// コマンドは引数のJSONを標準入力で受け取り、結果を標準出力に書く
+type Plugin struct {
+	// Name はツール名（英数字・_・-のみ）
+	Name string `json:"name"`
+
+	// Command は実行するコマンドと引数（例: ["python3", "/path/to/tool.py"]）
+	Command []string `json:"command"`
+
+	// Description はモデルに示すツールの説明
+	Description string `json:"description"`
+
+	// Parameters は引数のJSON Schema。省略した場合は任意のオブジェクトを受け取る
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+
+	// ReadOnly がtrueの場合、変更を加えないツールとして承認なしで実行する
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// TimeoutSeconds は実行時間の上限（秒）。0の場合は60秒
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+// LanguageServer は言語サーバーの起動方法と対象のファイル
+type LanguageServer struct {
+	// Command は言語サーバーを標準入出力モードで起動するコマンド（例: ["gopls"]）
+	Command []string `json:"command"`
+
+	// Extensions は対象のファイルの拡張子（例: [".go"]）
+	Extensions []string `json:"extensions"`
+
+	// LanguageID はLSPの言語ID。空の場合は拡張子から決める
+	LanguageID string `json:"language_id,omitempty"`
+}
+
+// WebSearch はwebSearchツールの検索エンジンの設定
+type WebSearch struct {
+	// Provider は検索エンジン（bing, brave, searxng）
+	Provider string `json:"provider"`
+
+	// BaseURL はAPIのベースURL。searxngでは必須（例: http://localhost:8888）、それ以外は省略すると公式のエンドポイントを使う
+	BaseURL string `json:"base_url,omitempty"`
+
+	// APIKeyEnv はAPIキーを読む環境変数名。空の場合はbingならBING_SEARCH_API_KEY、braveならBRAVE_SEARCH_API_KEY
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+}
+
+// APIKey は検索エンジンのAPIキーを環境変数から読む
+func (w *WebSearch) APIKey() string {
+	name := w.APIKeyEnv
+	if name == "" {
+		switch w.Provider {
+		case "bing":
+			name = "BING_SEARCH_API_KEY"
+		case "brave":
+			name = "BRAVE_SEARCH_API_KEY"
+		default:
+			return ""
+		}
+	}
+	return os.Getenv(name)
+}
+
+// Path は設定ファイルのパスを返す。NEBULA_CONFIG_PATHが設定されていればそれを優先する
+func Path() (string, error) {
+	if path := os.Getenv("NEBULA_CONFIG_PATH"); path != "" {
+		return path, nil
+	}
+
+	if runtime.GOOS == "windows" {
+		dir, err := DataDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "config.json"), nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".config", "nebula", "config.json"), nil
+}
+
+// DataDir はデータベースやスクラッチディレクトリを置くディレクトリを返す
+// Windowsでは%APPDATA%\nebula、それ以外では~/.local/share/nebulaを使う
+func DataDir() (string, error) {
+	if runtime.GOOS == "windows" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get application data directory: %w", err)
+		}
+		return filepath.Join(dir, "nebula"), nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".local", "share", "nebula"), nil
+}
+
+// ToolsDir はプラグインの実行ファイルを置くディレクトリ（設定ファイルと同じ場所のtools）を返す
+func ToolsDir() (string, error) {
+	path, err := Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "tools"), nil
+}
+
+// Load は設定ファイルを読み込む。ファイルが存在しない場合はデフォルトの設定を返す
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	// 拒否のルールを読み飛ばして実行してしまわないよう、不正なルールがあれば起動しない
+	for i, rule := range cfg.Permissions {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("invalid permissions[%d] in %s: %w", i, path, err)
+		}
+	}
+	for i, webhook := range cfg.Webhooks {
+		if err := webhook.validate(); err != nil {
+			return nil, fmt.Errorf("invalid webhooks[%d] in %s: %w", i, path, err)
+		}
+	}
+	return cfg, nil
+}
+
+// ToolEnabled は指定したツールが設定で無効化されていないかを返す
+func (c *Config) ToolEnabled(name string) bool {
+	// webSearchは検索エンジンを設定した場合だけ使える
+	if name == "webSearch" && c.WebSearch == nil {
+		return false
+	}
+	for _, disabled := range c.DisabledTools {
+		if disabled == name {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/lsp"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// defaultDiagnosticsTimeout は1ファイルの診断を待つ時間の上限
+const defaultDiagnosticsTimeout = 5 * time.Second
+
+// lspDiagnostics は編集されたファイルを言語サーバーで検査し、エラーをモデルに返すための報告を作る
+type lspDiagnostics struct {
+	servers *languageServers
+	timeout time.Duration
+	changed []string // 前回の報告以降に変更されたファイル
+}
+
+// newLSPDiagnostics は言語サーバーが設定されていれば診断の収集を準備する。設定がなければnilを返す
+// goplsを自動で使うのはコードの移動だけで、診断は設定した場合に限る
+func newLSPDiagnostics(cfg *config.Config, servers *languageServers) *lspDiagnostics {
+	if len(cfg.LanguageServers) == 0 || servers == nil {
+		return nil
+	}
+	timeout := defaultDiagnosticsTimeout
+	if cfg.DiagnosticsTimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.DiagnosticsTimeoutSeconds) * time.Second
+	}
+	return &lspDiagnostics{
+		servers: servers,
+		timeout: timeout,
+	}
+}
+
+// Record はツールによって変更されたファイルを記録する
+func (d *lspDiagnostics) Record(change tools.FileChange) {
+	if change.NewContent == nil {
+		return
+	}
+	path, err := filepath.Abs(change.Path)
+	if err != nil {
+		path = change.Path
+	}
+	for _, changed := range d.changed {
+		if changed == path {
+			return
+		}
+	}
+	d.changed = append(d.changed, path)
+}
+
+// Report は記録したファイルの診断のうちエラーをまとめた文字列を返す。エラーがなければ空文字列を返す
+func (d *lspDiagnostics) Report() string {
+	changed := d.changed
+	d.changed = nil
+
+	var lines []string
+	for _, path := range changed {
+		index, server, ok := d.servers.serverFor(path)
+		if !ok {
+			continue
+		}
+		client, err := d.servers.client(index, server)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		diagnostics, err := client.Diagnostics(path, languageID(server, path), string(content), d.timeout)
+		if err != nil {
+			fmt.Printf("Warning: failed to get diagnostics for %s: %v\n", path, err)
+			continue
+		}
+
+		displayPath := path
+		if rel, err := filepath.Rel(d.servers.rootDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			displayPath = rel
+		}
+		for _, diagnostic := range diagnostics {
+			if diagnostic.Severity != lsp.SeverityError {
+				continue
+			}
+			line := fmt.Sprintf("%s:%d:%d: %s", displayPath, diagnostic.Range.Start.Line+1, diagnostic.Range.Start.Character+1, diagnostic.Message)
+			if diagnostic.Source != "" {
+				line += " (" + diagnostic.Source + ")"
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return "Language server diagnostics after this change (errors):\n" + strings.Join(lines, "\n")
+}
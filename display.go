@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// wideRanges は端末で2桁分の幅で表示される文字（東アジアの全角文字や絵文字）の範囲
+var wideRanges = []struct{ lo, hi rune }{
+	{0x1100, 0x115F},   // ハングルの字母
+	{0x2E80, 0x303E},   // CJKの部首、記号と句読点
+	{0x3041, 0x33FF},   // ひらがな、カタカナ、CJKの互換文字
+	{0x3400, 0x4DBF},   // CJK統合漢字拡張A
+	{0x4E00, 0x9FFF},   // CJK統合漢字
+	{0xA000, 0xA4CF},   // イ文字
+	{0xAC00, 0xD7A3},   // ハングルの音節
+	{0xF900, 0xFAFF},   // CJK互換漢字
+	{0xFE30, 0xFE4F},   // CJK互換形
+	{0xFF00, 0xFF60},   // 全角英数字と記号
+	{0xFFE0, 0xFFE6},   // 全角の通貨記号など
+	{0x1F300, 0x1F64F}, // 絵文字
+	{0x1F900, 0x1F9FF}, // 補助絵文字
+	{0x20000, 0x3FFFD}, // CJK統合漢字拡張B以降
+}
+
+// runeWidth は文字を端末に表示したときの桁数を返す。結合文字や制御文字は0桁とする
+func runeWidth(r rune) int {
+	if r == 0 || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) || unicode.IsControl(r) {
+		return 0
+	}
+	for _, wide := range wideRanges {
+		if r >= wide.lo && r <= wide.hi {
+			return 2
+		}
+	}
+	return 1
+}
+
+// displayWidth は文字列を端末に表示したときの桁数を返す
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// truncateDisplay は文字列を表示幅maxWidth桁以内に収める。切り詰めた場合は末尾に"..."を付ける
+// 文字の途中や、基底文字と結合文字の間では切らない
+func truncateDisplay(s string, maxWidth int) string {
+	if displayWidth(s) <= maxWidth {
+		return s
+	}
+	const ellipsis = "..."
+	limit := maxWidth - len(ellipsis)
+	width, end := 0, 0
+	for i, r := range s {
+		w := runeWidth(r)
+		if w > 0 && width+w > limit {
+			break
+		}
+		width += w
+		end = i + utf8.RuneLen(r)
+	}
+	return s[:end] + ellipsis
+}
+
+// padDisplay は文字列の右を空白で埋めて表示幅width桁にそろえる
+func padDisplay(s string, width int) string {
+	return s + strings.Repeat(" ", max(width-displayWidth(s), 0))
+}
+
+// singleLine は改行やタブを空白にして1行で表示できるようにする
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
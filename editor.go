@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// editorContextLines は選択範囲の前後に含めてモデルに渡す行数
+const editorContextLines = 30
+
+// editorPosition はファイル内の位置。LSPと同じく、行は0始まり、文字はUTF-16のコード単位で数える
+type editorPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// editorRange はファイル内の範囲
+type editorRange struct {
+	Start editorPosition `json:"start"`
+	End   editorPosition `json:"end"`
+}
+
+// editorRequest はエディタプラグインからのリクエスト
+type editorRequest struct {
+	File        string          `json:"file"`                  // プロジェクトのルートからの相対パスまたは絶対パス
+	Text        *string         `json:"text,omitempty"`        // エディタで編集中の内容。省略時はディスク上の内容を使う
+	Selection   *editorRange    `json:"selection,omitempty"`   // explainとeditで対象にする範囲
+	Position    *editorPosition `json:"position,omitempty"`    // insertで挿入する位置
+	Instruction string          `json:"instruction,omitempty"` // editとinsertでの指示、explainでの質問
+}
+
+// editorTextEdit はLSPのTextEdit
+type editorTextEdit struct {
+	Range   editorRange `json:"range"`
+	NewText string      `json:"newText"`
+}
+
+// editorWorkspaceEdit はLSPのWorkspaceEdit。エディタがそのまま適用できる
+type editorWorkspaceEdit struct {
+	Changes map[string][]editorTextEdit `json:"changes"`
+}
+
+// editorPromptExtension はエディタからのリクエストを処理するときのシステムプロンプトへの追記
+const editorPromptExtension = `
+
+# Editor request
+This request comes from an editor plugin. The editor applies your answer itself, so never try to modify files; use the read-only tools only when you need more context than the request provides.`
+
+// editorCodePromptExtension は選択範囲の書き換えや挿入で、コードだけを返させるための追記
+const editorCodePromptExtension = editorPromptExtension + `
+Reply with the resulting code only, in a single fenced code block, without any explanation. Match the indentation and style of the surrounding code.`
+
+// handleEditorExplain は選択範囲のコードを説明する
+func (s *server) handleEditorExplain(w http.ResponseWriter, r *http.Request) {
+	var req editorRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	doc, err := s.loadEditorDocument(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Selection == nil {
+		writeError(w, http.StatusBadRequest, errors.New("selection is required"))
+		return
+	}
+	start, end, err := doc.rangeOffsets(*req.Selection)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	question := req.Instruction
+	if question == "" {
+		question = "Explain what the selected code does, how it fits into the surrounding code, and anything non-obvious about it."
+	}
+	input := doc.describe(start, end, "Selected code") + "\n" + question
+
+	answer, err := s.runAgent(r.Context(), editorPromptExtension, input)
+	if err != nil {
+		writeAgentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"explanation": answer})
+}
+
+// handleEditorEdit は指示に従って選択範囲を書き換えるWorkspaceEditを返す
+func (s *server) handleEditorEdit(w http.ResponseWriter, r *http.Request) {
+	var req editorRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	doc, err := s.loadEditorDocument(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Selection == nil || req.Instruction == "" {
+		writeError(w, http.StatusBadRequest, errors.New("selection and instruction are required"))
+		return
+	}
+	start, end, err := doc.rangeOffsets(*req.Selection)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	input := doc.describe(start, end, "Selected code") +
+		fmt.Sprintf("\nRewrite the selected code according to this instruction: %s\nReply with the code that replaces the selection.", req.Instruction)
+	answer, err := s.runAgent(r.Context(), editorCodePromptExtension, input)
+	if err != nil {
+		writeAgentError(w, err)
+		return
+	}
+
+	newText := extractCodeBlock(answer)
+	// 選択範囲が行末の改行まで含む場合、置き換え後も改行で終わるようにする
+	if strings.HasSuffix(doc.text[start:end], "\n") && !strings.HasSuffix(newText, "\n") {
+		newText += "\n"
+	}
+	writeJSON(w, http.StatusOK, doc.workspaceEdit(*req.Selection, newText))
+}
+
+// handleEditorInsert は指示に従ってカーソル位置にコードを挿入するWorkspaceEditを返す
+func (s *server) handleEditorInsert(w http.ResponseWriter, r *http.Request) {
+	var req editorRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	doc, err := s.loadEditorDocument(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Position == nil || req.Instruction == "" {
+		writeError(w, http.StatusBadRequest, errors.New("position and instruction are required"))
+		return
+	}
+	offset, err := doc.offset(*req.Position)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	input := doc.describe(offset, offset, "Cursor") +
+		fmt.Sprintf("\nWrite code to insert at the cursor (marked with <CURSOR>) according to this instruction: %s\nReply with only the code to insert.", req.Instruction)
+	answer, err := s.runAgent(r.Context(), editorCodePromptExtension, input)
+	if err != nil {
+		writeAgentError(w, err)
+		return
+	}
+
+	newText := extractCodeBlock(answer)
+	writeJSON(w, http.StatusOK, doc.workspaceEdit(editorRange{Start: *req.Position, End: *req.Position}, newText))
+}
+
+// editorDocument はリクエストの対象のファイルとその内容
+type editorDocument struct {
+	path    string // 絶対パス
+	relPath string // プロジェクトのルートからの相対パス
+	text    string
+}
+
+// loadEditorDocument はリクエストのファイルを解決し、編集中の内容またはディスク上の内容を読み込む
+func (s *server) loadEditorDocument(req editorRequest) (*editorDocument, error) {
+	path, err := s.resolveProjectPath(req.File)
+	if err != nil {
+		return nil, err
+	}
+	relPath, _ := filepath.Rel(s.root, path)
+
+	doc := &editorDocument{path: path, relPath: relPath}
+	if req.Text != nil {
+		doc.text = *req.Text
+		return doc, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	doc.text = string(content)
+	return doc, nil
+}
+
+// offset はLSPの位置をバイトオフセットに変換する
+func (d *editorDocument) offset(pos editorPosition) (int, error) {
+	if pos.Line < 0 || pos.Character < 0 {
+		return 0, fmt.Errorf("invalid position %d:%d", pos.Line, pos.Character)
+	}
+	lineStart := 0
+	for i := 0; i < pos.Line; i++ {
+		next := strings.IndexByte(d.text[lineStart:], '\n')
+		if next < 0 {
+			return 0, fmt.Errorf("line %d is out of range", pos.Line)
+		}
+		lineStart += next + 1
+	}
+
+	lineEnd := len(d.text)
+	if next := strings.IndexByte(d.text[lineStart:], '\n'); next >= 0 {
+		lineEnd = lineStart + next
+	}
+
+	// UTF-16のコード単位で数えた文字数だけ進める。行末を超える位置は行末として扱う
+	offset, units := lineStart, 0
+	for offset < lineEnd && units < pos.Character {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset, nil
+}
+
+// rangeOffsets はLSPの範囲をバイトオフセットの開始と終了に変換する
+func (d *editorDocument) rangeOffsets(rng editorRange) (int, int, error) {
+	start, err := d.offset(rng.Start)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := d.offset(rng.End)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, errors.New("selection end is before its start")
+	}
+	return start, end, nil
+}
+
+// describe はモデルに渡すため、対象の範囲とその前後の行を組み立てる
+// 範囲が空の場合は位置に<CURSOR>の印を付ける
+func (d *editorDocument) describe(start, end int, label string) string {
+	// 前後editorContextLines行だけを含める
+	contextStart := strings.LastIndexByte(d.text[:start], '\n') + 1
+	for i := 0; i < editorContextLines && contextStart > 0; i++ {
+		contextStart = strings.LastIndexByte(d.text[:contextStart-1], '\n') + 1
+	}
+	contextEnd := end
+	for i := 0; i <= editorContextLines && contextEnd < len(d.text); i++ {
+		next := strings.IndexByte(d.text[contextEnd:], '\n')
+		if next < 0 {
+			contextEnd = len(d.text)
+			break
+		}
+		contextEnd += next + 1
+	}
+	before := d.text[contextStart:start]
+	after := d.text[end:contextEnd]
+
+	startLine := strings.Count(d.text[:start], "\n") + 1
+	endLine := strings.Count(d.text[:end], "\n") + 1
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "File: %s\n", d.relPath)
+	if start == end {
+		fmt.Fprintf(&b, "%s: line %d\n\n", label, startLine)
+		fmt.Fprintf(&b, "```\n%s<CURSOR>%s\n```\n", before, after)
+	} else {
+		fmt.Fprintf(&b, "%s (lines %d-%d):\n```\n%s\n```\n\n", label, startLine, endLine, d.text[start:end])
+		fmt.Fprintf(&b, "Surrounding code:\n```\n%s<SELECTION>%s\n```\n", before, after)
+	}
+	return b.String()
+}
+
+// workspaceEdit は範囲をnewTextで置き換えるWorkspaceEditを組み立てる
+func (d *editorDocument) workspaceEdit(rng editorRange, newText string) map[string]any {
+	uri := (&url.URL{Scheme: "file", Path: filepath.ToSlash(d.path)}).String()
+	return map[string]any{
+		"newText": newText,
+		"workspaceEdit": editorWorkspaceEdit{
+			Changes: map[string][]editorTextEdit{uri: {{Range: rng, NewText: newText}}},
+		},
+	}
+}
+
+// codeBlockPattern は応答中の最初のフェンス付きコードブロックにマッチする
+var codeBlockPattern = regexp.MustCompile("(?s)```[^\\n]*\\n(.*?)```")
+
+// extractCodeBlock は応答から最初のコードブロックの中身を取り出す。コードブロックがなければ応答全体を返す
+func extractCodeBlock(answer string) string {
+	if match := codeBlockPattern.FindStringSubmatch(answer); match != nil {
+		return match[1]
+	}
+	return strings.TrimSpace(answer)
+}
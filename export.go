@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hexops/gotextdiff"
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+	"github.com/shibayu36/nebula/memory"
+)
+
+// runExportPatch はセッション中のファイル変更をgit applyできる1つのパッチとして出力する
+func runExportPatch(args []string) error {
+	fs := flag.NewFlagSet("export-patch", flag.ExitOnError)
+	sessionID := fs.String("session", "", "Session ID to export")
+	output := fs.String("o", "", "Write the patch to this file instead of stdout")
+	fs.Parse(args)
+
+	if *sessionID == "" {
+		return fmt.Errorf("--session is required")
+	}
+
+	manager, err := openManager()
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	session, err := manager.GetSession(*sessionID)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := manager.GetSessionFileSnapshots(session.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get file snapshots: %w", err)
+	}
+
+	patch := buildSessionPatch(session.ProjectPath, snapshots)
+	if patch == "" {
+		fmt.Fprintln(os.Stderr, "No file changes found in this session.")
+		return nil
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	_, err = io.WriteString(w, patch)
+	return err
+}
+
+// buildSessionPatch はファイルごとに最初の変更前と最後の変更後の内容を比較し、パッチを組み立てる
+func buildSessionPatch(projectPath string, snapshots []*memory.FileSnapshot) string {
+	type fileState struct {
+		before *string
+		after  *string
+	}
+
+	var paths []string
+	states := map[string]*fileState{}
+	for _, snapshot := range snapshots {
+		state, ok := states[snapshot.Path]
+		if !ok {
+			state = &fileState{before: snapshot.BeforeContent}
+			states[snapshot.Path] = state
+			paths = append(paths, snapshot.Path)
+		}
+		state.after = snapshot.AfterContent
+	}
+
+	var patch strings.Builder
+	for _, path := range paths {
+		relPath, err := filepath.Rel(projectPath, path)
+		if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s because it is outside the project\n", path)
+			continue
+		}
+		state := states[path]
+		patch.WriteString(formatGitPatch(filepath.ToSlash(relPath), state.before, state.after))
+	}
+	return patch.String()
+}
+
+// formatGitPatch は1ファイル分の変更をgit形式のパッチに整形する
+// beforeがnilの場合は新規作成、afterがnilの場合は削除として扱う
+// 両方nil（セッション中に作成して削除したファイル）の場合は変更なしとして空文字列を返す
+func formatGitPatch(path string, before, after *string) string {
+	if before == nil && after == nil {
+		return ""
+	}
+	var oldText, newText string
+	if before != nil {
+		oldText = *before
+	}
+	if after != nil {
+		newText = *after
+	}
+	if before != nil && after != nil && oldText == newText {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n", path, path)
+
+	fromName, toName := "a/"+path, "b/"+path
+	switch {
+	case before == nil:
+		b.WriteString("new file mode 100644\n")
+		fromName = "/dev/null"
+	case after == nil:
+		b.WriteString("deleted file mode 100644\n")
+		toName = "/dev/null"
+	}
+
+	edits := myers.ComputeEdits(span.URIFromPath(path), oldText, newText)
+	unified := gotextdiff.ToUnified(fromName, toName, oldText, edits)
+	if len(unified.Hunks) == 0 {
+		// 空ファイルの作成・削除はヘッダーのみで表現する
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "--- %s\n", fromName)
+	fmt.Fprintf(&b, "+++ %s\n", toName)
+	for _, hunk := range unified.Hunks {
+		fromCount, toCount := 0, 0
+		for _, line := range hunk.Lines {
+			switch line.Kind {
+			case gotextdiff.Delete:
+				fromCount++
+			case gotextdiff.Insert:
+				toCount++
+			default:
+				fromCount++
+				toCount++
+			}
+		}
+
+		// 行数が0の場合、開始行は直前の行番号（空ファイルなら0）で表す
+		fromLine, toLine := hunk.FromLine, hunk.ToLine
+		if fromCount == 0 {
+			fromLine--
+		}
+		if toCount == 0 {
+			toLine--
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+
+		for _, line := range hunk.Lines {
+			switch line.Kind {
+			case gotextdiff.Delete:
+				b.WriteString("-")
+			case gotextdiff.Insert:
+				b.WriteString("+")
+			default:
+				b.WriteString(" ")
+			}
+			b.WriteString(line.Content)
+			if !strings.HasSuffix(line.Content, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return b.String()
+}
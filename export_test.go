@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/shibayu36/nebula/memory"
+)
+
+func TestFormatGitPatch(t *testing.T) {
+	s := func(v string) *string { return &v }
+	tests := []struct {
+		name   string
+		before *string
+		after  *string
+		want   string
+	}{
+		{
+			name:   "編集",
+			before: s("a\nb\n"),
+			after:  s("a\nc\n"),
+			want:   "diff --git a/dir/f.txt b/dir/f.txt\n--- a/dir/f.txt\n+++ b/dir/f.txt\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n",
+		},
+		{
+			name:  "新規作成",
+			after: s("x\n"),
+			want:  "diff --git a/dir/f.txt b/dir/f.txt\nnew file mode 100644\n--- /dev/null\n+++ b/dir/f.txt\n@@ -0,0 +1,1 @@\n+x\n",
+		},
+		{
+			name:   "削除",
+			before: s("x\n"),
+			want:   "diff --git a/dir/f.txt b/dir/f.txt\ndeleted file mode 100644\n--- a/dir/f.txt\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-x\n",
+		},
+		{
+			name:  "空ファイルの作成はヘッダーのみ",
+			after: s(""),
+			want:  "diff --git a/dir/f.txt b/dir/f.txt\nnew file mode 100644\n",
+		},
+		{
+			name:   "末尾に改行がない",
+			before: s("a"),
+			after:  s("b"),
+			want:   "diff --git a/dir/f.txt b/dir/f.txt\n--- a/dir/f.txt\n+++ b/dir/f.txt\n@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name:   "変更なし",
+			before: s("a\n"),
+			after:  s("a\n"),
+			want:   "",
+		},
+		{
+			name: "セッション中に作成して削除した",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatGitPatch("dir/f.txt", tt.before, tt.after); got != tt.want {
+				t.Errorf("formatGitPatch() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSessionPatch(t *testing.T) {
+	s := func(v string) *string { return &v }
+	project := filepath.Join(t.TempDir(), "project")
+	snapshots := []*memory.FileSnapshot{
+		// 同じファイルへの複数の変更は最初の変更前と最後の変更後の差分にまとめる
+		{Path: filepath.Join(project, "a.txt"), BeforeContent: s("1\n"), AfterContent: s("2\n")},
+		{Path: filepath.Join(project, "a.txt"), BeforeContent: s("2\n"), AfterContent: s("3\n")},
+		// ..で始まる名前でもプロジェクト内のファイル
+		{Path: filepath.Join(project, "..env"), AfterContent: s("x\n")},
+		// プロジェクトの外のファイルは含めない
+		{Path: filepath.Join(project, "..", "outside.txt"), AfterContent: s("y\n")},
+		// 作成して削除したファイルは含めない
+		{Path: filepath.Join(project, "tmp.txt"), AfterContent: s("z\n")},
+		{Path: filepath.Join(project, "tmp.txt"), BeforeContent: s("z\n")},
+	}
+
+	want := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1,1 +1,1 @@\n-1\n+3\n" +
+		"diff --git a/..env b/..env\nnew file mode 100644\n--- /dev/null\n+++ b/..env\n@@ -0,0 +1,1 @@\n+x\n"
+	if got := buildSessionPatch(project, snapshots); got != want {
+		t.Errorf("buildSessionPatch() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shibayu36/nebula/memory"
+)
+
+// runExportFeedback は評価を付けた応答をJSONLとして出力する
+func runExportFeedback(args []string) error {
+	fs := flag.NewFlagSet("export-feedback", flag.ExitOnError)
+	rating := fs.String("rating", "", "Only export responses with this rating (good or bad)")
+	output := fs.String("o", "", "Write the JSONL to this file instead of stdout")
+	fs.Parse(args)
+
+	if *rating != "" && *rating != memory.RatingGood && *rating != memory.RatingBad {
+		return fmt.Errorf("--rating must be %s or %s", memory.RatingGood, memory.RatingBad)
+	}
+
+	manager, err := openManager()
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	turns, err := manager.GetRatedTurns(*rating)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	encoder := json.NewEncoder(w)
+	for _, turn := range turns {
+		if err := encoder.Encode(turn); err != nil {
+			return fmt.Errorf("failed to write rated turn: %w", err)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d rated response(s).\n", len(turns))
+	return nil
+}
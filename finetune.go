@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/memory"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// fineTuneExample はファインチューニング用JSONLの1行
+type fineTuneExample struct {
+	Messages []openai.ChatCompletionMessage `json:"messages"`
+	Tools    []openai.Tool                  `json:"tools,omitempty"`
+}
+
+// runExportFineTune は指定したセッションをOpenAIのチャットファインチューニング形式のJSONLとして出力する
+func runExportFineTune(args []string) error {
+	fs := flag.NewFlagSet("export-finetune", flag.ExitOnError)
+	sessionIDs := fs.String("session", "", "Comma-separated session IDs to export")
+	all := fs.Bool("all", false, "Export all sessions of the current project")
+	output := fs.String("o", "", "Write the JSONL to this file instead of stdout")
+	fs.Parse(args)
+
+	if *sessionIDs == "" && !*all {
+		return fmt.Errorf("--session or --all is required")
+	}
+
+	manager, err := openManager()
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	var ids []string
+	if *all {
+		projectPath, err := currentProject()
+		if err != nil {
+			return err
+		}
+		sessions, err := manager.GetSessionsByProject(projectPath, -1, 0)
+		if err != nil {
+			return fmt.Errorf("failed to get sessions: %w", err)
+		}
+		for _, s := range sessions {
+			ids = append(ids, s.ID)
+		}
+	} else {
+		for _, id := range strings.Split(*sessionIDs, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	availableTools := tools.GetAvailableTools()
+	var toolSchemas []openai.Tool
+	for _, name := range sortedToolNames(availableTools) {
+		toolSchemas = append(toolSchemas, availableTools[name].Schema)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	encoder := json.NewEncoder(w)
+	exported := 0
+	for _, id := range ids {
+		// シークレットセッションはメッセージの内容を保存していないので学習データにできない
+		session, err := manager.GetSession(id)
+		if err != nil {
+			return err
+		}
+		if session.Incognito {
+			fmt.Fprintf(os.Stderr, "Warning: skipping session %s because it is incognito\n", id)
+			continue
+		}
+
+		// 巨大なセッションでも全メッセージを一度に読み込まないよう、ページ単位で読みながら会話を復元する
+		var builder fineTuneBuilder
+		if err := manager.EachSessionMessage(id, func(msg *memory.Message) error {
+			builder.add(msg)
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to get session messages: %w", err)
+		}
+
+		example := builder.result()
+		if example == nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping session %s because it has no complete assistant response\n", id)
+			continue
+		}
+
+		example = append([]openai.ChatCompletionMessage{{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: getSystemPrompt(),
+		}}, example...)
+		if err := encoder.Encode(fineTuneExample{Messages: example, Tools: toolSchemas}); err != nil {
+			return fmt.Errorf("failed to write example: %w", err)
+		}
+		exported++
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d session(s).\n", exported)
+	return nil
+}
+
+// fineTuneBuilder は保存されたメッセージを順に受け取り、ツール呼び出しを含む会話を復元する
+// ツール結果は呼び出しの順に保存されているので、直前のアシスタントメッセージのツール呼び出しと順に対応付ける
+// 学習データとして成立するよう、結果が揃っていないツール呼び出し以降と末尾のアシスタント以外のメッセージは除く
+// loadSessionHistoryと同じく、/clearより前の会話は使わず、要約より前の会話は要約に置き換える
+type fineTuneBuilder struct {
+	messages []openai.ChatCompletionMessage
+	pending  []openai.ToolCall // 結果を待っているツール呼び出し
+	complete int               // 学習データとして使える末尾の位置
+	broken   bool              // 会話が壊れていて、次の/clearか要約まで以降のメッセージを使えない
+}
+
+// add はメッセージを1件追加する
+func (b *fineTuneBuilder) add(msg *memory.Message) {
+	switch msg.Role {
+	case clearRole:
+		*b = fineTuneBuilder{}
+		return
+	case summaryRole:
+		*b = fineTuneBuilder{messages: []openai.ChatCompletionMessage{summaryMessage(msg.Content)}}
+		return
+	}
+	if b.broken {
+		return
+	}
+
+	switch msg.Role {
+	case openai.ChatMessageRoleUser:
+		if len(b.pending) > 0 {
+			b.broken = true
+			return
+		}
+		b.messages = append(b.messages, openai.ChatCompletionMessage{Role: msg.Role, Content: msg.Content})
+
+	case openai.ChatMessageRoleAssistant:
+		if len(b.pending) > 0 {
+			b.broken = true
+			return
+		}
+		assistant := openai.ChatCompletionMessage{Role: msg.Role, Content: msg.Content}
+		if msg.ToolCalls != nil {
+			if err := json.Unmarshal([]byte(*msg.ToolCalls), &assistant.ToolCalls); err != nil {
+				b.broken = true
+				return
+			}
+		}
+		b.messages = append(b.messages, assistant)
+		b.pending = assistant.ToolCalls
+		if len(b.pending) == 0 {
+			b.complete = len(b.messages)
+		}
+
+	case openai.ChatMessageRoleTool:
+		if len(b.pending) == 0 {
+			return
+		}
+		b.messages = append(b.messages, openai.ChatCompletionMessage{
+			Role:       openai.ChatMessageRoleTool,
+			Content:    msg.Content,
+			ToolCallID: b.pending[0].ID,
+		})
+		b.pending = b.pending[1:]
+	}
+}
+
+// result は復元した会話を返す。完結したアシスタントの応答がなければnilを返す
+func (b *fineTuneBuilder) result() []openai.ChatCompletionMessage {
+	if b.complete == 0 {
+		return nil
+	}
+	return b.messages[:b.complete]
+}
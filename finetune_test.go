@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/memory"
+)
+
+func TestFineTuneBuilder(t *testing.T) {
+	toolCalls := `[{"id":"call_1","type":"function","function":{"name":"readFile","arguments":"{}"}}]`
+	user := func(content string) *memory.Message { return &memory.Message{Role: "user", Content: content} }
+	assistant := func(content string) *memory.Message { return &memory.Message{Role: "assistant", Content: content} }
+	calling := &memory.Message{Role: "assistant", ToolCalls: &toolCalls}
+	tool := &memory.Message{Role: "tool", Content: "result"}
+
+	tests := []struct {
+		name     string
+		messages []*memory.Message
+		want     []openai.ChatCompletionMessage
+	}{
+		{
+			name:     "ツール呼び出しと結果を対応付ける",
+			messages: []*memory.Message{user("q"), calling, tool, assistant("a")},
+			want: []openai.ChatCompletionMessage{
+				{Role: "user", Content: "q"},
+				{Role: "assistant", ToolCalls: []openai.ToolCall{{ID: "call_1", Type: "function", Function: openai.FunctionCall{Name: "readFile", Arguments: "{}"}}}},
+				{Role: "tool", Content: "result", ToolCallID: "call_1"},
+				{Role: "assistant", Content: "a"},
+			},
+		},
+		{
+			name:     "末尾の完結していないメッセージは除く",
+			messages: []*memory.Message{user("q1"), assistant("a1"), user("q2"), calling},
+			want:     []openai.ChatCompletionMessage{{Role: "user", Content: "q1"}, {Role: "assistant", Content: "a1"}},
+		},
+		{
+			name:     "/clearより前の会話は使わない",
+			messages: []*memory.Message{user("q1"), assistant("a1"), {Role: clearRole}, user("q2"), assistant("a2")},
+			want:     []openai.ChatCompletionMessage{{Role: "user", Content: "q2"}, {Role: "assistant", Content: "a2"}},
+		},
+		{
+			name:     "/clearの後に完結した応答がなければ使わない",
+			messages: []*memory.Message{user("q1"), assistant("a1"), {Role: clearRole}, user("q2")},
+			want:     nil,
+		},
+		{
+			name:     "要約より前の会話は要約に置き換える",
+			messages: []*memory.Message{user("q1"), assistant("a1"), {Role: summaryRole, Content: "summary"}, user("q2"), assistant("a2")},
+			want:     []openai.ChatCompletionMessage{summaryMessage("summary"), {Role: "user", Content: "q2"}, {Role: "assistant", Content: "a2"}},
+		},
+		{
+			name:     "結果の揃っていないツール呼び出し以降は使わない",
+			messages: []*memory.Message{user("q1"), assistant("a1"), user("q2"), calling, user("q3"), assistant("a3")},
+			want:     []openai.ChatCompletionMessage{{Role: "user", Content: "q1"}, {Role: "assistant", Content: "a1"}},
+		},
+		{
+			name:     "壊れた会話も/clearの後からは使う",
+			messages: []*memory.Message{user("q1"), calling, user("q2"), {Role: clearRole}, user("q3"), assistant("a3")},
+			want:     []openai.ChatCompletionMessage{{Role: "user", Content: "q3"}, {Role: "assistant", Content: "a3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var builder fineTuneBuilder
+			for _, msg := range tt.messages {
+				builder.add(msg)
+			}
+			if got := builder.result(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("result() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
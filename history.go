@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const defaultToolResultRetentionTurns = 10
+
+// toolResultRetentionTurns は設定からツール結果を保持するターン数を返す。0以下の場合は省略しない
+func toolResultRetentionTurns(configured int) int {
+	switch {
+	case configured == 0:
+		return defaultToolResultRetentionTurns
+	case configured < 0:
+		return 0
+	default:
+		return configured
+	}
+}
+
+// elideStaleToolResults は直近keepTurnsターンより前のツール結果を1行のプレースホルダーに置き換えたメッセージ列を返す
+// API送信用のコピーを作るだけで、履歴やDBに保存された内容は変更しない
+func elideStaleToolResults(messages []openai.ChatCompletionMessage, keepTurns int) []openai.ChatCompletionMessage {
+	if keepTurns <= 0 {
+		return messages
+	}
+
+	// 後ろから数えてkeepTurns番目のユーザーメッセージより前を古いターンとみなす
+	boundary := -1
+	turns := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != openai.ChatMessageRoleUser {
+			continue
+		}
+		turns++
+		if turns == keepTurns {
+			boundary = i
+			break
+		}
+	}
+	if boundary <= 0 {
+		return messages
+	}
+
+	elided := make([]openai.ChatCompletionMessage, len(messages))
+	copy(elided, messages)
+
+	calls := map[string]openai.FunctionCall{}
+	for i := 0; i < boundary; i++ {
+		for _, toolCall := range messages[i].ToolCalls {
+			calls[toolCall.ID] = toolCall.Function
+		}
+		if messages[i].Role == openai.ChatMessageRoleTool {
+			elided[i].Content = toolResultPlaceholder(calls[messages[i].ToolCallID], messages[i].Content)
+		}
+	}
+	return elided
+}
+
+// toolResultPlaceholder はツール結果の代わりに送る1行の要約を作る
+// 例: "readFile foo.go – 312 lines, elided"
+func toolResultPlaceholder(call openai.FunctionCall, content string) string {
+	name := call.Name
+	if name == "" {
+		name = "tool result"
+	}
+
+	// 引数にパスがあれば対象として表示する
+	var args map[string]any
+	if err := json.Unmarshal([]byte(call.Arguments), &args); err == nil {
+		if path, ok := args["path"].(string); ok && path != "" {
+			name += " " + path
+		}
+	}
+
+	// readFileのようにcontentを持つ結果は中身の行数、それ以外は結果全体の行数を数える
+	text := content
+	var result map[string]any
+	if err := json.Unmarshal([]byte(content), &result); err == nil {
+		if fileContent, ok := result["content"].(string); ok {
+			text = fileContent
+		} else if matches, ok := result["matches"].([]any); ok {
+			return fmt.Sprintf("%s – %d matches, elided", name, len(matches))
+		} else if files, ok := result["files"].([]any); ok {
+			return fmt.Sprintf("%s – %d entries, elided", name, len(files))
+		}
+	}
+	return fmt.Sprintf("%s – %d lines, elided", name, strings.Count(text, "\n")+1)
+}
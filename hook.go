@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/shibayu36/nebula/config"
+)
+
+// hookMaxDiffBytes はプロンプトに含めるステージされた差分の最大バイト数
+const hookMaxDiffBytes = 64 * 1024
+
+// hookFinding はpre-commitレビューで見つかった問題
+type hookFinding struct {
+	Severity string `json:"severity"` // "blocking"（コミットを止める）または"warning"
+	Category string `json:"category"` // "bug"、"secret"、"missing-test"のいずれか
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+// hookReview はpre-commitレビューの結果。差分のハッシュごとにキャッシュする
+type hookReview struct {
+	Findings []hookFinding `json:"findings"`
+}
+
+// blocking はコミットを止める問題の数を返す
+func (r hookReview) blocking() int {
+	count := 0
+	for _, finding := range r.Findings {
+		if finding.Severity == "blocking" {
+			count++
+		}
+	}
+	return count
+}
+
+// hookPromptExtension はpre-commitレビューでのシステムプロンプトへの追記
+const hookPromptExtension = `
+
+# Pre-commit review
+You are reviewing the staged changes of a commit that is about to be made. This overrides the Implementation step of the Execution Protocol.
+Look only for:
+- Obvious bugs: wrong conditions, nil/null dereferences, unhandled errors, typos in identifiers, debug code left behind
+- Secrets added in the diff: API keys, tokens, passwords, private keys, credentials in URLs
+- Missing tests: changed behavior without a matching test change, when the project has tests for that code
+Do NOT report style or naming preferences. Do NOT modify any files; write tools are unavailable.
+Use "blocking" only for definite bugs and committed secrets; use "warning" for everything else, including missing tests.
+End your answer with a single fenced json code block in exactly this form, with an empty list when there is nothing to report:
+` + "```json" + `
+{"findings": [{"severity": "blocking", "category": "secret", "file": "path/to/file", "line": 12, "message": "what is wrong and how to fix it"}]}
+` + "```"
+
+// runHook はgitのフックから呼び出されるサブコマンドを実行する
+func runHook(args []string) error {
+	if len(args) == 0 || args[0] != "pre-commit" {
+		fmt.Println("Usage: nebula hook pre-commit [flags]")
+		fmt.Println("Add `exec nebula hook pre-commit` to .git/hooks/pre-commit to review staged changes before each commit")
+		return errors.New("unknown hook")
+	}
+	return runPreCommitHook(args[1:])
+}
+
+// runPreCommitHook はステージされた差分を読み取り専用のツールでレビューし、コミットを止める問題があればエラーを返す
+func runPreCommitHook(args []string) error {
+	fs := flag.NewFlagSet("hook pre-commit", flag.ExitOnError)
+	modelFlag := fs.String("model", "", "Model to use (default: config model or "+defaultModel+")")
+	noCache := fs.Bool("no-cache", false, "Review again even if the same diff was reviewed before")
+	fs.Parse(args)
+
+	// gitはフックの実行時にGIT_INDEX_FILEを設定することがあるので、環境変数を引き継いだまま差分を取る
+	diff, err := runGit(nil, "diff", "--cached", "--no-ext-diff", "--no-textconv", "--no-color")
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		return nil
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	model := resolveModel(*modelFlag, cfg)
+
+	// 同じ差分を同じモデルでレビューした結果があれば使う
+	sum := sha256.Sum256([]byte(model + "\x00" + diff))
+	cachePath, err := hookCachePath(hex.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	var review hookReview
+	cached := false
+	if !*noCache {
+		if data, err := os.ReadFile(cachePath); err == nil && json.Unmarshal(data, &review) == nil {
+			cached = true
+		}
+	}
+
+	if !cached {
+		review, err = reviewStagedDiff(cfg, model, diff)
+		if err != nil {
+			return err
+		}
+		if data, err := json.Marshal(review); err == nil {
+			if err := os.WriteFile(cachePath, data, 0o644); err != nil {
+				fmt.Printf("Warning: failed to cache the review: %v\n", err)
+			}
+		}
+	}
+
+	printHookReview(review, cached)
+	if count := review.blocking(); count > 0 {
+		return fmt.Errorf("pre-commit review found %d blocking issue(s); fix them or commit with --no-verify", count)
+	}
+	return nil
+}
+
+// reviewStagedDiff はエージェントにステージされた差分をレビューさせ、最後のjsonブロックから結果を取り出す
+func reviewStagedDiff(cfg *config.Config, model, diff string) (hookReview, error) {
+	oneShot, err := newOneShotAgent(cfg, model)
+	if err != nil {
+		return hookReview{}, err
+	}
+	defer oneShot.close()
+
+	input := "Review the staged changes below.\n\n```diff\n"
+	if len(diff) > hookMaxDiffBytes {
+		input += diff[:strings.LastIndex(diff[:hookMaxDiffBytes], "\n")+1] + "```\n\nThe diff was truncated. Use 'gitDiff' with staged set to true to read the rest."
+	} else {
+		input += diff + "```"
+	}
+	answer, err := oneShot.run(context.Background(), hookPromptExtension, input)
+	if err != nil {
+		return hookReview{}, err
+	}
+	return parseHookReview(answer)
+}
+
+// jsonBlockPattern は応答中のjsonのコードブロックにマッチする
+var jsonBlockPattern = regexp.MustCompile("(?s)```json\\s*\\n(.*?)```")
+
+// parseHookReview は応答の最後のjsonブロックからレビュー結果を取り出す
+func parseHookReview(answer string) (hookReview, error) {
+	matches := jsonBlockPattern.FindAllStringSubmatch(answer, -1)
+	if len(matches) == 0 {
+		return hookReview{}, errors.New("the review response did not contain a json block with findings")
+	}
+	var review hookReview
+	if err := json.Unmarshal([]byte(matches[len(matches)-1][1]), &review); err != nil {
+		return hookReview{}, fmt.Errorf("failed to parse the review findings: %w", err)
+	}
+	return review, nil
+}
+
+// hookCachePath はレビュー結果のキャッシュファイルのパスを返す
+func hookCachePath(hash string) (string, error) {
+	dataDir, err := localDataDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(dataDir, "hook-cache")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create hook cache directory: %w", err)
+	}
+	return filepath.Join(dir, hash+".json"), nil
+}
+
+// printHookReview はレビュー結果を表示する
+func printHookReview(review hookReview, cached bool) {
+	if cached {
+		fmt.Println("nebula: using the cached review of the same staged changes")
+	}
+	if len(review.Findings) == 0 {
+		fmt.Println("nebula: no issues found in the staged changes")
+		return
+	}
+	fmt.Printf("nebula: %d issue(s) found in the staged changes\n", len(review.Findings))
+	for _, finding := range review.Findings {
+		location := finding.File
+		if finding.Line > 0 {
+			location = fmt.Sprintf("%s:%d", finding.File, finding.Line)
+		}
+		fmt.Printf("  [%s] %s %s: %s\n", finding.Severity, finding.Category, location, finding.Message)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+// errTurnInterrupted はユーザーがCtrl+Cでターンを中断したことを表す
+var errTurnInterrupted = errors.New("interrupted by the user")
+
+// runInterruptible はCtrl+Cでターンを中断できるようにしてturnを実行する
+// Ctrl+Cを押すとLLMへのリクエストと実行中のツールをキャンセルし、セッションを保ったまま入力待ちに戻る
+// 中断を待っている間にもう一度Ctrl+Cを押すとプロセスを終了する
+func (a *agent) runInterruptible(turn func() error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-signals:
+			fmt.Println("\nInterrupting the current turn... (press Ctrl+C again to quit)")
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-signals:
+			fmt.Println("\nQuit.")
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	a.ctx = ctx
+	defer func() { a.ctx = nil }()
+
+	err := turn()
+	if ctx.Err() != nil {
+		return errTurnInterrupted
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/lsp"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// navigationTimeout は定義や参照の検索を待つ時間の上限
+// 言語サーバーは起動直後にワークスペースを読み込むので、診断よりも長めにする
+const navigationTimeout = 30 * time.Second
+
+// goplsServer は言語サーバーが設定されていない場合に、コードの移動に使うgoplsの設定
+var goplsServer = config.LanguageServer{Command: []string{"gopls"}, Extensions: []string{".go"}, LanguageID: "go"}
+
+// languageServers は言語サーバーを必要になった時点で起動し、編集後の診断とコードの移動で共有する
+type languageServers struct {
+	servers []config.LanguageServer
+	clients map[int]*lsp.Client // serversの添字ごとの起動済みクライアント
+	failed  map[int]bool        // 起動に失敗したサーバー（再試行しない）
+	rootDir string
+}
+
+// newLanguageServers は設定された言語サーバーを準備する
+// 設定がない場合でもgoplsがインストールされていればGoのコードの移動に使う。どちらもなければnilを返す
+func newLanguageServers(cfg *config.Config) *languageServers {
+	servers := cfg.LanguageServers
+	if len(servers) == 0 {
+		if _, err := exec.LookPath(goplsServer.Command[0]); err != nil {
+			return nil
+		}
+		servers = []config.LanguageServer{goplsServer}
+	}
+	rootDir, err := os.Getwd()
+	if err != nil {
+		rootDir = "."
+	}
+	return &languageServers{
+		servers: servers,
+		clients: map[int]*lsp.Client{},
+		failed:  map[int]bool{},
+		rootDir: rootDir,
+	}
+}
+
+// serverFor はファイルの拡張子に対応する言語サーバーの設定を返す
+func (l *languageServers) serverFor(path string) (int, config.LanguageServer, bool) {
+	ext := filepath.Ext(path)
+	for i, server := range l.servers {
+		for _, e := range server.Extensions {
+			if e == ext {
+				return i, server, true
+			}
+		}
+	}
+	return 0, config.LanguageServer{}, false
+}
+
+// client は言語サーバーを必要になった時点で起動して返す
+func (l *languageServers) client(index int, server config.LanguageServer) (*lsp.Client, error) {
+	if client, ok := l.clients[index]; ok {
+		return client, nil
+	}
+	if l.failed[index] {
+		return nil, fmt.Errorf("language server %s is unavailable", strings.Join(server.Command, " "))
+	}
+
+	client, err := lsp.Start(server.Command, l.rootDir)
+	if err != nil {
+		l.failed[index] = true
+		return nil, err
+	}
+	l.clients[index] = client
+	return client, nil
+}
+
+// languageID はLSPの言語IDを返す。設定がなければ拡張子から決める
+func languageID(server config.LanguageServer, path string) string {
+	if server.LanguageID != "" {
+		return server.LanguageID
+	}
+	return strings.TrimPrefix(filepath.Ext(path), ".")
+}
+
+// Definition はtools.CodeNavigatorの実装。シンボルの定義の場所を返す
+func (l *languageServers) Definition(pos tools.CodePosition) ([]tools.CodePosition, error) {
+	client, server, content, err := l.open(pos.Path)
+	if err != nil {
+		return nil, err
+	}
+	locations, err := client.Definition(pos.Path, languageID(server, pos.Path), content, lsp.Position{Line: pos.Line, Character: pos.Character}, navigationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return codePositions(locations), nil
+}
+
+// References はtools.CodeNavigatorの実装。シンボルを参照している場所を返す
+func (l *languageServers) References(pos tools.CodePosition, includeDeclaration bool) ([]tools.CodePosition, error) {
+	client, server, content, err := l.open(pos.Path)
+	if err != nil {
+		return nil, err
+	}
+	locations, err := client.References(pos.Path, languageID(server, pos.Path), content, lsp.Position{Line: pos.Line, Character: pos.Character}, includeDeclaration, navigationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return codePositions(locations), nil
+}
+
+// open はファイルに対応する言語サーバーとファイルの内容を返す
+func (l *languageServers) open(path string) (*lsp.Client, config.LanguageServer, string, error) {
+	index, server, ok := l.serverFor(path)
+	if !ok {
+		return nil, server, "", fmt.Errorf("no language server is configured for %s files", filepath.Ext(path))
+	}
+	client, err := l.client(index, server)
+	if err != nil {
+		return nil, server, "", err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, server, "", err
+	}
+	return client, server, string(content), nil
+}
+
+// codePositions は言語サーバーが返した場所をツールに渡す形に変換する
+func codePositions(locations []lsp.Location) []tools.CodePosition {
+	positions := make([]tools.CodePosition, 0, len(locations))
+	for _, location := range locations {
+		positions = append(positions, tools.CodePosition{
+			Path:      lsp.FilePath(location.URI),
+			Line:      location.Range.Start.Line,
+			Character: location.Range.Start.Character,
+		})
+	}
+	return positions
+}
+
+// Close は起動した言語サーバーをすべて終了させる
+func (l *languageServers) Close() {
+	for _, client := range l.clients {
+		client.Close()
+	}
+}
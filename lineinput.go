@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	// inputHistorySize はプロジェクトごとに残す入力履歴の件数
+	inputHistorySize = 1000
+	// continuationPrompt は複数行の入力の2行目以降に表示するプロンプト
+	continuationPrompt = "...  "
+	// newlineMarker は行の編集中に改行を表す文字。Alt+Enterで入力し、複数行の履歴もこの文字でつないで1行で編集する
+	newlineMarker = "↵"
+	// codeFence は複数行のコードブロックの始まりと終わりを表す
+	codeFence = "```"
+	// keyEscape はESCのバイト
+	keyEscape = 27
+)
+
+// lineReader はプロンプトを表示してユーザー入力を1件ずつ読む。入力の終わり（Ctrl+D）ではio.EOFを返す
+// 次の場合は複数行を1件の入力として、改行でつないで返す
+//   - ```で始まるコードブロックは、閉じる```の行まで続けて読む
+//   - 端末では、貼り付けた複数行を改行が入ったまま読み、空行でEnterを押すと（貼り付けに続けて行を入力した場合はその行で）終わる
+//   - 端末では、Alt+Enterで行を送らずに改行を入れられる
+type lineReader interface {
+	ReadInput(prompt string) (string, error)
+}
+
+// newLineReader は標準入力が端末であれば行編集と履歴が使えるReaderを、そうでなければ1行ずつ読むだけのReaderを返す
+// historyPathが空の場合、履歴はプロセスの中だけで保持しファイルに残さない
+func newLineReader(historyPath string) lineReader {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		scanner := bufio.NewScanner(os.Stdin)
+		// 長いログなどを1行で渡されても読めるようにする
+		scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+		return &scannerLineReader{scanner: scanner}
+	}
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{&altEnterReader{r: os.Stdin}, os.Stdout}, "")
+	history := loadInputHistory(historyPath)
+	t.History = terminalHistory{history}
+	return &terminalLineReader{fd: fd, terminal: t, history: history}
+}
+
+// readInput はreadLineで1行ずつ読み、入力が続く間は行を改行でつないで返す
+// readLineのpastedは、貼り付けた文字列の途中の改行で区切られた行であること
+// 入力の途中で終わり（io.EOF）になった場合は、それまでの行を入力として返す
+func readInput(prompt string, readLine func(prompt string) (line string, pasted bool, err error)) (string, error) {
+	var lines []string
+	inFence := false
+	for {
+		line, pasted, err := readLine(prompt)
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(lines) > 0 {
+				return strings.Join(lines, "\n"), nil
+			}
+			return "", err
+		}
+		for _, l := range strings.Split(line, "\n") {
+			// 行の中で```が開いて閉じる（`code`のような）場合はコードブロックの始まりとみなさない
+			if strings.Count(l, codeFence)%2 == 1 {
+				inFence = !inFence
+			}
+			lines = append(lines, l)
+		}
+		if !inFence && !pasted {
+			// 貼り付けの最後の改行の後に押したEnterの空行は入力に含めない
+			for len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+				lines = lines[:len(lines)-1]
+			}
+			return strings.Join(lines, "\n"), nil
+		}
+		prompt = continuationPrompt
+	}
+}
+
+// scannerLineReader はパイプなど端末でない標準入力から読む
+type scannerLineReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *scannerLineReader) ReadInput(prompt string) (string, error) {
+	return readInput(prompt, func(prompt string) (string, bool, error) {
+		fmt.Print(prompt)
+		if !r.scanner.Scan() {
+			if err := r.scanner.Err(); err != nil {
+				return "", false, err
+			}
+			return "", false, io.EOF
+		}
+		return r.scanner.Text(), false, nil
+	})
+}
+
+// terminalLineReader はemacs風のキー操作（Ctrl+A/E/B/F/K/U/W、矢印キーなど）で行を編集し、
+// 上下キー（Ctrl+P/N）で以前の入力を呼び出せるReader
+// 承認の確認などほかの処理も標準入力を読むので、端末をrawモードにするのは1件の入力を読む間だけにする
+type terminalLineReader struct {
+	fd       int
+	terminal *term.Terminal
+	history  *inputHistory
+}
+
+func (r *terminalLineReader) ReadInput(prompt string) (string, error) {
+	state, err := term.MakeRaw(r.fd)
+	if err != nil {
+		return "", fmt.Errorf("failed to set up the terminal: %w", err)
+	}
+	defer term.Restore(r.fd, state)
+	// 貼り付けた文字列を入力したキーと区別できるよう、端末に貼り付けの始まりと終わりを知らせてもらう
+	r.terminal.SetBracketedPasteMode(true)
+	defer r.terminal.SetBracketedPasteMode(false)
+
+	input, err := readInput(prompt, func(prompt string) (string, bool, error) {
+		if width, height, err := term.GetSize(r.fd); err == nil {
+			r.terminal.SetSize(width, height)
+		}
+		r.terminal.SetPrompt(prompt)
+		// rawモードではCtrl+CもCtrl+Dと同じく入力の終わりとして返る
+		line, err := r.terminal.ReadLine()
+		pasted := errors.Is(err, term.ErrPasteIndicator)
+		if pasted {
+			err = nil
+		}
+		return strings.ReplaceAll(line, newlineMarker, "\n"), pasted, err
+	})
+	if err == nil {
+		r.history.Add(input)
+	}
+	return input, err
+}
+
+// altEnterReader は端末からの入力のAlt+Enter（ESCに続くCRかLF）を改行を表すnewlineMarkerに置き換える
+// term.TerminalはAlt+Enterを扱わず、Enterは行を送ってしまうため
+type altEnterReader struct {
+	r       io.Reader
+	buf     []byte // 置き換えた後のまだ返していないバイト列
+	pending bool   // 直前に読んだ最後のバイトがESCで、次のバイトを見るまで返していない
+}
+
+func (a *altEnterReader) Read(p []byte) (int, error) {
+	for len(a.buf) == 0 {
+		in := make([]byte, len(p))
+		n, err := a.r.Read(in)
+		if a.pending {
+			in, n = append([]byte{keyEscape}, in[:n]...), n+1
+			a.pending = false
+		}
+		for i := 0; i < n; i++ {
+			switch {
+			case in[i] == keyEscape && i == n-1 && err == nil:
+				a.pending = true
+			case in[i] == keyEscape && i+1 < n && (in[i+1] == '\r' || in[i+1] == '\n'):
+				a.buf = append(a.buf, newlineMarker...)
+				i++
+			default:
+				a.buf = append(a.buf, in[i])
+			}
+		}
+		if err != nil && len(a.buf) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, a.buf)
+	a.buf = a.buf[n:]
+	return n, nil
+}
+
+// inputHistoryPath はプロジェクトごとの入力履歴のファイルのパスを返す
+func inputHistoryPath(projectPath string) (string, error) {
+	dataDir, err := localDataDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(projectPath))
+	return filepath.Join(dataDir, "history", hex.EncodeToString(sum[:8])+".jsonl"), nil
+}
+
+// inputHistory は入力履歴。1行に1件をJSONの文字列として追記し、次回の起動時に読み込む
+type inputHistory struct {
+	path    string   // 空の場合はファイルに残さない
+	entries []string // 古い順
+}
+
+// loadInputHistory はファイルから直近inputHistorySize件の履歴を読み込む。読めない場合は空の履歴から始める
+func loadInputHistory(path string) *inputHistory {
+	h := &inputHistory{path: path}
+	if path == "" {
+		return h
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, line := range lines {
+		var entry string
+		if json.Unmarshal([]byte(line), &entry) == nil && entry != "" {
+			h.entries = append(h.entries, entry)
+		}
+	}
+	if len(h.entries) > inputHistorySize {
+		h.entries = h.entries[len(h.entries)-inputHistorySize:]
+	}
+	// ファイルが大きくなり続けないよう、古い履歴を捨てて書き直す
+	if len(lines) > 2*inputHistorySize {
+		h.rewrite()
+	}
+	return h
+}
+
+// Add は入力を履歴に加えてファイルに追記する。空の入力や直前と同じ入力は加えない
+// 複数行の入力は1件として加える
+func (h *inputHistory) Add(entry string) {
+	if strings.TrimSpace(entry) == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > inputHistorySize {
+		h.entries = h.entries[1:]
+	}
+	if h.path == "" {
+		return
+	}
+	if err := h.append(entry); err != nil {
+		fmt.Printf("\r\nWarning: failed to save input history: %v\r\n", err)
+		h.path = ""
+	}
+}
+
+func (h *inputHistory) Len() int {
+	return len(h.entries)
+}
+
+// At は新しい順にidx番目の入力を返す
+func (h *inputHistory) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
+
+// terminalHistory はterm.Terminalに渡す履歴。複数行の入力を1件として残すため、
+// 行ごとの追加は無視してterminalLineReaderが入力全体を追加し、改行はnewlineMarkerにして1行で編集できるようにする
+type terminalHistory struct {
+	*inputHistory
+}
+
+func (h terminalHistory) Add(string) {}
+
+func (h terminalHistory) At(idx int) string {
+	return strings.ReplaceAll(h.inputHistory.At(idx), "\n", newlineMarker)
+}
+
+func (h *inputHistory) append(entry string) error {
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(entry)
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rewrite は現在の履歴でファイルを置き換える
+func (h *inputHistory) rewrite() {
+	var b strings.Builder
+	for _, entry := range h.entries {
+		data, _ := json.Marshal(entry)
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return
+	}
+	os.Rename(tmp, h.path)
+}
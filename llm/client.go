@@ -0,0 +1,23 @@
+package llm
+
+import (
+	"context"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Client はチャット補完とツール呼び出しを提供するLLMバックエンドのインターフェース
+// メッセージ・ツール定義・応答の表現には、多くのバックエンドが対応しているOpenAI互換の型を共通形式として使う
+type Client interface {
+	// CreateChatCompletion は応答全体をまとめて返す
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	// CreateChatCompletionStream は応答を差分ごとに受け取るストリームを返す
+	CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (Stream, error)
+}
+
+// Stream はストリーミング応答の受信口
+type Stream interface {
+	// Recv は次の差分を返す。ストリームの終端ではio.EOFを返す
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
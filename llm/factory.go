@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	ProviderOpenAI           = "openai"
+	ProviderOllama           = "ollama"
+	ProviderOpenAICompatible = "openai-compatible"
+	ProviderAzure            = "azure"
+
+	defaultOllamaBaseURL   = "http://localhost:11434/v1"
+	defaultAzureAPIVersion = "2024-10-21"
+)
+
+const (
+	ToolCallingAuto   = "auto"   // ネイティブの関数呼び出しを使い、非対応ならプロンプトベースに切り替える
+	ToolCallingNative = "native" // ネイティブの関数呼び出しだけを使う
+	ToolCallingPrompt = "prompt" // ツールをプロンプトで説明し、応答中のブロックから呼び出しを読み取る
+)
+
+// Options はLLMクライアントの作成に必要な設定
+type Options struct {
+	Provider string // openai, ollama, openai-compatible, azure（空の場合はopenai）
+	BaseURL  string // APIのベースURL（ollamaの場合は省略可能、azureの場合はリソースのエンドポイント）
+	APIKey   string
+	// AzureDeployment はazureで使うデプロイメント名。空の場合はモデル名をデプロイメント名として扱う
+	AzureDeployment string
+	// AzureAPIVersion はazureのAPIバージョン。空の場合はdefaultAzureAPIVersion
+	AzureAPIVersion string
+	// ToolCalling はツール呼び出しの方式（auto, native, prompt）。空の場合はopenaiならnative、それ以外はauto
+	ToolCalling string
+	// MaxRetries はレート制限やサーバーエラーなどの一時的な失敗でリクエストをやり直す回数。0の場合はdefaultMaxRetries、負の場合はやり直さない
+	MaxRetries int
+	Warn       func(message string) // バックエンドの制約で動作を変えたときやリクエストをやり直すときの通知先
+}
+
+// New は設定に応じたバックエンドのClientを作成する
+func New(opts Options) (Client, error) {
+	client, err := newBackend(opts)
+	if err != nil {
+		return nil, err
+	}
+	client = newRetryClient(client, opts.MaxRetries, opts.Warn)
+
+	toolCalling := opts.ToolCalling
+	if toolCalling == "" {
+		toolCalling = ToolCallingNative
+		if opts.Provider != "" && opts.Provider != ProviderOpenAI {
+			// ローカルモデルは関数呼び出しに対応していないことがあるので、非対応ならプロンプトベースに切り替える
+			toolCalling = ToolCallingAuto
+		}
+	}
+
+	switch toolCalling {
+	case ToolCallingNative:
+		return client, nil
+	case ToolCallingAuto:
+		return newToolFallbackClient(client, opts.Warn), nil
+	case ToolCallingPrompt:
+		return newPromptToolClient(client), nil
+	default:
+		return nil, fmt.Errorf("unknown tool calling mode: %s", toolCalling)
+	}
+}
+
+// newBackend はプロバイダーに応じたAPIクライアントを作成する
+func newBackend(opts Options) (Client, error) {
+	switch opts.Provider {
+	case "", ProviderOpenAI:
+		if opts.APIKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY environment variable is not set")
+		}
+		config := openai.DefaultConfig(opts.APIKey)
+		if opts.BaseURL != "" {
+			config.BaseURL = opts.BaseURL
+		}
+		config.HTTPClient = newRetryAfterRecorder()
+		return &openAIClient{client: openai.NewClientWithConfig(config)}, nil
+
+	case ProviderOllama, ProviderOpenAICompatible:
+		baseURL := opts.BaseURL
+		if baseURL == "" {
+			if opts.Provider != ProviderOllama {
+				return nil, fmt.Errorf("base_url is required for provider %s", opts.Provider)
+			}
+			baseURL = defaultOllamaBaseURL
+		}
+		// ローカルのサーバーは多くの場合APIキーを検証しないが、空だと送信されないためダミーを設定する
+		apiKey := opts.APIKey
+		if apiKey == "" {
+			apiKey = opts.Provider
+		}
+		config := openai.DefaultConfig(apiKey)
+		config.BaseURL = baseURL
+		config.HTTPClient = newRetryAfterRecorder()
+		return &openAIClient{client: openai.NewClientWithConfig(config)}, nil
+
+	case ProviderAzure:
+		if opts.APIKey == "" {
+			return nil, fmt.Errorf("AZURE_OPENAI_API_KEY environment variable is not set")
+		}
+		if opts.BaseURL == "" {
+			return nil, fmt.Errorf("base_url is required for provider %s (e.g. https://<resource>.openai.azure.com/)", opts.Provider)
+		}
+		config := openai.DefaultAzureConfig(opts.APIKey, opts.BaseURL)
+		config.APIVersion = opts.AzureAPIVersion
+		if config.APIVersion == "" {
+			config.APIVersion = defaultAzureAPIVersion
+		}
+		// Azureではモデル名ではなくデプロイメント名でリクエスト先が決まる
+		if opts.AzureDeployment != "" {
+			config.AzureModelMapperFunc = func(model string) string { return opts.AzureDeployment }
+		}
+		config.HTTPClient = newRetryAfterRecorder()
+		return &openAIClient{client: openai.NewClientWithConfig(config)}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", opts.Provider)
+	}
+}
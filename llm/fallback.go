@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// toolFallbackClient はモデルが関数呼び出しに対応していない場合に、
+// プロンプトベースのツール呼び出しに切り替えてリクエストをやり直すClient
+type toolFallbackClient struct {
+	native           Client
+	prompt           Client
+	warn             func(message string)
+	toolsUnsupported atomic.Bool // サーバーモードでは複数のセッションから同時に使われる
+}
+
+func newToolFallbackClient(inner Client, warn func(message string)) *toolFallbackClient {
+	return &toolFallbackClient{native: inner, prompt: newPromptToolClient(inner), warn: warn}
+}
+
+func (c *toolFallbackClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	if c.toolsUnsupported.Load() {
+		return c.prompt.CreateChatCompletion(ctx, request)
+	}
+	resp, err := c.native.CreateChatCompletion(ctx, request)
+	if c.switchToPrompt(request, err) {
+		return c.prompt.CreateChatCompletion(ctx, request)
+	}
+	return resp, err
+}
+
+func (c *toolFallbackClient) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (Stream, error) {
+	if c.toolsUnsupported.Load() {
+		return c.prompt.CreateChatCompletionStream(ctx, request)
+	}
+	stream, err := c.native.CreateChatCompletionStream(ctx, request)
+	if c.switchToPrompt(request, err) {
+		return c.prompt.CreateChatCompletionStream(ctx, request)
+	}
+	return stream, err
+}
+
+// switchToPrompt はエラーが関数呼び出し非対応によるものかを判定し、以降プロンプトベースのツール呼び出しを使うよう記録する
+func (c *toolFallbackClient) switchToPrompt(request openai.ChatCompletionRequest, err error) bool {
+	if err == nil || len(request.Tools) == 0 || !isToolsUnsupportedError(err) {
+		return false
+	}
+
+	// 同時に失敗したリクエストが複数あっても警告は1回だけ表示する
+	if c.toolsUnsupported.Swap(true) {
+		return true
+	}
+	if c.warn != nil {
+		c.warn("the model does not support function calling; describing tools in the prompt instead")
+	}
+	return true
+}
+
+// isToolsUnsupportedError はモデルがツールに対応していないことを示すエラーかを返す
+// 例: Ollamaの "registry.ollama.ai/library/gemma:2b does not support tools"
+func isToolsUnsupportedError(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "does not support tools") ||
+		strings.Contains(message, "tools is not supported") ||
+		strings.Contains(message, "tool use is not supported") ||
+		strings.Contains(message, "function calling is not supported")
+}
@@ -0,0 +1,20 @@
+package llm
+
+import (
+	"context"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// openAIClient はOpenAI APIをバックエンドとするClient
+type openAIClient struct {
+	client *openai.Client
+}
+
+func (c *openAIClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return c.client.CreateChatCompletion(ctx, request)
+}
+
+func (c *openAIClient) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (Stream, error) {
+	return c.client.CreateChatCompletionStream(ctx, request)
+}
@@ -0,0 +1,284 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// promptToolClient は関数呼び出しに対応していないモデル向けに、ツールをプロンプトで説明し、
+// 応答中の ```tool_call ブロックをツール呼び出しに変換するClient
+// エージェントからはネイティブの関数呼び出しと同じ形式で扱える
+type promptToolClient struct {
+	inner   Client
+	counter atomic.Int64 // ツール呼び出しIDの採番用
+}
+
+func newPromptToolClient(inner Client) *promptToolClient {
+	return &promptToolClient{inner: inner}
+}
+
+func (c *promptToolClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	hasTools := len(request.Tools) > 0
+	resp, err := c.inner.CreateChatCompletion(ctx, toPromptToolRequest(request))
+	if err != nil || !hasTools || len(resp.Choices) == 0 {
+		return resp, err
+	}
+
+	choice := &resp.Choices[0]
+	content, toolCalls := c.parseToolCalls(choice.Message.Content)
+	choice.Message.Content = content
+	if len(toolCalls) > 0 {
+		choice.Message.ToolCalls = toolCalls
+		// lengthなどで途中で止まった場合は、そのことが伝わるよう終了理由を残す
+		if choice.FinishReason == openai.FinishReasonStop {
+			choice.FinishReason = openai.FinishReasonToolCalls
+		}
+	}
+	return resp, nil
+}
+
+func (c *promptToolClient) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (Stream, error) {
+	hasTools := len(request.Tools) > 0
+	stream, err := c.inner.CreateChatCompletionStream(ctx, toPromptToolRequest(request))
+	if err != nil || !hasTools {
+		return stream, err
+	}
+	return &promptToolStream{inner: stream, client: c}, nil
+}
+
+// toolCallBlockPattern は応答中のツール呼び出しブロックにマッチする
+var toolCallBlockPattern = regexp.MustCompile("(?s)```tool_call[ \\t]*\\r?\\n(.*?)```")
+
+// promptToolCall はツール呼び出しブロックの中身
+type promptToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// parseToolCalls は応答本文からツール呼び出しブロックを取り出し、残りの本文とツール呼び出しを返す
+// JSONとして解釈できないブロックは本文にそのまま残す
+func (c *promptToolClient) parseToolCalls(content string) (string, []openai.ToolCall) {
+	var toolCalls []openai.ToolCall
+	rest := toolCallBlockPattern.ReplaceAllStringFunc(content, func(block string) string {
+		body := toolCallBlockPattern.FindStringSubmatch(block)[1]
+
+		var call promptToolCall
+		if err := json.Unmarshal([]byte(strings.TrimSpace(body)), &call); err != nil || call.Name == "" {
+			return block
+		}
+
+		// 引数はオブジェクトでもJSON文字列でも受け付ける
+		arguments := string(call.Arguments)
+		var encoded string
+		if err := json.Unmarshal(call.Arguments, &encoded); err == nil {
+			arguments = encoded
+		}
+		if arguments == "" || arguments == "null" {
+			arguments = "{}"
+		}
+
+		toolCalls = append(toolCalls, openai.ToolCall{
+			ID:   fmt.Sprintf("call_prompt_%d", c.counter.Add(1)),
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      call.Name,
+				Arguments: arguments,
+			},
+		})
+		return ""
+	})
+	return strings.TrimSpace(rest), toolCalls
+}
+
+// toPromptToolRequest はツール定義をシステムプロンプトに埋め込み、
+// ツール呼び出しを含む履歴をテキストだけのメッセージに変換したリクエストを返す
+func toPromptToolRequest(request openai.ChatCompletionRequest) openai.ChatCompletionRequest {
+	if len(request.Tools) == 0 && !hasToolMessages(request.Messages) {
+		return request
+	}
+
+	toolNames := map[string]string{}
+	var messages []openai.ChatCompletionMessage
+	for _, msg := range request.Messages {
+		switch {
+		case msg.Role == openai.ChatMessageRoleAssistant && len(msg.ToolCalls) > 0:
+			var b strings.Builder
+			b.WriteString(msg.Content)
+			for _, toolCall := range msg.ToolCalls {
+				toolNames[toolCall.ID] = toolCall.Function.Name
+				if b.Len() > 0 {
+					b.WriteString("\n\n")
+				}
+				b.WriteString(formatToolCallBlock(toolCall.Function))
+			}
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: b.String(),
+			})
+		case msg.Role == openai.ChatMessageRoleTool:
+			name := toolNames[msg.ToolCallID]
+			if name == "" {
+				name = "tool"
+			}
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: fmt.Sprintf("Result of %s:\n%s", name, msg.Content),
+			})
+		default:
+			messages = append(messages, msg)
+		}
+	}
+
+	if len(request.Tools) > 0 {
+		instructions := toolInstructions(request.Tools)
+		if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem {
+			messages[0].Content += "\n\n" + instructions
+		} else {
+			messages = append([]openai.ChatCompletionMessage{{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: instructions,
+			}}, messages...)
+		}
+	}
+
+	request.Messages = messages
+	request.Tools = nil
+	request.ToolChoice = nil
+	request.ParallelToolCalls = nil
+	return request
+}
+
+func hasToolMessages(messages []openai.ChatCompletionMessage) bool {
+	for _, msg := range messages {
+		if msg.Role == openai.ChatMessageRoleTool || len(msg.ToolCalls) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// toolInstructions はツールの一覧と呼び出し方をモデルに説明するプロンプトを作成する
+func toolInstructions(tools []openai.Tool) string {
+	var b strings.Builder
+	b.WriteString("# Tools\n")
+	b.WriteString("You can call the following tools. To call a tool, write a fenced block exactly in this format:\n\n")
+	b.WriteString("```tool_call\n{\"name\": \"<tool name>\", \"arguments\": {<arguments as a JSON object>}}\n```\n\n")
+	b.WriteString("You may write several blocks to call several tools. After the tool calls, stop and wait: ")
+	b.WriteString("the results will be sent back to you in the next message. Do not invent tool results. ")
+	b.WriteString("When you no longer need tools, answer normally without any tool_call block.\n\n")
+	b.WriteString("Available tools:\n")
+	for _, tool := range tools {
+		if tool.Function == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n%s\n", tool.Function.Name, tool.Function.Description)
+		if tool.Function.Parameters != nil {
+			if params, err := json.Marshal(tool.Function.Parameters); err == nil {
+				fmt.Fprintf(&b, "Parameters (JSON Schema): %s\n", params)
+			}
+		}
+	}
+	return b.String()
+}
+
+// formatToolCallBlock はツール呼び出しを履歴用のブロックに整形する
+func formatToolCallBlock(call openai.FunctionCall) string {
+	arguments := json.RawMessage(call.Arguments)
+	if !json.Valid(arguments) {
+		arguments = json.RawMessage("{}")
+	}
+	body, _ := json.Marshal(promptToolCall{Name: call.Name, Arguments: arguments})
+	return "```tool_call\n" + string(body) + "\n```"
+}
+
+// promptToolStream はストリーム全体を受信してからツール呼び出しブロックを変換して返す
+// ブロックの途中では判定できないため、この方式では応答が逐次表示されない
+type promptToolStream struct {
+	inner   Stream
+	client  *promptToolClient
+	pending []openai.ChatCompletionStreamResponse
+	loaded  bool
+}
+
+func (s *promptToolStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if !s.loaded {
+		if err := s.load(); err != nil {
+			return openai.ChatCompletionStreamResponse{}, err
+		}
+		s.loaded = true
+	}
+	if len(s.pending) == 0 {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	chunk := s.pending[0]
+	s.pending = s.pending[1:]
+	return chunk, nil
+}
+
+func (s *promptToolStream) Close() error {
+	return s.inner.Close()
+}
+
+// load は元のストリームを最後まで読み、本文とツール呼び出しを1つのチャンクにまとめる
+func (s *promptToolStream) load() error {
+	var content strings.Builder
+	var last openai.ChatCompletionStreamResponse
+	var finishReason openai.FinishReason
+	var usage *openai.Usage
+	received := false
+
+	for {
+		chunk, err := s.inner.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		last = chunk
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		received = true
+		content.WriteString(chunk.Choices[0].Delta.Content)
+		if chunk.Choices[0].FinishReason != "" {
+			finishReason = chunk.Choices[0].FinishReason
+		}
+	}
+	if !received {
+		return nil
+	}
+
+	text, toolCalls := s.client.parseToolCalls(content.String())
+	delta := openai.ChatCompletionStreamChoiceDelta{
+		Role:    openai.ChatMessageRoleAssistant,
+		Content: text,
+	}
+	for i, toolCall := range toolCalls {
+		index := i
+		toolCall.Index = &index
+		delta.ToolCalls = append(delta.ToolCalls, toolCall)
+	}
+	if len(toolCalls) > 0 && finishReason == openai.FinishReasonStop {
+		finishReason = openai.FinishReasonToolCalls
+	}
+
+	last.Choices = []openai.ChatCompletionStreamChoice{{Delta: delta, FinishReason: finishReason}}
+	last.Usage = nil
+	s.pending = append(s.pending, last)
+	if usage != nil {
+		s.pending = append(s.pending, openai.ChatCompletionStreamResponse{ID: last.ID, Model: last.Model, Usage: usage})
+	}
+	return nil
+}
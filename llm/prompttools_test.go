@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeClient は決まった応答を返すClient
+type fakeClient struct {
+	resp openai.ChatCompletionResponse
+}
+
+func (c *fakeClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return c.resp, nil
+}
+
+func (c *fakeClient) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (Stream, error) {
+	panic("not implemented")
+}
+
+func TestPromptToolClientFinishReason(t *testing.T) {
+	content := "ファイルを読みます\n```tool_call\n{\"name\": \"readFile\", \"arguments\": {\"path\": \"a.txt\"}}\n```"
+	tests := []struct {
+		name         string
+		finishReason openai.FinishReason
+		want         openai.FinishReason
+	}{
+		{name: "stopはtool_callsにする", finishReason: openai.FinishReasonStop, want: openai.FinishReasonToolCalls},
+		{name: "lengthは途中で止まったことが伝わるよう残す", finishReason: openai.FinishReasonLength, want: openai.FinishReasonLength},
+		{name: "content_filterは残す", finishReason: openai.FinishReasonContentFilter, want: openai.FinishReasonContentFilter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &fakeClient{resp: openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+				FinishReason: tt.finishReason,
+			}}}}
+			request := openai.ChatCompletionRequest{Tools: []openai.Tool{{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{Name: "readFile"}}}}
+
+			resp, err := newPromptToolClient(inner).CreateChatCompletion(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			choice := resp.Choices[0]
+			if len(choice.Message.ToolCalls) != 1 {
+				t.Fatalf("ToolCalls = %+v, want 1 call", choice.Message.ToolCalls)
+			}
+			if choice.FinishReason != tt.want {
+				t.Errorf("FinishReason = %q, want %q", choice.FinishReason, tt.want)
+			}
+		})
+	}
+}
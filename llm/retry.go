@@ -0,0 +1,196 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// defaultMaxRetries は一時的なエラーでリクエストをやり直す回数のデフォルト
+	defaultMaxRetries = 4
+	// retryBaseDelay は最初のやり直しまでの待ち時間。やり直すごとに倍にする
+	retryBaseDelay = time.Second
+	// retryMaxDelay は待ち時間の上限。Retry-Afterがこれより長い場合はやり直さずにエラーを返す
+	retryMaxDelay = time.Minute
+)
+
+// retryClient はレート制限やサーバーエラー、ネットワークエラーのような一時的な失敗を、
+// 指数バックオフで待ってからやり直すClient
+// ストリーミングではストリームを開くまでをやり直す。受信中に切れた場合は表示済みの応答があるのでやり直さない
+type retryClient struct {
+	inner      Client
+	maxRetries int
+	warn       func(message string)
+}
+
+// newRetryClient はmaxRetriesが0の場合はdefaultMaxRetries回、負の場合はやり直さないClientを作る
+func newRetryClient(inner Client, maxRetries int, warn func(message string)) Client {
+	if maxRetries < 0 {
+		return inner
+	}
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	}
+	return &retryClient{inner: inner, maxRetries: maxRetries, warn: warn}
+}
+
+func (c *retryClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	var resp openai.ChatCompletionResponse
+	err := c.retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.inner.CreateChatCompletion(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+func (c *retryClient) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (Stream, error) {
+	var stream Stream
+	err := c.retry(ctx, func(ctx context.Context) error {
+		var err error
+		stream, err = c.inner.CreateChatCompletionStream(ctx, request)
+		return err
+	})
+	return stream, err
+}
+
+// retry はcallが一時的なエラーで失敗する間、待ち時間を伸ばしながらやり直す
+// サーバーがRetry-Afterで待ち時間を指定した場合はそれに従う
+func (c *retryClient) retry(ctx context.Context, call func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		hint := &retryAfterHint{}
+		err := call(context.WithValue(ctx, retryAfterKey{}, hint))
+		if err == nil || ctx.Err() != nil || !isRetryableError(err) {
+			return err
+		}
+		if attempt >= c.maxRetries {
+			return fmt.Errorf("API request still failing after %d attempts, giving up: %w", attempt+1, err)
+		}
+
+		delay := hint.delay
+		if delay > retryMaxDelay {
+			return fmt.Errorf("API request was rejected and the server asked to retry after %s: %w", delay.Round(time.Second), err)
+		}
+		if delay <= 0 {
+			delay = backoffDelay(attempt)
+		}
+		if c.warn != nil {
+			c.warn(fmt.Sprintf("API request failed (%v); retrying in %s (%d/%d)", err, delay.Round(100*time.Millisecond), attempt+1, c.maxRetries))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoffDelay はattempt回目のやり直しまでの待ち時間を返す
+// 複数のプロセスが同時にやり直さないよう、後半の半分をランダムにする
+func backoffDelay(attempt int) time.Duration {
+	// シフトで倍にするとattemptが大きい場合にオーバーフローするので、上限に達したら倍にするのをやめる
+	delay := retryBaseDelay
+	for range attempt {
+		if delay >= retryMaxDelay {
+			break
+		}
+		delay *= 2
+	}
+	delay = min(delay, retryMaxDelay)
+	return delay/2 + rand.N(delay/2)
+}
+
+// isRetryableError はやり直せば成功する見込みのある一時的なエラーかを返す
+func isRetryableError(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		// 利用枠の不足はやり直しても解消しない
+		if apiErr.Type == "insufficient_quota" || apiErr.Code == "insufficient_quota" {
+			return false
+		}
+		return isRetryableStatus(apiErr.HTTPStatusCode)
+	}
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) && requestErr.HTTPStatusCode != 0 {
+		return isRetryableStatus(requestErr.HTTPStatusCode)
+	}
+
+	// 接続の失敗やタイムアウト、応答の途中での切断
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isRetryableStatus はやり直す対象のHTTPステータスかを返す
+func isRetryableStatus(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// retryAfterKey はretryAfterHintをリクエストのコンテキストに入れるためのキー
+type retryAfterKey struct{}
+
+// retryAfterHint はサーバーが応答で指定したやり直しまでの待ち時間
+// go-openaiのエラーには応答ヘッダーが含まれないので、retryAfterRecorderがコンテキスト経由で記録する
+type retryAfterHint struct {
+	delay time.Duration
+}
+
+// retryAfterRecorder はエラー応答のRetry-Afterヘッダーをリクエストのコンテキストのヒントに記録するHTTPクライアント
+type retryAfterRecorder struct {
+	client *http.Client
+}
+
+func newRetryAfterRecorder() *retryAfterRecorder {
+	return &retryAfterRecorder{client: &http.Client{}}
+}
+
+func (r *retryAfterRecorder) Do(req *http.Request) (*http.Response, error) {
+	resp, err := r.client.Do(req)
+	if err != nil || resp.StatusCode < http.StatusBadRequest {
+		return resp, err
+	}
+	if hint, ok := req.Context().Value(retryAfterKey{}).(*retryAfterHint); ok {
+		hint.delay = parseRetryAfter(resp.Header, time.Now())
+	}
+	return resp, nil
+}
+
+// parseRetryAfter はretry-after-ms（OpenAIの拡張）またはRetry-After（秒数または日時）から待ち時間を返す。指定がなければ0を返す
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,28 @@
+package llm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 0, max: retryBaseDelay},
+		{attempt: 1, max: 2 * retryBaseDelay},
+		{attempt: 3, max: 8 * retryBaseDelay},
+		{attempt: 6, max: retryMaxDelay},
+		// シフトでは桁あふれする回数でも上限の待ち時間になる
+		{attempt: 40, max: retryMaxDelay},
+		{attempt: 64, max: retryMaxDelay},
+		{attempt: 1000, max: retryMaxDelay},
+	}
+	for _, tt := range tests {
+		got := backoffDelay(tt.attempt)
+		if got < tt.max/2 || got >= tt.max {
+			t.Errorf("backoffDelay(%d) = %v, want in [%v, %v)", tt.attempt, got, tt.max/2, tt.max)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// failedEditLoopThreshold は同じファイルへの編集が何回失敗したらループとみなすか
+const failedEditLoopThreshold = 3
+
+// loopDetector はステップをまたいで繰り返されるツール呼び出しを検出する
+type loopDetector struct {
+	steps       []string // 各ステップのツール呼び出しを表すシグネチャ
+	stepCalls   [][]openai.FunctionCall
+	failedEdits map[string]int
+	warned      bool // 既にモデルへ是正メッセージを送ったかどうか
+}
+
+func newLoopDetector() *loopDetector {
+	return &loopDetector{failedEdits: map[string]int{}}
+}
+
+// RecordStep は1ステップ分のツール呼び出しを記録する
+func (d *loopDetector) RecordStep(toolCalls []openai.ToolCall) {
+	var keys []string
+	var calls []openai.FunctionCall
+	for _, toolCall := range toolCalls {
+		keys = append(keys, toolCallKey(toolCall.Function))
+		calls = append(calls, toolCall.Function)
+	}
+	sort.Strings(keys)
+	d.steps = append(d.steps, strings.Join(keys, "\x01"))
+	d.stepCalls = append(d.stepCalls, calls)
+}
+
+// RecordResult はツールの実行結果を記録し、書き込み系ツールの失敗をファイルごとに数える
+func (d *loopDetector) RecordResult(call openai.FunctionCall, result string) {
+	if call.Name != "editFile" && call.Name != "writeFile" {
+		return
+	}
+
+	var res struct {
+		Success bool `json:"success"`
+	}
+	if err := json.Unmarshal([]byte(result), &res); err != nil || res.Success {
+		return
+	}
+	if path := toolCallPath(call); path != "" {
+		d.failedEdits[path]++
+	}
+}
+
+// Detect はループしている場合にその説明を返す。ループしていなければ空文字列を返す
+func (d *loopDetector) Detect() string {
+	n := len(d.steps)
+
+	// 同じ呼び出しを3ステップ連続で繰り返している
+	if n >= 3 && d.steps[n-1] == d.steps[n-2] && d.steps[n-2] == d.steps[n-3] {
+		return fmt.Sprintf("the same tool call (%s) was repeated in the last 3 steps", describeCalls(d.stepCalls[n-1]))
+	}
+
+	// 2つの呼び出しを交互に繰り返している (A, B, A, B)
+	if n >= 4 && d.steps[n-1] == d.steps[n-3] && d.steps[n-2] == d.steps[n-4] && d.steps[n-1] != d.steps[n-2] {
+		return fmt.Sprintf("alternating between the same two tool calls (%s) and (%s)",
+			describeCalls(d.stepCalls[n-2]), describeCalls(d.stepCalls[n-1]))
+	}
+
+	// 同じファイルへの編集が繰り返し失敗している
+	for path, count := range d.failedEdits {
+		if count >= failedEditLoopThreshold {
+			return fmt.Sprintf("edits to %s failed %d times", path, count)
+		}
+	}
+
+	return ""
+}
+
+// Warn は是正済みとして記録し、ループから抜け出すようモデルに促すシステムメッセージを返す
+// 編集の失敗回数はリセットし、是正後に再び失敗が重なった場合にだけ中断するようにする
+func (d *loopDetector) Warn(reason string) openai.ChatCompletionMessage {
+	d.warned = true
+	d.failedEdits = map[string]int{}
+	return openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleSystem,
+		Content: fmt.Sprintf(`Loop detected: %s.
+Repeating the same actions will not produce a different result. Stop and reconsider:
+- Re-read the previous tool results instead of requesting them again
+- If an edit keeps failing, read the error message and change the approach (or the file content) before retrying
+- If you cannot make progress, stop calling tools and explain to the user what is blocking you`, reason),
+	}
+}
+
+// Summary はこれまでのステップで呼び出したツールの一覧を返す
+func (d *loopDetector) Summary() string {
+	var b strings.Builder
+	b.WriteString("Tool calls so far:\n")
+	for i, calls := range d.stepCalls {
+		fmt.Fprintf(&b, "  step %d: %s\n", i+1, describeCalls(calls))
+	}
+	return b.String()
+}
+
+// describeCalls はツール呼び出しを "readFile main.go, list ." のような短い文字列にする
+func describeCalls(calls []openai.FunctionCall) string {
+	var parts []string
+	for _, call := range calls {
+		part := call.Name
+		if path := toolCallPath(call); path != "" {
+			part += " " + path
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// toolCallPath はツール呼び出しの引数からpathを取り出す
+func toolCallPath(call openai.FunctionCall) string {
+	var args struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+		return ""
+	}
+	return args.Path
+}
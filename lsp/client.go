@@ -0,0 +1,496 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client は標準入出力で起動した言語サーバーと通信するLSPクライアント
+// エージェントの編集結果の検証とコードの移動に絞り、ドキュメントの同期・診断の受信・定義と参照の検索だけを扱う
+type Client struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	writeM sync.Mutex
+
+	mu          sync.Mutex
+	nextID      int
+	pending     map[int]chan response
+	diagnostics map[string][]Diagnostic // URIごとの最新の診断
+	published   chan string             // 診断を受信したURIの通知
+	versions    map[string]int          // 開いているドキュメントのバージョン
+	closed      bool
+}
+
+// Diagnostic は言語サーバーが報告したエラーや警告
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"` // 1: Error, 2: Warning, 3: Information, 4: Hint
+	Source   string `json:"source,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Severityの値
+const (
+	SeverityError   = 1
+	SeverityWarning = 2
+)
+
+// Range はドキュメント内の範囲（0始まり）
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Position はドキュメント内の位置（0始まり）。CharacterはUTF-16のコード単位で数える
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Location はファイル内の範囲
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type response struct {
+	result json.RawMessage
+	err    error
+}
+
+// Start は言語サーバーを起動し、rootDirをワークスペースとして初期化する
+func Start(command []string, rootDir string) (*Client, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("language server command is empty")
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = rootDir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin of %s: %w", command[0], err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdout of %s: %w", command[0], err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", command[0], err)
+	}
+
+	c := &Client{
+		cmd:         cmd,
+		stdin:       stdin,
+		pending:     map[int]chan response{},
+		diagnostics: map[string][]Diagnostic{},
+		published:   make(chan string, 64),
+		versions:    map[string]int{},
+	}
+	go c.readLoop(bufio.NewReader(stdout))
+
+	rootURI := FileURI(rootDir)
+	initParams := map[string]any{
+		"processId": os.Getpid(),
+		"rootUri":   rootURI,
+		"workspaceFolders": []map[string]string{
+			{"uri": rootURI, "name": filepath.Base(rootDir)},
+		},
+		"capabilities": map[string]any{
+			"textDocument": map[string]any{
+				"synchronization":    map[string]any{"didSave": false},
+				"publishDiagnostics": map[string]any{"versionSupport": true},
+				"definition":         map[string]any{"linkSupport": true},
+				"references":         map[string]any{},
+			},
+			"workspace": map[string]any{"workspaceFolders": true, "configuration": true},
+		},
+	}
+	if _, err := c.call("initialize", initParams, 30*time.Second); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to initialize %s: %w", command[0], err)
+	}
+	if err := c.notify("initialized", map[string]any{}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// FileURI はファイルパスをfile:// URIに変換する
+func FileURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// FilePath はfile:// URIをファイルパスに変換する。file:// URIでない場合はそのまま返す
+func FilePath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// Diagnostics はファイルの最新の内容を言語サーバーに送り、診断が届くまでtimeoutを上限に待って返す
+func (c *Client) Diagnostics(path, languageID, content string, timeout time.Duration) ([]Diagnostic, error) {
+	uri := FileURI(path)
+
+	// 以前の通知を捨ててから変更を送り、この変更に対する診断だけを待つ
+	c.drainPublished()
+	c.mu.Lock()
+	delete(c.diagnostics, uri)
+	c.mu.Unlock()
+
+	if err := c.syncDocument(uri, languageID, content); err != nil {
+		return nil, err
+	}
+
+	// 言語サーバーは構文チェックと型チェックなど複数回に分けて診断を送ることがあるので、
+	// 最初の通知の後も少しの間は更新を待つ
+	deadline := time.After(timeout)
+	var settle <-chan time.Time
+	for {
+		select {
+		case published := <-c.published:
+			if published == uri {
+				settle = time.After(500 * time.Millisecond)
+			}
+		case <-settle:
+			return c.latestDiagnostics(uri), nil
+		case <-deadline:
+			return c.latestDiagnostics(uri), nil
+		}
+	}
+}
+
+// Definition はファイルの位置にあるシンボルの定義の場所を返す
+func (c *Client) Definition(path, languageID, content string, pos Position, timeout time.Duration) ([]Location, error) {
+	uri := FileURI(path)
+	if err := c.syncDocument(uri, languageID, content); err != nil {
+		return nil, err
+	}
+	result, err := c.call("textDocument/definition", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     pos,
+	}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return parseLocations(result)
+}
+
+// References はファイルの位置にあるシンボルを参照している場所を返す
+func (c *Client) References(path, languageID, content string, pos Position, includeDeclaration bool, timeout time.Duration) ([]Location, error) {
+	uri := FileURI(path)
+	if err := c.syncDocument(uri, languageID, content); err != nil {
+		return nil, err
+	}
+	result, err := c.call("textDocument/references", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     pos,
+		"context":      map[string]any{"includeDeclaration": includeDeclaration},
+	}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return parseLocations(result)
+}
+
+// parseLocations はnull、Location、Locationの配列、LocationLinkの配列のいずれかの応答を読み込む
+func parseLocations(raw json.RawMessage) ([]Location, error) {
+	type locationOrLink struct {
+		Location
+		TargetURI            string `json:"targetUri"`
+		TargetSelectionRange *Range `json:"targetSelectionRange"`
+	}
+	var items []locationOrLink
+	trimmed := strings.TrimSpace(string(raw))
+	switch {
+	case trimmed == "" || trimmed == "null":
+		return nil, nil
+	case strings.HasPrefix(trimmed, "["):
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("invalid locations: %w", err)
+		}
+	default:
+		var item locationOrLink
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, fmt.Errorf("invalid location: %w", err)
+		}
+		items = []locationOrLink{item}
+	}
+
+	locations := make([]Location, 0, len(items))
+	for _, item := range items {
+		if item.TargetURI != "" {
+			location := Location{URI: item.TargetURI}
+			if item.TargetSelectionRange != nil {
+				location.Range = *item.TargetSelectionRange
+			}
+			locations = append(locations, location)
+			continue
+		}
+		locations = append(locations, item.Location)
+	}
+	return locations, nil
+}
+
+// syncDocument はドキュメントの最新の内容を言語サーバーに送る。初回はdidOpen、以降はdidChangeを送る
+func (c *Client) syncDocument(uri, languageID, content string) error {
+	c.mu.Lock()
+	version, opened := c.versions[uri]
+	version++
+	c.versions[uri] = version
+	c.mu.Unlock()
+
+	if !opened {
+		return c.notify("textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": languageID, "version": version, "text": content},
+		})
+	}
+	return c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": version},
+		"contentChanges": []map[string]any{{"text": content}},
+	})
+}
+
+func (c *Client) latestDiagnostics(uri string) []Diagnostic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.diagnostics[uri]
+}
+
+func (c *Client) drainPublished() {
+	for {
+		select {
+		case <-c.published:
+		default:
+			return
+		}
+	}
+}
+
+// Close は言語サーバーを終了させる
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	c.call("shutdown", nil, 2*time.Second)
+	c.notify("exit", nil)
+
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.stdin.Close()
+
+	done := make(chan struct{})
+	go func() {
+		c.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		c.cmd.Process.Kill()
+	}
+	return nil
+}
+
+// call はリクエストを送り、応答をtimeoutまで待つ
+func (c *Client) call(method string, params any, timeout time.Duration) (json.RawMessage, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("language server is closed")
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan response, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	rawID := json.RawMessage(strconv.Itoa(id))
+	if err := c.write(map[string]any{"jsonrpc": "2.0", "id": &rawID, "method": method, "params": params}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp.result, resp.err
+	case <-time.After(timeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%s timed out", method)
+	}
+}
+
+// notify は応答を求めない通知を送る
+func (c *Client) notify(method string, params any) error {
+	return c.write(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (c *Client) write(msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeM.Lock()
+	defer c.writeM.Unlock()
+	if _, err := fmt.Fprintf(c.stdin, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		return fmt.Errorf("failed to write to language server: %w", err)
+	}
+	return nil
+}
+
+// readLoop はサーバーからのメッセージを読み続け、応答・通知・リクエストに振り分ける
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			c.failPending(err)
+			return
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			continue
+		}
+
+		switch {
+		case msg.Method == "" && msg.ID != nil:
+			c.handleResponse(msg)
+		case msg.Method != "" && msg.ID != nil:
+			c.handleServerRequest(msg)
+		case msg.Method == "textDocument/publishDiagnostics":
+			c.handlePublishDiagnostics(msg.Params)
+		}
+	}
+}
+
+func (c *Client) handleResponse(msg message) {
+	id, err := strconv.Atoi(string(*msg.ID))
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	ch, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	if msg.Error != nil {
+		ch <- response{err: fmt.Errorf("%s (code %d)", msg.Error.Message, msg.Error.Code)}
+		return
+	}
+	ch <- response{result: msg.Result}
+}
+
+// handleServerRequest はサーバーからのリクエストに最小限の応答を返す。応答しないとサーバーが待ち続けることがある
+func (c *Client) handleServerRequest(msg message) {
+	var result any
+	if msg.Method == "workspace/configuration" {
+		var params struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		result = make([]any, len(params.Items))
+	}
+	c.write(map[string]any{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+}
+
+func (c *Client) handlePublishDiagnostics(raw json.RawMessage) {
+	var params struct {
+		URI         string       `json:"uri"`
+		Version     *int         `json:"version"`
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	// 古いバージョンに対する診断は無視する
+	stale := params.Version != nil && *params.Version < c.versions[params.URI]
+	if !stale {
+		c.diagnostics[params.URI] = params.Diagnostics
+	}
+	c.mu.Unlock()
+	if stale {
+		return
+	}
+
+	select {
+	case c.published <- params.URI:
+	default:
+	}
+}
+
+func (c *Client) failPending(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, ch := range c.pending {
+		ch <- response{err: fmt.Errorf("language server connection closed: %w", err)}
+		delete(c.pending, id)
+	}
+}
+
+// readMessage はContent-Lengthヘッダーで区切られたメッセージを1つ読み込む
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %w", err)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
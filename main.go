@@ -1,300 +1,591 @@
 package main
 
 import (
-	"bufio"
-	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/llm"
 	"github.com/shibayu36/nebula/memory"
 	"github.com/shibayu36/nebula/tools"
 )
 
-const maxToolCallSteps = 5
+// subcommands はサブコマンド名と実装の対応
+var subcommands = map[string]func(args []string) error{
+	"export-patch":    runExportPatch,
+	"export-finetune": runExportFineTune,
+	"export-feedback": runExportFeedback,
+	"export-report":   runExportReport,
+	"acp":             runACP,
+	"task":            runTask,
+	"serve":           runServe,
+	"hook":            runHook,
+	"changelog":       runChangelog,
+	"triage":          runTriage,
+	"tools":           runToolsCommand,
+	"backup":          runBackup,
+}
 
 func main() {
-	// コマンドライン引数の解析
-	listSessions := flag.Bool("list-sessions", false, "List recent sessions for current project")
-	sessionID := flag.String("session", "", "Resume an existing session by ID")
-	flag.Parse()
+	// サブコマンドの実行
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 
-	// メモリ管理の初期化
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Printf("Error: failed to get home directory: %v\n", err)
+	if err := runChat(os.Args[1:], nil); err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
-	dbPath := os.Getenv("NEBULA_DB_PATH")
-	if dbPath == "" {
-		dbPath = filepath.Join(homeDir, ".local", "share", "nebula", "memory.db")
+}
+
+// runChat は対話セッションを開始する。taskが指定された場合はタスクテンプレートで新規セッションを開始する
+func runChat(args []string, task *taskTemplate) error {
+	// コマンドライン引数の解析
+	fs := flag.NewFlagSet("nebula", flag.ExitOnError)
+	listSessionsFlag := fs.Bool("list-sessions", false, "List recent sessions for current project")
+	allProjects := fs.Bool("all-projects", false, "With --list-sessions, list sessions of all projects")
+	listLimit := fs.Int("limit", 20, "With --list-sessions, number of sessions per page")
+	listPage := fs.Int("page", 1, "With --list-sessions, page to show (1 is the most recent)")
+	listJSON := fs.Bool("json", false, "With --list-sessions or --session-stats, print the result as JSON")
+	sessionStatsID := fs.String("session-stats", "", "Show the message counts, token usage and estimated cost of a session by ID")
+	sessionID := fs.String("session", "", "Resume an existing session by ID")
+	force := fs.Bool("force", false, "Resume the session even if another process is using it (that process can no longer write to it)")
+	modelFlag := fs.String("model", "", "Model to use (default: config model or "+defaultModel+"; resumed sessions use the model they were started with)")
+	useWorktree := fs.Bool("worktree", false, "Work in a temporary git worktree and review the aggregate diff before merging it back at the end")
+	snapshotTurns := fs.Bool("snapshot-turns", false, "Snapshot the working tree before each turn that modifies files so it can be undone with /restore")
+	autoApprove := fs.Bool("yes", false, "Approve file changes and command execution without asking (for scripted runs)")
+	readOnly := fs.Bool("read-only", false, "Only provide read-only tools, so the agent cannot change files or run commands")
+	dryRun := fs.Bool("dry-run", false, "Show the files the agent would create, edit or delete without writing them to disk")
+	incognito := fs.Bool("incognito", false, "Do not store message contents, file snapshots or feedback comments; only token usage and cost are recorded")
+	ephemeralFlag := fs.Bool("ephemeral", false, "Keep the session in memory only: nothing (messages, file snapshots, scratch files) is saved after nebula exits")
+	autoGitMode := fs.String("auto-git", "", "Automatically record approved file changes in git: \"stage\" runs git add, \"commit\" commits to a nebula/<session> branch")
+	fs.Parse(args)
+
+	if task != nil && *sessionID != "" {
+		return fmt.Errorf("--session cannot be used with a task")
+	}
+	if *ephemeralFlag && *sessionID != "" {
+		return fmt.Errorf("--session cannot be used with --ephemeral: ephemeral runs cannot see saved sessions")
 	}
+	ephemeral = *ephemeralFlag
 
-	manager, err := memory.NewManager(dbPath)
+	// 設定ファイルの読み込み
+	cfg, err := config.Load()
 	if err != nil {
-		fmt.Printf("Error: failed to initialize memory manager: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+
+	// メモリ管理の初期化
+	manager, err := openManager()
+	if err != nil {
+		return err
 	}
 	defer manager.Close()
+	closeIdleSessions(manager, cfg)
+	manager.SetIncognito(*incognito || cfg.Incognito)
 
 	// セッション一覧表示
-	if *listSessions {
-		sessions, err := manager.GetCurrentProjectSessions(20)
-		if err != nil {
-			fmt.Printf("Error: failed to get sessions: %v\n", err)
-			os.Exit(1)
-		}
+	if *listSessionsFlag {
+		return listSessions(manager, sessionListOptions{
+			allProjects: *allProjects,
+			limit:       *listLimit,
+			page:        *listPage,
+			json:        *listJSON,
+		})
+	}
+	if *sessionStatsID != "" {
+		return showSessionStats(manager, *sessionStatsID, *listJSON)
+	}
 
-		if len(sessions) == 0 {
-			fmt.Println("No sessions found for current project.")
-			return
-		}
+	// LLMクライアントを初期化（APIキーは環境変数から取得）
+	client, err := newLLMClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	// モードの決定
+	modeName := cfg.DefaultMode
+	if modeName == "" {
+		modeName = defaultModeName
+	}
+	mode, err := lookupMode(modeName)
+	if err != nil {
+		return err
+	}
 
-		fmt.Println("Recent sessions:")
-		fmt.Println("ID\t\t\tStarted At\t\t\tLast Message")
-		fmt.Println(strings.Repeat("-", 100))
-		for _, s := range sessions {
-			lastMsg := s.LastMessage
-			if len(lastMsg) > 50 {
-				lastMsg = lastMsg[:50] + "..."
+	// 利用可能なツールのうち、モードで許可されたものを取得
+	availableTools := enabledTools(cfg)
+	approve := *autoApprove || cfg.AutoApprove
+	readOnlyMode := *readOnly || cfg.ReadOnly
+	if !approve && !readOnlyMode && !*dryRun && !stdinIsTerminal() {
+		// 標準入力が端末でなければ承認を尋ねられないので、--yesか--dry-runで明示しない限り変更を加えない
+		fmt.Println("Standard input is not a terminal: running in read-only mode. Pass --yes to allow changes without confirmation.")
+		readOnlyMode = true
+	}
+	if readOnlyMode {
+		// モードを切り替えても書き込み系ツールを使えないよう、元のツールから絞り込む
+		for name, tool := range availableTools {
+			if !tool.ReadOnly {
+				delete(availableTools, name)
 			}
-			fmt.Printf("%s\t%s\t%s\n", s.ID, s.StartedAt.Format("2006-01-02 15:04:05"), lastMsg)
 		}
-		return
+	} else if approve {
+		tools.SetApprover(tools.AutoApprover)
+		// 読み込み後に変更されたファイルの衝突は確認できる人がいないので、モデルに返して編集し直させる
+		tools.SetConflictResolver(nil)
+		fmt.Println("Auto-approve is on: file changes and commands run without confirmation.")
 	}
-
-	// 環境変数からAPIキーを取得
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		fmt.Println("Error: OPENAI_API_KEY environment variable is not set")
-		fmt.Println("Please set your OpenAI API key: export OPENAI_API_KEY=your_api_key_here")
-		os.Exit(1)
+	if *dryRun && !readOnlyMode {
+		// ファイルの変更は表示だけにする。コマンドの実行は通常どおり承認を求める
+		tools.SetDryRun(true)
+		fmt.Println("Dry run: file changes are shown but not written to disk.")
 	}
+	modeTools, toolNames := mode.filterTools(availableTools)
+	configureTools(cfg)
 
-	// OpenAIクライアントを初期化
-	client := openai.NewClient(apiKey)
-
-	// 利用可能なツールを取得
-	tools := tools.GetAvailableTools()
+	// 一時的なworktreeで作業し、終了時に差分を確認してから元のリポジトリに取り込む
+	var wt *worktree
+	if *useWorktree {
+		wt, err = newWorktree()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := wt.finish(); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}()
+		fmt.Printf("Working in isolated worktree: %s\n", wt.dir)
+	}
 
-	// ツールのスキーマを配列に変換
-	var toolNames []string
-	var toolSchemas []openai.Tool
-	for name, tool := range tools {
-		toolNames = append(toolNames, name)
-		toolSchemas = append(toolSchemas, tool.Schema)
+	// システムプロンプトの構築
+	basePrompt := getSystemPrompt()
+	if task != nil {
+		basePrompt += task.promptExtension()
+	}
+	if wt != nil {
+		basePrompt += wt.promptExtension()
 	}
+	systemPrompt := mode.systemPrompt(basePrompt)
 
 	// セッションの開始または復元
 	var messages []openai.ChatCompletionMessage
+	var model string
 
 	if *sessionID != "" {
 		// 既存セッションの復元
-		session, err := manager.RestoreSession(*sessionID)
+		session, err := manager.RestoreSession(*sessionID, *force)
 		if err != nil {
-			fmt.Printf("Error: failed to restore session: %v\n", err)
-			os.Exit(1)
+			var inUse *memory.SessionInUseError
+			if errors.As(err, &inUse) {
+				return fmt.Errorf("%w; use --force to resume it anyway", err)
+			}
+			return fmt.Errorf("failed to restore session: %w", err)
 		}
 
-		// 過去のメッセージを取得
-		memoryMessages, err := manager.GetSessionMessages(*sessionID)
-		if err != nil {
-			fmt.Printf("Error: failed to get session messages: %v\n", err)
-			os.Exit(1)
+		// セッション開始時のモデルを引き継ぐ。明示的に指定された場合は切り替えて記録する
+		model = session.ModelUsed
+		if *modelFlag != "" && *modelFlag != model {
+			if err := manager.UpdateSessionModel(*modelFlag); err != nil {
+				return fmt.Errorf("failed to update session model: %w", err)
+			}
+			model = *modelFlag
 		}
 
-		// メッセージをOpenAI形式に変換
-		messages = convertToOpenAIMessages(memoryMessages)
+		// 過去のメッセージをOpenAI形式に変換しながら取得
+		messages, err = loadSessionHistory(manager, session)
+		if err != nil {
+			return err
+		}
+		if session.Incognito {
+			fmt.Println("This session was recorded in incognito mode, so the previous conversation is not available.")
+		}
 		// システムプロンプトを先頭に追加
 		messages = append([]openai.ChatCompletionMessage{
 			{
 				Role:    openai.ChatMessageRoleSystem,
-				Content: getSystemPrompt(),
+				Content: systemPrompt,
 			},
 		}, messages...)
 
-		fmt.Printf("Resumed session: %s\n", session.ID)
+		fmt.Printf("Resumed session: %s (model: %s)\n", session.ID, model)
 	} else {
 		// 新規セッションの開始
-		projectPath, err := os.Getwd()
+		projectPath, err := currentProject()
 		if err != nil {
-			fmt.Printf("Error: failed to get current directory: %v\n", err)
-			os.Exit(1)
+			return err
+		}
+		if wt != nil {
+			projectPath = wt.repoRoot
 		}
 
-		session, err := manager.StartSession(projectPath, openai.GPT5Nano)
+		model = resolveModel(*modelFlag, cfg)
+
+		session, err := manager.StartSession(projectPath, model)
 		if err != nil {
-			fmt.Printf("Error: failed to start session: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to start session: %w", err)
 		}
 
 		messages = []openai.ChatCompletionMessage{
 			{
 				Role:    openai.ChatMessageRoleSystem,
-				Content: getSystemPrompt(),
+				Content: systemPrompt,
 			},
 		}
-		fmt.Printf("Started new session: %s\n", session.ID)
-		fmt.Printf("Use --session %s to resume this session later\n", session.ID)
+		fmt.Printf("Started new session: %s (model: %s)\n", session.ID, model)
+		switch {
+		case ephemeral:
+			fmt.Println("Ephemeral mode: this session is kept in memory only and discarded on exit.")
+		case session.Incognito:
+			fmt.Println("Incognito mode: message contents are not saved, only token usage and cost.")
+		default:
+			fmt.Printf("Use --session %s to resume this session later\n", session.ID)
+		}
+	}
+
+	// 一時ファイルや試行用のスクリプトをプロジェクトの外に置けるよう、セッション専用のディレクトリを用意する
+	scratchDir, err := newScratchDir(manager.GetCurrentSession().ID)
+	if err != nil {
+		return err
+	}
+	if ephemeral {
+		defer os.RemoveAll(scratchDir)
+	}
+	tools.SetScratchDir(scratchDir)
+	basePrompt += scratchPromptExtension(scratchDir)
+	messages[0].Content = mode.systemPrompt(basePrompt)
+
+	// ファイル変更をスナップショットとして記録する
+	tools.OnFileChange(func(change tools.FileChange) {
+		path, err := filepath.Abs(change.Path)
+		if err != nil {
+			path = change.Path
+		}
+		if wt != nil {
+			// worktree内の変更も元のリポジトリのパスで記録し、export-patchで扱えるようにする
+			path = wt.originalPath(path)
+		}
+		if err := manager.SaveFileSnapshot(path, change.OldContent, change.NewContent); err != nil {
+			fmt.Printf("Warning: failed to save file snapshot: %v\n", err)
+		}
+	})
+
+	// 承認済みの変更をgitに自動で記録する
+	autoGit, err := newAutoGit(*autoGitMode, manager.GetCurrentSession().ID)
+	if err != nil {
+		return err
+	}
+	if autoGit != nil {
+		tools.OnFileChange(autoGit.Record)
+		if autoGit.mode == autoGitCommit {
+			fmt.Printf("Approved changes will be committed to branch %s\n", autoGit.branch)
+		}
 	}
 
 	fmt.Println("nebula - OpenAI Chat CLI with Function Calling")
+	fmt.Println("Mode: " + mode.Name)
 	fmt.Println("Available tools: " + strings.Join(toolNames, ", "))
-	fmt.Println("Type 'exit' or 'quit' to end the conversation")
+	fmt.Println("Type /help for commands, 'exit' or 'quit' to end the conversation, Ctrl+C to interrupt a response")
+	fmt.Println("For multi-line input, paste it and press Enter on the empty line, use Alt+Enter for a newline, or wrap it in ```")
 	fmt.Println("---")
 
-	scanner := bufio.NewScanner(os.Stdin)
+	ag := &agent{
+		client:   client,
+		model:    model,
+		manager:  manager,
+		cfg:      cfg,
+		tools:    modeTools,
+		messages: messages,
+	}
 
-	for {
-		fmt.Print("You: ")
-		if !scanner.Scan() {
-			break
+	// 言語サーバーはコードの移動と、編集したファイルの検査で共有する
+	if servers := newLanguageServers(cfg); servers != nil {
+		defer servers.Close()
+		tools.SetCodeNavigator(servers)
+		if diagnostics := newLSPDiagnostics(cfg, servers); diagnostics != nil {
+			ag.diagnostics = diagnostics
+			tools.OnFileChange(diagnostics.Record)
 		}
+	}
 
-		userInput := strings.TrimSpace(scanner.Text())
+	// 書き込み系ツールを使うターンの前に作業ツリーを記録する
+	if cfg.SnapshotTurns || *snapshotTurns {
+		ag.snapshots = newTurnSnapshotter()
+		tools.OnFileChange(ag.snapshots.Record)
+	}
 
-		// 終了コマンドをチェック
-		if userInput == "exit" || userInput == "quit" {
-			fmt.Println("Goodbye!")
+	repl := &chatREPL{
+		ag:             ag,
+		manager:        manager,
+		mode:           mode,
+		basePrompt:     basePrompt,
+		availableTools: availableTools,
+		toolNames:      toolNames,
+	}
+
+	// セッションの開始・終了、ターンの完了、承認を設定されたWebhookに通知する
+	if webhooks := newWebhookNotifier(cfg.Webhooks, manager); webhooks != nil {
+		repl.webhooks = webhooks
+		tools.OnApproval(webhooks.approvalDecided)
+		webhooks.sessionStarted(*sessionID != "")
+		defer webhooks.sessionEnded()
+	}
+
+	// タスクの依頼内容が引数で渡された場合は最初の入力として処理する
+	if task != nil {
+		fmt.Printf("Task: %s\n", task.Name)
+		if initialInput := strings.Join(fs.Args(), " "); initialInput != "" {
+			fmt.Printf("You: %s\n", initialInput)
+			repl.runUserInput(initialInput)
+		}
+	}
+
+	// 上キーで以前の入力を呼び出せるよう、プロジェクトごとの入力履歴を残す。メッセージを残さないモードでは残さない
+	historyPath := ""
+	if !ephemeral && !manager.GetCurrentSession().Incognito {
+		if historyPath, err = inputHistoryPath(manager.GetCurrentSession().ProjectPath); err != nil {
+			return err
+		}
+	}
+	input := newLineReader(historyPath)
+
+	for !repl.exit {
+		line, err := input.ReadInput("You: ")
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Printf("Error: failed to read input: %v\n", err)
+			}
 			break
 		}
 
+		userInput := strings.TrimSpace(line)
 		if userInput == "" {
 			continue
 		}
 
-		// handleUserInputでユーザー入力1件を処理
-		var err error
-		messages, err = handleUserInput(client, userInput, messages, tools, toolSchemas, manager)
-		if err != nil {
-			fmt.Printf("Error handling user input: %v\n", err)
+		// スラッシュコマンドと終了コマンドはLLMに送らずに処理する。複数行の入力はコマンドとみなさない
+		if !strings.Contains(userInput, "\n") && repl.handleCommand(userInput) {
 			continue
 		}
+
+		repl.runUserInput(userInput)
 	}
+
+	return nil
 }
 
-// handleUserInput はユーザー入力1件を処理し、ツールコールがなくなるまで繰り返し実行する
-func handleUserInput(
-	client *openai.Client,
-	userInput string,
-	messages []openai.ChatCompletionMessage,
-	tools map[string]tools.ToolDefinition,
-	toolSchemas []openai.Tool,
-	manager *memory.Manager,
-) ([]openai.ChatCompletionMessage, error) {
-	// ユーザーメッセージを履歴に追加
-	userMsg := openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleUser,
-		Content: userInput,
-	}
-	messages = append(messages, userMsg)
-
-	// ユーザーメッセージを永続化
-	if err := manager.SaveMessage("user", userInput, nil, nil); err != nil {
-		return messages, fmt.Errorf("failed to save user message: %w", err)
-	}
-
-	// ツールコールがなくなるまでループ
-	for step := 0; step < maxToolCallSteps; step++ {
-		// OpenAI APIに送信
-		resp, err := client.CreateChatCompletion(
-			context.Background(),
-			openai.ChatCompletionRequest{
-				Model:    openai.GPT5Nano,
-				Messages: messages,
-				Tools:    toolSchemas,
-			},
-		)
-		if err != nil {
-			return messages, fmt.Errorf("error calling OpenAI API: %v", err)
+// newLLMClient は設定に従ってLLMクライアントを初期化する。APIキーは環境変数から取得する
+func newLLMClient(cfg *config.Config) (llm.Client, error) {
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if cfg.Provider == llm.ProviderAzure {
+		apiKey = os.Getenv("AZURE_OPENAI_API_KEY")
+	}
+	client, err := llm.New(llm.Options{
+		Provider:        cfg.Provider,
+		BaseURL:         cfg.BaseURL,
+		APIKey:          apiKey,
+		AzureDeployment: cfg.AzureDeployment,
+		AzureAPIVersion: cfg.AzureAPIVersion,
+		ToolCalling:     cfg.ToolCalling,
+		MaxRetries:      cfg.MaxRetries,
+		Warn: func(message string) {
+			fmt.Printf("Warning: %s\n", message)
+		},
+	})
+	if err != nil {
+		switch {
+		case cfg.Provider == "" || cfg.Provider == llm.ProviderOpenAI:
+			fmt.Println("Please set your OpenAI API key: export OPENAI_API_KEY=your_api_key_here")
+		case cfg.Provider == llm.ProviderAzure && apiKey == "":
+			fmt.Println("Please set your Azure OpenAI API key: export AZURE_OPENAI_API_KEY=your_api_key_here")
 		}
+		return nil, err
+	}
+	return client, nil
+}
 
-		if len(resp.Choices) == 0 {
-			return messages, fmt.Errorf("no response received from OpenAI")
-		}
+// configureTools は設定ファイルの内容をツールに反映する
+func configureTools(cfg *config.Config) {
+	tools.SetReadFileMaxBytes(cfg.ReadFileMaxBytes)
+	tools.SetEnvAllowlist(cfg.EnvAllowlist)
+	tools.SetPermissionRules(permissionRules(cfg.Permissions))
+	tools.SetWritableDirs(cfg.WritableDirs)
+	if cfg.WebSearch != nil {
+		tools.SetWebSearchBackend(cfg.WebSearch.Provider, cfg.WebSearch.BaseURL, cfg.WebSearch.APIKey())
+	}
+	loadPlugins(cfg)
+}
 
-		responseMessage := resp.Choices[0].Message
-		messages = append(messages, responseMessage)
+// stdinIsTerminal は標準入力が端末かどうかを返す
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
-		// アシスタントメッセージを永続化
-		var toolCallsJSON string
-		if len(responseMessage.ToolCalls) > 0 {
-			toolCallsBytes, err := json.Marshal(responseMessage.ToolCalls)
-			if err == nil {
-				toolCallsJSON = string(toolCallsBytes)
-			}
-		}
+// permissionRules は設定のpermissionsをツールのルールに変換する
+func permissionRules(configured []config.PermissionRule) []tools.PermissionRule {
+	rules := make([]tools.PermissionRule, 0, len(configured))
+	for _, rule := range configured {
+		rules = append(rules, tools.PermissionRule{
+			Tool:   rule.Tool,
+			Kind:   tools.ApprovalKind(rule.Kind),
+			Paths:  rule.Paths,
+			Action: tools.PermissionAction(rule.Action),
+		})
+	}
+	return rules
+}
 
-		var toolCallsArg any
-		if toolCallsJSON != "" {
-			toolCallsArg = toolCallsJSON
-		}
+// loadPlugins は設定で宣言されたプラグインとプラグインディレクトリの実行ファイルをツールとして登録する
+func loadPlugins(cfg *config.Config) {
+	var specs []tools.PluginSpec
+	for _, plugin := range cfg.Plugins {
+		specs = append(specs, tools.PluginSpec{
+			Name:        plugin.Name,
+			Command:     plugin.Command,
+			Description: plugin.Description,
+			Parameters:  plugin.Parameters,
+			ReadOnly:    plugin.ReadOnly,
+			Timeout:     time.Duration(plugin.TimeoutSeconds) * time.Second,
+		})
+	}
+	dir, err := config.ToolsDir()
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return
+	}
+	for _, err := range tools.LoadPlugins(dir, specs) {
+		fmt.Printf("Warning: failed to load plugin: %v\n", err)
+	}
+}
 
-		if err := manager.SaveMessage("assistant", responseMessage.Content, toolCallsArg, nil); err != nil {
-			return messages, fmt.Errorf("failed to save assistant message: %w", err)
-		}
+// ephemeral は--ephemeralが指定された場合にtrue。セッションをメモリ上のデータベースに記録し、終了時に何も残さない
+var ephemeral bool
 
-		// ツールコールがない場合は最終応答として表示して終了
-		if len(responseMessage.ToolCalls) == 0 {
-			fmt.Printf("Assistant: %s\n\n", responseMessage.Content)
-			return messages, nil
-		}
+// databasePath はデータベースのパスを返す。--ephemeralの場合はメモリ上のデータベース、
+// NEBULA_DB_PATHが設定されていればそれを優先する（":memory:"を指定するとメモリ上のデータベースを使う）
+func databasePath() (string, error) {
+	if ephemeral {
+		return memory.InMemoryPath, nil
+	}
+	if dbPath := os.Getenv("NEBULA_DB_PATH"); dbPath != "" {
+		return dbPath, nil
+	}
+	dataDir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "memory.db"), nil
+}
 
-		// ツールコールがある場合の処理
-		fmt.Println("Assistant is using tools...")
-
-		for _, toolCall := range responseMessage.ToolCalls {
-			fmt.Printf("Tool call: %s, arguments: %s\n", toolCall.Function.Name, toolCall.Function.Arguments)
-
-			if tool, exists := tools[toolCall.Function.Name]; exists {
-				// ツール関数を実行
-				result, err := tool.Function(toolCall.Function.Arguments)
-				if err != nil {
-					result = fmt.Sprintf(`{"error": "Tool execution failed: %v"}`, err)
-				}
-
-				// ツール実行結果をメッセージ履歴に追加
-				toolMsg := openai.ChatCompletionMessage{
-					Role:       openai.ChatMessageRoleTool,
-					Content:    result,
-					ToolCallID: toolCall.ID,
-				}
-				messages = append(messages, toolMsg)
-
-				// ツール実行結果を永続化
-				if err := manager.SaveMessage("tool", result, nil, result); err != nil {
-					return messages, fmt.Errorf("failed to save tool message: %w", err)
-				}
-
-				fmt.Printf("Tool '%s' executed with result: %s\n", toolCall.Function.Name, result)
-			}
-		}
+// localDataDir はスクラッチディレクトリなど、データベース以外に保存するファイルを置くディレクトリを返す
+// データベースの隣に置くが、メモリ上のデータベースを使う場合は終了時に消せるよう一時ディレクトリの下にする
+func localDataDir() (string, error) {
+	dbPath, err := databasePath()
+	if err != nil {
+		return "", err
+	}
+	if memory.IsInMemory(dbPath) {
+		return filepath.Join(os.TempDir(), "nebula-ephemeral"), nil
+	}
+	return filepath.Dir(dbPath), nil
+}
 
-		// ループを継続して、ツール実行結果を元に再度APIを呼び出す
+// openManager はデータベースのパスを解決してメモリマネージャーを初期化する
+func openManager() (*memory.Manager, error) {
+	dbPath, err := databasePath()
+	if err != nil {
+		return nil, err
 	}
 
-	return messages, fmt.Errorf("maximum tool call steps (%d) exceeded", maxToolCallSteps)
+	manager, err := memory.NewManager(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize memory manager: %w", err)
+	}
+	manager.SetBranch(currentBranch())
+	return manager, nil
 }
 
-// convertToOpenAIMessages converts memory messages to OpenAI format
-func convertToOpenAIMessages(memoryMessages []*memory.Message) []openai.ChatCompletionMessage {
-	var messages []openai.ChatCompletionMessage
+// currentProject はセッションを記録・検索するプロジェクトのパスを返す
+func currentProject() (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+	return projectRoot(cwd), nil
+}
 
-	for _, msg := range memoryMessages {
-		// Skip tool messages for now (they are complex to restore properly)
+// projectRoot はdirを含むgitリポジトリのルートを返す。リポジトリ外ではdirをそのまま返す
+// サブディレクトリで起動してもセッションが同じプロジェクトにまとまるようにする
+func projectRoot(dir string) string {
+	root, err := runGit(nil, "-C", dir, "rev-parse", "--show-toplevel")
+	if err != nil || root == "" {
+		return dir
+	}
+	return filepath.FromSlash(root)
+}
+
+// defaultSessionIdleTimeout は最後のメッセージから、セッションを終了したものとして扱うまでの時間のデフォルト
+const defaultSessionIdleTimeout = 24 * time.Hour
+
+// sessionIdleTimeout は設定されたセッションのアイドル時間の上限を返す
+func sessionIdleTimeout(cfg *config.Config) time.Duration {
+	if cfg.SessionIdleTimeoutMinutes > 0 {
+		return time.Duration(cfg.SessionIdleTimeoutMinutes) * time.Minute
+	}
+	return defaultSessionIdleTimeout
+}
+
+// closeIdleSessions はアイドル時間の上限を超えたまま終了していないセッションを終了させる
+func closeIdleSessions(manager *memory.Manager, cfg *config.Config) {
+	if _, err := manager.CloseIdleSessions(sessionIdleTimeout(cfg)); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
+
+// loadSessionHistory はセッションのメッセージをページ単位で読み込み、OpenAI形式に変換する
+// ツールメッセージは正しく復元するのが複雑なのでスキップし、巨大なツール結果をメモリに保持しないようにする
+// 最後の要約より前のメッセージは要約に置き換える。シークレットセッションは内容を保存していないので空の履歴から始める
+func loadSessionHistory(manager *memory.Manager, session *memory.Session) ([]openai.ChatCompletionMessage, error) {
+	if session.Incognito {
+		return nil, nil
+	}
+	var messages []openai.ChatCompletionMessage
+	err := manager.EachSessionMessage(session.ID, func(msg *memory.Message) error {
 		if msg.Role == "tool" {
-			continue
+			return nil
+		}
+		if msg.Role == summaryRole {
+			// 要約より前の会話は要約に置き換えられている
+			messages = []openai.ChatCompletionMessage{summaryMessage(msg.Content)}
+			return nil
+		}
+		if msg.Role == clearRole {
+			// /clearより前の会話は読み込まない
+			messages = nil
+			return nil
 		}
-
 		messages = append(messages, openai.ChatCompletionMessage{
 			Role:    msg.Role,
 			Content: msg.Content,
 		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session messages: %w", err)
 	}
-
-	return messages
+	return messages, nil
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shibayu36/nebula/memory"
+)
+
+func TestDatabasePath(t *testing.T) {
+	tests := []struct {
+		name         string
+		ephemeral    bool
+		envPath      string
+		wantDBPath   string
+		wantLocalDir string
+	}{
+		{
+			name:         "--ephemeralはメモリ上のデータベースを使う",
+			ephemeral:    true,
+			envPath:      "/data/memory.db",
+			wantDBPath:   memory.InMemoryPath,
+			wantLocalDir: filepath.Join(os.TempDir(), "nebula-ephemeral"),
+		},
+		{
+			name:         "NEBULA_DB_PATHに:memory:を指定",
+			envPath:      memory.InMemoryPath,
+			wantDBPath:   memory.InMemoryPath,
+			wantLocalDir: filepath.Join(os.TempDir(), "nebula-ephemeral"),
+		},
+		{
+			name:         "NEBULA_DB_PATHのファイル",
+			envPath:      filepath.Join("data", "memory.db"),
+			wantDBPath:   filepath.Join("data", "memory.db"),
+			wantLocalDir: "data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NEBULA_DB_PATH", tt.envPath)
+			ephemeral = tt.ephemeral
+			t.Cleanup(func() { ephemeral = false })
+
+			dbPath, err := databasePath()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dbPath != tt.wantDBPath {
+				t.Errorf("databasePath() = %q, want %q", dbPath, tt.wantDBPath)
+			}
+			localDir, err := localDataDir()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if localDir != tt.wantLocalDir {
+				t.Errorf("localDataDir() = %q, want %q", localDir, tt.wantLocalDir)
+			}
+		})
+	}
+}
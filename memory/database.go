@@ -5,27 +5,61 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	_ "modernc.org/sqlite"
 )
 
+const (
+	// maxOpenConns limits the connection pool. SQLite serializes writes anyway,
+	// so a few connections are enough for the lock heartbeat and readers to run alongside a write
+	maxOpenConns = 4
+	// busyTimeoutMillis is how long a connection waits for a lock held by another connection or process
+	busyTimeoutMillis = 5000
+)
+
+// InMemoryPath is the database path that keeps everything in memory, discarded when the database is closed.
+// Used for ephemeral runs and for tests that must not touch the user's database
+const InMemoryPath = ":memory:"
+
+// IsInMemory reports whether dbPath refers to an in-memory database
+func IsInMemory(dbPath string) bool {
+	return dbPath == InMemoryPath
+}
+
 type Database struct {
-	db *sql.DB
+	db    *sql.DB
+	stmts preparedStatements
 }
 
 func NewDatabase(dbPath string) (*Database, error) {
 	// 存在しなかったらディレクトリを作成
-	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
-		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	if !IsInMemory(dbPath) {
+		if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
 	}
 
-	db, err := sql.Open("sqlite", dbPath)
+	dsn := dbPath
+	if !strings.Contains(dsn, "?") {
+		dsn += fmt.Sprintf("?_pragma=busy_timeout(%d)", busyTimeoutMillis)
+	}
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	conns := maxOpenConns
+	if IsInMemory(dbPath) {
+		// Every connection to :memory: opens its own empty database, so keep exactly one connection alive
+		conns = 1
+	}
+	db.SetMaxOpenConns(conns)
+	// Keep idle connections so the statements prepared on them are reused between messages
+	db.SetMaxIdleConns(conns)
 
 	// connectionをテスト
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
@@ -33,16 +67,31 @@ func NewDatabase(dbPath string) (*Database, error) {
 
 	// テーブルを初期化
 	if err := database.initTables(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to initialize tables: %w", err)
 	}
+	if err := database.prepareStatements(); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	return database, nil
 }
 
 func (d *Database) Close() error {
+	d.stmts.close()
 	return d.db.Close()
 }
 
+// SnapshotTo writes a consistent copy of the database to path, which must not exist yet.
+// It is safe to call while other connections or processes are using the database
+func (d *Database) SnapshotTo(path string) error {
+	if _, err := d.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	return nil
+}
+
 func (d *Database) initTables() error {
 	// sessions table
 	sessionsTableSQL := `
@@ -51,13 +100,35 @@ func (d *Database) initTables() error {
 		started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		ended_at DATETIME,
 		project_path TEXT NOT NULL,
-		model_used TEXT NOT NULL
+		model_used TEXT NOT NULL,
+		user_id TEXT NOT NULL DEFAULT '',
+		estimated_cost REAL NOT NULL DEFAULT 0,
+		last_active_at INTEGER NOT NULL DEFAULT 0,
+		branch TEXT NOT NULL DEFAULT '',
+		incognito INTEGER NOT NULL DEFAULT 0
 	);`
 
 	if _, err := d.db.Exec(sessionsTableSQL); err != nil {
 		return fmt.Errorf("failed to create sessions table: %w", err)
 	}
 
+	// Databases created before multi-user support lack these columns
+	if err := d.addColumnIfMissing("sessions", "user_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("sessions", "estimated_cost", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("sessions", "last_active_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("sessions", "branch", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("sessions", "incognito", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
 	// messages table
 	messagesTableSQL := `
 	CREATE TABLE IF NOT EXISTS messages (
@@ -67,18 +138,117 @@ func (d *Database) initTables() error {
 		role TEXT NOT NULL,
 		content TEXT,
 		tool_calls TEXT,
-		tool_results TEXT
+		tool_results TEXT,
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0
 	);`
 
 	if _, err := d.db.Exec(messagesTableSQL); err != nil {
 		return fmt.Errorf("failed to create messages table: %w", err)
 	}
 
+	// Databases created before token usage tracking lack these columns
+	if err := d.addColumnIfMissing("messages", "prompt_tokens", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("messages", "completion_tokens", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// file_snapshots table
+	fileSnapshotsTableSQL := `
+	CREATE TABLE IF NOT EXISTS file_snapshots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT REFERENCES sessions(id),
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		path TEXT NOT NULL,
+		before_content TEXT,
+		after_content TEXT
+	);`
+
+	if _, err := d.db.Exec(fileSnapshotsTableSQL); err != nil {
+		return fmt.Errorf("failed to create file_snapshots table: %w", err)
+	}
+
+	// feedback table
+	feedbackTableSQL := `
+	CREATE TABLE IF NOT EXISTS feedback (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT REFERENCES sessions(id),
+		message_id INTEGER REFERENCES messages(id),
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		rating TEXT NOT NULL,
+		comment TEXT
+	);`
+
+	if _, err := d.db.Exec(feedbackTableSQL); err != nil {
+		return fmt.Errorf("failed to create feedback table: %w", err)
+	}
+
+	// session_locks table: one row per session in use, refreshed by the owner's heartbeat
+	sessionLocksTableSQL := `
+	CREATE TABLE IF NOT EXISTS session_locks (
+		session_id TEXT PRIMARY KEY REFERENCES sessions(id),
+		owner TEXT NOT NULL,
+		pid INTEGER NOT NULL,
+		hostname TEXT NOT NULL,
+		heartbeat_at INTEGER NOT NULL
+	);`
+
+	if _, err := d.db.Exec(sessionLocksTableSQL); err != nil {
+		return fmt.Errorf("failed to create session_locks table: %w", err)
+	}
+
+	// session_bindings table: which session an external conversation (e.g. a Slack channel) continues
+	sessionBindingsTableSQL := `
+	CREATE TABLE IF NOT EXISTS session_bindings (
+		binding_key TEXT PRIMARY KEY,
+		session_id TEXT NOT NULL REFERENCES sessions(id),
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := d.db.Exec(sessionBindingsTableSQL); err != nil {
+		return fmt.Errorf("failed to create session_bindings table: %w", err)
+	}
+
+	// cost_entries table: the cost of each API call with its time, so budgets count spending in the period it happened
+	// even for sessions that span several periods. Entries are kept when a session is deleted
+	var costEntriesExists int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'cost_entries'`).Scan(&costEntriesExists); err != nil {
+		return fmt.Errorf("failed to inspect cost_entries table: %w", err)
+	}
+	costEntriesTableSQL := `
+	CREATE TABLE IF NOT EXISTS cost_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		user_id TEXT NOT NULL DEFAULT '',
+		cost REAL NOT NULL,
+		created_at INTEGER NOT NULL
+	);`
+
+	if _, err := d.db.Exec(costEntriesTableSQL); err != nil {
+		return fmt.Errorf("failed to create cost_entries table: %w", err)
+	}
+	if costEntriesExists == 0 {
+		// Databases created before per-call costs only have session totals; count each at the session's last activity
+		backfillSQL := `
+		INSERT INTO cost_entries (session_id, user_id, cost, created_at)
+		SELECT id, user_id, estimated_cost, CASE WHEN last_active_at > 0 THEN last_active_at ELSE CAST(strftime('%s', started_at) AS INTEGER) END
+		FROM sessions WHERE estimated_cost > 0`
+		if _, err := d.db.Exec(backfillSQL); err != nil {
+			return fmt.Errorf("failed to backfill cost_entries table: %w", err)
+		}
+	}
+
 	// indexes
 	indexSQL := []string{
 		"CREATE INDEX IF NOT EXISTS idx_sessions_project_path ON sessions(project_path);",
+		"CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id, started_at);",
 		"CREATE INDEX IF NOT EXISTS idx_messages_session_id ON messages(session_id);",
 		"CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);",
+		"CREATE INDEX IF NOT EXISTS idx_file_snapshots_session_id ON file_snapshots(session_id);",
+		"CREATE INDEX IF NOT EXISTS idx_feedback_session_id ON feedback(session_id);",
+		"CREATE INDEX IF NOT EXISTS idx_cost_entries_user_id ON cost_entries(user_id, created_at);",
 	}
 
 	for _, index := range indexSQL {
@@ -90,6 +260,37 @@ func (d *Database) initTables() error {
 	return nil
 }
 
+// addColumnIfMissing adds a column to an existing table unless it is already there
+func (d *Database) addColumnIfMissing(table, column, definition string) error {
+	rows, err := d.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s table: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan %s table info: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect %s table: %w", table, err)
+	}
+
+	if _, err := d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s.%s column: %w", table, column, err)
+	}
+	return nil
+}
+
 func (d *Database) GetDB() *sql.DB {
 	return d.db
 }
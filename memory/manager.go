@@ -4,12 +4,33 @@ import (
 	"fmt"
 	"os"
 	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// sessionLockHeartbeatInterval is how often the lock of the current session is refreshed
+	sessionLockHeartbeatInterval = 15 * time.Second
+	// sessionLockStaleAfter is how long a lock survives without a heartbeat, e.g. after the owner crashed
+	sessionLockStaleAfter = 4 * sessionLockHeartbeatInterval
+	// messagePageSize is how many messages EachSessionMessage reads at a time
+	messagePageSize = 200
 )
 
 // Manager handles memory operations
 type Manager struct {
-	db             *Database
-	currentSession *Session
+	db                     *Database
+	currentSession         *Session
+	lastAssistantMessageID int
+
+	// lockOwner identifies this Manager in session_locks so that two processes never write to the same session
+	lockOwner     string
+	stopHeartbeat chan struct{}
+
+	// branch is recorded in the sessions started by this Manager
+	branch string
+	// incognito makes the sessions started by this Manager incognito
+	incognito bool
 }
 
 func NewManager(dbPath string) (*Manager, error) {
@@ -17,7 +38,7 @@ func NewManager(dbPath string) (*Manager, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create database: %w", err)
 	}
-	return &Manager{db: db}, nil
+	return &Manager{db: db, lockOwner: uuid.NewString()}, nil
 }
 
 func (m *Manager) Close() error {
@@ -30,35 +51,209 @@ func (m *Manager) Close() error {
 	return m.db.Close()
 }
 
+// SnapshotDatabase writes a consistent copy of the whole database to path, e.g. for backups
+func (m *Manager) SnapshotDatabase(path string) error {
+	return m.db.SnapshotTo(path)
+}
+
+// BoundSession returns the ID of the session bound to an external conversation key, or "" if there is none
+func (m *Manager) BoundSession(key string) (string, error) {
+	return m.db.GetSessionBinding(key)
+}
+
+// BindCurrentSession binds an external conversation key to the current session
+func (m *Manager) BindCurrentSession(key string) error {
+	if m.currentSession == nil {
+		return fmt.Errorf("no active session")
+	}
+	return m.db.SetSessionBinding(key, m.currentSession.ID)
+}
+
+// UnbindSession removes the binding of an external conversation key
+func (m *Manager) UnbindSession(key string) error {
+	return m.db.DeleteSessionBinding(key)
+}
+
+// SetBranch sets the git branch recorded in sessions started afterwards
+func (m *Manager) SetBranch(branch string) {
+	m.branch = branch
+}
+
+// SetIncognito makes the sessions started afterwards incognito: message contents, file snapshots and feedback comments
+// are not stored, only the accounting (roles, timestamps, token usage and cost)
+func (m *Manager) SetIncognito(incognito bool) {
+	m.incognito = incognito
+}
+
 func (m *Manager) StartSession(projectPath, modelUsed string) (*Session, error) {
+	return m.StartUserSession(projectPath, modelUsed, "")
+}
+
+// StartUserSession starts a session owned by a server user
+func (m *Manager) StartUserSession(projectPath, modelUsed, userID string) (*Session, error) {
 	// session IDをtimestampベースで作成
 	sessionID := fmt.Sprintf("session_%s", time.Now().Format("20060102_150405"))
+	// The server can start several sessions within the same second, so add a suffix to keep the ID unique
+	for i := 2; ; i++ {
+		if _, err := m.db.GetSession(sessionID); err != nil {
+			break
+		}
+		sessionID = fmt.Sprintf("session_%s_%d", time.Now().Format("20060102_150405"), i)
+	}
 
 	session := &Session{
 		ID:          sessionID,
 		StartedAt:   time.Now(),
 		ProjectPath: projectPath,
 		ModelUsed:   modelUsed,
+		UserID:      userID,
+		Branch:      m.branch,
+		Incognito:   m.incognito,
 	}
+	session.LastActiveAt = session.StartedAt
 
 	if err := m.db.CreateSession(session); err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
+	if err := m.lockSession(session.ID, false); err != nil {
+		return nil, err
+	}
 
 	m.currentSession = session
 	return session, nil
 }
 
-func (m *Manager) RestoreSession(sessionID string) (*Session, error) {
+// RestoreSession makes an existing session the current session.
+// It fails with *SessionInUseError while another process is using the session, unless force is true
+func (m *Manager) RestoreSession(sessionID string, force bool) (*Session, error) {
 	session, err := m.db.GetSession(sessionID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get session: %w", err)
 	}
+	if err := m.lockSession(sessionID, force); err != nil {
+		return nil, err
+	}
 
 	m.currentSession = session
 	return session, nil
 }
 
+// lockSession takes the lock of a session, releases the lock of the previous current session
+// and keeps the new lock alive with a heartbeat until it is released
+func (m *Manager) lockSession(sessionID string, force bool) error {
+	hostname, _ := os.Hostname()
+	holder, err := m.db.AcquireSessionLock(&SessionLock{
+		SessionID:   sessionID,
+		Owner:       m.lockOwner,
+		PID:         os.Getpid(),
+		Hostname:    hostname,
+		HeartbeatAt: time.Now(),
+	}, time.Now().Add(-sessionLockStaleAfter), force)
+	if err != nil {
+		return err
+	}
+	if holder != nil {
+		return &SessionInUseError{Lock: *holder}
+	}
+
+	if m.currentSession != nil && m.currentSession.ID != sessionID {
+		m.unlockSession()
+	}
+	m.stopLockHeartbeat()
+	stop := make(chan struct{})
+	m.stopHeartbeat = stop
+	go func() {
+		ticker := time.NewTicker(sessionLockHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				m.db.HeartbeatSessionLock(sessionID, m.lockOwner, now)
+			}
+		}
+	}()
+	return nil
+}
+
+// stopLockHeartbeat stops refreshing the lock of the current session
+func (m *Manager) stopLockHeartbeat() {
+	if m.stopHeartbeat != nil {
+		close(m.stopHeartbeat)
+		m.stopHeartbeat = nil
+	}
+}
+
+// unlockSession releases the lock of the current session
+func (m *Manager) unlockSession() error {
+	m.stopLockHeartbeat()
+	if m.currentSession == nil {
+		return nil
+	}
+	return m.db.ReleaseSessionLock(m.currentSession.ID, m.lockOwner)
+}
+
+// checkSessionLock fails if another process took over the current session, e.g. with --force,
+// so that the two processes do not interleave their messages
+func (m *Manager) checkSessionLock() error {
+	lock, err := m.db.GetSessionLock(m.currentSession.ID)
+	if err != nil {
+		return err
+	}
+	if lock == nil || lock.Owner != m.lockOwner {
+		return fmt.Errorf("session %s was taken over by another process", m.currentSession.ID)
+	}
+	return nil
+}
+
+// GetSession returns a session by ID without making it the current session
+func (m *Manager) GetSession(sessionID string) (*Session, error) {
+	return m.db.GetSession(sessionID)
+}
+
+// UpdateSessionModel changes the model recorded for the current session
+func (m *Manager) UpdateSessionModel(modelUsed string) error {
+	if m.currentSession == nil {
+		return nil
+	}
+
+	if err := m.db.UpdateSessionModel(m.currentSession.ID, modelUsed); err != nil {
+		return err
+	}
+	m.currentSession.ModelUsed = modelUsed
+	return nil
+}
+
+// CloseIdleSessions ends the sessions with no activity for longer than idle,
+// e.g. sessions left open by a process that crashed. Sessions in use by a live process are kept open
+func (m *Manager) CloseIdleSessions(idle time.Duration) (int, error) {
+	return m.db.CloseIdleSessions(time.Now().Add(-idle), time.Now().Add(-sessionLockStaleAfter))
+}
+
+// AddCost adds the estimated cost of an API call to the current session
+func (m *Manager) AddCost(cost float64) error {
+	if m.currentSession == nil || cost == 0 {
+		return nil
+	}
+
+	if err := m.db.AddSessionCost(m.currentSession.ID, cost, time.Now()); err != nil {
+		return err
+	}
+	m.currentSession.EstimatedCost += cost
+	return nil
+}
+
+// GetUserCostSince returns the total estimated cost a user spent at or after since
+func (m *Manager) GetUserCostSince(userID string, since time.Time) (float64, error) {
+	return m.db.GetUserCostSince(userID, since)
+}
+
+// GetSessionsByUser returns the most recent sessions owned by a user
+func (m *Manager) GetSessionsByUser(userID string, limit int) ([]*SessionSummary, error) {
+	return m.db.GetSessionsByUser(userID, limit)
+}
+
 // EndSession ends the current session
 func (m *Manager) EndSession() error {
 	if m.currentSession == nil {
@@ -68,6 +263,9 @@ func (m *Manager) EndSession() error {
 	if err := m.db.EndSession(m.currentSession.ID); err != nil {
 		return fmt.Errorf("failed to end session: %w", err)
 	}
+	if err := m.unlockSession(); err != nil {
+		return err
+	}
 
 	// Update local session
 	now := time.Now()
@@ -82,17 +280,7 @@ func (m *Manager) GetCurrentSession() *Session {
 }
 
 func (m *Manager) SaveMessage(role, content string, toolCalls, toolResults any) error {
-	if m.currentSession == nil {
-		return nil
-	}
-
-	message := &Message{
-		SessionID: m.currentSession.ID,
-		Timestamp: time.Now(),
-		Role:      role,
-		Content:   content,
-	}
-
+	message := Message{Role: role, Content: content}
 	if toolCalls != nil {
 		if toolCallsJSON, ok := toolCalls.(string); ok {
 			message.ToolCalls = &toolCallsJSON
@@ -103,22 +291,133 @@ func (m *Manager) SaveMessage(role, content string, toolCalls, toolResults any)
 			message.ToolResults = &toolResultsJSON
 		}
 	}
+	return m.SaveMessages([]Message{message})
+}
 
-	return m.db.SaveMessage(message)
+// SaveMessages saves messages to the current session in a single transaction, e.g. all the messages of a turn.
+// SessionID and ID are filled in by the Manager; a zero Timestamp means now
+func (m *Manager) SaveMessages(messages []Message) error {
+	if m.currentSession == nil || len(messages) == 0 {
+		return nil
+	}
+	if err := m.checkSessionLock(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	records := make([]*Message, len(messages))
+	for i, message := range messages {
+		record := message
+		record.ID = 0
+		record.SessionID = m.currentSession.ID
+		if record.Timestamp.IsZero() {
+			record.Timestamp = now
+		}
+		if m.currentSession.Incognito {
+			record.Content = ""
+			record.ToolCalls = nil
+			record.ToolResults = nil
+		}
+		records[i] = &record
+	}
+
+	if err := m.db.SaveMessages(m.currentSession.ID, records); err != nil {
+		return err
+	}
+	last := records[len(records)-1]
+	m.currentSession.LastActiveAt = last.Timestamp
+	m.currentSession.EndedAt = nil
+	for _, record := range records {
+		if record.Role == "assistant" {
+			m.lastAssistantMessageID = record.ID
+		}
+	}
+	return nil
 }
 
-// GetSessionsByProject
-func (m *Manager) GetSessionsByProject(projectPath string, limit int) ([]*SessionSummary, error) {
-	return m.db.GetSessionsByProject(projectPath, limit)
+// AppendToLastAssistantMessage appends content to the last assistant message saved in this process,
+// used to stitch a continuation of a truncated response into a single message
+func (m *Manager) AppendToLastAssistantMessage(content string) error {
+	if m.currentSession == nil || m.currentSession.Incognito || m.lastAssistantMessageID == 0 {
+		return nil
+	}
+	return m.db.AppendMessageContent(m.lastAssistantMessageID, content)
 }
 
-func (m *Manager) GetCurrentProjectSessions(limit int) ([]*SessionSummary, error) {
-	currentDir, err := os.Getwd()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current directory: %w", err)
+// AddLastAssistantMessageUsage adds the token usage of a continuation to the last assistant message saved in this process
+func (m *Manager) AddLastAssistantMessageUsage(promptTokens, completionTokens int) error {
+	if m.currentSession == nil || m.lastAssistantMessageID == 0 {
+		return nil
 	}
+	return m.db.AddMessageUsage(m.lastAssistantMessageID, promptTokens, completionTokens)
+}
+
+// GetSessionUsage returns the message counts and token usage of a session
+func (m *Manager) GetSessionUsage(sessionID string) (*SessionUsage, error) {
+	return m.db.GetSessionUsage(sessionID)
+}
+
+// RateLastAssistantMessage attaches a rating and optional comment to the latest assistant message of the current session
+func (m *Manager) RateLastAssistantMessage(rating, comment string) error {
+	if m.currentSession == nil {
+		return fmt.Errorf("no active session")
+	}
+
+	messageID := m.lastAssistantMessageID
+	if messageID == 0 {
+		// Resumed sessions have no assistant message saved in this process yet
+		id, err := m.db.GetLastAssistantMessageID(m.currentSession.ID)
+		if err != nil {
+			return err
+		}
+		messageID = id
+	}
+	if messageID == 0 {
+		return fmt.Errorf("there is no assistant response to rate yet")
+	}
+
+	if m.currentSession.Incognito {
+		comment = ""
+	}
+	return m.db.SaveFeedback(&Feedback{
+		SessionID: m.currentSession.ID,
+		MessageID: messageID,
+		Timestamp: time.Now(),
+		Rating:    rating,
+		Comment:   comment,
+	})
+}
+
+// GetRatedTurns returns rated assistant responses across all sessions, filtered by rating if it is not empty
+func (m *Manager) GetRatedTurns(rating string) ([]*RatedTurn, error) {
+	return m.db.GetRatedTurns(rating)
+}
+
+// SaveFileSnapshot records the before/after content of a file changed in the current session
+func (m *Manager) SaveFileSnapshot(path string, beforeContent, afterContent *string) error {
+	if m.currentSession == nil || m.currentSession.Incognito {
+		return nil
+	}
+
+	snapshot := &FileSnapshot{
+		SessionID:     m.currentSession.ID,
+		Timestamp:     time.Now(),
+		Path:          path,
+		BeforeContent: beforeContent,
+		AfterContent:  afterContent,
+	}
+
+	return m.db.SaveFileSnapshot(snapshot)
+}
 
-	return m.GetSessionsByProject(currentDir, limit)
+// GetSessionFileSnapshots returns all file snapshots for a session
+func (m *Manager) GetSessionFileSnapshots(sessionID string) ([]*FileSnapshot, error) {
+	return m.db.GetSessionFileSnapshots(sessionID)
+}
+
+// GetSessionsByProject returns the sessions of a project, including sessions started in its subdirectories
+func (m *Manager) GetSessionsByProject(projectPath string, limit, offset int) ([]*SessionSummary, error) {
+	return m.db.GetSessionsByProject(projectPath, limit, offset)
 }
 
 // GetSessionMessages returns all messages for a session
@@ -126,15 +425,35 @@ func (m *Manager) GetSessionMessages(sessionID string) ([]*Message, error) {
 	return m.db.GetSessionMessages(sessionID)
 }
 
+// EachSessionMessage calls fn with the messages of a session in order, reading them a page at a time
+// so that huge sessions are never loaded into memory at once. It stops at the first error returned by fn
+func (m *Manager) EachSessionMessage(sessionID string, fn func(*Message) error) error {
+	for offset := 0; ; offset += messagePageSize {
+		messages, err := m.db.GetSessionMessagesPage(sessionID, messagePageSize, offset)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			if err := fn(message); err != nil {
+				return err
+			}
+		}
+		if len(messages) < messagePageSize {
+			return nil
+		}
+	}
+}
+
 // GetRecentSessions returns recent sessions across all projects
-func (m *Manager) GetRecentSessions(limit int) ([]*SessionSummary, error) {
-	return m.db.GetRecentSessions(limit)
+func (m *Manager) GetRecentSessions(limit, offset int) ([]*SessionSummary, error) {
+	return m.db.GetRecentSessions(limit, offset)
 }
 
 // DeleteSession deletes a session and all its messages
 func (m *Manager) DeleteSession(sessionID string) error {
 	// If deleting current session, clear it
 	if m.currentSession != nil && m.currentSession.ID == sessionID {
+		m.stopLockHeartbeat()
 		m.currentSession = nil
 	}
 
@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"testing"
+)
+
+// newTestManager opens an in-memory database so that tests never touch the user's database
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	m, err := NewManager(InMemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestIncognitoSessionStoresOnlyAccounting(t *testing.T) {
+	toolCalls := `[{"id":"call_1","type":"function","function":{"name":"readFile","arguments":"{\"path\":\"secret.txt\"}"}}]`
+	toolResults := `{"content":"secret"}`
+
+	tests := []struct {
+		name        string
+		incognito   bool
+		wantContent bool
+	}{
+		{name: "normal session keeps contents", incognito: false, wantContent: true},
+		{name: "incognito session blanks contents", incognito: true, wantContent: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			m.SetIncognito(tt.incognito)
+			session, err := m.StartSession("/project", "gpt-5-nano")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.Incognito != tt.incognito {
+				t.Fatalf("session.Incognito = %v, want %v", session.Incognito, tt.incognito)
+			}
+
+			turn := []Message{
+				{Role: "user", Content: "read secret.txt"},
+				{Role: "assistant", ToolCalls: &toolCalls, PromptTokens: 10, CompletionTokens: 5},
+				{Role: "tool", Content: "secret", ToolResults: &toolResults},
+				{Role: "assistant", Content: "The secret is", PromptTokens: 20, CompletionTokens: 3},
+			}
+			if err := m.SaveMessages(turn); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.AppendToLastAssistantMessage(" 42"); err != nil {
+				t.Fatal(err)
+			}
+			before, after := "old", "new"
+			if err := m.SaveFileSnapshot("/project/secret.txt", &before, &after); err != nil {
+				t.Fatal(err)
+			}
+
+			messages, err := m.GetSessionMessages(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != len(turn) {
+				t.Fatalf("got %d messages, want %d", len(messages), len(turn))
+			}
+			for i, got := range messages {
+				want := turn[i]
+				if got.Role != want.Role || got.PromptTokens != want.PromptTokens || got.CompletionTokens != want.CompletionTokens {
+					t.Errorf("message %d = %+v, want role and token usage of %+v", i, got, want)
+				}
+				hasContent := got.Content != "" || got.ToolCalls != nil || got.ToolResults != nil
+				if hasContent != tt.wantContent {
+					t.Errorf("message %d has content = %v, want %v (%+v)", i, hasContent, tt.wantContent, got)
+				}
+			}
+			if tt.wantContent && messages[3].Content != "The secret is 42" {
+				t.Errorf("last assistant content = %q, want the appended continuation", messages[3].Content)
+			}
+
+			snapshots, err := m.GetSessionFileSnapshots(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(snapshots) > 0, tt.wantContent; got != want {
+				t.Errorf("has file snapshots = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestInMemoryDatabasesAreIndependent(t *testing.T) {
+	first := newTestManager(t)
+	second := newTestManager(t)
+
+	session, err := first.StartSession("/project", "gpt-5-nano")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.SaveMessages([]Message{{Role: "user", Content: "hello"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The session must survive across queries on the same manager, since :memory: is per connection
+	messages, err := first.GetSessionMessages(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Content != "hello" {
+		t.Fatalf("messages = %+v, want the saved message", messages)
+	}
+
+	if _, err := second.GetSession(session.ID); err == nil {
+		t.Errorf("session %s is visible from another in-memory database", session.ID)
+	}
+}
+
+func TestIsInMemory(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: InMemoryPath, want: true},
+		{path: "/home/user/.local/share/nebula/memory.db", want: false},
+		{path: "memory.db", want: false},
+		{path: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := IsInMemory(tt.path); got != tt.want {
+			t.Errorf("IsInMemory(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package memory
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // Session represents a conversation session
 type Session struct {
@@ -9,6 +12,35 @@ type Session struct {
 	EndedAt     *time.Time `json:"ended_at,omitempty"`
 	ProjectPath string     `json:"project_path"`
 	ModelUsed   string     `json:"model_used"`
+	// UserID is the server user who owns the session, empty for local sessions
+	UserID string `json:"user_id,omitempty"`
+	// EstimatedCost is the estimated API cost of the session in USD
+	EstimatedCost float64 `json:"estimated_cost"`
+	// LastActiveAt is when a message was last saved to the session
+	LastActiveAt time.Time `json:"last_active_at"`
+	// Branch is the git branch checked out when the session was started, empty outside a repository
+	Branch string `json:"branch,omitempty"`
+	// Incognito sessions store only the role, timestamp and token usage of messages, never their contents
+	Incognito bool `json:"incognito,omitempty"`
+}
+
+// SessionLock records which process is currently using a session
+type SessionLock struct {
+	SessionID   string
+	Owner       string // random token identifying the Manager holding the lock
+	PID         int
+	Hostname    string
+	HeartbeatAt time.Time
+}
+
+// SessionInUseError is returned when another live process holds the lock of a session
+type SessionInUseError struct {
+	Lock SessionLock
+}
+
+func (e *SessionInUseError) Error() string {
+	return fmt.Sprintf("session %s is in use by another process (pid %d on %s, last active %s ago)",
+		e.Lock.SessionID, e.Lock.PID, e.Lock.Hostname, time.Since(e.Lock.HeartbeatAt).Round(time.Second))
 }
 
 // Message represents a single message in the conversation
@@ -20,17 +52,83 @@ type Message struct {
 	Content     string    `json:"content"`
 	ToolCalls   *string   `json:"tool_calls,omitempty"`
 	ToolResults *string   `json:"tool_results,omitempty"`
+	// PromptTokens and CompletionTokens are the token usage of the completion that produced an assistant message
+	PromptTokens     int `json:"prompt_tokens,omitempty"`
+	CompletionTokens int `json:"completion_tokens,omitempty"`
+}
+
+// SessionUsage is the number of messages and tokens recorded for a session
+type SessionUsage struct {
+	SessionID         string `json:"session_id"`
+	UserMessages      int    `json:"user_messages"`
+	AssistantMessages int    `json:"assistant_messages"`
+	ToolMessages      int    `json:"tool_messages"`
+	PromptTokens      int    `json:"prompt_tokens"`
+	CompletionTokens  int    `json:"completion_tokens"`
+}
+
+// TotalTokens returns the sum of prompt and completion tokens
+func (u *SessionUsage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// FileSnapshot represents the content of a file before and after a change applied in a session
+type FileSnapshot struct {
+	ID            int       `json:"id"`
+	SessionID     string    `json:"session_id"`
+	Timestamp     time.Time `json:"timestamp"`
+	Path          string    `json:"path"`
+	BeforeContent *string   `json:"before_content,omitempty"` // nil if the file was created
+	AfterContent  *string   `json:"after_content,omitempty"`  // nil if the file was deleted
+}
+
+// Ratings that can be attached to an assistant response
+const (
+	RatingGood = "good"
+	RatingBad  = "bad"
+)
+
+// Feedback represents a rating and optional comment attached to an assistant message
+type Feedback struct {
+	ID        int       `json:"id"`
+	SessionID string    `json:"session_id"`
+	MessageID int       `json:"message_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Rating    string    `json:"rating"` // good, bad
+	Comment   string    `json:"comment,omitempty"`
+}
+
+// RatedTurn represents a rated assistant response together with the user message that prompted it
+type RatedTurn struct {
+	Feedback
+	Model     string `json:"model"`
+	UserInput string `json:"user_input"`
+	Response  string `json:"response"`
 }
 
 // SessionSummary represents a brief summary of a session for listing
 type SessionSummary struct {
-	ID           string     `json:"id"`
-	StartedAt    time.Time  `json:"started_at"`
-	EndedAt      *time.Time `json:"ended_at,omitempty"`
-	ProjectPath  string     `json:"project_path"`
-	ModelUsed    string     `json:"model_used"`
-	MessageCount int        `json:"message_count"`
-	LastMessage  string     `json:"last_message"`
+	ID            string     `json:"id"`
+	StartedAt     time.Time  `json:"started_at"`
+	EndedAt       *time.Time `json:"ended_at,omitempty"`
+	LastActiveAt  time.Time  `json:"last_active_at"`
+	ProjectPath   string     `json:"project_path"`
+	Branch        string     `json:"branch,omitempty"`
+	ModelUsed     string     `json:"model_used"`
+	Title         string     `json:"title"` // the first user message
+	MessageCount  int        `json:"message_count"`
+	LastMessage   string     `json:"last_message"`
+	EstimatedCost float64    `json:"estimated_cost"`
+	Incognito     bool       `json:"incognito,omitempty"`
+}
+
+// Duration returns how long the session was in use, from its start to its end or last activity
+func (s *SessionSummary) Duration() time.Duration {
+	end := s.LastActiveAt
+	if s.EndedAt != nil && s.EndedAt.Before(end) {
+		end = *s.EndedAt
+	}
+	return max(end.Sub(s.StartedAt), 0)
 }
 
 func (s *Session) IsActive() bool {
@@ -2,16 +2,20 @@ package memory
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // CreateSession creates a new session in the database
 func (d *Database) CreateSession(session *Session) error {
 	query := `
-		INSERT INTO sessions (id, started_at, project_path, model_used)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO sessions (id, started_at, project_path, model_used, user_id, last_active_at, branch, incognito)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := d.db.Exec(query, session.ID, session.StartedAt, session.ProjectPath, session.ModelUsed)
+	_, err := d.db.Exec(query, session.ID, session.StartedAt, session.ProjectPath, session.ModelUsed, session.UserID, session.StartedAt.Unix(), session.Branch, session.Incognito)
 	if err != nil {
 		return fmt.Errorf("failed to create session: %w", err)
 	}
@@ -28,18 +32,34 @@ func (d *Database) EndSession(sessionID string) error {
 	return nil
 }
 
+// UpdateSessionModel updates the model recorded for a session
+func (d *Database) UpdateSessionModel(sessionID, modelUsed string) error {
+	query := `UPDATE sessions SET model_used = ? WHERE id = ?`
+	_, err := d.db.Exec(query, modelUsed, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update session model: %w", err)
+	}
+	return nil
+}
+
 // GetSession retrieves a session by ID
 func (d *Database) GetSession(sessionID string) (*Session, error) {
-	query := `SELECT id, started_at, ended_at, project_path, model_used FROM sessions WHERE id = ?`
+	query := `SELECT id, started_at, ended_at, project_path, model_used, user_id, estimated_cost, last_active_at, branch, incognito FROM sessions WHERE id = ?`
 	row := d.db.QueryRow(query, sessionID)
 
 	var session Session
 	var endedAt sql.NullTime
-	err := row.Scan(&session.ID, &session.StartedAt, &endedAt, &session.ProjectPath, &session.ModelUsed)
+	var lastActiveAt int64
+	err := row.Scan(&session.ID, &session.StartedAt, &endedAt, &session.ProjectPath, &session.ModelUsed, &session.UserID, &session.EstimatedCost, &lastActiveAt, &session.Branch, &session.Incognito)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get session: %w", err)
 	}
 
+	session.LastActiveAt = session.StartedAt
+	if lastActiveAt > 0 {
+		session.LastActiveAt = time.Unix(lastActiveAt, 0)
+	}
+
 	if endedAt.Valid {
 		session.EndedAt = &endedAt.Time
 	}
@@ -47,57 +67,191 @@ func (d *Database) GetSession(sessionID string) (*Session, error) {
 	return &session, nil
 }
 
-// GetSessionsByProject retrieves sessions for a specific project path
-func (d *Database) GetSessionsByProject(projectPath string, limit int) ([]*SessionSummary, error) {
+// TouchSession records activity in a session, reopening it if it had been closed for being idle
+func (d *Database) TouchSession(sessionID string, at time.Time) error {
+	_, err := d.stmts.touchSession.Exec(at.Unix(), sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	return nil
+}
+
+// CloseIdleSessions ends the sessions that have had no activity since idleSince and are not locked by a live process.
+// The end time is set to the last activity. It returns the number of sessions closed
+func (d *Database) CloseIdleSessions(idleSince, lockStaleBefore time.Time) (int, error) {
 	query := `
-		SELECT s.id, s.started_at, s.ended_at, s.project_path, s.model_used,
-			   COUNT(m.id) as message_count,
-			   COALESCE(
-				   (SELECT content FROM messages WHERE session_id = s.id ORDER BY timestamp DESC LIMIT 1),
-				   ''
-			   ) as last_message
-		FROM sessions s
-		LEFT JOIN messages m ON s.id = m.session_id
-		WHERE s.project_path = ?
-		GROUP BY s.id
-		ORDER BY s.started_at DESC
-		LIMIT ?
+		UPDATE sessions
+		SET ended_at = CASE WHEN last_active_at > 0 THEN datetime(last_active_at, 'unixepoch') ELSE started_at END
+		WHERE ended_at IS NULL
+		  AND last_active_at < ?
+		  AND id NOT IN (SELECT session_id FROM session_locks WHERE heartbeat_at >= ?)
 	`
-	rows, err := d.db.Query(query, projectPath, limit)
+	result, err := d.db.Exec(query, idleSince.Unix(), lockStaleBefore.Unix())
 	if err != nil {
-		return nil, fmt.Errorf("failed to get sessions by project: %w", err)
+		return 0, fmt.Errorf("failed to close idle sessions: %w", err)
 	}
-	defer rows.Close()
+	closed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to close idle sessions: %w", err)
+	}
+	return int(closed), nil
+}
 
-	var sessions []*SessionSummary
-	for rows.Next() {
-		var summary SessionSummary
-		var endedAt sql.NullTime
-		err := rows.Scan(
-			&summary.ID, &summary.StartedAt, &endedAt, &summary.ProjectPath,
-			&summary.ModelUsed, &summary.MessageCount, &summary.LastMessage,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan session summary: %w", err)
-		}
+// AddSessionCost adds to the estimated cost recorded for a session and records the cost as spent at the given time
+func (d *Database) AddSessionCost(sessionID string, cost float64, at time.Time) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-		if endedAt.Valid {
-			summary.EndedAt = &endedAt.Time
-		}
+	if _, err := tx.Stmt(d.stmts.addSessionCost).Exec(cost, sessionID); err != nil {
+		return fmt.Errorf("failed to add session cost: %w", err)
+	}
+	if _, err := tx.Stmt(d.stmts.addCostEntry).Exec(cost, at.Unix(), sessionID); err != nil {
+		return fmt.Errorf("failed to record cost: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
 
-		sessions = append(sessions, &summary)
+// GetUserCostSince returns the total estimated cost a user spent at or after since, in any session
+func (d *Database) GetUserCostSince(userID string, since time.Time) (float64, error) {
+	query := `SELECT COALESCE(SUM(cost), 0) FROM cost_entries WHERE user_id = ? AND created_at >= ?`
+	var cost float64
+	if err := d.db.QueryRow(query, userID, since.Unix()).Scan(&cost); err != nil {
+		return 0, fmt.Errorf("failed to get user cost: %w", err)
 	}
+	return cost, nil
+}
 
+// GetSessionsByUser retrieves the most recent sessions owned by a user
+func (d *Database) GetSessionsByUser(userID string, limit int) ([]*SessionSummary, error) {
+	sessions, err := d.querySessionSummaries(`WHERE s.user_id = ?`, limit, 0, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions by user: %w", err)
+	}
 	return sessions, nil
 }
 
-// SaveMessage saves a message to the database
-func (d *Database) SaveMessage(message *Message) error {
+// GetSessionBinding returns the ID of the session bound to key, or "" if there is none
+func (d *Database) GetSessionBinding(key string) (string, error) {
+	var sessionID string
+	err := d.db.QueryRow(`SELECT session_id FROM session_bindings WHERE binding_key = ?`, key).Scan(&sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get session binding: %w", err)
+	}
+	return sessionID, nil
+}
+
+// SetSessionBinding binds key to a session, replacing the previous binding
+func (d *Database) SetSessionBinding(key, sessionID string) error {
 	query := `
-		INSERT INTO messages (session_id, timestamp, role, content, tool_calls, tool_results)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO session_bindings (binding_key, session_id, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(binding_key) DO UPDATE SET session_id = excluded.session_id, updated_at = excluded.updated_at
 	`
-	result, err := d.db.Exec(query, message.SessionID, message.Timestamp, message.Role, message.Content, message.ToolCalls, message.ToolResults)
+	if _, err := d.db.Exec(query, key, sessionID, time.Now()); err != nil {
+		return fmt.Errorf("failed to set session binding: %w", err)
+	}
+	return nil
+}
+
+// DeleteSessionBinding removes the binding of key so that the next conversation starts a new session
+func (d *Database) DeleteSessionBinding(key string) error {
+	if _, err := d.db.Exec(`DELETE FROM session_bindings WHERE binding_key = ?`, key); err != nil {
+		return fmt.Errorf("failed to delete session binding: %w", err)
+	}
+	return nil
+}
+
+// AcquireSessionLock takes the lock of a session unless another owner holds it with a heartbeat after staleBefore.
+// force takes the lock regardless of the current holder. It returns the current holder if the lock was not taken
+func (d *Database) AcquireSessionLock(lock *SessionLock, staleBefore time.Time, force bool) (*SessionLock, error) {
+	query := `
+		INSERT INTO session_locks (session_id, owner, pid, hostname, heartbeat_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(session_id) DO UPDATE SET
+			owner = excluded.owner, pid = excluded.pid, hostname = excluded.hostname, heartbeat_at = excluded.heartbeat_at
+		WHERE session_locks.owner = excluded.owner OR session_locks.heartbeat_at < ? OR ?
+	`
+	result, err := d.db.Exec(query, lock.SessionID, lock.Owner, lock.PID, lock.Hostname, lock.HeartbeatAt.Unix(), staleBefore.Unix(), force)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire session lock: %w", err)
+	}
+	acquired, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire session lock: %w", err)
+	}
+	if acquired > 0 {
+		return nil, nil
+	}
+
+	holder, err := d.GetSessionLock(lock.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if holder == nil {
+		// The holder released the lock in the meantime
+		return d.AcquireSessionLock(lock, staleBefore, force)
+	}
+	return holder, nil
+}
+
+// GetSessionLock returns the lock of a session, or nil if nobody holds it
+func (d *Database) GetSessionLock(sessionID string) (*SessionLock, error) {
+	var lock SessionLock
+	var heartbeatAt int64
+	err := d.stmts.getSessionLock.QueryRow(sessionID).Scan(&lock.SessionID, &lock.Owner, &lock.PID, &lock.Hostname, &heartbeatAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session lock: %w", err)
+	}
+	lock.HeartbeatAt = time.Unix(heartbeatAt, 0)
+	return &lock, nil
+}
+
+// HeartbeatSessionLock refreshes the heartbeat of a lock held by owner
+func (d *Database) HeartbeatSessionLock(sessionID, owner string, at time.Time) error {
+	query := `UPDATE session_locks SET heartbeat_at = ? WHERE session_id = ? AND owner = ?`
+	if _, err := d.db.Exec(query, at.Unix(), sessionID, owner); err != nil {
+		return fmt.Errorf("failed to refresh session lock: %w", err)
+	}
+	return nil
+}
+
+// ReleaseSessionLock releases a lock held by owner
+func (d *Database) ReleaseSessionLock(sessionID, owner string) error {
+	query := `DELETE FROM session_locks WHERE session_id = ? AND owner = ?`
+	if _, err := d.db.Exec(query, sessionID, owner); err != nil {
+		return fmt.Errorf("failed to release session lock: %w", err)
+	}
+	return nil
+}
+
+// GetSessionsByProject retrieves sessions for a specific project path, skipping the first offset sessions.
+// Sessions recorded with a subdirectory of the project as their path are included too
+func (d *Database) GetSessionsByProject(projectPath string, limit, offset int) ([]*SessionSummary, error) {
+	subdirPrefix := strings.TrimSuffix(projectPath, string(filepath.Separator)) + string(filepath.Separator)
+	sessions, err := d.querySessionSummaries(
+		`WHERE s.project_path = ? OR substr(s.project_path, 1, length(?)) = ?`,
+		limit, offset, projectPath, subdirPrefix, subdirPrefix,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions by project: %w", err)
+	}
+	return sessions, nil
+}
+
+// SaveMessage saves a message to the database
+func (d *Database) SaveMessage(message *Message) error {
+	result, err := d.stmts.saveMessage.Exec(message.SessionID, message.Timestamp, message.Role, message.Content, message.ToolCalls, message.ToolResults, message.PromptTokens, message.CompletionTokens)
 	if err != nil {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
@@ -112,18 +266,109 @@ func (d *Database) SaveMessage(message *Message) error {
 	return nil
 }
 
-// GetSessionMessages retrieves all messages for a session
-func (d *Database) GetSessionMessages(sessionID string) ([]*Message, error) {
+// SaveMessages saves the messages of a session in a single transaction and records the activity of the session
+// at the timestamp of the last message
+func (d *Database) SaveMessages(sessionID string, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	saveMessage := tx.Stmt(d.stmts.saveMessage)
+	ids := make([]int64, len(messages))
+	for i, message := range messages {
+		result, err := saveMessage.Exec(message.SessionID, message.Timestamp, message.Role, message.Content, message.ToolCalls, message.ToolResults, message.PromptTokens, message.CompletionTokens)
+		if err != nil {
+			return fmt.Errorf("failed to save message: %w", err)
+		}
+		if ids[i], err = result.LastInsertId(); err != nil {
+			return fmt.Errorf("failed to get last insert ID: %w", err)
+		}
+	}
+
+	if _, err := tx.Stmt(d.stmts.touchSession).Exec(messages[len(messages)-1].Timestamp.Unix(), sessionID); err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Only assign the IDs once the messages are actually stored
+	for i, message := range messages {
+		message.ID = int(ids[i])
+	}
+	return nil
+}
+
+// AppendMessageContent appends content to an existing message
+func (d *Database) AppendMessageContent(messageID int, content string) error {
+	query := `UPDATE messages SET content = COALESCE(content, '') || ? WHERE id = ?`
+	_, err := d.db.Exec(query, content, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to append message content: %w", err)
+	}
+	return nil
+}
+
+// AddMessageUsage adds the tokens of another completion to a message, e.g. the continuation of a truncated response
+func (d *Database) AddMessageUsage(messageID, promptTokens, completionTokens int) error {
+	query := `UPDATE messages SET prompt_tokens = prompt_tokens + ?, completion_tokens = completion_tokens + ? WHERE id = ?`
+	_, err := d.db.Exec(query, promptTokens, completionTokens, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to add message usage: %w", err)
+	}
+	return nil
+}
+
+// GetSessionUsage counts the messages of a session by role and sums up their token usage
+func (d *Database) GetSessionUsage(sessionID string) (*SessionUsage, error) {
 	query := `
-		SELECT id, session_id, timestamp, role, content, tool_calls, tool_results
+		SELECT
+			COUNT(CASE WHEN role = 'user' THEN 1 END),
+			COUNT(CASE WHEN role = 'assistant' THEN 1 END),
+			COUNT(CASE WHEN role = 'tool' THEN 1 END),
+			COALESCE(SUM(prompt_tokens), 0),
+			COALESCE(SUM(completion_tokens), 0)
 		FROM messages
 		WHERE session_id = ?
-		ORDER BY timestamp ASC
 	`
-	rows, err := d.db.Query(query, sessionID)
+	usage := SessionUsage{SessionID: sessionID}
+	err := d.db.QueryRow(query, sessionID).Scan(
+		&usage.UserMessages, &usage.AssistantMessages, &usage.ToolMessages,
+		&usage.PromptTokens, &usage.CompletionTokens,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session usage: %w", err)
+	}
+	return &usage, nil
+}
+
+// GetSessionMessages retrieves all messages for a session
+func (d *Database) GetSessionMessages(sessionID string) ([]*Message, error) {
+	rows, err := d.stmts.getSessionMessages.Query(sessionID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get session messages: %w", err)
 	}
+	return scanMessages(rows)
+}
+
+// GetSessionMessagesPage retrieves up to limit messages of a session in order, skipping the first offset messages
+func (d *Database) GetSessionMessagesPage(sessionID string, limit, offset int) ([]*Message, error) {
+	rows, err := d.db.Query(getSessionMessagesSQL+" LIMIT ? OFFSET ?", sessionID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session messages: %w", err)
+	}
+	return scanMessages(rows)
+}
+
+// scanMessages reads the messages of a query and closes the rows
+func scanMessages(rows *sql.Rows) ([]*Message, error) {
 	defer rows.Close()
 
 	var messages []*Message
@@ -133,6 +378,7 @@ func (d *Database) GetSessionMessages(sessionID string) ([]*Message, error) {
 		err := rows.Scan(
 			&message.ID, &message.SessionID, &message.Timestamp,
 			&message.Role, &message.Content, &toolCalls, &toolResults,
+			&message.PromptTokens, &message.CompletionTokens,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
@@ -147,14 +393,32 @@ func (d *Database) GetSessionMessages(sessionID string) ([]*Message, error) {
 
 		messages = append(messages, &message)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
 
 	return messages, nil
 }
 
-// GetRecentSessions retrieves the most recent sessions across all projects
-func (d *Database) GetRecentSessions(limit int) ([]*SessionSummary, error) {
+// GetRecentSessions retrieves the most recent sessions across all projects, skipping the first offset sessions
+func (d *Database) GetRecentSessions(limit, offset int) ([]*SessionSummary, error) {
+	sessions, err := d.querySessionSummaries("", limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// querySessionSummaries lists the sessions matching the where clause, newest first.
+// A negative limit returns all of them
+func (d *Database) querySessionSummaries(where string, limit, offset int, args ...any) ([]*SessionSummary, error) {
 	query := `
-		SELECT s.id, s.started_at, s.ended_at, s.project_path, s.model_used,
+		SELECT s.id, s.started_at, s.ended_at, s.last_active_at, s.project_path, s.branch, s.model_used,
+			   s.estimated_cost, s.incognito,
+			   COALESCE(
+				   (SELECT content FROM messages WHERE session_id = s.id AND role = 'user' ORDER BY timestamp, id LIMIT 1),
+				   ''
+			   ) as title,
 			   COUNT(m.id) as message_count,
 			   COALESCE(
 				   (SELECT content FROM messages WHERE session_id = s.id ORDER BY timestamp DESC LIMIT 1),
@@ -162,13 +426,14 @@ func (d *Database) GetRecentSessions(limit int) ([]*SessionSummary, error) {
 			   ) as last_message
 		FROM sessions s
 		LEFT JOIN messages m ON s.id = m.session_id
+		` + where + `
 		GROUP BY s.id
 		ORDER BY s.started_at DESC
-		LIMIT ?
+		LIMIT ? OFFSET ?
 	`
-	rows, err := d.db.Query(query, limit)
+	rows, err := d.db.Query(query, append(args, limit, offset)...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get recent sessions: %w", err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -176,9 +441,10 @@ func (d *Database) GetRecentSessions(limit int) ([]*SessionSummary, error) {
 	for rows.Next() {
 		var summary SessionSummary
 		var endedAt sql.NullTime
+		var lastActiveAt int64
 		err := rows.Scan(
-			&summary.ID, &summary.StartedAt, &endedAt, &summary.ProjectPath,
-			&summary.ModelUsed, &summary.MessageCount, &summary.LastMessage,
+			&summary.ID, &summary.StartedAt, &endedAt, &lastActiveAt, &summary.ProjectPath, &summary.Branch,
+			&summary.ModelUsed, &summary.EstimatedCost, &summary.Incognito, &summary.Title, &summary.MessageCount, &summary.LastMessage,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan session summary: %w", err)
@@ -187,11 +453,15 @@ func (d *Database) GetRecentSessions(limit int) ([]*SessionSummary, error) {
 		if endedAt.Valid {
 			summary.EndedAt = &endedAt.Time
 		}
+		summary.LastActiveAt = summary.StartedAt
+		if lastActiveAt > 0 {
+			summary.LastActiveAt = time.Unix(lastActiveAt, 0)
+		}
 
 		sessions = append(sessions, &summary)
 	}
 
-	return sessions, nil
+	return sessions, rows.Err()
 }
 
 // DeleteSession deletes a session and all its messages
@@ -207,6 +477,22 @@ func (d *Database) DeleteSession(sessionID string) error {
 		return fmt.Errorf("failed to delete messages: %w", err)
 	}
 
+	if _, err := tx.Exec("DELETE FROM file_snapshots WHERE session_id = ?", sessionID); err != nil {
+		return fmt.Errorf("failed to delete file snapshots: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM feedback WHERE session_id = ?", sessionID); err != nil {
+		return fmt.Errorf("failed to delete feedback: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM session_locks WHERE session_id = ?", sessionID); err != nil {
+		return fmt.Errorf("failed to delete session lock: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM session_bindings WHERE session_id = ?", sessionID); err != nil {
+		return fmt.Errorf("failed to delete session bindings: %w", err)
+	}
+
 	// Delete session
 	if _, err := tx.Exec("DELETE FROM sessions WHERE id = ?", sessionID); err != nil {
 		return fmt.Errorf("failed to delete session: %w", err)
@@ -218,3 +504,137 @@ func (d *Database) DeleteSession(sessionID string) error {
 
 	return nil
 }
+
+// SaveFileSnapshot saves a file snapshot to the database
+func (d *Database) SaveFileSnapshot(snapshot *FileSnapshot) error {
+	query := `
+		INSERT INTO file_snapshots (session_id, timestamp, path, before_content, after_content)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	result, err := d.db.Exec(query, snapshot.SessionID, snapshot.Timestamp, snapshot.Path, snapshot.BeforeContent, snapshot.AfterContent)
+	if err != nil {
+		return fmt.Errorf("failed to save file snapshot: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	snapshot.ID = int(id)
+
+	return nil
+}
+
+// GetSessionFileSnapshots retrieves all file snapshots for a session in the order they were recorded
+func (d *Database) GetSessionFileSnapshots(sessionID string) ([]*FileSnapshot, error) {
+	query := `
+		SELECT id, session_id, timestamp, path, before_content, after_content
+		FROM file_snapshots
+		WHERE session_id = ?
+		ORDER BY id ASC
+	`
+	rows, err := d.db.Query(query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var snapshots []*FileSnapshot
+	for rows.Next() {
+		var snapshot FileSnapshot
+		var beforeContent, afterContent sql.NullString
+		err := rows.Scan(
+			&snapshot.ID, &snapshot.SessionID, &snapshot.Timestamp,
+			&snapshot.Path, &beforeContent, &afterContent,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file snapshot: %w", err)
+		}
+
+		if beforeContent.Valid {
+			snapshot.BeforeContent = &beforeContent.String
+		}
+		if afterContent.Valid {
+			snapshot.AfterContent = &afterContent.String
+		}
+
+		snapshots = append(snapshots, &snapshot)
+	}
+
+	return snapshots, nil
+}
+
+// GetLastAssistantMessageID returns the ID of the most recent assistant message in a session, or 0 if there is none
+func (d *Database) GetLastAssistantMessageID(sessionID string) (int, error) {
+	query := `
+		SELECT COALESCE(MAX(id), 0)
+		FROM messages
+		WHERE session_id = ? AND role = 'assistant'
+	`
+	var id int
+	if err := d.db.QueryRow(query, sessionID).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to get last assistant message: %w", err)
+	}
+	return id, nil
+}
+
+// SaveFeedback saves a rating of an assistant message
+func (d *Database) SaveFeedback(feedback *Feedback) error {
+	query := `
+		INSERT INTO feedback (session_id, message_id, timestamp, rating, comment)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	result, err := d.db.Exec(query, feedback.SessionID, feedback.MessageID, feedback.Timestamp, feedback.Rating, feedback.Comment)
+	if err != nil {
+		return fmt.Errorf("failed to save feedback: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	feedback.ID = int(id)
+
+	return nil
+}
+
+// GetRatedTurns retrieves rated assistant responses with the user message that preceded each of them.
+// If rating is empty, all ratings are returned
+func (d *Database) GetRatedTurns(rating string) ([]*RatedTurn, error) {
+	query := `
+		SELECT f.id, f.session_id, f.message_id, f.timestamp, f.rating, COALESCE(f.comment, ''),
+			   s.model_used,
+			   COALESCE(
+				   (SELECT content FROM messages
+				    WHERE session_id = f.session_id AND role = 'user' AND id < f.message_id
+				    ORDER BY id DESC LIMIT 1),
+				   ''
+			   ) as user_input,
+			   COALESCE(m.content, '') as response
+		FROM feedback f
+		JOIN messages m ON m.id = f.message_id
+		JOIN sessions s ON s.id = f.session_id
+		WHERE ? = '' OR f.rating = ?
+		ORDER BY f.id ASC
+	`
+	rows, err := d.db.Query(query, rating, rating)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rated turns: %w", err)
+	}
+	defer rows.Close()
+
+	var turns []*RatedTurn
+	for rows.Next() {
+		var turn RatedTurn
+		err := rows.Scan(
+			&turn.ID, &turn.SessionID, &turn.MessageID, &turn.Timestamp, &turn.Rating, &turn.Comment,
+			&turn.Model, &turn.UserInput, &turn.Response,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rated turn: %w", err)
+		}
+		turns = append(turns, &turn)
+	}
+
+	return turns, nil
+}
@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Queries run for every message of a tool loop. They are prepared once when the database is opened
+const (
+	saveMessageSQL = `
+		INSERT INTO messages (session_id, timestamp, role, content, tool_calls, tool_results, prompt_tokens, completion_tokens)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	touchSessionSQL       = `UPDATE sessions SET last_active_at = ?, ended_at = NULL WHERE id = ?`
+	addSessionCostSQL     = `UPDATE sessions SET estimated_cost = estimated_cost + ? WHERE id = ?`
+	addCostEntrySQL       = `INSERT INTO cost_entries (session_id, user_id, cost, created_at) SELECT id, user_id, ?, ? FROM sessions WHERE id = ?`
+	getSessionLockSQL     = `SELECT session_id, owner, pid, hostname, heartbeat_at FROM session_locks WHERE session_id = ?`
+	getSessionMessagesSQL = `
+		SELECT id, session_id, timestamp, role, content, tool_calls, tool_results, prompt_tokens, completion_tokens
+		FROM messages
+		WHERE session_id = ?
+		ORDER BY timestamp ASC, id ASC
+	`
+)
+
+// preparedStatements holds the prepared statements of the hot paths
+type preparedStatements struct {
+	saveMessage        *sql.Stmt
+	touchSession       *sql.Stmt
+	addSessionCost     *sql.Stmt
+	addCostEntry       *sql.Stmt
+	getSessionLock     *sql.Stmt
+	getSessionMessages *sql.Stmt
+}
+
+// prepareStatements prepares the hot path queries. It must run after the tables are created
+func (d *Database) prepareStatements() error {
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&d.stmts.saveMessage, saveMessageSQL},
+		{&d.stmts.touchSession, touchSessionSQL},
+		{&d.stmts.addSessionCost, addSessionCostSQL},
+		{&d.stmts.addCostEntry, addCostEntrySQL},
+		{&d.stmts.getSessionLock, getSessionLockSQL},
+		{&d.stmts.getSessionMessages, getSessionMessagesSQL},
+	}
+	for _, s := range statements {
+		stmt, err := d.db.Prepare(s.query)
+		if err != nil {
+			d.stmts.close()
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		*s.stmt = stmt
+	}
+	return nil
+}
+
+// close closes the statements that have been prepared
+func (s *preparedStatements) close() {
+	for _, stmt := range []*sql.Stmt{s.saveMessage, s.touchSession, s.addSessionCost, s.addCostEntry, s.getSessionLock, s.getSessionMessages} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newBenchmarkManager opens a file-backed database in a temporary directory and starts a session,
+// so the benchmarks include the disk writes that a real tool loop pays for
+func newBenchmarkManager(b *testing.B) *Manager {
+	b.Helper()
+	m, err := NewManager(filepath.Join(b.TempDir(), "memory.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { m.Close() })
+	if _, err := m.StartSession("/project", "gpt-5-nano"); err != nil {
+		b.Fatal(err)
+	}
+	return m
+}
+
+// BenchmarkSaveMessage measures persisting one message at a time, as the agent does for each tool result
+func BenchmarkSaveMessage(b *testing.B) {
+	m := newBenchmarkManager(b)
+	for i := 0; b.Loop(); i++ {
+		if err := m.SaveMessage("tool", fmt.Sprintf("result %d", i), nil, `{"ok":true}`); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSaveMessagesTurn measures persisting the messages of a whole turn in one transaction
+func BenchmarkSaveMessagesTurn(b *testing.B) {
+	m := newBenchmarkManager(b)
+	toolCalls := `[{"id":"call_1","type":"function","function":{"name":"readFile","arguments":"{\"path\":\"main.go\"}"}}]`
+	turn := []Message{
+		{Role: "user", Content: "Read main.go and explain it"},
+		{Role: "assistant", ToolCalls: &toolCalls},
+		{Role: "tool", Content: "package main\n"},
+		{Role: "assistant", Content: "It is the entry point."},
+	}
+	for b.Loop() {
+		if err := m.SaveMessages(turn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSaveMessageUnprepared is the baseline for BenchmarkSaveMessage: the same insert and
+// session update without the prepared statements, as the Manager did before they were added
+func BenchmarkSaveMessageUnprepared(b *testing.B) {
+	m := newBenchmarkManager(b)
+	sessionID := m.GetCurrentSession().ID
+	for i := 0; b.Loop(); i++ {
+		tx, err := m.db.db.Begin()
+		if err != nil {
+			b.Fatal(err)
+		}
+		now := time.Now()
+		if _, err := tx.Exec(saveMessageSQL, sessionID, now, "tool", fmt.Sprintf("result %d", i), nil, `{"ok":true}`, 0, 0); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := tx.Exec(touchSessionSQL, now.Unix(), sessionID); err != nil {
+			b.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetSessionMessages measures loading the history of a long session, as restoring it does
+func BenchmarkGetSessionMessages(b *testing.B) {
+	m := newBenchmarkManager(b)
+	messages := make([]Message, 500)
+	for i := range messages {
+		messages[i] = Message{Role: "tool", Content: fmt.Sprintf("result %d", i)}
+	}
+	if err := m.SaveMessages(messages); err != nil {
+		b.Fatal(err)
+	}
+	sessionID := m.GetCurrentSession().ID
+	for b.Loop() {
+		got, err := m.GetSessionMessages(sessionID)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(got) != len(messages) {
+			b.Fatalf("got %d messages, want %d", len(got), len(messages))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shibayu36/nebula/tools"
+)
+
+const defaultModeName = "coder"
+
+// agentMode はシステムプロンプトの差分と利用できるツールの方針を組み合わせたエージェントのモード
+type agentMode struct {
+	Name        string
+	Description string
+	Prompt      string
+	AllowTool   func(tool tools.ToolDefinition) bool
+}
+
+// agentModes は切り替え可能なエージェントのモード
+var agentModes = map[string]agentMode{
+	"architect": {
+		Name:        "architect",
+		Description: "Read-only planning: explores the codebase and proposes an implementation plan",
+		Prompt: `# Mode: architect
+You are in read-only planning mode. This overrides the Implementation step of the Execution Protocol.
+- Explore the codebase with the read-only tools to understand the current design
+- Do NOT modify any files; write tools are unavailable in this mode
+- Finish with a concrete plan: files to change, the change for each file, and risks or open questions`,
+		AllowTool: func(tool tools.ToolDefinition) bool { return tool.ReadOnly },
+	},
+	"coder": {
+		Name:        "coder",
+		Description: "Full tool access: reads and modifies files to complete the task",
+		AllowTool:   func(tool tools.ToolDefinition) bool { return true },
+	},
+	"docs": {
+		Name:        "docs",
+		Description: "Documentation upkeep: checks markdown structure, links and spelling, and edits docs with approval",
+		Prompt: `# Mode: docs
+You are maintaining documentation and code comments, not changing program behavior.
+- Use markdownOutline to understand the structure of a document before editing it
+- Use checkLinks to find broken relative links and heading anchors, and spellcheck to find misspelled words
+- Treat spellcheck results as candidates: keep product names, identifiers and technical terms, and suggest adding them to .nebula-wordlist
+- Fix spelling, grammar, broken links and unclear wording with minimal edits that keep the author's voice
+- Only modify documentation files and comments; do NOT change code behavior`,
+		AllowTool: func(tool tools.ToolDefinition) bool {
+			return tool.ReadOnly || docsWriteTools[tool.Schema.Function.Name]
+		},
+	},
+	"reviewer": {
+		Name:        "reviewer",
+		Description: "Diff-focused review: reads changes and reports problems without modifying files",
+		Prompt: `# Mode: reviewer
+You are reviewing changes, not writing them. This overrides the Implementation step of the Execution Protocol.
+- Focus on what changed: read the modified files and the code that depends on them
+- Report bugs, missing edge cases, missing tests, and deviations from the surrounding code's conventions
+- Order findings by severity and reference file paths and line numbers
+- Do NOT modify any files; write tools are unavailable in this mode`,
+		AllowTool: func(tool tools.ToolDefinition) bool { return tool.ReadOnly },
+	},
+}
+
+// docsWriteTools はdocsモードで使える書き込み系ツール。コマンドの実行などは許可しない
+var docsWriteTools = map[string]bool{
+	"writeFile": true,
+	"editFile":  true,
+}
+
+// lookupMode は名前からモードを取得する
+func lookupMode(name string) (agentMode, error) {
+	mode, ok := agentModes[name]
+	if !ok {
+		return agentMode{}, fmt.Errorf("unknown mode: %s (available: %s)", name, strings.Join(modeNames(), ", "))
+	}
+	return mode, nil
+}
+
+// modeNames はモード名をソートして返す
+func modeNames() []string {
+	var names []string
+	for name := range agentModes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// systemPrompt はベースのシステムプロンプトにモード固有の指示を追加して返す
+func (m agentMode) systemPrompt(base string) string {
+	if m.Prompt == "" {
+		return base
+	}
+	return base + "\n\n" + m.Prompt
+}
+
+// filterTools はモードで許可されたツールと、その名前の一覧を返す
+func (m agentMode) filterTools(available map[string]tools.ToolDefinition) (map[string]tools.ToolDefinition, []string) {
+	allowed := map[string]tools.ToolDefinition{}
+	var names []string
+	for name, tool := range available {
+		if !m.AllowTool(tool) {
+			continue
+		}
+		allowed[name] = tool
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return allowed, names
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/llm"
+	"github.com/shibayu36/nebula/memory"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// oneShotAgent はサーバーモードやgitのフック、バッチ処理のように承認を尋ねる相手がいない実行で、
+// 新しいセッションでユーザー入力を1件だけ処理するための設定
+type oneShotAgent struct {
+	cfg     *config.Config
+	client  llm.Client
+	manager *memory.Manager
+	model   string
+	root    string                          // プロジェクトのルート（カレントディレクトリ）
+	project string                          // セッションに記録するプロジェクトのパス（gitリポジトリのルート）
+	tools   map[string]tools.ToolDefinition // 読み取り専用のツールだけを使う
+	servers *languageServers                // コードの移動に使う言語サーバー。利用できない場合はnil
+}
+
+// newOneShotAgent はLLMクライアントとメモリマネージャーを初期化する。使い終わったらcloseを呼ぶ
+func newOneShotAgent(cfg *config.Config, model string) (*oneShotAgent, error) {
+	client, err := newLLMClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	configureTools(cfg)
+
+	root, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+	manager, err := openManager()
+	if err != nil {
+		return nil, err
+	}
+	manager.SetIncognito(cfg.Incognito)
+
+	// 承認を尋ねる相手がいないので、読み取り専用のツールだけを使う
+	readOnlyTools := map[string]tools.ToolDefinition{}
+	for name, tool := range enabledTools(cfg) {
+		if tool.ReadOnly {
+			readOnlyTools[name] = tool
+		}
+	}
+
+	servers := newLanguageServers(cfg)
+	if servers != nil {
+		tools.SetCodeNavigator(servers)
+	}
+
+	return &oneShotAgent{
+		cfg:     cfg,
+		client:  client,
+		manager: manager,
+		model:   model,
+		root:    root,
+		project: projectRoot(root),
+		tools:   readOnlyTools,
+		servers: servers,
+	}, nil
+}
+
+// close はメモリマネージャーと起動した言語サーバーを閉じる
+func (o *oneShotAgent) close() error {
+	if o.servers != nil {
+		o.servers.Close()
+	}
+	return o.manager.Close()
+}
+
+// run は新しいセッションでユーザー入力を1件処理し、最後のアシスタントの応答を返す
+// promptExtensionは用途ごとの指示としてシステムプロンプトに追加する
+// memory.Managerが扱える現在のセッションは1つなので、並行して呼び出してはならない
+func (o *oneShotAgent) run(ctx context.Context, promptExtension, input string) (string, error) {
+	return o.runAs(ctx, runOptions{}, promptExtension, input)
+}
+
+// runOptions はサーバーモードでユーザーごとに変えるrunAsの設定
+type runOptions struct {
+	userID    string                          // セッションの所有者
+	costLimit float64                         // 0より大きく、設定のturn_cost_limitより小さければ推定コストの上限として使う
+	tools     map[string]tools.ToolDefinition // 使えるツール。nilの場合は読み取り専用のツール
+	// bindingKey が空でなければ、このキーに結びついたセッションを再開して会話を続ける（Slackのチャンネルなど）
+	// 結びついたセッションがなければ新しいセッションを始めて結びつける
+	bindingKey string
+	// ask が設定されていれば、ロールで許可された操作を実行する前にこれで承認を求める
+	ask tools.Approver
+}
+
+// runAs はoptsのユーザーが所有するセッションとしてrunを実行する
+func (o *oneShotAgent) runAs(ctx context.Context, opts runOptions, promptExtension, input string) (string, error) {
+	cfg := o.cfg
+	if opts.costLimit > 0 && (cfg.TurnCostLimit == 0 || opts.costLimit < cfg.TurnCostLimit) {
+		limited := *o.cfg
+		limited.TurnCostLimit = opts.costLimit
+		cfg = &limited
+	}
+	toolset := o.tools
+	if opts.tools != nil {
+		toolset = opts.tools
+	}
+
+	session, history, err := o.openSession(opts)
+	if err != nil {
+		return "", err
+	}
+	defer o.manager.EndSession()
+
+	scratchDir, err := newScratchDir(session.ID)
+	if err != nil {
+		return "", err
+	}
+	tools.SetScratchDir(scratchDir)
+
+	ag := &agent{
+		client:  o.client,
+		model:   o.model,
+		manager: o.manager,
+		cfg:     cfg,
+		tools:   toolset,
+		messages: append([]openai.ChatCompletionMessage{{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: getSystemPrompt() + scratchPromptExtension(scratchDir) + promptExtension,
+		}}, history...),
+		ctx:     ctx,
+		confirm: func(string) bool { return false },
+	}
+	if err := ag.handleUserInput(input); err != nil {
+		return "", err
+	}
+
+	last := ag.messages[len(ag.messages)-1]
+	if last.Role != openai.ChatMessageRoleAssistant {
+		return "", errors.New("the agent did not produce a final response")
+	}
+	return last.Content, nil
+}
+
+// openSession はoptsのユーザーのセッションを開始する。bindingKeyに結びついたセッションがあればそれを再開し、過去の会話も返す
+// 結びついたセッションが他のユーザーのものであれば再開せず、新しいセッションを結びつけ直す
+func (o *oneShotAgent) openSession(opts runOptions) (*memory.Session, []openai.ChatCompletionMessage, error) {
+	if opts.bindingKey != "" {
+		sessionID, err := o.manager.BoundSession(opts.bindingKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sessionID != "" {
+			owner, err := o.manager.GetSession(sessionID)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to get session: %w", err)
+			}
+			if owner.UserID != opts.userID {
+				return o.startBoundSession(opts)
+			}
+			session, err := o.manager.RestoreSession(sessionID, false)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to restore session: %w", err)
+			}
+			history, err := loadSessionHistory(o.manager, session)
+			if err != nil {
+				o.manager.EndSession()
+				return nil, nil, err
+			}
+			return session, history, nil
+		}
+	}
+	return o.startBoundSession(opts)
+}
+
+// startBoundSession はoptsのユーザーの新しいセッションを開始し、bindingKeyがあればそれに結びつける
+func (o *oneShotAgent) startBoundSession(opts runOptions) (*memory.Session, []openai.ChatCompletionMessage, error) {
+	session, err := o.manager.StartUserSession(o.project, o.model, opts.userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start session: %w", err)
+	}
+	if opts.bindingKey != "" {
+		if err := o.manager.BindCurrentSession(opts.bindingKey); err != nil {
+			o.manager.EndSession()
+			return nil, nil, err
+		}
+	}
+	return session, nil, nil
+}
+
+// resolveModel は--modelフラグ、設定ファイル、デフォルトの順に使うモデルを決める
+func resolveModel(flagValue string, cfg *config.Config) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if cfg.Model != "" {
+		return cfg.Model
+	}
+	return defaultModel
+}
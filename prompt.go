@@ -0,0 +1,19 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// confirm はユーザーにy/Nで確認を求め、yまたはYが入力された場合にtrueを返す
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		return false
+	}
+	response := strings.TrimSpace(scanner.Text())
+	return response == "y" || response == "Y"
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/memory"
+)
+
+// diagramRenderer は図のコードブロックを画像に変換するローカルのコマンド
+type diagramRenderer struct {
+	command string
+	formats []string                                    // 出力できる形式
+	args    func(input, output, format string) []string // コマンドの引数
+	stdin   bool                                        // 入力を標準入力で渡し、出力を標準出力から受け取るかどうか
+}
+
+// diagramRenderers はコードブロックの言語ごとの変換コマンド
+// mermaidはmermaid-cli（mmdc）、ASCIIの図はsvgbobで変換する
+var diagramRenderers = map[string]diagramRenderer{
+	"mermaid": {
+		command: "mmdc",
+		formats: []string{"svg", "png"},
+		args: func(input, output, format string) []string {
+			return []string{"-i", input, "-o", output, "-e", format, "-q"}
+		},
+	},
+	"svgbob": {
+		command: "svgbob_cli",
+		formats: []string{"svg"},
+		args:    func(input, output, format string) []string { return nil },
+		stdin:   true,
+	},
+}
+
+// diagramLanguageAliases はコードブロックの言語名の別名
+var diagramLanguageAliases = map[string]string{
+	"bob":   "svgbob",
+	"ascii": "svgbob",
+}
+
+// runExportReport はセッションの依頼と最終的な回答をMarkdownのレポートとして出力し、図を画像に変換して一緒に保存する
+func runExportReport(args []string) error {
+	fs := flag.NewFlagSet("export-report", flag.ExitOnError)
+	sessionID := fs.String("session", "", "Session ID to export")
+	output := fs.String("o", "", "Directory to write report.md and rendered diagrams to")
+	diagramFormat := fs.String("diagram-format", "svg", "Render mermaid/ASCII (svgbob) diagrams to \"svg\" or \"png\" with local renderers, or \"none\" to keep them as code blocks")
+	fs.Parse(args)
+
+	if *sessionID == "" {
+		return fmt.Errorf("--session is required")
+	}
+	if *output == "" {
+		return fmt.Errorf("-o is required")
+	}
+	switch *diagramFormat {
+	case "svg", "png", "none":
+	default:
+		return fmt.Errorf("invalid --diagram-format %q: must be svg, png or none", *diagramFormat)
+	}
+
+	manager, err := openManager()
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	session, err := manager.GetSession(*sessionID)
+	if err != nil {
+		return err
+	}
+	// シークレットセッションはメッセージの内容を保存していないので、空のレポートを作らずにエラーにする
+	if session.Incognito {
+		return fmt.Errorf("session %s is incognito and has no stored messages to report", session.ID)
+	}
+	// 長いセッションでもすべてのメッセージを読み込まないよう、1件ずつレポートに加える
+	builder := newSessionReportBuilder(session)
+	if err := manager.EachSessionMessage(session.ID, func(msg *memory.Message) error {
+		builder.add(msg)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to get session messages: %w", err)
+	}
+
+	if err := os.MkdirAll(*output, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	report := builder.String()
+	if *diagramFormat != "none" {
+		report = renderReportDiagrams(report, *output, *diagramFormat)
+	}
+
+	reportPath := filepath.Join(*output, "report.md")
+	if err := os.WriteFile(reportPath, []byte(report), 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported report to %s\n", reportPath)
+	return nil
+}
+
+// sessionReportBuilder はユーザーの依頼ごとに、そのターンの最後のアシスタントの回答を並べたMarkdownを組み立てる
+// ツール呼び出しの途中経過は成果物として不要なので含めない
+type sessionReportBuilder struct {
+	b      strings.Builder
+	turn   int
+	answer string // 現在のターンで最後に受け取ったアシスタントの回答
+}
+
+func newSessionReportBuilder(session *memory.Session) *sessionReportBuilder {
+	r := &sessionReportBuilder{}
+	fmt.Fprintf(&r.b, "# Session %s\n\n", session.ID)
+	fmt.Fprintf(&r.b, "- Project: %s\n", session.ProjectPath)
+	fmt.Fprintf(&r.b, "- Model: %s\n", session.ModelUsed)
+	fmt.Fprintf(&r.b, "- Started: %s\n", session.StartedAt.Format("2006-01-02 15:04:05"))
+	return r
+}
+
+// add はメッセージを保存された順に1件ずつ受け取る
+func (r *sessionReportBuilder) add(msg *memory.Message) {
+	switch msg.Role {
+	case openai.ChatMessageRoleUser:
+		r.flush()
+		r.turn++
+		fmt.Fprintf(&r.b, "\n## Request %d\n\n", r.turn)
+		for _, line := range strings.Split(strings.TrimSpace(msg.Content), "\n") {
+			fmt.Fprintf(&r.b, "> %s\n", line)
+		}
+	case openai.ChatMessageRoleAssistant:
+		if strings.TrimSpace(msg.Content) != "" {
+			r.answer = msg.Content
+		}
+	}
+}
+
+// flush は現在のターンの回答を書き出す
+func (r *sessionReportBuilder) flush() {
+	if r.turn > 0 && r.answer != "" {
+		fmt.Fprintf(&r.b, "\n%s\n", strings.TrimSpace(r.answer))
+	}
+	r.answer = ""
+}
+
+// String は最後のターンの回答まで含めたレポートを返す
+func (r *sessionReportBuilder) String() string {
+	r.flush()
+	return r.b.String()
+}
+
+// renderReportDiagrams は図のコードブロックを画像に変換してoutputDir/diagramsに保存し、画像への参照に置き換える
+// 元のコードは折りたたんで残す。変換できなかった図はコードブロックのまま残す
+func renderReportDiagrams(report, outputDir, format string) string {
+	lines := strings.Split(report, "\n")
+	var out []string
+	count := 0
+	for i := 0; i < len(lines); i++ {
+		language, ok := strings.CutPrefix(strings.TrimSpace(lines[i]), "```")
+		language = strings.ToLower(strings.TrimSpace(language))
+		if alias, isAlias := diagramLanguageAliases[language]; isAlias {
+			language = alias
+		}
+		renderer, isDiagram := diagramRenderers[language]
+		if !ok || !isDiagram {
+			out = append(out, lines[i])
+			continue
+		}
+
+		// 閉じるフェンスまでを図のソースとして読む
+		end := i + 1
+		for end < len(lines) && strings.TrimSpace(lines[end]) != "```" {
+			end++
+		}
+		block := lines[i:min(end+1, len(lines))]
+		source := strings.Join(lines[i+1:min(end, len(lines))], "\n") + "\n"
+		i = end
+
+		count++
+		name := fmt.Sprintf("diagram-%d", count)
+		path, err := renderDiagram(renderer, source, filepath.Join(outputDir, "diagrams"), name, format)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to render %s (%s): %v\n", name, language, err)
+			out = append(out, block...)
+			continue
+		}
+		rel, _ := filepath.Rel(outputDir, path)
+		out = append(out, fmt.Sprintf("![%s](%s)", name, filepath.ToSlash(rel)), "", "<details><summary>Diagram source</summary>", "")
+		out = append(out, block...)
+		out = append(out, "", "</details>")
+	}
+	return strings.Join(out, "\n")
+}
+
+// renderDiagram は図のソースをローカルのコマンドで画像に変換し、保存したパスを返す
+// 指定した形式に対応していないコマンドはsvgで出力する
+func renderDiagram(renderer diagramRenderer, source, dir, name, format string) (string, error) {
+	if _, err := exec.LookPath(renderer.command); err != nil {
+		return "", fmt.Errorf("%s is not installed", renderer.command)
+	}
+	supported := false
+	for _, f := range renderer.formats {
+		if f == format {
+			supported = true
+		}
+	}
+	if !supported {
+		format = renderer.formats[0]
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	input := filepath.Join(dir, name+".src")
+	outputPath := filepath.Join(dir, name+"."+format)
+	if err := os.WriteFile(input, []byte(source), 0o644); err != nil {
+		return "", err
+	}
+	defer os.Remove(input)
+
+	cmd := exec.Command(renderer.command, renderer.args(input, outputPath, format)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if renderer.stdin {
+		cmd.Stdin = strings.NewReader(source)
+	}
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if renderer.stdin {
+		if err := os.WriteFile(outputPath, stdout.Bytes(), 0o644); err != nil {
+			return "", err
+		}
+	}
+	return outputPath, nil
+}
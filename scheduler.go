@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// errQueueFull は待ち行列が上限に達していて実行を受け付けられないことを表す
+	errQueueFull = errors.New("too many queued requests, try again later")
+	// errQueueTimeout は実行を待つ時間が上限を超えたことを表す
+	errQueueTimeout = errors.New("timed out waiting for a free agent, try again later")
+)
+
+// runScheduler はサーバーモードで同時に実行するエージェントの数を制限し、超えた分を待ち行列で待たせる
+// 待ち行列も上限を超えた場合はすぐに断り、クライアントに時間をおいて再試行させる
+type runScheduler struct {
+	slots    chan struct{} // 実行中のエージェントの数だけ埋まる
+	maxQueue int
+	timeout  time.Duration // 待ち行列で待つ時間の上限
+
+	mu          sync.Mutex
+	waiting     int
+	running     int
+	completed   int64
+	failed      int64
+	rejected    int64
+	timedOut    int64
+	waitSeconds float64 // 実行を始めるまでに待った時間の合計
+}
+
+func newRunScheduler(concurrency, maxQueue int, timeout time.Duration) *runScheduler {
+	return &runScheduler{
+		slots:    make(chan struct{}, concurrency),
+		maxQueue: maxQueue,
+		timeout:  timeout,
+	}
+}
+
+// acquire は実行できるようになるまで待つ。成功した場合は実行後に結果を渡して呼び出す関数を返す
+func (q *runScheduler) acquire(ctx context.Context) (func(err error), error) {
+	started := time.Now()
+	select {
+	case q.slots <- struct{}{}:
+		return q.start(started), nil
+	default:
+	}
+
+	q.mu.Lock()
+	if q.waiting >= q.maxQueue {
+		q.rejected++
+		q.mu.Unlock()
+		return nil, errQueueFull
+	}
+	q.waiting++
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	select {
+	case q.slots <- struct{}{}:
+		q.mu.Lock()
+		q.waiting--
+		q.mu.Unlock()
+		return q.start(started), nil
+	case <-timer.C:
+		q.mu.Lock()
+		q.waiting--
+		q.timedOut++
+		q.mu.Unlock()
+		return nil, errQueueTimeout
+	case <-ctx.Done():
+		q.mu.Lock()
+		q.waiting--
+		q.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// start は実行の開始を記録し、終了を記録する関数を返す
+func (q *runScheduler) start(queuedAt time.Time) func(err error) {
+	q.mu.Lock()
+	q.running++
+	q.waitSeconds += time.Since(queuedAt).Seconds()
+	q.mu.Unlock()
+
+	return func(err error) {
+		q.mu.Lock()
+		q.running--
+		if err != nil {
+			q.failed++
+		} else {
+			q.completed++
+		}
+		q.mu.Unlock()
+		<-q.slots
+	}
+}
+
+// writeMetrics は待ち行列の状態をPrometheusのテキスト形式で書き出す
+func (q *runScheduler) writeMetrics(w io.Writer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	metrics := []struct {
+		name, kind, help string
+		value            float64
+	}{
+		{"nebula_queue_depth", "gauge", "Number of requests waiting for a free agent.", float64(q.waiting)},
+		{"nebula_queue_capacity", "gauge", "Maximum number of requests that can wait for a free agent.", float64(q.maxQueue)},
+		{"nebula_runs_active", "gauge", "Number of agent runs in progress.", float64(q.running)},
+		{"nebula_runs_concurrency_limit", "gauge", "Maximum number of concurrent agent runs.", float64(cap(q.slots))},
+		{"nebula_runs_completed_total", "counter", "Number of agent runs that finished successfully.", float64(q.completed)},
+		{"nebula_runs_failed_total", "counter", "Number of agent runs that returned an error.", float64(q.failed)},
+		{"nebula_queue_rejected_total", "counter", "Number of requests rejected because the queue was full.", float64(q.rejected)},
+		{"nebula_queue_timeouts_total", "counter", "Number of requests that gave up waiting for a free agent.", float64(q.timedOut)},
+		{"nebula_queue_wait_seconds_total", "counter", "Total time requests spent waiting before their run started.", q.waitSeconds},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// newScratchDir はセッション専用のスクラッチディレクトリをデータディレクトリの下に作成する
+// 同じセッションを再開した場合は以前のディレクトリをそのまま使う
+func newScratchDir(sessionID string) (string, error) {
+	dataDir, err := localDataDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(dataDir, "scratch", sessionID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	return dir, nil
+}
+
+// scratchPromptExtension はスクラッチディレクトリをモデルに伝えるシステムプロンプトの追記
+func scratchPromptExtension(dir string) string {
+	return fmt.Sprintf(`
+
+# Scratch directory
+You have a private scratch directory for this session at %s.
+Use it for temporary files, throwaway scripts and notes that should not end up in the project tree.
+Creating, editing and deleting files inside it does not require user approval, so prefer it for experiments; never put changes meant for the project there.`, dir)
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/memory"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// maxListedSessions はGET /v1/sessionsで返すセッションの最大数
+const maxListedSessions = 50
+
+// serverConcurrency はサーバーモードで同時に実行するエージェントの数
+// memory.Managerの現在のセッションとツールの承認方法はプロセスで1つなので、1件ずつ実行する
+const serverConcurrency = 1
+
+// errBudgetExhausted はユーザーの今月の予算を使い切ったことを表す
+var errBudgetExhausted = errors.New("monthly budget exhausted")
+
+// server はHTTPでエージェントを提供するサーバーモードの状態を保持する
+// リクエストのファイルパスはoneShotAgentのroot（プロジェクトのルート）の中に限る
+type server struct {
+	*oneShotAgent
+
+	// users はトークンのSHA-256をキーにしたユーザー。空の場合は認証せずローカルの1ユーザーとして扱う
+	users map[[sha256.Size]byte]config.ServerUser
+	// policies はロール名ごとのツールの利用方針
+	policies map[string]toolPolicy
+	// available は設定で有効なすべてのツール。ユーザーの方針で絞り込んで使う
+	available map[string]tools.ToolDefinition
+	// policy は実行中のエージェントのユーザーの方針。muを持っている間だけ変更する
+	policy toolPolicy
+	// ask は実行中のエージェントの操作の承認を求める相手（Slackのユーザーなど）。nilの場合はロールの判断だけで実行する
+	ask tools.Approver
+	// slack はSlackのボット。設定されていなければnil
+	slack *slackBot
+
+	// scheduler はエージェントの実行を待ち行列に入れ、溢れた分を断る
+	scheduler *runScheduler
+
+	// memory.Managerが扱える現在のセッションは1つなので、エージェントの実行は1件ずつ行う
+	// 実行中はSlackでの承認待ちも含めて長く持つので、データベースを読むだけの処理では使わない
+	mu sync.Mutex
+}
+
+// userContextKey はリクエストのコンテキストに認証したユーザーを入れるためのキー
+type userContextKey struct{}
+
+// runServe はカレントディレクトリをプロジェクトとして、HTTPサーバーとしてエージェントを提供する
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:7777", "Address to listen on")
+	modelFlag := fs.String("model", "", "Model to use (default: config model or "+defaultModel+")")
+	maxQueue := fs.Int("max-queue", 8, "Maximum number of requests waiting for the agent; further requests get 503")
+	queueTimeout := fs.Duration("queue-timeout", 2*time.Minute, "Maximum time a request waits for the agent before getting 503")
+	fs.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	oneShot, err := newOneShotAgent(cfg, resolveModel(*modelFlag, cfg))
+	if err != nil {
+		return err
+	}
+	defer oneShot.close()
+
+	policies, err := newToolPolicies(cfg.Server.Roles)
+	if err != nil {
+		return err
+	}
+	users, err := serverUsers(cfg.Server.Users, policies)
+	if err != nil {
+		return err
+	}
+	// 料金のわからないモデルではコストが0と見積もられ、予算がいつまでも尽きないので起動しない
+	if _, ok := modelPrices[oneShot.model]; !ok {
+		for _, user := range users {
+			if user.MonthlyBudget > 0 {
+				return fmt.Errorf("server.users: %s has a monthly budget, but no price is known for model %s, so the budget cannot be enforced", user.ID, oneShot.model)
+			}
+		}
+	}
+	// 認証なしで他のホストから使えるようにはしない
+	if len(users) == 0 && !isLoopbackAddr(*addr) {
+		return fmt.Errorf("refusing to listen on %s without authentication: configure server.users in the config file", *addr)
+	}
+
+	s := &server{
+		oneShotAgent: oneShot,
+		users:        users,
+		policies:     policies,
+		available:    enabledTools(cfg),
+		policy:       readOnlyPolicy,
+		scheduler:    newRunScheduler(serverConcurrency, *maxQueue, *queueTimeout),
+	}
+	// 承認が必要な操作は、実行中のユーザーのロールで許可されているかで判断する
+	// 設定のpermissionsの許可のルールでロールの制限を迂回しないよう、許可は確認（ロールの判断）として扱う
+	rules := permissionRules(cfg.Permissions)
+	for i := range rules {
+		if rules[i].Action == tools.PermissionAllow {
+			rules[i].Action = tools.PermissionAsk
+		}
+	}
+	tools.SetPermissionRules(rules)
+	// 読み込みだけのツールでも、プロジェクトの外やnebula自身のデータ（他のユーザーのセッション）は扱えないようにする
+	denied, err := nebulaDataDirs()
+	if err != nil {
+		return err
+	}
+	tools.RestrictPaths(denied)
+	tools.SetApprover(func(request tools.ApprovalRequest) (bool, error) {
+		if s.ask != nil {
+			// 承認を尋ねる相手がいる場合は、ロールで許可された操作を（警告があるものも）その人に確認する
+			if err := s.policy.allows(request); err != nil {
+				return false, err
+			}
+			return s.ask(request)
+		}
+		return s.policy.approve(request)
+	})
+	// 読み込み後に変更されたファイルの衝突は、尋ねる相手（Slackのユーザーなど）がいれば確認し、いなければモデルに返す
+	tools.SetConflictResolver(func(conflict tools.MergeConflict) (tools.MergeChoice, error) {
+		if s.ask == nil {
+			return tools.MergeUnresolved, nil
+		}
+		return tools.ApprovalConflictResolver(conflict)
+	})
+	if cfg.Server.Slack != nil {
+		if s.slack, err = newSlackBot(s, cfg.Server.Slack, users); err != nil {
+			return err
+		}
+	}
+	go s.closeIdleSessionsPeriodically(sessionIdleTimeout(cfg))
+
+	fmt.Printf("nebula server listening on http://%s (project: %s, model: %s, users: %d)\n", *addr, s.root, s.model, len(users))
+	if s.slack != nil {
+		fmt.Printf("Slack bot enabled: events at /slack/events, interactivity at /slack/interactions (%d Slack users)\n", len(s.slack.users))
+	}
+	return http.ListenAndServe(*addr, s.routes())
+}
+
+// nebulaDataDirs はnebulaがデータベースや設定、スクラッチディレクトリを置くディレクトリを返す
+func nebulaDataDirs() ([]string, error) {
+	localDir, err := localDataDir()
+	if err != nil {
+		return nil, err
+	}
+	dataDir, err := config.DataDir()
+	if err != nil {
+		return nil, err
+	}
+	configPath, err := config.Path()
+	if err != nil {
+		return nil, err
+	}
+	return []string{localDir, dataDir, filepath.Dir(configPath)}, nil
+}
+
+// closeIdleSessionsPeriodically はアイドル時間の上限を超えたセッションを定期的に終了させる
+// データベースだけを更新し、現在のセッションには触れないので、エージェントの実行中でも実行できる
+func (s *server) closeIdleSessionsPeriodically(idle time.Duration) {
+	ticker := time.NewTicker(min(idle, 5*time.Minute))
+	defer ticker.Stop()
+	for range ticker.C {
+		closeIdleSessions(s.manager, s.cfg)
+	}
+}
+
+// serverUsers は設定のユーザーを検証し、トークンのSHA-256をキーにしたマップにする
+func serverUsers(configured []config.ServerUser, policies map[string]toolPolicy) (map[[sha256.Size]byte]config.ServerUser, error) {
+	users := map[[sha256.Size]byte]config.ServerUser{}
+	ids := map[string]bool{}
+	for _, user := range configured {
+		if user.ID == "" {
+			return nil, errors.New("server.users: id is required")
+		}
+		if ids[user.ID] {
+			return nil, fmt.Errorf("server.users: duplicate id %s", user.ID)
+		}
+		ids[user.ID] = true
+		if _, ok := policies[user.Role]; user.Role != "" && !ok {
+			return nil, fmt.Errorf("server.users: role %s of %s is not defined in server.roles", user.Role, user.ID)
+		}
+
+		decoded, err := hex.DecodeString(user.TokenSHA256)
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("server.users: token_sha256 of %s must be a hex-encoded SHA-256 hash", user.ID)
+		}
+		var hash [sha256.Size]byte
+		copy(hash[:], decoded)
+		if _, exists := users[hash]; exists {
+			return nil, fmt.Errorf("server.users: %s shares a token with another user", user.ID)
+		}
+		users[hash] = user
+	}
+	return users, nil
+}
+
+// isLoopbackAddr はアドレスがループバックインターフェースだけで待ち受けるかを返す
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// routes はサーバーのエンドポイントを登録したハンドラーを返す
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/editor/explain", s.handleEditorExplain)
+	mux.HandleFunc("POST /v1/editor/edit", s.handleEditorEdit)
+	mux.HandleFunc("POST /v1/editor/insert", s.handleEditorInsert)
+	mux.HandleFunc("GET /v1/sessions", s.handleListSessions)
+	mux.HandleFunc("GET /v1/sessions/{id}", s.handleGetSession)
+	mux.HandleFunc("GET /v1/usage", s.handleUsage)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	if s.slack == nil {
+		return s.authenticate(mux)
+	}
+	// SlackからのリクエストはBearerトークンではなくSlackの署名で検証する
+	root := http.NewServeMux()
+	root.Handle("/", s.authenticate(mux))
+	root.HandleFunc("POST /slack/events", s.slack.handleEvents)
+	root.HandleFunc("POST /slack/interactions", s.slack.handleInteractions)
+	return root
+}
+
+// authenticate はBearerトークンからユーザーを特定し、リクエストのコンテキストに入れる
+// ユーザーが設定されていない場合は認証せず、IDが空のローカルユーザーとして扱う
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.users) == 0 {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, config.ServerUser{})))
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="nebula"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing bearer token"))
+			return
+		}
+		hash := sha256.Sum256([]byte(token))
+		var matched *config.ServerUser
+		// どのユーザーのトークンと一致したかで応答時間が変わらないよう、すべて比較する
+		for key, user := range s.users {
+			if subtle.ConstantTimeCompare(hash[:], key[:]) == 1 {
+				matched = &user
+			}
+		}
+		if matched == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="nebula", error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, errors.New("invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, *matched)))
+	})
+}
+
+// requestUser はauthenticateで特定したユーザーを返す
+func requestUser(ctx context.Context) config.ServerUser {
+	user, _ := ctx.Value(userContextKey{}).(config.ServerUser)
+	return user
+}
+
+// monthStart は予算の集計を始める今月の初め（UTC）を返す
+func monthStart(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// runAgent はリクエストのユーザーの新しいセッションでユーザー入力を1件処理し、最後のアシスタントの応答を返す
+// promptExtensionはエンドポイントごとの指示としてシステムプロンプトに追加する
+// ユーザーのロールで許可されたツールだけを使い、予算がある場合は今月の残りを超えないように実行する
+func (s *server) runAgent(ctx context.Context, promptExtension, input string) (string, error) {
+	user := requestUser(ctx)
+
+	release, err := s.scheduler.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	answer, err := s.runAgentLocked(ctx, user, runOptions{}, promptExtension, input)
+	release(err)
+	return answer, err
+}
+
+// runAgentLocked はrunAgentで実行の順番が回ってきた後の処理
+// optsのユーザー・コストの上限・ツールはuserから決め、それ以外（bindingKeyやask）はそのまま使う
+func (s *server) runAgentLocked(ctx context.Context, user config.ServerUser, opts runOptions, promptExtension, input string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var remaining float64
+	if user.MonthlyBudget > 0 {
+		spent, err := s.manager.GetUserCostSince(user.ID, monthStart(time.Now()))
+		if err != nil {
+			return "", err
+		}
+		remaining = user.MonthlyBudget - spent
+		if remaining <= 0 {
+			return "", fmt.Errorf("%w: spent $%.4f of $%.2f this month", errBudgetExhausted, spent, user.MonthlyBudget)
+		}
+	}
+
+	s.policy = readOnlyPolicy
+	if user.Role != "" {
+		s.policy = s.policies[user.Role]
+	}
+	s.ask = opts.ask
+	defer func() { s.policy, s.ask = readOnlyPolicy, nil }()
+	opts.userID, opts.costLimit, opts.tools = user.ID, remaining, s.policy.filter(s.available)
+	return s.runAs(ctx, opts, promptExtension, input)
+}
+
+// writeAgentError はエージェントの実行に失敗した理由に応じたHTTPステータスでエラーを返す
+func writeAgentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errBudgetExhausted):
+		writeError(w, http.StatusTooManyRequests, err)
+	case errors.Is(err, errQueueFull), errors.Is(err, errQueueTimeout):
+		// 混雑している間はクライアントに時間をおいて再試行させる
+		w.Header().Set("Retry-After", "10")
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// handleMetrics は待ち行列の深さなどの指標をPrometheusのテキスト形式で返す
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.scheduler.writeMetrics(w)
+}
+
+// handleListSessions はリクエストのユーザーの最近のセッションを返す
+// 読み込みはデータベースだけで現在のセッションには触れないので、エージェントの実行中でも待たずに返す
+func (s *server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.manager.GetSessionsByUser(requestUser(r.Context()).ID, maxListedSessions)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if sessions == nil {
+		sessions = []*memory.SessionSummary{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": sessions})
+}
+
+// handleGetSession はセッションとそのメッセージを返す。他のユーザーのセッションは存在しないものとして扱う
+// 実行中のセッションでも、保存済みのメッセージまでを返す
+func (s *server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	session, err := s.manager.GetSession(r.PathValue("id"))
+	if err != nil || session.UserID != requestUser(r.Context()).ID {
+		writeError(w, http.StatusNotFound, errors.New("session not found"))
+		return
+	}
+	messages, err := s.manager.GetSessionMessages(session.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if messages == nil {
+		messages = []*memory.Message{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"session": session, "messages": messages})
+}
+
+// handleUsage はリクエストのユーザーの今月の推定コストと予算を返す
+func (s *server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r.Context())
+	since := monthStart(time.Now())
+
+	spent, err := s.manager.GetUserCostSince(user.ID, since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"user":          user.ID,
+		"since":         since,
+		"estimatedCost": spent,
+		"monthlyBudget": user.MonthlyBudget, // 0の場合は無制限
+	})
+}
+
+// resolveProjectPath はリクエストのファイルパスをプロジェクト内の絶対パスに解決する
+func (s *server) resolveProjectPath(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("file is required")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.root, path)
+	}
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file is outside the project: %s", path)
+	}
+	return path, nil
+}
+
+// decodeRequest はリクエストのJSONをvにデコードする。失敗した場合は400を返してfalseを返す
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+// writeJSON はvをJSONとして書き込む
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError はエラーをJSONで返す
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
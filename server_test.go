@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shibayu36/nebula/memory"
+)
+
+// TestServerReadsDuringRun はエージェントの実行中（muを持っている間）でもセッションや使用量を読めることを確認する
+func TestServerReadsDuringRun(t *testing.T) {
+	manager, err := memory.NewManager(memory.InMemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { manager.Close() })
+	s := &server{oneShotAgent: &oneShotAgent{manager: manager}}
+	handler := s.routes()
+
+	// 承認待ちなどで実行が終わらない状態
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, path := range []string{"/v1/sessions", "/v1/sessions/unknown", "/v1/usage"} {
+		t.Run(path, func(t *testing.T) {
+			done := make(chan int, 1)
+			go func() {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+				done <- recorder.Code
+			}()
+			select {
+			case code := <-done:
+				if code == http.StatusInternalServerError {
+					t.Errorf("GET %s returned %d", path, code)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("GET %s is blocked by the running agent", path)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// toolPolicy はサーバーモードでユーザーのロールに応じて使えるツールと操作を決める
+type toolPolicy struct {
+	role        string
+	permissions map[tools.ApprovalKind]bool
+	disabled    map[string]bool
+}
+
+// readOnlyPolicy はロールのないユーザーの方針。読み取り専用のツールだけを使える
+var readOnlyPolicy = toolPolicy{}
+
+// newToolPolicies は設定のロールを検証して方針に変換する
+func newToolPolicies(roles map[string]config.ServerRole) (map[string]toolPolicy, error) {
+	policies := map[string]toolPolicy{}
+	for name, role := range roles {
+		policy := toolPolicy{role: name, permissions: map[tools.ApprovalKind]bool{}, disabled: map[string]bool{}}
+		for _, permission := range role.Permissions {
+			kind := tools.ApprovalKind(permission)
+			switch kind {
+			case tools.ApprovalEdit, tools.ApprovalDelete, tools.ApprovalExecute:
+				policy.permissions[kind] = true
+			default:
+				return nil, fmt.Errorf("server.roles.%s: unknown permission %q (use edit, delete or execute)", name, permission)
+			}
+		}
+		for _, tool := range role.DisabledTools {
+			policy.disabled[tool] = true
+		}
+		policies[name] = policy
+	}
+	return policies, nil
+}
+
+// filter はこの方針で使えるツールを返す
+// 書き込みや実行の権限が1つでもあれば承認が必要なツールも渡し、操作の種類はapproveで確認する
+func (p toolPolicy) filter(available map[string]tools.ToolDefinition) map[string]tools.ToolDefinition {
+	filtered := map[string]tools.ToolDefinition{}
+	for name, tool := range available {
+		if p.disabled[name] || (!tool.ReadOnly && len(p.permissions) == 0) {
+			continue
+		}
+		filtered[name] = tool
+	}
+	return filtered
+}
+
+// approve はtools.Approverとして、承認を求められた操作がロールで許可されているかを判断する
+// サーバーには承認を尋ねる相手がいないので、許可されている操作は尋ねずに実行する
+func (p toolPolicy) approve(request tools.ApprovalRequest) (bool, error) {
+	if err := p.allows(request); err != nil {
+		return false, err
+	}
+	// 警告は確認を求める相手がいないと判断できないので実行しない
+	if len(request.Warnings) > 0 {
+		return false, fmt.Errorf("書き込み先に警告があるため、サーバーモードでは実行できません: %s", request.Warnings[0])
+	}
+	return true, nil
+}
+
+// allows は操作がロールで許可されているかを確認し、許可されていなければ理由をエラーで返す
+func (p toolPolicy) allows(request tools.ApprovalRequest) error {
+	if p.disabled[request.Tool] {
+		return fmt.Errorf("ロール%sでは%sを使えません", p.roleName(), request.Tool)
+	}
+	if !p.permissions[request.Kind] {
+		return fmt.Errorf("ロール%sには%sの権限がありません", p.roleName(), request.Kind)
+	}
+	return nil
+}
+
+// roleName はエラーメッセージに使うロール名を返す
+func (p toolPolicy) roleName() string {
+	if p.role == "" {
+		return "（なし）"
+	}
+	return p.role
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shibayu36/nebula/memory"
+)
+
+// sessionTitleWidth はセッション一覧で表示するタイトル（最初のユーザー入力）の最大の表示幅
+const sessionTitleWidth = 50
+
+// sessionListOptions は--list-sessionsの表示方法
+type sessionListOptions struct {
+	allProjects bool // 現在のプロジェクト以外のセッションも表示する
+	limit       int  // 1ページに表示する件数
+	page        int  // 表示するページ（1始まり）
+	json        bool // 表ではなくJSONで出力する
+}
+
+// listSessions はセッションの一覧を表またはJSONで表示する
+func listSessions(manager *memory.Manager, opts sessionListOptions) error {
+	if opts.limit <= 0 {
+		return fmt.Errorf("--limit must be positive")
+	}
+	if opts.page <= 0 {
+		return fmt.Errorf("--page must be positive")
+	}
+	offset := (opts.page - 1) * opts.limit
+
+	var sessions []*memory.SessionSummary
+	var err error
+	if opts.allProjects {
+		sessions, err = manager.GetRecentSessions(opts.limit, offset)
+	} else {
+		projectPath, projectErr := currentProject()
+		if projectErr != nil {
+			return projectErr
+		}
+		sessions, err = manager.GetSessionsByProject(projectPath, opts.limit, offset)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	if opts.json {
+		if sessions == nil {
+			sessions = []*memory.SessionSummary{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(sessions)
+	}
+
+	if len(sessions) == 0 {
+		switch {
+		case opts.page > 1:
+			fmt.Printf("No sessions on page %d.\n", opts.page)
+		case opts.allProjects:
+			fmt.Println("No sessions found.")
+		default:
+			fmt.Println("No sessions found for current project.")
+		}
+		return nil
+	}
+
+	headers := []string{"ID", "Status", "Started At", "Duration", "Msgs", "Cost", "Branch"}
+	if opts.allProjects {
+		headers = append(headers, "Project")
+	}
+	headers = append(headers, "Title")
+
+	var rows [][]string
+	for _, s := range sessions {
+		status := "active"
+		if s.EndedAt != nil {
+			status = "ended"
+		}
+		row := []string{
+			s.ID,
+			status,
+			s.StartedAt.Format("2006-01-02 15:04"),
+			formatSessionDuration(s.Duration()),
+			fmt.Sprint(s.MessageCount),
+			fmt.Sprintf("$%.4f", s.EstimatedCost),
+			s.Branch,
+		}
+		if opts.allProjects {
+			row = append(row, s.ProjectPath)
+		}
+		title := s.Title
+		if s.Incognito {
+			title = "(incognito)"
+		}
+		rows = append(rows, append(row, truncateDisplay(singleLine(title), sessionTitleWidth)))
+	}
+
+	fmt.Printf("Sessions (page %d):\n", opts.page)
+	printTable(headers, rows, map[int]bool{3: true, 4: true, 5: true})
+	if len(sessions) == opts.limit {
+		fmt.Printf("\nMore sessions may exist: use --page %d to see the next page.\n", opts.page+1)
+	}
+	return nil
+}
+
+// printTable は列の表示幅をそろえて表を出力する。rightAlignedの列は右寄せにする
+func printTable(headers []string, rows [][]string, rightAligned map[int]bool) {
+	widths := make([]int, len(headers))
+	for i, header := range headers {
+		widths[i] = displayWidth(header)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], displayWidth(cell))
+		}
+	}
+
+	printRow := func(cells []string) {
+		formatted := make([]string, len(cells))
+		for i, cell := range cells {
+			switch {
+			case rightAligned[i]:
+				formatted[i] = strings.Repeat(" ", widths[i]-displayWidth(cell)) + cell
+			case i == len(cells)-1:
+				// 最後の列は行末に空白を残さない
+				formatted[i] = cell
+			default:
+				formatted[i] = padDisplay(cell, widths[i])
+			}
+		}
+		fmt.Println(strings.Join(formatted, "  "))
+	}
+
+	printRow(headers)
+	total := 2 * (len(widths) - 1)
+	for _, width := range widths {
+		total += width
+	}
+	fmt.Println(strings.Repeat("-", total))
+	for _, row := range rows {
+		printRow(row)
+	}
+}
+
+// formatSessionDuration はセッションの長さを"1h05m"や"12m"のように短く表す
+func formatSessionDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dd%02dh", int(d.Hours())/24, int(d.Hours())%24)
+	}
+}
+
+// currentBranch は現在のgitのブランチ名を返す。リポジトリ外やdetached HEADでは空文字列を返す
+func currentBranch() string {
+	branch, err := runGit(nil, "branch", "--show-current")
+	if err != nil {
+		return ""
+	}
+	return branch
+}
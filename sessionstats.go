@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/shibayu36/nebula/memory"
+)
+
+// sessionStats は--session-stats --jsonで出力する内容
+type sessionStats struct {
+	Session     *memory.Session      `json:"session"` // 推定コストはsession.estimated_cost
+	Usage       *memory.SessionUsage `json:"usage"`
+	TotalTokens int                  `json:"total_tokens"`
+}
+
+// showSessionStats はセッションのメッセージ数・トークン数・推定コストを表示する
+func showSessionStats(manager *memory.Manager, sessionID string, asJSON bool) error {
+	session, err := manager.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	usage, err := manager.GetSessionUsage(session.ID)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(sessionStats{
+			Session:     session,
+			Usage:       usage,
+			TotalTokens: usage.TotalTokens(),
+		})
+	}
+
+	end := session.LastActiveAt
+	if session.EndedAt != nil && session.EndedAt.Before(end) {
+		end = *session.EndedAt
+	}
+	project := session.ProjectPath
+	if session.Branch != "" {
+		project += fmt.Sprintf(" (%s)", session.Branch)
+	}
+	cost := fmt.Sprintf("$%.4f", session.EstimatedCost)
+	if _, ok := modelPrices[session.ModelUsed]; !ok {
+		cost += fmt.Sprintf(" (no price is known for %s)", session.ModelUsed)
+	}
+
+	rows := [][2]string{
+		{"Session", session.ID},
+		{"Project", project},
+		{"Model", session.ModelUsed},
+		{"Started", session.StartedAt.Local().Format("2006-01-02 15:04")},
+		{"Duration", formatSessionDuration(max(end.Sub(session.StartedAt), 0))},
+		{"Messages", fmt.Sprintf("%d (user %d, assistant %d, tool %d)",
+			usage.UserMessages+usage.AssistantMessages+usage.ToolMessages, usage.UserMessages, usage.AssistantMessages, usage.ToolMessages)},
+		{"Prompt tokens", formatCount(usage.PromptTokens)},
+		{"Completion tokens", formatCount(usage.CompletionTokens)},
+		{"Total tokens", formatCount(usage.TotalTokens())},
+		{"Estimated cost", cost},
+	}
+	width := 0
+	for _, row := range rows {
+		width = max(width, displayWidth(row[0]))
+	}
+	for _, row := range rows {
+		fmt.Printf("%s  %s\n", padDisplay(row[0]+":", width+1), row[1])
+	}
+	return nil
+}
+
+// formatCount は数を3桁ごとにカンマで区切って返す
+func formatCount(n int) string {
+	digits := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return sign + digits
+}
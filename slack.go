@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/tools"
+)
+
+const (
+	// slackAPIBaseURL はSlack Web APIのベースURL
+	slackAPIBaseURL = "https://slack.com/api/"
+	// slackMaxRequestBytes はSlackから受け取るリクエストの本文の最大バイト数
+	slackMaxRequestBytes = 1 << 20
+	// slackMaxClockSkew は署名を検証するリクエストの時刻のずれの上限。古いリクエストの再送を受け付けない
+	slackMaxClockSkew = 5 * time.Minute
+	// slackMaxMessageChars はSlackに投稿する1件のメッセージの最大文字数
+	slackMaxMessageChars = 39000
+	// slackMaxDetailChars は承認を求めるメッセージに載せる差分やコマンドの最大文字数
+	slackMaxDetailChars = 2500
+	// defaultSlackApprovalTimeout はボタンでの承認を待つ時間の上限のデフォルト
+	defaultSlackApprovalTimeout = 10 * time.Minute
+	// slackNewSessionCommand はチャンネルでの自分のセッションを終えて新しい会話を始めるメッセージ
+	slackNewSessionCommand = "!new"
+)
+
+// slackMentionPattern はメッセージの先頭のボットへのメンション
+var slackMentionPattern = regexp.MustCompile(`^\s*<@[A-Z0-9]+>\s*`)
+
+// slackPromptExtension はSlackから使う場合のシステムプロンプトの追記
+const slackPromptExtension = `
+
+# Slack
+You are talking with the user in Slack, and this conversation continues across their messages in the same channel.
+Other people in the channel have their own separate conversations with you.
+Format responses with Slack mrkdwn (*bold*, _italic_, ` + "`code`" + `, ` + "```code blocks```" + `) rather than Markdown headings or tables, and keep them concise.
+Changes to files are posted to the channel as a diff after your response, so do not repeat whole files.`
+
+// slackBot はSlackのチャンネルやDMのメッセージをエージェントへの入力として処理する
+// チャンネル（DM）とユーザーごとに1つのセッションを続け、承認が必要な操作はボタンで確認し、変更した差分をスニペットとして投稿する
+type slackBot struct {
+	s               *server
+	api             *slackAPI
+	signingSecret   string
+	users           map[string]config.ServerUser // SlackのユーザーIDごとのサーバーのユーザー
+	channels        map[string]bool              // メンションに応答するチャンネル。空の場合はすべて
+	approvalTimeout time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]bool          // 処理したイベントのID。Slackの再送を二重に処理しない
+	seenOrder []string                 // seenから古いIDを捨てるための順序
+	approvals map[string]*slackPending // ボタンの応答を待っている承認
+	changes   *fileChangeSet           // 実行中のターンで変更されたファイル
+}
+
+// slackPending はボタンの応答を待っている承認
+type slackPending struct {
+	user     string // 承認できるSlackのユーザー（依頼した人）
+	decision chan bool
+}
+
+// newSlackBot は設定を検証してボットを作る
+func newSlackBot(s *server, cfg *config.SlackConfig, serverUsers map[[sha256.Size]byte]config.ServerUser) (*slackBot, error) {
+	token, secret := cfg.BotToken(), cfg.SigningSecret()
+	if token == "" || secret == "" {
+		return nil, errors.New("server.slack: set the bot token and signing secret in SLACK_BOT_TOKEN and SLACK_SIGNING_SECRET (or the variables named by bot_token_env and signing_secret_env)")
+	}
+	byID := map[string]config.ServerUser{}
+	for _, user := range serverUsers {
+		byID[user.ID] = user
+	}
+	users := map[string]config.ServerUser{}
+	for slackUser, id := range cfg.Users {
+		user, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("server.slack.users: %s is mapped to %s, which is not defined in server.users", slackUser, id)
+		}
+		users[slackUser] = user
+	}
+	if len(users) == 0 {
+		return nil, errors.New("server.slack.users: map at least one Slack user ID to a server user")
+	}
+	channels := map[string]bool{}
+	for _, channel := range cfg.Channels {
+		channels[channel] = true
+	}
+	timeout := defaultSlackApprovalTimeout
+	if cfg.ApprovalTimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.ApprovalTimeoutSeconds) * time.Second
+	}
+
+	b := &slackBot{
+		s:               s,
+		api:             &slackAPI{token: token, baseURL: slackAPIBaseURL, client: &http.Client{Timeout: 30 * time.Second}},
+		signingSecret:   secret,
+		users:           users,
+		channels:        channels,
+		approvalTimeout: timeout,
+		seen:            map[string]bool{},
+		approvals:       map[string]*slackPending{},
+	}
+	tools.OnFileChange(b.recordChange)
+	return b, nil
+}
+
+// verify はSlackの署名（v0=HMAC-SHA256(v0:タイムスタンプ:本文)）とタイムスタンプを検証し、本文を返す
+func (b *slackBot) verify(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, slackMaxRequestBytes))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("missing request timestamp")
+	}
+	if skew := time.Since(time.Unix(seconds, 0)); skew > slackMaxClockSkew || skew < -slackMaxClockSkew {
+		return nil, errors.New("request timestamp is too far from the current time")
+	}
+	mac := hmac.New(sha256.New, []byte(b.signingSecret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature"))) {
+		return nil, errors.New("invalid signature")
+	}
+	return body, nil
+}
+
+// slackEventEnvelope はEvents APIのリクエスト
+type slackEventEnvelope struct {
+	Type      string     `json:"type"`
+	Challenge string     `json:"challenge"`
+	EventID   string     `json:"event_id"`
+	Event     slackEvent `json:"event"`
+}
+
+// slackEvent はメッセージのイベント
+type slackEvent struct {
+	Type        string `json:"type"` // message または app_mention
+	Subtype     string `json:"subtype"`
+	User        string `json:"user"`
+	BotID       string `json:"bot_id"`
+	Text        string `json:"text"`
+	Channel     string `json:"channel"`
+	ChannelType string `json:"channel_type"`
+	TS          string `json:"ts"`
+	ThreadTS    string `json:"thread_ts"`
+}
+
+// handleEvents はEvents APIのリクエストを受け取る。Slackは3秒以内の応答を求めるので、エージェントはバックグラウンドで実行する
+func (b *slackBot) handleEvents(w http.ResponseWriter, r *http.Request) {
+	body, err := b.verify(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	var envelope slackEventEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid event: %w", err))
+		return
+	}
+
+	switch envelope.Type {
+	case "url_verification":
+		writeJSON(w, http.StatusOK, map[string]string{"challenge": envelope.Challenge})
+		return
+	case "event_callback":
+		if b.firstDelivery(envelope.EventID) {
+			if event := envelope.Event; b.accepts(event) {
+				go b.handleMessage(event)
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// firstDelivery はイベントを初めて受け取った場合にtrueを返す。応答が遅れたときのSlackの再送を無視する
+func (b *slackBot) firstDelivery(eventID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if eventID == "" || b.seen[eventID] {
+		return eventID == ""
+	}
+	b.seen[eventID] = true
+	b.seenOrder = append(b.seenOrder, eventID)
+	if len(b.seenOrder) > 1000 {
+		delete(b.seen, b.seenOrder[0])
+		b.seenOrder = b.seenOrder[1:]
+	}
+	return true
+}
+
+// accepts はエージェントへの入力として扱うメッセージかを返す
+// DMのメッセージと、対象のチャンネルでのメンションを扱い、ボット自身の投稿や編集・削除などは無視する
+func (b *slackBot) accepts(event slackEvent) bool {
+	if event.BotID != "" || event.Subtype != "" || event.User == "" {
+		return false
+	}
+	switch {
+	case event.Type == "message" && event.ChannelType == "im":
+		return true
+	case event.Type == "app_mention":
+		return len(b.channels) == 0 || b.channels[event.Channel]
+	default:
+		return false
+	}
+}
+
+// handleMessage はメッセージをチャンネルのセッションへの入力として処理し、応答と差分を投稿する
+func (b *slackBot) handleMessage(event slackEvent) {
+	ctx := context.Background()
+	// チャンネルでは依頼のメッセージのスレッドに返信し、DMではそのまま返信する
+	thread := ""
+	if event.ChannelType != "im" {
+		thread = event.ThreadTS
+		if thread == "" {
+			thread = event.TS
+		}
+	}
+	reply := func(text string) {
+		if _, err := b.api.postMessage(ctx, event.Channel, thread, text, nil); err != nil {
+			fmt.Printf("Warning: failed to post to Slack: %v\n", err)
+		}
+	}
+
+	user, ok := b.users[event.User]
+	if !ok {
+		reply("Sorry, you are not allowed to use this agent. Ask the administrator to add your Slack user ID to server.slack.users.")
+		return
+	}
+	input := strings.TrimSpace(slackMentionPattern.ReplaceAllString(event.Text, ""))
+	// 共有のチャンネルでも他の人のセッション（会話の内容や予算）を使わないよう、チャンネルとユーザーごとにセッションを分ける
+	key := "slack:" + event.Channel + ":" + event.User
+	if input == slackNewSessionCommand {
+		b.s.mu.Lock()
+		err := b.s.manager.UnbindSession(key)
+		b.s.mu.Unlock()
+		if err != nil {
+			reply(fmt.Sprintf("Error: %v", err))
+			return
+		}
+		reply("Started a new conversation. The next message begins a new session.")
+		return
+	}
+	if input == "" {
+		return
+	}
+
+	release, err := b.s.scheduler.acquire(ctx)
+	if err != nil {
+		reply(fmt.Sprintf("Error: %v", err))
+		return
+	}
+	changes := &fileChangeSet{}
+	b.mu.Lock()
+	b.changes = changes
+	b.mu.Unlock()
+	answer, err := b.s.runAgentLocked(ctx, user, runOptions{
+		bindingKey: key,
+		ask:        b.approver(ctx, event.Channel, thread, event.User),
+	}, slackPromptExtension, input)
+	b.mu.Lock()
+	b.changes = nil
+	b.mu.Unlock()
+	release(err)
+
+	if err != nil {
+		reply(fmt.Sprintf("Error: %v", err))
+	} else {
+		reply(answer)
+	}
+	if patch := changes.patch(b.s.root); patch != "" {
+		if err := b.api.uploadSnippet(ctx, event.Channel, thread, "changes.diff", "diff", patch); err != nil {
+			fmt.Printf("Warning: failed to upload the diff to Slack: %v\n", err)
+			reply("Changed files:\n" + strings.Join(changes.paths(b.s.root), "\n"))
+		}
+	}
+}
+
+// recordChange はSlackからの実行中にツールが変更したファイルを記録する
+func (b *slackBot) recordChange(change tools.FileChange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.changes != nil {
+		b.changes.add(change)
+	}
+}
+
+// approver は承認が必要な操作をボタン付きのメッセージで依頼した人に確認するtools.Approverを返す
+func (b *slackBot) approver(ctx context.Context, channel, thread, slackUser string) tools.Approver {
+	return func(request tools.ApprovalRequest) (bool, error) {
+		id := rand.Text()
+		pending := &slackPending{user: slackUser, decision: make(chan bool, 1)}
+		b.mu.Lock()
+		b.approvals[id] = pending
+		b.mu.Unlock()
+		defer func() {
+			b.mu.Lock()
+			delete(b.approvals, id)
+			b.mu.Unlock()
+		}()
+
+		text := fmt.Sprintf("<@%s> %s", slackUser, request.Title)
+		ts, err := b.api.postMessage(ctx, channel, thread, text, approvalBlocks(id, text, request))
+		if err != nil {
+			return false, fmt.Errorf("Slackで承認を求められませんでした: %w", err)
+		}
+
+		var approved bool
+		select {
+		case approved = <-pending.decision:
+		case <-time.After(b.approvalTimeout):
+			b.api.updateMessage(ctx, channel, ts, text+"\n:hourglass: Timed out waiting for approval; not executed.")
+			return false, fmt.Errorf("承認の待ち時間（%s）を過ぎたため実行しませんでした", b.approvalTimeout)
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		if !approved {
+			b.api.updateMessage(ctx, channel, ts, text+fmt.Sprintf("\n:x: Denied by <@%s>", slackUser))
+			return false, errors.New("ユーザーがSlackで拒否しました")
+		}
+		b.api.updateMessage(ctx, channel, ts, text+fmt.Sprintf("\n:white_check_mark: Approved by <@%s>", slackUser))
+		return true, nil
+	}
+}
+
+// approvalBlocks は操作の内容と承認・拒否のボタンを表示するBlock Kitのブロックを返す
+func approvalBlocks(id, text string, request tools.ApprovalRequest) []any {
+	body := text
+	for _, warning := range request.Warnings {
+		body += "\n:warning: " + warning
+	}
+	if detail := strings.TrimSpace(request.Detail); detail != "" {
+		if runes := []rune(detail); len(runes) > slackMaxDetailChars {
+			detail = string(runes[:slackMaxDetailChars]) + "\n..."
+		}
+		// コードブロックが途中で閉じないよう、本文中の```を崩す
+		body += "\n```" + strings.ReplaceAll(detail, "```", "`​``") + "```"
+	}
+	return []any{
+		map[string]any{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": body}},
+		map[string]any{"type": "actions", "elements": []any{
+			map[string]any{"type": "button", "action_id": "approve", "value": id, "style": "primary", "text": map[string]string{"type": "plain_text", "text": "Approve"}},
+			map[string]any{"type": "button", "action_id": "deny", "value": id, "style": "danger", "text": map[string]string{"type": "plain_text", "text": "Deny"}},
+		}},
+	}
+}
+
+// slackInteraction はボタンを押したときにInteractivityのURLに送られる内容
+type slackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// handleInteractions は承認・拒否のボタンの応答を受け取り、待っている承認に伝える。依頼した人以外の応答は無視する
+func (b *slackBot) handleInteractions(w http.ResponseWriter, r *http.Request) {
+	body, err := b.verify(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var interaction slackInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &interaction); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %w", err))
+		return
+	}
+
+	if interaction.Type == "block_actions" {
+		for _, action := range interaction.Actions {
+			b.mu.Lock()
+			pending, ok := b.approvals[action.Value]
+			b.mu.Unlock()
+			if !ok || pending.user != interaction.User.ID {
+				continue
+			}
+			select {
+			case pending.decision <- action.ActionID == "approve":
+			default:
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// fileChangeSet は1ターンで変更されたファイルの最初の変更前と最後の変更後の内容
+type fileChangeSet struct {
+	order  []string
+	before map[string]*string
+	after  map[string]*string
+}
+
+func (c *fileChangeSet) add(change tools.FileChange) {
+	path, err := filepath.Abs(change.Path)
+	if err != nil {
+		path = change.Path
+	}
+	if c.before == nil {
+		c.before, c.after = map[string]*string{}, map[string]*string{}
+	}
+	if _, ok := c.before[path]; !ok {
+		c.order = append(c.order, path)
+		c.before[path] = change.OldContent
+	}
+	c.after[path] = change.NewContent
+}
+
+// patch は変更をgit形式のパッチにする。変更がなければ空文字列を返す
+func (c *fileChangeSet) patch(root string) string {
+	var b strings.Builder
+	for i, rel := range c.paths(root) {
+		path := c.order[i]
+		b.WriteString(formatGitPatch(rel, c.before[path], c.after[path]))
+	}
+	return b.String()
+}
+
+// paths は変更したファイルのrootからの相対パスを返す
+func (c *fileChangeSet) paths(root string) []string {
+	paths := make([]string, 0, len(c.order))
+	for _, path := range c.order {
+		if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+		paths = append(paths, filepath.ToSlash(path))
+	}
+	return paths
+}
+
+// slackAPI はSlack Web APIのクライアント
+type slackAPI struct {
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+// call はWeb APIのメソッドを呼び出し、応答をresultに読み込む。okがfalseの場合はerrorの内容をエラーとして返す
+func (a *slackAPI) call(ctx context.Context, method string, params url.Values, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("%s: unexpected response (%s)", method, resp.Status)
+	}
+	if !status.OK {
+		return fmt.Errorf("%s: %s", method, status.Error)
+	}
+	if result != nil {
+		return json.Unmarshal(body, result)
+	}
+	return nil
+}
+
+// postMessage はメッセージを投稿し、そのタイムスタンプを返す。threadが空でなければスレッドに返信する
+func (a *slackAPI) postMessage(ctx context.Context, channel, thread, text string, blocks []any) (string, error) {
+	if runes := []rune(text); len(runes) > slackMaxMessageChars {
+		text = string(runes[:slackMaxMessageChars]) + "\n...(truncated)"
+	}
+	params := url.Values{"channel": {channel}, "text": {text}}
+	if thread != "" {
+		params.Set("thread_ts", thread)
+	}
+	if blocks != nil {
+		data, err := json.Marshal(blocks)
+		if err != nil {
+			return "", err
+		}
+		params.Set("blocks", string(data))
+	}
+	var result struct {
+		TS string `json:"ts"`
+	}
+	if err := a.call(ctx, "chat.postMessage", params, &result); err != nil {
+		return "", err
+	}
+	return result.TS, nil
+}
+
+// updateMessage はメッセージの本文を置き換え、ボタンを取り除く
+func (a *slackAPI) updateMessage(ctx context.Context, channel, ts, text string) {
+	params := url.Values{"channel": {channel}, "ts": {ts}, "text": {text}, "blocks": {"[]"}}
+	if err := a.call(ctx, "chat.update", params, nil); err != nil {
+		fmt.Printf("Warning: failed to update Slack message: %v\n", err)
+	}
+}
+
+// uploadSnippet はcontentをスニペットとしてチャンネル（スレッド）に共有する
+func (a *slackAPI) uploadSnippet(ctx context.Context, channel, thread, filename, snippetType, content string) error {
+	var upload struct {
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	params := url.Values{"filename": {filename}, "length": {strconv.Itoa(len(content))}, "snippet_type": {snippetType}}
+	if err := a.call(ctx, "files.getUploadURLExternal", params, &upload); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upload.UploadURL, bytes.NewReader([]byte(content)))
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", filename, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to upload %s: %s", filename, resp.Status)
+	}
+
+	files, _ := json.Marshal([]map[string]string{{"id": upload.FileID, "title": filename}})
+	params = url.Values{"files": {string(files)}, "channel_id": {channel}}
+	if thread != "" {
+		params.Set("thread_ts", thread)
+	}
+	return a.call(ctx, "files.completeUploadExternal", params, nil)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/memory"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// clearRole は/clearで会話を消したことをメッセージとして保存するときのrole
+const clearRole = "clear"
+
+// chatREPL は対話ループの状態。スラッシュコマンドはこれを参照・変更する
+type chatREPL struct {
+	ag             *agent
+	manager        *memory.Manager
+	mode           agentMode
+	basePrompt     string
+	availableTools map[string]tools.ToolDefinition
+	toolNames      []string
+	webhooks       *webhookNotifier // Webhookが設定されていなければnil
+	exit           bool             // trueになると対話ループを終了する
+}
+
+// slashCommand は対話ループで/から始まる入力として実行するコマンド
+type slashCommand struct {
+	name        string
+	args        string // /helpに表示する引数の書式
+	description string
+	run         func(r *chatREPL, arg string) error
+}
+
+// slashCommands は利用できるスラッシュコマンドを/helpに表示する順に返す
+func slashCommands() []slashCommand {
+	return []slashCommand{
+		{name: "help", description: "Show the available commands", run: (*chatREPL).help},
+		{name: "tools", description: "List the tools available in the current mode", run: (*chatREPL).listTools},
+		{name: "mode", args: "[name]", description: "Show or switch the mode (" + strings.Join(modeNames(), ", ") + ")", run: (*chatREPL).switchMode},
+		{name: "model", args: "[name]", description: "Show or switch the model", run: (*chatREPL).switchModel},
+		{name: "session", description: "Show the current session, its token usage and estimated cost", run: (*chatREPL).showSession},
+		{name: "clear", description: "Forget the conversation so far and start over in the same session", run: (*chatREPL).clear},
+		{name: "compact", description: "Replace the conversation so far with a summary to free up context", run: (*chatREPL).compact},
+		{name: "continue", description: "Continue a response that was cut off at the token limit", run: (*chatREPL).continueResponse},
+		{name: "restore", description: "Undo the file changes of the last turn (requires --snapshot-turns)", run: (*chatREPL).restore},
+		{name: memory.RatingGood, args: "[comment]", description: "Rate the last response as good", run: func(r *chatREPL, comment string) error { return r.rate(memory.RatingGood, comment) }},
+		{name: memory.RatingBad, args: "[comment]", description: "Rate the last response as bad", run: func(r *chatREPL, comment string) error { return r.rate(memory.RatingBad, comment) }},
+		{name: "exit", description: "End the conversation (also: exit, quit, /quit)", run: (*chatREPL).quit},
+		{name: "quit", run: (*chatREPL).quit},
+	}
+}
+
+// parseSlashCommand は入力をコマンド名と引数に分ける
+// "/usr/bin/env は何？"のようにパスで始まる入力はコマンドとみなさずokはfalse
+func parseSlashCommand(input string) (name, arg string, ok bool) {
+	if !strings.HasPrefix(input, "/") {
+		return "", "", false
+	}
+	name, arg, _ = strings.Cut(input[1:], " ")
+	if name == "" || strings.Contains(name, "/") {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(arg), true
+}
+
+// handleCommand は入力がスラッシュコマンドか終了コマンドであれば実行してtrueを返す。falseならLLMに送る
+func (r *chatREPL) handleCommand(input string) bool {
+	if input == "exit" || input == "quit" {
+		r.quit("")
+		return true
+	}
+	name, arg, ok := parseSlashCommand(input)
+	if !ok {
+		return false
+	}
+	for _, command := range slashCommands() {
+		if command.name == name {
+			if err := command.run(r, arg); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			return true
+		}
+	}
+	fmt.Printf("Unknown command: /%s (type /help to see the available commands)\n", name)
+	return true
+}
+
+// runUserInput はhandleUserInputでユーザー入力1件を処理する。Ctrl+Cで中断した場合やエラーの場合も次の入力を待つ
+func (r *chatREPL) runUserInput(input string) {
+	started := time.Now()
+	startCost := r.manager.GetCurrentSession().EstimatedCost
+
+	err := r.ag.runInterruptible(func() error { return r.ag.handleUserInput(input) })
+	if errors.Is(err, errTurnInterrupted) {
+		fmt.Println("Interrupted.")
+	} else if err != nil {
+		fmt.Printf("Error handling user input: %v\n", err)
+	}
+
+	if r.webhooks != nil {
+		// ターンの前に会話が要約に置き換えられることがあるので、最後のユーザー入力以降をこのターンのメッセージとする
+		turn := len(r.ag.messages)
+		for turn > 0 && r.ag.messages[turn-1].Role != openai.ChatMessageRoleUser {
+			turn--
+		}
+		r.webhooks.turnCompleted(input, r.ag.messages[turn:], started, r.manager.GetCurrentSession().EstimatedCost-startCost, err)
+	}
+}
+
+// runTurn はCtrl+Cで中断できるようにしてモデルを呼び出すコマンドを実行する
+func (r *chatREPL) runTurn(turn func() error) error {
+	err := r.ag.runInterruptible(turn)
+	if errors.Is(err, errTurnInterrupted) {
+		fmt.Println("Interrupted.")
+		return nil
+	}
+	return err
+}
+
+func (r *chatREPL) help(string) error {
+	commands := slashCommands()
+	width := 0
+	for _, command := range commands {
+		width = max(width, displayWidth(command.usage()))
+	}
+	fmt.Println("Commands:")
+	for _, command := range commands {
+		if command.description == "" {
+			continue
+		}
+		fmt.Printf("  %s  %s\n", padDisplay(command.usage(), width), command.description)
+	}
+	fmt.Println("Anything else is sent to the model. Press Ctrl+C to interrupt a response.")
+	fmt.Println("Multi-line input: paste text and press Enter on the empty line, press Alt+Enter for a newline, or start a line with ``` and end the block with ```.")
+	return nil
+}
+
+// usage は/helpに表示するコマンドの書式を返す
+func (c slashCommand) usage() string {
+	if c.args == "" {
+		return "/" + c.name
+	}
+	return "/" + c.name + " " + c.args
+}
+
+func (r *chatREPL) listTools(string) error {
+	names := append([]string(nil), r.toolNames...)
+	sort.Strings(names)
+	fmt.Printf("Tools available in %s mode:\n", r.mode.Name)
+	for _, name := range names {
+		description := ""
+		if tool := r.ag.tools[name]; tool.Schema.Function != nil {
+			description, _, _ = strings.Cut(tool.Schema.Function.Description, "\n")
+		}
+		fmt.Printf("  %s  %s\n", name, description)
+	}
+	fmt.Println("Run `nebula tools` for their parameters and approval requirements.")
+	return nil
+}
+
+func (r *chatREPL) switchMode(name string) error {
+	if name == "" {
+		fmt.Printf("Current mode: %s (available: %s)\n", r.mode.Name, strings.Join(modeNames(), ", "))
+		return nil
+	}
+	mode, err := lookupMode(name)
+	if err != nil {
+		return err
+	}
+	r.mode = mode
+	r.ag.tools, r.toolNames = mode.filterTools(r.availableTools)
+	r.ag.messages[0].Content = mode.systemPrompt(r.basePrompt)
+	fmt.Printf("Switched to %s mode. Available tools: %s\n", mode.Name, strings.Join(r.toolNames, ", "))
+	return nil
+}
+
+func (r *chatREPL) switchModel(name string) error {
+	if name == "" {
+		fmt.Printf("Current model: %s\n", r.ag.model)
+		return nil
+	}
+	// 再開したときも同じモデルを使うよう、セッションのモデルも切り替える
+	if err := r.manager.UpdateSessionModel(name); err != nil {
+		return fmt.Errorf("failed to update session model: %w", err)
+	}
+	r.ag.model = name
+	fmt.Printf("Switched to model %s.\n", name)
+	if _, ok := modelPrices[name]; !ok {
+		fmt.Printf("No price is known for %s, so its cost is not estimated or limited.\n", name)
+	}
+	return nil
+}
+
+func (r *chatREPL) showSession(string) error {
+	fmt.Printf("Mode: %s\n", r.mode.Name)
+	return showSessionStats(r.manager, r.manager.GetCurrentSession().ID, false)
+}
+
+// clear はシステムプロンプト以外の会話を消す。セッションを再開したときも消した会話は読み込まない
+func (r *chatREPL) clear(string) error {
+	if len(r.ag.messages) <= 1 {
+		fmt.Println("The conversation is already empty.")
+		return nil
+	}
+	err := r.manager.SaveMessages([]memory.Message{{Role: clearRole, Timestamp: time.Now()}})
+	if err != nil {
+		return fmt.Errorf("failed to save the session: %w", err)
+	}
+	r.ag.messages = []openai.ChatCompletionMessage{r.ag.messages[0]}
+	r.ag.contextTokens = 0
+	r.ag.truncated = false
+	fmt.Println("Cleared the conversation. The next message starts from scratch.")
+	return nil
+}
+
+func (r *chatREPL) compact(string) error {
+	return r.runTurn(r.ag.compact)
+}
+
+func (r *chatREPL) continueResponse(string) error {
+	return r.runTurn(r.ag.continueResponse)
+}
+
+func (r *chatREPL) restore(string) error {
+	if r.ag.snapshots == nil {
+		return errors.New("snapshots are disabled; start nebula with --snapshot-turns or set snapshot_turns in the config")
+	}
+	return r.ag.snapshots.Restore()
+}
+
+// rate は直前の応答をratingとして評価し、コメントがあれば記録する
+func (r *chatREPL) rate(rating, comment string) error {
+	if err := r.manager.RateLastAssistantMessage(rating, comment); err != nil {
+		return err
+	}
+	fmt.Printf("Rated the last response as %s.\n", rating)
+	return nil
+}
+
+func (r *chatREPL) quit(string) error {
+	fmt.Println("Goodbye!")
+	r.exit = true
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// completionResult はストリーミングで受信した応答を1つにまとめたもの
+type completionResult struct {
+	Message      openai.ChatCompletionMessage
+	FinishReason openai.FinishReason
+	Usage        openai.Usage
+}
+
+// streamCompletion はチャット補完をストリーミングで要求し、受信したトークンを逐次表示しながら応答を組み立てる
+// テキストの前にはheaderを表示し、ツール呼び出しの引数も差分ごとに表示する
+func (a *agent) streamCompletion(request openai.ChatCompletionRequest, header string) (*completionResult, error) {
+	request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+
+	stream, err := a.client.CreateChatCompletionStream(a.context(), request)
+	if err != nil {
+		return nil, fmt.Errorf("error calling LLM API: %v", err)
+	}
+	defer stream.Close()
+
+	result := &completionResult{
+		Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant},
+	}
+	var content strings.Builder
+	var toolCalls []openai.ToolCall
+	printedHeader := false
+	received := false
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fmt.Println()
+			return nil, fmt.Errorf("error receiving stream from LLM API: %v", err)
+		}
+
+		// usageは最後のチャンクにだけ含まれる
+		if chunk.Usage != nil {
+			result.Usage = *chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		received = true
+		choice := chunk.Choices[0]
+
+		if choice.Delta.Content != "" {
+			if !printedHeader {
+				fmt.Print(header)
+				printedHeader = true
+			}
+			fmt.Print(choice.Delta.Content)
+			content.WriteString(choice.Delta.Content)
+			if a.observer != nil {
+				a.observer.AssistantText(choice.Delta.Content)
+			}
+		}
+
+		// ツール呼び出しはindexごとに差分が届くので連結する
+		for _, delta := range choice.Delta.ToolCalls {
+			index := len(toolCalls)
+			if delta.Index != nil {
+				index = *delta.Index
+			}
+			for len(toolCalls) <= index {
+				toolCalls = append(toolCalls, openai.ToolCall{Type: openai.ToolTypeFunction})
+			}
+
+			toolCall := &toolCalls[index]
+			if delta.ID != "" {
+				toolCall.ID = delta.ID
+			}
+			if delta.Function.Name != "" {
+				toolCall.Function.Name += delta.Function.Name
+				fmt.Printf("\nTool call: %s, arguments: ", toolCall.Function.Name)
+			}
+			if delta.Function.Arguments != "" {
+				toolCall.Function.Arguments += delta.Function.Arguments
+				fmt.Print(delta.Function.Arguments)
+			}
+		}
+
+		if choice.FinishReason != "" {
+			result.FinishReason = choice.FinishReason
+		}
+	}
+
+	if !received {
+		return nil, fmt.Errorf("no response received from LLM")
+	}
+	if printedHeader || len(toolCalls) > 0 {
+		fmt.Println()
+	}
+
+	result.Message.Content = content.String()
+	result.Message.ToolCalls = toolCalls
+	return result, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// taskTemplate は定型的な開発タスク用のシステムプロンプト拡張と初期の作業計画
+type taskTemplate struct {
+	Name        string
+	Description string
+	Prompt      string
+	Plan        []string
+}
+
+// taskTemplates は組み込みのタスクテンプレート
+var taskTemplates = map[string]taskTemplate{
+	"fix-failing-test": {
+		Name:        "fix-failing-test",
+		Description: "Find the cause of a failing test and fix the code (or the test)",
+		Prompt: `You are fixing a failing test. Decide whether the bug is in the implementation or in the test itself before changing anything.
+Prefer fixing the implementation; only change the test when its expectation is clearly wrong.`,
+		Plan: []string{
+			"Use 'searchInDirectory' to locate the failing test by name or error message",
+			"Use 'readFile' to read the test and the code under test",
+			"Explain the root cause in one or two sentences",
+			"Apply the minimal fix with 'editFile'",
+		},
+	},
+	"add-endpoint": {
+		Name:        "add-endpoint",
+		Description: "Add a new API endpoint following the project's existing routing and handler patterns",
+		Prompt:      `You are adding a new API endpoint. The new endpoint must look like the existing ones: same router registration style, handler signature, validation, error responses, and naming.`,
+		Plan: []string{
+			"Use 'list' and 'searchInDirectory' to find where routes are registered",
+			"Use 'readFile' to read at least one existing handler end-to-end, including its tests",
+			"Add the handler, route registration, and tests following the same patterns",
+		},
+	},
+	"write-tests-for-file": {
+		Name:        "write-tests-for-file",
+		Description: "Write tests for a file using the project's existing test conventions",
+		Prompt:      `You are writing tests for an existing file. Match the project's test framework, file naming, helper usage, and assertion style exactly. Cover normal cases, edge cases, and error paths.`,
+		Plan: []string{
+			"Use 'readFile' to read the target file",
+			"Use 'searchInDirectory' to find existing tests and read one or two of them",
+			"Create or extend the test file next to the target with 'writeFile' or 'editFile'",
+		},
+	},
+	"refactor-function": {
+		Name:        "refactor-function",
+		Description: "Refactor a function without changing its behavior",
+		Prompt:      `You are refactoring a function. External behavior must not change. Keep the public signature unless asked otherwise, and update every caller if you must change it.`,
+		Plan: []string{
+			"Use 'readFile' to read the function and its surrounding file",
+			"Use 'searchInDirectory' to find every caller of the function",
+			"Apply the refactoring with 'editFile', updating callers in the same pass",
+		},
+	},
+}
+
+// promptExtension はシステムプロンプトの末尾に追加するタスク固有の指示を返す
+func (t taskTemplate) promptExtension() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n\n# Task: %s\n%s\n\n# Initial Plan\n", t.Name, t.Prompt)
+	for i, step := range t.Plan {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, step)
+	}
+	return b.String()
+}
+
+// runTask はタスクテンプレートを指定して新規セッションを開始する
+func runTask(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		printTaskTemplates()
+		return nil
+	}
+
+	task, ok := taskTemplates[args[0]]
+	if !ok {
+		printTaskTemplates()
+		return fmt.Errorf("unknown task: %s", args[0])
+	}
+
+	return runChat(args[1:], &task)
+}
+
+// printTaskTemplates は利用可能なタスクテンプレートの一覧を表示する
+func printTaskTemplates() {
+	var names []string
+	for name := range taskTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println("Usage: nebula task <name> [flags] [request...]")
+	fmt.Println("Available tasks:")
+	for _, name := range names {
+		fmt.Printf("  %-22s %s\n", name, taskTemplates[name].Description)
+	}
+}
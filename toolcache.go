@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// toolCallCache は1ターン内で実行した読み取り専用ツールの結果を保持し、同一の呼び出しを検出する
+type toolCallCache struct {
+	results map[string]string
+}
+
+func newToolCallCache() *toolCallCache {
+	return &toolCallCache{results: map[string]string{}}
+}
+
+// toolCallKey はツール名と引数から呼び出しを識別するキーを作る
+// 引数のJSONは空白の違いを無視するために整形してから使う
+func toolCallKey(call openai.FunctionCall) string {
+	args := []byte(call.Arguments)
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, args); err == nil {
+		args = compacted.Bytes()
+	}
+	return call.Name + "\x00" + string(args)
+}
+
+// Get は同一の呼び出しの結果がキャッシュにあれば返す
+func (c *toolCallCache) Get(call openai.FunctionCall) (string, bool) {
+	result, ok := c.results[toolCallKey(call)]
+	return result, ok
+}
+
+// Put は読み取り専用ツールの呼び出しの結果をキャッシュに保存する
+// 呼び出すたびに結果が変わるツール（NoCache）の結果は、同じ値を返さないよう保存しない
+func (c *toolCallCache) Put(call openai.FunctionCall, tool tools.ToolDefinition, result string) {
+	if !tool.ReadOnly || tool.NoCache {
+		return
+	}
+	c.results[toolCallKey(call)] = result
+}
+
+// Invalidate はキャッシュを破棄する。書き込み系ツールの実行後は読み取り結果が古くなるため呼び出す
+func (c *toolCallCache) Invalidate() {
+	c.results = map[string]string{}
+}
+
+// duplicateToolResult はキャッシュされた結果に、重複呼び出しであることの注意書きを付けて返す
+func duplicateToolResult(cached string) string {
+	var result any = cached
+	if json.Valid([]byte(cached)) {
+		result = json.RawMessage(cached)
+	}
+	resultJSON, _ := json.Marshal(map[string]any{
+		"note":   "This exact tool call was already made earlier in this turn. Returning the previous result without re-running it; use it instead of calling the tool again.",
+		"result": result,
+	})
+	return string(resultJSON)
+}
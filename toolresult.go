@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// defaultToolResultMaxTokens は1つのツール結果としてモデルに渡すトークン数の上限のデフォルト
+	defaultToolResultMaxTokens = 10000
+	// truncationMarkerTokens は切り詰めたことを知らせる文の分として上限から差し引くトークン数
+	truncationMarkerTokens = 100
+)
+
+// toolResultMaxTokens は設定からツール結果のトークン数の上限を返す。0以下の場合は切り詰めない
+func toolResultMaxTokens(configured int) int {
+	switch {
+	case configured == 0:
+		return defaultToolResultMaxTokens
+	case configured < 0:
+		return 0
+	default:
+		return configured
+	}
+}
+
+// estimateTokens はテキストのトークン数を見積もる
+// ASCIIは約4文字で1トークン、日本語などそれ以外の文字は1文字で1トークンとして数える
+func estimateTokens(s string) int {
+	ascii, others := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			others++
+		}
+	}
+	return (ascii+3)/4 + others
+}
+
+// limitToolResult はツール結果がmaxTokensを超える場合に切り詰め、省略した量を結果に書き添える
+// JSONのオブジェクトであれば最も大きい配列の末尾の要素を省き、それでも収まらなければ最も長い文字列を切り詰める
+// JSONでない場合や、それでも収まらない場合はテキストとして行単位で切り詰める
+func limitToolResult(result string, maxTokens int) string {
+	if maxTokens <= 0 || estimateTokens(result) <= maxTokens {
+		return result
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(result))
+	decoder.UseNumber()
+	var object map[string]any
+	if err := decoder.Decode(&object); err == nil && !decoder.More() {
+		if limited, ok := limitJSONResult(object, maxTokens-truncationMarkerTokens); ok {
+			return limited
+		}
+	}
+	return truncateTextResult(result, maxTokens-truncationMarkerTokens)
+}
+
+// limitJSONResult はJSONのオブジェクトをbudgetトークンに収まるように切り詰める。収まらなければfalseを返す
+func limitJSONResult(object map[string]any, budget int) (string, bool) {
+	if key, entries := largestArray(object); key != "" {
+		// 収まらなくなる最小の要素数を探し、その1つ手前までを残す
+		keep := sort.Search(len(entries)+1, func(n int) bool {
+			object[key] = entries[:n]
+			return jsonTokens(object) > budget
+		}) - 1
+		object[key] = entries[:max(keep, 0)]
+		object["truncated"] = true
+		object["truncationNote"] = fmt.Sprintf("truncated, %d more entries of %q were omitted to fit the context window. Narrow the request (e.g. a more specific path, pattern or range) to see them.", len(entries)-max(keep, 0), key)
+		if keep >= 0 {
+			return marshalJSONResult(object), true
+		}
+	}
+
+	// 配列を省いても収まらない場合は、最も長い文字列（ファイルの内容やコマンドの出力など）を切り詰める
+	key, text := longestString(object)
+	if key == "" {
+		return "", false
+	}
+	object[key] = ""
+	remaining := budget - jsonTokens(object)
+	if remaining <= 0 {
+		return "", false
+	}
+	object[key] = truncateTextResult(text, remaining)
+	object["truncated"] = true
+	return marshalJSONResult(object), true
+}
+
+// largestArray はオブジェクトの中でJSONにしたときに最も大きい配列のキーと要素を返す。配列がなければ空文字列を返す
+func largestArray(object map[string]any) (string, []any) {
+	var largestKey string
+	var largest []any
+	largestTokens := 0
+	for key, value := range object {
+		entries, ok := value.([]any)
+		if !ok || len(entries) == 0 {
+			continue
+		}
+		if tokens := jsonTokens(entries); tokens > largestTokens || (tokens == largestTokens && key < largestKey) {
+			largestKey, largest, largestTokens = key, entries, tokens
+		}
+	}
+	return largestKey, largest
+}
+
+// longestString はオブジェクトの中で最も長い文字列のキーと値を返す。文字列がなければ空文字列を返す
+func longestString(object map[string]any) (string, string) {
+	var longestKey, longest string
+	for key, value := range object {
+		text, ok := value.(string)
+		if !ok || text == "" {
+			continue
+		}
+		if len(text) > len(longest) || (len(text) == len(longest) && key < longestKey) {
+			longestKey, longest = key, text
+		}
+	}
+	return longestKey, longest
+}
+
+// truncateTextResult はテキストをbudgetトークンに収まるよう行単位で切り詰め、省略した行数を末尾に書き添える
+// 1行目から収まらない場合は文字単位で切り詰め、省略したバイト数を書き添える
+func truncateTextResult(text string, budget int) string {
+	budget = max(budget, 1)
+	lines := strings.SplitAfter(text, "\n")
+	used, kept := 0, 0
+	for _, line := range lines {
+		tokens := estimateTokens(line)
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		kept++
+	}
+	if kept == len(lines) {
+		return text
+	}
+
+	if kept == 0 {
+		head := cutAtTokens(lines[0], budget)
+		return fmt.Sprintf("%s\n...(truncated, %d more bytes omitted to fit the context window)", head, len(text)-len(head))
+	}
+	head := strings.Join(lines[:kept], "")
+	return fmt.Sprintf("%s\n...(truncated, %d more lines omitted to fit the context window)", strings.TrimSuffix(head, "\n"), len(lines)-kept)
+}
+
+// cutAtTokens はテキストの先頭からbudgetトークンに収まる分を文字の境界で切り出す
+func cutAtTokens(text string, budget int) string {
+	ascii, others := 0, 0
+	for i, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			others++
+		}
+		if (ascii+3)/4+others > budget {
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// jsonTokens は値をJSONにしたときのトークン数を見積もる
+func jsonTokens(value any) int {
+	data, _ := json.Marshal(value)
+	return estimateTokens(string(data))
+}
+
+// marshalJSONResult はツール結果のオブジェクトをJSONの文字列にする
+func marshalJSONResult(object map[string]any) string {
+	data, _ := json.Marshal(object)
+	return string(data)
+}
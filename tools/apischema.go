@@ -0,0 +1,291 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InspectAPISchemaArgs はinspectAPISchemaツールの引数を表す構造体
+type InspectAPISchemaArgs struct {
+	Path string `json:"path" description:"解析する.protoファイル、またはOpenAPI/SwaggerのJSON・YAMLファイルのパス"`
+}
+
+// InspectAPISchemaResult はinspectAPISchemaツールの結果を表す構造体
+type InspectAPISchemaResult struct {
+	Format  string          `json:"format"` // protobuf, openapi, swagger
+	Proto   *protoSummary   `json:"proto,omitempty"`
+	OpenAPI *openAPISummary `json:"openapi,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// openAPISummary はOpenAPI/Swaggerの定義の要約
+type openAPISummary struct {
+	Version   string            `json:"version"`
+	Title     string            `json:"title,omitempty"`
+	APIVer    string            `json:"apiVersion,omitempty"`
+	Servers   []string          `json:"servers,omitempty"`
+	Endpoints []openAPIEndpoint `json:"endpoints"`
+	Schemas   []openAPISchema   `json:"schemas"`
+}
+
+type openAPIEndpoint struct {
+	Method      string   `json:"method"`
+	Path        string   `json:"path"`
+	OperationID string   `json:"operationId,omitempty"`
+	Summary     string   `json:"summary,omitempty"`
+	Parameters  []string `json:"parameters,omitempty"`
+	RequestBody string   `json:"requestBody,omitempty"`
+	Responses   []string `json:"responses,omitempty"`
+}
+
+type openAPISchema struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type,omitempty"`
+	Properties []string `json:"properties,omitempty"`
+	Required   []string `json:"required,omitempty"`
+}
+
+// openAPIMethods はOpenAPIのパスに定義できるHTTPメソッド
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// InspectAPISchema は.protoやOpenAPI/Swaggerのファイルを解析し、サービス・メッセージ・エンドポイントの要約を返す
+func InspectAPISchema(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてInspectAPISchemaArgsに変換
+	var inspectArgs InspectAPISchemaArgs
+	if err := json.Unmarshal([]byte(args), &inspectArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := InspectAPISchemaResult{Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	content, err := os.ReadFile(inspectArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err)), nil
+	}
+
+	var result InspectAPISchemaResult
+	if strings.EqualFold(filepath.Ext(inspectArgs.Path), ".proto") {
+		summary, err := parseProto(string(content))
+		if err != nil {
+			return genErrorResult(fmt.Sprintf(".protoの解析に失敗しました: %v", err)), nil
+		}
+		result = InspectAPISchemaResult{Format: "protobuf", Proto: summary}
+	} else {
+		// YAMLはJSONを包含するので、どちらの形式もYAMLとして読み込める
+		var doc map[string]any
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return genErrorResult(fmt.Sprintf("JSON/YAMLの解析に失敗しました: %v", err)), nil
+		}
+		summary, format := summarizeOpenAPI(doc)
+		if summary == nil {
+			return genErrorResult("openapiまたはswaggerのバージョン指定がありません。.proto、OpenAPI、Swaggerのファイルのみ対応しています"), nil
+		}
+		result = InspectAPISchemaResult{Format: format, OpenAPI: summary}
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// summarizeOpenAPI はOpenAPI 3系またはSwagger 2.0の定義を要約する
+func summarizeOpenAPI(doc map[string]any) (*openAPISummary, string) {
+	format := "openapi"
+	version := stringField(doc, "openapi")
+	if version == "" {
+		format = "swagger"
+		version = stringField(doc, "swagger")
+	}
+	if version == "" {
+		return nil, ""
+	}
+
+	summary := &openAPISummary{
+		Version:   version,
+		Endpoints: []openAPIEndpoint{},
+		Schemas:   []openAPISchema{},
+	}
+	if info, ok := doc["info"].(map[string]any); ok {
+		summary.Title = stringField(info, "title")
+		summary.APIVer = stringField(info, "version")
+	}
+	if servers, ok := doc["servers"].([]any); ok {
+		for _, server := range servers {
+			if m, ok := server.(map[string]any); ok {
+				summary.Servers = append(summary.Servers, stringField(m, "url"))
+			}
+		}
+	} else if host := stringField(doc, "host"); host != "" {
+		summary.Servers = append(summary.Servers, host+stringField(doc, "basePath"))
+	}
+
+	paths, _ := doc["paths"].(map[string]any)
+	for _, path := range sortedKeys(paths) {
+		item, _ := paths[path].(map[string]any)
+		// パス全体に共通のパラメーター
+		common := describeParameters(item["parameters"])
+		for _, method := range openAPIMethods {
+			op, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			endpoint := openAPIEndpoint{
+				Method:      strings.ToUpper(method),
+				Path:        path,
+				OperationID: stringField(op, "operationId"),
+				Summary:     stringField(op, "summary"),
+				Parameters:  append(append([]string{}, common...), describeParameters(op["parameters"])...),
+			}
+			if body, ok := op["requestBody"].(map[string]any); ok {
+				endpoint.RequestBody = describeContent(body)
+			}
+			if responses, ok := op["responses"].(map[string]any); ok {
+				for _, code := range sortedKeys(responses) {
+					response, _ := responses[code].(map[string]any)
+					description := code
+					if content := describeContent(response); content != "" {
+						description += " " + content
+					} else if schema, ok := response["schema"]; ok {
+						description += " " + schemaType(schema)
+					}
+					endpoint.Responses = append(endpoint.Responses, description)
+				}
+			}
+			summary.Endpoints = append(summary.Endpoints, endpoint)
+		}
+	}
+
+	schemas, _ := doc["definitions"].(map[string]any)
+	if components, ok := doc["components"].(map[string]any); ok {
+		schemas, _ = components["schemas"].(map[string]any)
+	}
+	for _, name := range sortedKeys(schemas) {
+		definition, _ := schemas[name].(map[string]any)
+		schema := openAPISchema{Name: name, Type: schemaType(definition)}
+		properties, _ := definition["properties"].(map[string]any)
+		for _, property := range sortedKeys(properties) {
+			schema.Properties = append(schema.Properties, fmt.Sprintf("%s: %s", property, schemaType(properties[property])))
+		}
+		if required, ok := definition["required"].([]any); ok {
+			for _, r := range required {
+				schema.Required = append(schema.Required, fmt.Sprint(r))
+			}
+		}
+		summary.Schemas = append(summary.Schemas, schema)
+	}
+
+	return summary, format
+}
+
+// describeParameters はパラメーターを「名前 (場所, required)」の形式で表す
+func describeParameters(value any) []string {
+	parameters, _ := value.([]any)
+	var descriptions []string
+	for _, parameter := range parameters {
+		m, ok := parameter.(map[string]any)
+		if !ok {
+			continue
+		}
+		if ref := stringField(m, "$ref"); ref != "" {
+			descriptions = append(descriptions, refName(ref))
+			continue
+		}
+		description := fmt.Sprintf("%s (%s", stringField(m, "name"), stringField(m, "in"))
+		if required, _ := m["required"].(bool); required {
+			description += ", required"
+		}
+		description += ")"
+		if schema, ok := m["schema"]; ok {
+			description += ": " + schemaType(schema)
+		} else if typ := stringField(m, "type"); typ != "" {
+			description += ": " + typ
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
+
+// describeContent はリクエストボディやレスポンスのcontentを「メディアタイプ 型」の形式で表す
+func describeContent(value map[string]any) string {
+	if ref := stringField(value, "$ref"); ref != "" {
+		return refName(ref)
+	}
+	content, _ := value["content"].(map[string]any)
+	var descriptions []string
+	for _, mediaType := range sortedKeys(content) {
+		media, _ := content[mediaType].(map[string]any)
+		description := mediaType
+		if schema, ok := media["schema"]; ok {
+			description += " " + schemaType(schema)
+		}
+		descriptions = append(descriptions, description)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// schemaType はスキーマの型を短く表す（$refは参照先の名前、配列は[]要素の型）
+func schemaType(value any) string {
+	schema, ok := value.(map[string]any)
+	if !ok {
+		return ""
+	}
+	if ref := stringField(schema, "$ref"); ref != "" {
+		return refName(ref)
+	}
+	for _, combinator := range []string{"oneOf", "anyOf", "allOf"} {
+		if variants, ok := schema[combinator].([]any); ok {
+			var types []string
+			for _, variant := range variants {
+				types = append(types, schemaType(variant))
+			}
+			return fmt.Sprintf("%s(%s)", combinator, strings.Join(types, ", "))
+		}
+	}
+	typ := stringField(schema, "type")
+	if typ == "array" {
+		return "[]" + schemaType(schema["items"])
+	}
+	if format := stringField(schema, "format"); format != "" {
+		typ += "(" + format + ")"
+	}
+	return typ
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+func stringField(m map[string]any, key string) string {
+	if value, ok := m[key]; ok && value != nil {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetInspectAPISchemaTool はinspectAPISchemaツールの定義を返す
+func GetInspectAPISchemaTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("inspectAPISchema", ".protoファイルやOpenAPI/Swagger（JSON・YAML）を解析し、サービス・rpc・メッセージ・enum、またはエンドポイント・パラメーター・スキーマの要約を返します。APIの変更前に現在の定義を正確に把握するために使います", InspectAPISchemaArgs{}),
+		Function: InspectAPISchema,
+		ReadOnly: true,
+	}
+}
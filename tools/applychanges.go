@@ -0,0 +1,318 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ApplyChangesArgs はapplyChangesツールの引数を表す構造体
+type ApplyChangesArgs struct {
+	Changes []FileChangeSpec `json:"changes" description:"適用するファイル変更の一覧。1つのファイルにつき1件まで"`
+}
+
+// FileChangeSpec はapplyChangesツールで適用する1ファイル分の変更を表す構造体
+type FileChangeSpec struct {
+	Action  string         `json:"action" enum:"create,edit,delete" description:"create: 新しいファイルを作成、edit: 既存ファイルを編集、delete: 既存ファイルを削除"`
+	Path    string         `json:"path" description:"対象のファイルのパス"`
+	Content string         `json:"content,omitempty" description:"createの場合はファイルの内容。editの場合はeditsを指定しなければファイル全体の新しい内容"`
+	Edits   []EditFileEdit `json:"edits,omitempty" description:"editの場合の部分的な置換の一覧（editFileのeditsと同じ）"`
+}
+
+// ApplyChangesResult はapplyChangesツールの結果を表す構造体
+type ApplyChangesResult struct {
+	Success   bool            `json:"success"`
+	Applied   []string        `json:"applied,omitempty"` // 適用した変更（例: "edit src/main.go"）
+	Note      string          `json:"note,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Conflicts []MergeConflict `json:"conflicts,omitempty"` // 読み込み後の変更と衝突し、解決できなかった箇所
+}
+
+// plannedChange は適用前に検証した変更。oldContentがnilの場合は新規作成、newContentがnilの場合は削除
+type plannedChange struct {
+	spec        FileChangeSpec
+	oldContent  *string
+	newContent  *string
+	perm        fs.FileMode
+	merged      bool     // 読み込み後のディスク上の変更とマージしたかどうか
+	createdDirs []string // 適用時に作成したディレクトリ（深い順）。ロールバック時に削除する
+}
+
+// ApplyChanges は複数ファイルの作成・編集・削除をまとめて承認を得てから適用する
+// 途中で失敗した場合は適用済みの変更を元に戻し、すべて適用されるか何も適用されないかのどちらかにする
+func ApplyChanges(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてApplyChangesArgsに変換
+	var applyChangesArgs ApplyChangesArgs
+	if err := json.Unmarshal([]byte(args), &applyChangesArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := ApplyChangesResult{
+			Success: false,
+			Error:   errorMessage,
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	if len(applyChangesArgs.Changes) == 0 {
+		return genErrorResult("changesが空です"), nil
+	}
+
+	// プロジェクトの外への変更が1件でもあれば何もしない
+	for _, spec := range applyChangesArgs.Changes {
+		if err := checkWritablePath(spec.Path); err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+	}
+
+	// すべての変更を書き込む前に検証し、1件でも不正なら何もしない
+	planned, unresolved, err := planChanges(applyChangesArgs.Changes)
+	if err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+	if len(unresolved) > 0 {
+		// editFileと同様に、衝突を判断できる人がいなければ何も書き込まず、衝突した箇所をモデルに返す
+		resultJSON, _ := json.Marshal(ApplyChangesResult{
+			Error:     "ファイルはreadFileで読み込んだ後に変更されていて、その変更とこの編集が衝突したため何も適用しませんでした。readFileで最新の内容を読み込み、conflictsのcurrentContent（ディスク上の変更）を考慮して編集し直してください",
+			Conflicts: unresolved,
+		})
+		return string(resultJSON), nil
+	}
+
+	// すべての変更の差分をまとめて表示する
+	request := ApprovalRequest{
+		Tool:  "applyChanges",
+		Kind:  ApprovalEdit,
+		Title: fmt.Sprintf("%d件のファイル変更をまとめて適用します:", len(planned)),
+	}
+	var details, actions []string
+	for _, change := range planned {
+		details = append(details, fmt.Sprintf("[%s] %s\n%s", change.spec.Action, change.spec.Path, describePlannedChange(change)))
+		actions = append(actions, change.spec.Action+" "+change.spec.Path)
+		request.Paths = append(request.Paths, change.spec.Path)
+		for _, warning := range writePathWarnings(change.spec.Path) {
+			request.Warnings = append(request.Warnings, fmt.Sprintf("%s: %s", change.spec.Path, warning))
+		}
+	}
+	request.Detail = strings.Join(details, "\n\n")
+
+	// ドライランでは変更の一覧と差分を表示するだけにする
+	if dryRun {
+		showDryRun(request)
+		resultJSON, _ := json.Marshal(ApplyChangesResult{Success: true, Applied: actions, Note: dryRunNote})
+		return string(resultJSON), nil
+	}
+
+	// スクラッチディレクトリ内の変更だけであれば承認なしで適用できる
+	needsApproval := false
+	for _, change := range planned {
+		if !inScratchDir(change.spec.Path) {
+			needsApproval = true
+		}
+	}
+
+	if needsApproval {
+		// ユーザー許可の取得
+		approved, err := requestApproval(request)
+		if err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+		if !approved {
+			return genErrorResult("ユーザーによってキャンセルされました"), nil
+		}
+	}
+
+	for i := range planned {
+		change := &planned[i]
+		if err := applyPlannedChange(change); err != nil {
+			message := fmt.Sprintf("%sの%sに失敗しました: %v", change.spec.Path, change.spec.Action, err)
+			// 失敗した変更のために作成したディレクトリも削除する
+			rollbackErr := errors.Join(removeCreatedDirs(change.createdDirs), rollbackChanges(planned[:i]))
+			if rollbackErr != nil {
+				message += fmt.Sprintf("。適用済みの変更を元に戻せませんでした: %v", rollbackErr)
+			} else {
+				message += "。適用済みの変更はすべて元に戻しました"
+			}
+			return genErrorResult(message), nil
+		}
+	}
+
+	// すべて適用できてから変更を通知する
+	for _, change := range planned {
+		var oldMode fs.FileMode
+		if change.oldContent != nil {
+			oldMode = change.perm
+		}
+		notifyFileChange(FileChange{
+			Path:       change.spec.Path,
+			OldContent: change.oldContent,
+			NewContent: change.newContent,
+			OldMode:    oldMode,
+		})
+		if change.newContent != nil {
+			rememberContent(change.spec.Path, *change.newContent)
+		}
+	}
+
+	result := ApplyChangesResult{
+		Success: true,
+		Applied: actions,
+	}
+	for _, change := range planned {
+		if change.merged {
+			result.Note = "読み込み後に変更されていたファイルがあったため、ディスク上の変更とマージした内容を書き込みました。最新の内容が必要な場合はreadFileで読み込み直してください"
+		}
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// planChanges は各変更の対象ファイルの状態を確認し、変更前後の内容を求める
+// editFileと同様に、読み込み後に変更されたファイルはディスク上の変更と3-wayマージし、解決できない衝突はunresolvedに返す
+func planChanges(specs []FileChangeSpec) (planned []plannedChange, unresolved []MergeConflict, err error) {
+	seen := map[string]bool{}
+	for i, spec := range specs {
+		if spec.Path == "" {
+			return nil, nil, fmt.Errorf("changes[%d]のpathが空です", i)
+		}
+		key := filepath.Clean(spec.Path)
+		if seen[key] {
+			return nil, nil, fmt.Errorf("%sへの変更が複数あります。1つのファイルにつき1件にまとめてください", spec.Path)
+		}
+		seen[key] = true
+
+		change := plannedChange{spec: spec, perm: 0o644}
+		info, statErr := os.Stat(spec.Path)
+		if statErr == nil && info.IsDir() {
+			return nil, nil, fmt.Errorf("%sはディレクトリです", spec.Path)
+		}
+
+		switch spec.Action {
+		case "create":
+			if statErr == nil {
+				return nil, nil, fmt.Errorf("%sは既に存在します。既存ファイルの変更にはeditを使用してください", spec.Path)
+			}
+			content := spec.Content
+			change.newContent = &content
+		case "edit", "delete":
+			if statErr != nil {
+				return nil, nil, fmt.Errorf("%sが存在しません: %v", spec.Path, statErr)
+			}
+			oldContentBytes, err := os.ReadFile(spec.Path)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%sの読み込みに失敗しました: %v", spec.Path, err)
+			}
+			oldContent := string(oldContentBytes)
+			change.oldContent = &oldContent
+			change.perm = info.Mode().Perm()
+
+			if spec.Action == "edit" {
+				// 部分的な置換は現在のディスク上の内容に適用するので、読み込み後の変更とのマージは不要
+				newContent := spec.Content
+				if len(spec.Edits) > 0 {
+					if newContent, err = applyEdits(oldContent, spec.Edits); err != nil {
+						return nil, nil, fmt.Errorf("%s: %v", spec.Path, err)
+					}
+				} else if base, ok := rememberedContent(spec.Path); ok && base != oldContent && base != newContent {
+					merged, conflicts, err := mergeChanges(spec.Path, base, oldContent, newContent)
+					if err != nil {
+						return nil, nil, err
+					}
+					unresolved = append(unresolved, conflicts...)
+					newContent = merged
+					change.merged = true
+				}
+				if newContent == oldContent {
+					return nil, nil, fmt.Errorf("%sに変更がありません", spec.Path)
+				}
+				change.newContent = &newContent
+			}
+		default:
+			return nil, nil, fmt.Errorf("changes[%d]のactionが不正です: %s", i, spec.Action)
+		}
+		planned = append(planned, change)
+	}
+	if len(unresolved) > 0 {
+		return nil, unresolved, nil
+	}
+	return planned, nil, nil
+}
+
+// describePlannedChange は承認時に表示する変更の内容を返す
+func describePlannedChange(change plannedChange) string {
+	switch {
+	case change.oldContent == nil:
+		return fmt.Sprintf("--- 内容 ---\n%s", *change.newContent)
+	case change.newContent == nil:
+		return fmt.Sprintf("--- 内容（先頭%d行） ---\n%s", deleteFilePreviewLines, previewLines(*change.oldContent, deleteFilePreviewLines))
+	default:
+		return formatUnifiedDiff(*change.oldContent, *change.newContent, change.spec.Path, change.spec.Path)
+	}
+}
+
+// applyPlannedChange は1件の変更をファイルシステムに適用する
+// 新規作成のために作成したディレクトリはロールバックできるようにchange.createdDirsに記録する
+func applyPlannedChange(change *plannedChange) error {
+	if change.newContent == nil {
+		return os.Remove(change.spec.Path)
+	}
+	if change.oldContent == nil {
+		for dir := filepath.Dir(change.spec.Path); ; dir = filepath.Dir(dir) {
+			if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+				break
+			}
+			change.createdDirs = append(change.createdDirs, dir)
+		}
+		if err := os.MkdirAll(filepath.Dir(change.spec.Path), 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(change.spec.Path, []byte(*change.newContent), change.perm)
+}
+
+// rollbackChanges は適用済みの変更を逆順に元に戻す
+func rollbackChanges(applied []plannedChange) error {
+	var errs []error
+	for i := len(applied) - 1; i >= 0; i-- {
+		change := applied[i]
+		var err error
+		if change.oldContent == nil {
+			err = os.Remove(change.spec.Path)
+		} else {
+			err = os.WriteFile(change.spec.Path, []byte(*change.oldContent), change.perm)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", change.spec.Path, err))
+		}
+		if err := removeCreatedDirs(change.createdDirs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// removeCreatedDirs は変更の適用時に作成したディレクトリを深い順に削除する
+func removeCreatedDirs(dirs []string) error {
+	var errs []error
+	for _, dir := range dirs {
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetApplyChangesTool はapplyChangesツールの定義を返す
+func GetApplyChangesTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("applyChanges", "複数ファイルの作成・編集・削除をまとめて適用します。すべての差分を表示して1回の承認で適用し、途中で失敗した場合はすべて元に戻します。複数ファイルにまたがるリファクタリングなど、関連する変更を一度に行う場合に使います。編集する前に対象のファイルをreadFileで読み込んでください", ApplyChangesArgs{}),
+		Function: ApplyChanges,
+	}
+}
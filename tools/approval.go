@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ApprovalKind は承認を求める操作の種類
+type ApprovalKind string
+
+const (
+	ApprovalEdit    ApprovalKind = "edit"    // ファイルの作成・編集
+	ApprovalDelete  ApprovalKind = "delete"  // ファイルの削除
+	ApprovalExecute ApprovalKind = "execute" // コマンドやコードの実行
+)
+
+// ApprovalRequest はツールがユーザーに承認を求める操作を表す構造体
+type ApprovalRequest struct {
+	Tool     string       // 承認を求めるツールの名前
+	Kind     ApprovalKind // 操作の種類
+	Title    string       // 操作の要約（例: ファイルを編集します: main.go）
+	Detail   string       // 差分や実行するコードなど、判断に必要な内容
+	Paths    []string     // 変更するファイルのパス
+	Warnings []string     // 書き込み先についての警告
+}
+
+// Approver は操作を承認するかを決める。応答を得られなかった場合や、拒否の理由をモデルに伝える場合はエラーを返す
+type Approver func(request ApprovalRequest) (bool, error)
+
+// alwaysAllowedTools はユーザーが確認で「常に許可」と答えたツール
+// プロセスで扱う対話セッションは1つなので、プロセスの終了まで有効にする
+var alwaysAllowedTools = map[string]bool{}
+
+// approver は現在の承認方法。デフォルトは端末でy/Nを尋ねる
+var approver Approver = terminalApprover
+
+// SetApprover は書き込みや実行の前に使う承認方法を設定する。エディタ連携などで端末以外から承認を得るために使う
+func SetApprover(a Approver) {
+	approver = a
+}
+
+var approvalListeners []func(ApprovalRequest, bool)
+
+// OnApproval は操作を承認・拒否した後に呼び出されるリスナーを登録する。ルールで決まった場合も呼び出す
+func OnApproval(listener func(request ApprovalRequest, approved bool)) {
+	approvalListeners = append(approvalListeners, listener)
+}
+
+// requestApproval は操作の承認を求め、結果をリスナーに通知する
+func requestApproval(request ApprovalRequest) (bool, error) {
+	approved, err := decideApproval(request)
+	for _, listener := range approvalListeners {
+		listener(request, approved && err == nil)
+	}
+	return approved, err
+}
+
+// decideApproval は設定のpermissionsのルールで操作を判断し、ルールで決まらなければ承認方法で承認を求める
+func decideApproval(request ApprovalRequest) (bool, error) {
+	action, rule := decidePermission(request)
+	switch action {
+	case PermissionDeny:
+		return false, fmt.Errorf("設定のpermissionsの%sにより拒否されました", rule.describe())
+	case PermissionAllow:
+		// 書き込み先に警告がある場合は、許可のルールがあっても確認する
+		if len(request.Warnings) == 0 {
+			return true, nil
+		}
+	}
+	if alwaysAllowedTools[request.Tool] && len(request.Warnings) == 0 {
+		return true, nil
+	}
+	return approver(request)
+}
+
+// AutoApprover は確認せずに操作を承認する。スクリプトなど、応答する人がいない実行で使う
+// 書き込み先に警告がある操作は人の判断が必要なので承認しない
+func AutoApprover(request ApprovalRequest) (bool, error) {
+	fmt.Printf("\n%s\n", request.Title)
+	if len(request.Warnings) > 0 {
+		return false, fmt.Errorf("書き込み先に警告があるため自動では承認しません: %s", strings.Join(request.Warnings, " "))
+	}
+	fmt.Println("自動で承認しました")
+	return true, nil
+}
+
+// terminalApprover は操作の内容を表示し、標準入力からy/Nで承認を得る
+func terminalApprover(request ApprovalRequest) (bool, error) {
+	fmt.Printf("\n%s\n", request.Title)
+	if request.Detail != "" {
+		fmt.Printf("%s\n\n", colorizeDiff(request.Detail))
+	}
+	for _, warning := range request.Warnings {
+		fmt.Println(colorize(colorYellow, "警告: "+warning))
+	}
+	fmt.Printf("実行してもよろしいですか？(y/N, a: このセッションでは%sを常に許可, d: 理由を伝えて拒否): ", request.Tool)
+
+	// ユーザー応答を読み取り
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		return false, fmt.Errorf("ユーザー応答の読み取りに失敗しました")
+	}
+	switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+	case "y":
+		return true, nil
+	case "a":
+		alwaysAllowedTools[request.Tool] = true
+		fmt.Printf("このセッションでは%sを確認せずに実行します（書き込み先に警告がある場合を除く）\n", request.Tool)
+		return true, nil
+	case "d":
+		// 理由をツールの結果としてモデルに伝え、別のやり方を考えさせる
+		fmt.Print("拒否する理由: ")
+		if !scanner.Scan() {
+			return false, fmt.Errorf("ユーザー応答の読み取りに失敗しました")
+		}
+		if reason := strings.TrimSpace(scanner.Text()); reason != "" {
+			return false, fmt.Errorf("ユーザーが拒否しました。理由: %s", reason)
+		}
+		return false, nil
+	default:
+		// それ以外はキャンセル扱い
+		return false, nil
+	}
+}
@@ -0,0 +1,278 @@
+package tools
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxArchiveEntries は一覧で返すエントリの最大数
+	maxArchiveEntries = 1000
+	// maxArchiveExtractBytes は一度に展開できる合計サイズ
+	maxArchiveExtractBytes = 100 * 1024 * 1024
+)
+
+// InspectArchiveArgs はinspectArchiveツールの引数を表す構造体
+type InspectArchiveArgs struct {
+	Path    string   `json:"path" description:"zip（.zip, .jar, .whl）またはtar（.tar, .tar.gz, .tgz）のアーカイブのパス"`
+	Action  string   `json:"action" enum:"list,extract" description:"list: エントリの一覧を返す、extract: 指定したエントリをスクラッチディレクトリに展開する"`
+	Entries []string `json:"entries,omitempty" description:"extractで展開するエントリの名前またはパターン（例: META-INF/MANIFEST.MF, **/*.json）"`
+}
+
+// archiveEntry はアーカイブ内のエントリ1つを表す構造体
+type archiveEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Dir  bool   `json:"dir,omitempty"`
+	// Type は通常のファイルとディレクトリ以外のエントリの種類（symlink、hardlink、other）。展開はしない
+	Type string `json:"type,omitempty"`
+}
+
+// archiveEntryType はzip・tarのエントリのモードから、通常のファイルとディレクトリ以外の種類を返す
+func archiveEntryType(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular() || mode.IsDir():
+		return ""
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
+
+// InspectArchiveResult はinspectArchiveツールの結果を表す構造体
+type InspectArchiveResult struct {
+	Entries   []archiveEntry `json:"entries,omitempty"`
+	Extracted []string       `json:"extracted,omitempty"` // 展開したファイルのパス
+	Skipped   []string       `json:"skipped,omitempty"`   // 通常のファイルではないため展開しなかったエントリ
+	Truncated bool           `json:"truncated,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// InspectArchive はアーカイブのエントリを一覧し、指定したエントリをスクラッチディレクトリに展開する
+func InspectArchive(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてInspectArchiveArgsに変換
+	var inspectArchiveArgs InspectArchiveArgs
+	if err := json.Unmarshal([]byte(args), &inspectArchiveArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := InspectArchiveResult{Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	var result InspectArchiveResult
+	switch inspectArchiveArgs.Action {
+	case "list":
+		err := walkArchive(inspectArchiveArgs.Path, func(entry archiveEntry, _ io.Reader) error {
+			if len(result.Entries) >= maxArchiveEntries {
+				result.Truncated = true
+				return errStopArchiveWalk
+			}
+			result.Entries = append(result.Entries, entry)
+			return nil
+		})
+		if err != nil {
+			return genErrorResult(fmt.Sprintf("アーカイブの読み込みに失敗しました: %v", err)), nil
+		}
+	case "extract":
+		if len(inspectArchiveArgs.Entries) == 0 {
+			return genErrorResult("展開するエントリをentriesに指定してください"), nil
+		}
+		extracted, skipped, err := extractArchiveEntries(inspectArchiveArgs.Path, inspectArchiveArgs.Entries)
+		if err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+		result.Skipped = skipped
+		if len(extracted) == 0 && len(skipped) > 0 {
+			return genErrorResult(fmt.Sprintf("指定したエントリは通常のファイルではないため展開できません: %s", strings.Join(skipped, ", "))), nil
+		}
+		if len(extracted) == 0 {
+			return genErrorResult("指定したエントリがアーカイブ内に見つかりません。action=listで名前を確認してください"), nil
+		}
+		result.Extracted = extracted
+	default:
+		return genErrorResult(fmt.Sprintf("actionが不正です: %s", inspectArchiveArgs.Action)), nil
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// errStopArchiveWalk はアーカイブの走査を途中で終えるための目印
+var errStopArchiveWalk = errors.New("stop archive walk")
+
+// walkArchive はアーカイブのエントリを順に渡す。readerはそのエントリの内容を読むために使う
+func walkArchive(archivePath string, fn func(entry archiveEntry, reader io.Reader) error) error {
+	lower := strings.ToLower(archivePath)
+	var err error
+	switch {
+	case strings.HasSuffix(lower, ".zip"), strings.HasSuffix(lower, ".jar"), strings.HasSuffix(lower, ".whl"):
+		err = walkZip(archivePath, fn)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"), strings.HasSuffix(lower, ".tar"):
+		err = walkTar(archivePath, fn)
+	default:
+		return fmt.Errorf("対応していない形式です（zip, jar, whl, tar, tar.gz, tgzに対応）")
+	}
+	if errors.Is(err, errStopArchiveWalk) {
+		return nil
+	}
+	return err
+}
+
+func walkZip(archivePath string, fn func(entry archiveEntry, reader io.Reader) error) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		entry := archiveEntry{Name: f.Name, Size: int64(f.UncompressedSize64), Dir: f.FileInfo().IsDir(), Type: archiveEntryType(f.Mode())}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(entry, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(archivePath string, fn func(entry archiveEntry, reader io.Reader) error) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if lower := strings.ToLower(archivePath); strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// シンボリックリンクなどは一覧にだけ含め、展開の対象にはしない
+		entry := archiveEntry{Name: header.Name, Size: header.Size, Dir: header.Typeflag == tar.TypeDir}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		case tar.TypeLink:
+			entry.Type = "hardlink"
+		default:
+			entry.Type = archiveEntryType(header.FileInfo().Mode())
+		}
+		if err := fn(entry, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// extractArchiveEntries は名前またはパターンにマッチするファイルをスクラッチディレクトリに展開する
+// シンボリックリンクやハードリンク、デバイスなど通常のファイル以外は、展開先の外を指せるので展開せずskippedに返す
+func extractArchiveEntries(archivePath string, patterns []string) (extracted, skipped []string, err error) {
+	baseDir := scratchDir
+	if baseDir == "" {
+		dir, err := os.MkdirTemp("", "nebula-archive-")
+		if err != nil {
+			return nil, nil, fmt.Errorf("展開先のディレクトリの作成に失敗しました: %v", err)
+		}
+		baseDir = dir
+	}
+	// アーカイブごとにディレクトリを分け、同じ名前のエントリが衝突しないようにする
+	destDir := filepath.Join(baseDir, "archives", filepath.Base(archivePath))
+
+	var total int64
+	err = walkArchive(archivePath, func(entry archiveEntry, reader io.Reader) error {
+		if entry.Dir || !matchArchiveEntry(patterns, entry.Name) {
+			return nil
+		}
+		if entry.Type != "" {
+			skipped = append(skipped, entry.Name)
+			return nil
+		}
+
+		// ../や絶対パスを含むエントリで展開先の外に書き込まないようにする
+		name := path.Clean(strings.TrimPrefix(filepath.ToSlash(entry.Name), "/"))
+		if name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("展開先の外を指すエントリは展開できません: %s", entry.Name)
+		}
+		dest := filepath.Join(destDir, filepath.FromSlash(name))
+
+		if total+entry.Size > maxArchiveExtractBytes {
+			return fmt.Errorf("展開するサイズの合計が上限（%dMB）を超えます。entriesを絞り込んでください", maxArchiveExtractBytes/1024/1024)
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return fmt.Errorf("ディレクトリの作成に失敗しました: %v", err)
+		}
+		file, err := os.Create(dest)
+		if err != nil {
+			return fmt.Errorf("ファイルの作成に失敗しました: %v", err)
+		}
+		// ヘッダーのサイズが偽られていても上限を超えて書き込まない
+		n, err := io.Copy(file, io.LimitReader(reader, maxArchiveExtractBytes-total+1))
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%sの展開に失敗しました: %v", entry.Name, err)
+		}
+		total += n
+		if total > maxArchiveExtractBytes {
+			return fmt.Errorf("展開するサイズの合計が上限（%dMB）を超えます。entriesを絞り込んでください", maxArchiveExtractBytes/1024/1024)
+		}
+		extracted = append(extracted, dest)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return extracted, skipped, nil
+}
+
+// matchArchiveEntry はエントリの名前がいずれかの名前またはパターンにマッチするかを返す
+func matchArchiveEntry(patterns []string, name string) bool {
+	name = strings.TrimPrefix(name, "./")
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(pattern, "./")
+		if pattern == name || matchGlobSegments(strings.Split(pattern, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetInspectArchiveTool はinspectArchiveツールの定義を返す
+func GetInspectArchiveTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("inspectArchive", "zip・tarのアーカイブのエントリを一覧し、指定したエントリをセッションのスクラッチディレクトリに展開します。プロジェクトのファイルは変更しません。展開したファイルはreadFileなどで読めます", InspectArchiveArgs{}),
+		Function: InspectArchive,
+		ReadOnly: true,
+	}
+}
@@ -0,0 +1,189 @@
+package tools
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testArchiveEntry はテスト用のアーカイブに入れるエントリ
+type testArchiveEntry struct {
+	name     string
+	body     string
+	typeflag byte   // tarのエントリの種類。0の場合は通常のファイル
+	linkname string // シンボリックリンクとハードリンクのリンク先
+}
+
+func writeTestTar(t *testing.T, path string, entries []testArchiveEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tw := tar.NewWriter(file)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Typeflag: entry.typeflag, Linkname: entry.linkname}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(entry.body))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, path string, entries []testArchiveEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zw := zip.NewWriter(file)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		body := entry.body
+		switch entry.typeflag {
+		case tar.TypeSymlink:
+			header.SetMode(fs.ModeSymlink | 0o777)
+			body = entry.linkname
+		default:
+			header.SetMode(0o644)
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchiveEntries(t *testing.T) {
+	tests := []struct {
+		name          string
+		entries       []testArchiveEntry
+		patterns      []string
+		wantExtracted []string // 展開先からの相対パス
+		wantSkipped   []string
+		wantErr       string
+	}{
+		{
+			name:          "パターンにマッチする通常のファイルを展開する",
+			entries:       []testArchiveEntry{{name: "src/a.go", body: "a"}, {name: "src/b.txt", body: "b"}, {name: "README.md", body: "r"}},
+			patterns:      []string{"src/*.go", "README.md"},
+			wantExtracted: []string{"src/a.go", "README.md"},
+		},
+		{
+			name:          "先頭の/は取り除いて展開先の中に展開する",
+			entries:       []testArchiveEntry{{name: "/etc/passwd", body: "x"}},
+			patterns:      []string{"/etc/passwd"},
+			wantExtracted: []string{"etc/passwd"},
+		},
+		{
+			name:     "../で展開先の外を指すエントリはエラー",
+			entries:  []testArchiveEntry{{name: "../evil.txt", body: "x"}},
+			patterns: []string{"../evil.txt"},
+			wantErr:  "展開先の外",
+		},
+		{
+			name:     "途中の../で展開先の外を指すエントリはエラー",
+			entries:  []testArchiveEntry{{name: "a/../../evil.txt", body: "x"}},
+			patterns: []string{"a/../../evil.txt"},
+			wantErr:  "展開先の外",
+		},
+		{
+			name: "シンボリックリンクは展開しない",
+			entries: []testArchiveEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+				{name: "a.txt", body: "a"},
+			},
+			patterns:      []string{"*"},
+			wantExtracted: []string{"a.txt"},
+			wantSkipped:   []string{"link"},
+		},
+	}
+
+	for _, format := range []string{"tar", "zip"} {
+		for _, tt := range tests {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				SetScratchDir(filepath.Join(dir, "scratch"))
+				t.Cleanup(func() { SetScratchDir("") })
+
+				archivePath := filepath.Join(dir, "test."+format)
+				if format == "tar" {
+					writeTestTar(t, archivePath, tt.entries)
+				} else {
+					writeTestZip(t, archivePath, tt.entries)
+				}
+
+				extracted, skipped, err := extractArchiveEntries(archivePath, tt.patterns)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("extractArchiveEntries() error = %v, want error containing %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("extractArchiveEntries() returned error: %v", err)
+				}
+
+				destDir := filepath.Join(dir, "scratch", "archives", filepath.Base(archivePath))
+				var got []string
+				for _, path := range extracted {
+					rel, err := filepath.Rel(destDir, path)
+					if err != nil || !isWithinDir(destDir, path) {
+						t.Fatalf("extracted %s outside of %s", path, destDir)
+					}
+					got = append(got, filepath.ToSlash(rel))
+				}
+				if strings.Join(got, ",") != strings.Join(tt.wantExtracted, ",") {
+					t.Errorf("extracted = %v, want %v", got, tt.wantExtracted)
+				}
+				if strings.Join(skipped, ",") != strings.Join(tt.wantSkipped, ",") {
+					t.Errorf("skipped = %v, want %v", skipped, tt.wantSkipped)
+				}
+			})
+		}
+	}
+
+	t.Run("tar/ハードリンクとデバイスは展開しない", func(t *testing.T) {
+		dir := t.TempDir()
+		SetScratchDir(filepath.Join(dir, "scratch"))
+		t.Cleanup(func() { SetScratchDir("") })
+
+		archivePath := filepath.Join(dir, "test.tar")
+		writeTestTar(t, archivePath, []testArchiveEntry{
+			{name: "hard", typeflag: tar.TypeLink, linkname: "/etc/passwd"},
+			{name: "dev", typeflag: tar.TypeChar},
+			{name: "fifo", typeflag: tar.TypeFifo},
+		})
+		extracted, skipped, err := extractArchiveEntries(archivePath, []string{"*"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(extracted) != 0 {
+			t.Errorf("extracted = %v, want none", extracted)
+		}
+		if got := strings.Join(skipped, ","); got != "hard,dev,fifo" {
+			t.Errorf("skipped = %v, want [hard dev fifo]", skipped)
+		}
+	})
+}
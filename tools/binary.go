@@ -0,0 +1,22 @@
+package tools
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// binarySniffLen はバイナリかどうかを判定するために調べる先頭のバイト数（gitと同じ8000バイト）
+const binarySniffLen = 8000
+
+// isBinary はファイルの先頭部分にNULバイトが含まれていればバイナリとみなす
+func isBinary(head []byte) bool {
+	if len(head) > binarySniffLen {
+		head = head[:binarySniffLen]
+	}
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// detectMIMEType はファイルの先頭部分からMIMEタイプを推定する
+func detectMIMEType(head []byte) string {
+	return http.DetectContentType(head)
+}
@@ -0,0 +1,467 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// CalculateArgs はcalculateツールの引数を表す構造体
+type CalculateArgs struct {
+	Operation  string   `json:"operation" enum:"arithmetic,date,convert,regex" description:"計算の種類。arithmetic: 数式の評価、date: 日時の変換・加算・差分、convert: バイト数や時間の単位変換、regex: 正規表現のテスト"`
+	Expression string   `json:"expression,omitempty" description:"arithmeticで評価する数式。+ - * / % **（累乗）、括弧、<< >> & | ^（整数のビット演算）、0x・0bの整数リテラルに対応"`
+	Date       string   `json:"date,omitempty" description:"dateで扱う日時。RFC3339、2006-01-02、2006-01-02 15:04:05、UNIX秒、UNIXミリ秒、nowのいずれか"`
+	Add        string   `json:"add,omitempty" description:"dateで日時に加える期間（例: 90m, -36h, 3d, 2w, 1d12h）"`
+	Since      string   `json:"since,omitempty" description:"dateで差分を求める基準の日時（dateと同じ形式）。date - sinceを返す"`
+	Timezone   string   `json:"timezone,omitempty" description:"dateで結果を表示するタイムゾーン（例: Asia/Tokyo）。省略時はUTC"`
+	Value      float64  `json:"value,omitempty" description:"convertで変換する値"`
+	From       string   `json:"from,omitempty" description:"convertの変換元の単位（B, KB, KiB, MB, MiB, GB, GiB, TB, TiB, ns, us, ms, s, m, h, d, w）"`
+	To         string   `json:"to,omitempty" description:"convertの変換先の単位（fromと同じ種類の単位）"`
+	Pattern    string   `json:"pattern,omitempty" description:"regexでテストする正規表現（Goのregexp構文）"`
+	Inputs     []string `json:"inputs,omitempty" description:"regexでテストする文字列の一覧"`
+}
+
+// regexTestResult は正規表現を1つの文字列に適用した結果
+type regexTestResult struct {
+	Input   string     `json:"input"`
+	Matched bool       `json:"matched"`
+	Matches [][]string `json:"matches,omitempty"` // マッチごとの全体とキャプチャグループ
+}
+
+// CalculateResult はcalculateツールの結果を表す構造体
+type CalculateResult struct {
+	Result  string            `json:"result,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+	Regex   []regexTestResult `json:"regex,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// Calculate は数式・日時・単位変換・正規表現の計算を行う
+func Calculate(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてCalculateArgsに変換
+	var calculateArgs CalculateArgs
+	if err := json.Unmarshal([]byte(args), &calculateArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	var result CalculateResult
+	var err error
+	switch calculateArgs.Operation {
+	case "arithmetic":
+		result, err = calculateArithmetic(calculateArgs.Expression)
+	case "date":
+		result, err = calculateDate(calculateArgs)
+	case "convert":
+		result, err = convertUnit(calculateArgs.Value, calculateArgs.From, calculateArgs.To)
+	case "regex":
+		result, err = testRegex(calculateArgs.Pattern, calculateArgs.Inputs)
+	default:
+		err = fmt.Errorf("operationが不正です: %s", calculateArgs.Operation)
+	}
+	if err != nil {
+		result = CalculateResult{Error: err.Error()}
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+func calculateArithmetic(expression string) (CalculateResult, error) {
+	value, err := evalExpression(expression)
+	if err != nil {
+		return CalculateResult{}, err
+	}
+
+	result := CalculateResult{Result: formatNumber(value)}
+	// 整数の結果はオフセットやビットマスクの確認に使えるよう16進数と2進数も返す
+	if value == math.Trunc(value) && math.Abs(value) < 1<<63 {
+		n := int64(value)
+		result.Details = map[string]string{
+			"hex":    fmt.Sprintf("%#x", n),
+			"binary": fmt.Sprintf("%#b", n),
+		}
+	}
+	return result, nil
+}
+
+// formatNumber は数値を指数表記を避けて表す
+func formatNumber(value float64) string {
+	if value == math.Trunc(value) && math.Abs(value) < 1e21 {
+		return strconv.FormatFloat(value, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// exprParser は四則演算・累乗・ビット演算の数式を評価する再帰下降パーサー
+type exprParser struct {
+	input string
+	pos   int
+}
+
+// evalExpression は数式を評価する
+func evalExpression(expression string) (float64, error) {
+	p := &exprParser{input: expression}
+	value, err := p.parseBitOr()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("数式の%d文字目に解釈できない文字があります: %q", p.pos+1, p.input[p.pos:])
+	}
+	return value, nil
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// consume は次の演算子がopであれば読み進める
+func (p *exprParser) consume(op string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.input[p.pos:], op) {
+		p.pos += len(op)
+		return true
+	}
+	return false
+}
+
+// parseBinary は左結合の二項演算子の並びを評価する
+func (p *exprParser) parseBinary(next func() (float64, error), ops map[string]func(a, b float64) (float64, error), order []string) (float64, error) {
+	left, err := next()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		matched := false
+		for _, op := range order {
+			if p.consume(op) {
+				right, err := next()
+				if err != nil {
+					return 0, err
+				}
+				if left, err = ops[op](left, right); err != nil {
+					return 0, err
+				}
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+	}
+}
+
+// integerOp はビット演算のように整数にしか適用できない演算を作る
+func integerOp(name string, op func(a, b int64) int64) func(a, b float64) (float64, error) {
+	return func(a, b float64) (float64, error) {
+		if a != math.Trunc(a) || b != math.Trunc(b) {
+			return 0, fmt.Errorf("%sは整数にしか使えません", name)
+		}
+		return float64(op(int64(a), int64(b))), nil
+	}
+}
+
+// shiftOp はシフト演算を作る。負のシフト量はGoではpanicになるので、int64のビット数の範囲外はエラーにする
+func shiftOp(name string, op func(a, b int64) int64) func(a, b float64) (float64, error) {
+	integer := integerOp(name, op)
+	return func(a, b float64) (float64, error) {
+		if b < 0 || b >= 64 {
+			return 0, fmt.Errorf("%sのシフト量は0以上64未満にしてください: %s", name, formatNumber(b))
+		}
+		return integer(a, b)
+	}
+}
+
+func (p *exprParser) parseBitOr() (float64, error) {
+	return p.parseBinary(p.parseBitXor, map[string]func(a, b float64) (float64, error){
+		"|": integerOp("|", func(a, b int64) int64 { return a | b }),
+	}, []string{"|"})
+}
+
+func (p *exprParser) parseBitXor() (float64, error) {
+	return p.parseBinary(p.parseBitAnd, map[string]func(a, b float64) (float64, error){
+		"^": integerOp("^", func(a, b int64) int64 { return a ^ b }),
+	}, []string{"^"})
+}
+
+func (p *exprParser) parseBitAnd() (float64, error) {
+	return p.parseBinary(p.parseShift, map[string]func(a, b float64) (float64, error){
+		"&": integerOp("&", func(a, b int64) int64 { return a & b }),
+	}, []string{"&"})
+}
+
+func (p *exprParser) parseShift() (float64, error) {
+	return p.parseBinary(p.parseSum, map[string]func(a, b float64) (float64, error){
+		"<<": shiftOp("<<", func(a, b int64) int64 { return a << b }),
+		">>": shiftOp(">>", func(a, b int64) int64 { return a >> b }),
+	}, []string{"<<", ">>"})
+}
+
+func (p *exprParser) parseSum() (float64, error) {
+	return p.parseBinary(p.parseProduct, map[string]func(a, b float64) (float64, error){
+		"+": func(a, b float64) (float64, error) { return a + b, nil },
+		"-": func(a, b float64) (float64, error) { return a - b, nil },
+	}, []string{"+", "-"})
+}
+
+func (p *exprParser) parseProduct() (float64, error) {
+	return p.parseBinary(p.parseUnary, map[string]func(a, b float64) (float64, error){
+		"*": func(a, b float64) (float64, error) { return a * b, nil },
+		"/": func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("0で割ることはできません")
+			}
+			return a / b, nil
+		},
+		"%": func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("0で割ることはできません")
+			}
+			return math.Mod(a, b), nil
+		},
+	}, []string{"*", "/", "%"})
+}
+
+func (p *exprParser) parseUnary() (float64, error) {
+	if p.consume("-") {
+		value, err := p.parseUnary()
+		return -value, err
+	}
+	if p.consume("+") {
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+// parsePower は累乗を評価する。累乗は右結合
+func (p *exprParser) parsePower() (float64, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return 0, err
+	}
+	if p.consume("**") {
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exponent), nil
+	}
+	return base, nil
+}
+
+func (p *exprParser) parsePrimary() (float64, error) {
+	if p.consume("(") {
+		value, err := p.parseBitOr()
+		if err != nil {
+			return 0, err
+		}
+		if !p.consume(")") {
+			return 0, fmt.Errorf("閉じ括弧がありません")
+		}
+		return value, nil
+	}
+
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.input) && (unicode.IsDigit(rune(p.input[p.pos])) || strings.ContainsRune(".xXbBoO_abcdefABCDEF", rune(p.input[p.pos]))) {
+		p.pos++
+	}
+	literal := p.input[start:p.pos]
+	if literal == "" {
+		return 0, fmt.Errorf("数式の%d文字目に数値が必要です", start+1)
+	}
+	if n, err := strconv.ParseInt(literal, 0, 64); err == nil {
+		return float64(n), nil
+	}
+	value, err := strconv.ParseFloat(strings.ReplaceAll(literal, "_", ""), 64)
+	if err != nil {
+		return 0, fmt.Errorf("数値を解釈できません: %s", literal)
+	}
+	return value, nil
+}
+
+// dateLayouts は日時として受け付ける形式
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// parseDate は日時を解釈する。タイムゾーンを含まない形式はlocationの時刻とみなす
+func parseDate(value string, location *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "now" {
+		return time.Now().In(location), nil
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// 12桁以上の数値はUNIXミリ秒とみなす
+		if len(strings.TrimPrefix(value, "-")) >= 12 {
+			return time.UnixMilli(n).In(location), nil
+		}
+		return time.Unix(n, 0).In(location), nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t.In(location), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("日時を解釈できません: %s", value)
+}
+
+// parseLongDuration はtime.ParseDurationに加えて日（d）と週（w）の単位を受け付ける
+// time.Durationで表せない（約292年を超える）期間はエラーにする
+func parseLongDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	original := value
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+		value = value[1:]
+	}
+
+	var total time.Duration
+	for _, unit := range []struct {
+		suffix string
+		size   time.Duration
+	}{{"w", 7 * 24 * time.Hour}, {"d", 24 * time.Hour}} {
+		if i := strings.Index(value, unit.suffix); i >= 0 {
+			n, err := strconv.ParseFloat(value[:i], 64)
+			if err != nil {
+				return 0, fmt.Errorf("期間を解釈できません: %s", value)
+			}
+			d := n * float64(unit.size)
+			if math.IsNaN(d) || math.Abs(d) >= math.MaxInt64 {
+				return 0, fmt.Errorf("期間が長すぎます: %s", original)
+			}
+			var ok bool
+			if total, ok = addDuration(total, time.Duration(d)); !ok {
+				return 0, fmt.Errorf("期間が長すぎます: %s", original)
+			}
+			value = value[i+1:]
+		}
+	}
+	if value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("期間を解釈できません: %s", value)
+		}
+		var ok bool
+		if total, ok = addDuration(total, d); !ok {
+			return 0, fmt.Errorf("期間が長すぎます: %s", original)
+		}
+	}
+	return sign * total, nil
+}
+
+// addDuration はa+bを返す。オーバーフローする場合はokがfalse
+func addDuration(a, b time.Duration) (sum time.Duration, ok bool) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, false
+	}
+	return a + b, true
+}
+
+func calculateDate(args CalculateArgs) (CalculateResult, error) {
+	location := time.UTC
+	if args.Timezone != "" {
+		loc, err := time.LoadLocation(args.Timezone)
+		if err != nil {
+			return CalculateResult{}, fmt.Errorf("タイムゾーンが不正です: %v", err)
+		}
+		location = loc
+	}
+
+	t, err := parseDate(args.Date, location)
+	if err != nil {
+		return CalculateResult{}, err
+	}
+	if args.Add != "" {
+		d, err := parseLongDuration(args.Add)
+		if err != nil {
+			return CalculateResult{}, err
+		}
+		t = t.Add(d)
+	}
+
+	result := CalculateResult{
+		Result: t.Format(time.RFC3339),
+		Details: map[string]string{
+			"utc":       t.UTC().Format(time.RFC3339),
+			"unix":      strconv.FormatInt(t.Unix(), 10),
+			"unixMilli": strconv.FormatInt(t.UnixMilli(), 10),
+			"weekday":   t.Weekday().String(),
+			"dayOfYear": strconv.Itoa(t.YearDay()),
+		},
+	}
+
+	if args.Since != "" {
+		since, err := parseDate(args.Since, location)
+		if err != nil {
+			return CalculateResult{}, err
+		}
+		diff := t.Sub(since)
+		result.Details["difference"] = diff.String()
+		result.Details["differenceSeconds"] = formatNumber(diff.Seconds())
+		result.Details["differenceDays"] = strconv.FormatFloat(diff.Hours()/24, 'f', -1, 64)
+	}
+	return result, nil
+}
+
+// unitSizes は単位ごとの基準単位（バイトまたはナノ秒）での大きさ
+var unitSizes = map[string]struct {
+	kind string
+	size float64
+}{
+	"B": {"bytes", 1}, "KB": {"bytes", 1e3}, "MB": {"bytes", 1e6}, "GB": {"bytes", 1e9}, "TB": {"bytes", 1e12},
+	"KiB": {"bytes", 1 << 10}, "MiB": {"bytes", 1 << 20}, "GiB": {"bytes", 1 << 30}, "TiB": {"bytes", 1 << 40},
+	"ns": {"time", 1}, "us": {"time", 1e3}, "ms": {"time", 1e6}, "s": {"time", 1e9},
+	"m": {"time", 60e9}, "h": {"time", 3600e9}, "d": {"time", 86400e9}, "w": {"time", 7 * 86400e9},
+}
+
+func convertUnit(value float64, from, to string) (CalculateResult, error) {
+	fromUnit, ok := unitSizes[from]
+	if !ok {
+		return CalculateResult{}, fmt.Errorf("変換元の単位が不正です: %s", from)
+	}
+	toUnit, ok := unitSizes[to]
+	if !ok {
+		return CalculateResult{}, fmt.Errorf("変換先の単位が不正です: %s", to)
+	}
+	if fromUnit.kind != toUnit.kind {
+		return CalculateResult{}, fmt.Errorf("%sと%sは異なる種類の単位のため変換できません", from, to)
+	}
+	return CalculateResult{Result: fmt.Sprintf("%s %s", formatNumber(value*fromUnit.size/toUnit.size), to)}, nil
+}
+
+func testRegex(pattern string, inputs []string) (CalculateResult, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return CalculateResult{}, fmt.Errorf("正規表現が不正です: %v", err)
+	}
+
+	var results []regexTestResult
+	for _, input := range inputs {
+		matches := re.FindAllStringSubmatch(input, -1)
+		results = append(results, regexTestResult{
+			Input:   input,
+			Matched: len(matches) > 0,
+			Matches: matches,
+		})
+	}
+	return CalculateResult{Regex: results}, nil
+}
+
+// GetCalculateTool はcalculateツールの定義を返す
+func GetCalculateTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("calculate", "数式の評価、日時の変換・加算・差分、バイト数や時間の単位変換、正規表現のテストを行います。オフセット、タイムスタンプ、サイズなどを暗算せずに正確に求めるために使います", CalculateArgs{}),
+		Function: Calculate,
+		ReadOnly: true,
+	}
+}
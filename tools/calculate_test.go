@@ -0,0 +1,93 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvalExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       float64
+		wantErr    string
+	}{
+		{expression: "1 + 2 * 3", want: 7},
+		{expression: "(1 + 2) * 3", want: 9},
+		{expression: "2 ** 10", want: 1024},
+		{expression: "-2 ** 2", want: -4},
+		{expression: "10 / 4", want: 2.5},
+		{expression: "0xff & 0x0f", want: 15},
+		{expression: "0b1010 | 0b0101", want: 15},
+		{expression: "6 ^ 3", want: 5},
+		{expression: "1 << 10", want: 1024},
+		{expression: "1024 >> 3", want: 128},
+		{expression: "1 << 63 >> 63", want: -1},
+		{expression: "1_000_000 + 1", want: 1000001},
+		{expression: "1 << 64", wantErr: "シフト量"},
+		{expression: "1 << -1", wantErr: "シフト量"},
+		{expression: "1 >> 64", wantErr: "シフト量"},
+		{expression: "1.5 & 1", wantErr: "整数"},
+		{expression: "(1 + 2", wantErr: "閉じ括弧"},
+		{expression: "1 +", wantErr: "数値が必要"},
+		{expression: "1 2", wantErr: "解釈できない文字"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			got, err := evalExpression(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("evalExpression(%q) = %v, %v, want error containing %q", tt.expression, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evalExpression(%q) returned error: %v", tt.expression, err)
+			}
+			if got != tt.want {
+				t.Errorf("evalExpression(%q) = %v, want %v", tt.expression, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLongDuration(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr string
+	}{
+		{value: "90m", want: 90 * time.Minute},
+		{value: "3d", want: 3 * day},
+		{value: "2w", want: 14 * day},
+		{value: "1w2d3h", want: 9*day + 3*time.Hour},
+		{value: "1.5d", want: 36 * time.Hour},
+		{value: "-1d12h", want: -36 * time.Hour},
+		{value: " 1d ", want: day},
+		{value: "1x", wantErr: "解釈できません"},
+		{value: "ad", wantErr: "解釈できません"},
+		{value: "100000w", wantErr: "長すぎます"},
+		{value: "106752d", wantErr: "長すぎます"},
+		{value: "15000w1000000h", wantErr: "長すぎます"},
+		{value: "1e300d", wantErr: "長すぎます"},
+		{value: "NaNd", wantErr: "長すぎます"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseLongDuration(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseLongDuration(%q) = %v, %v, want error containing %q", tt.value, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLongDuration(%q) returned error: %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseLongDuration(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
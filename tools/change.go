@@ -0,0 +1,29 @@
+package tools
+
+import "io/fs"
+
+// FileChange はツールによってファイルシステムに適用された変更を表す構造体
+type FileChange struct {
+	Path       string
+	OldContent *string     // 変更前の内容（新規作成の場合はnil）
+	NewContent *string     // 変更後の内容（削除の場合はnil）
+	OldMode    fs.FileMode // 変更前のパーミッション（新規作成の場合は0）
+}
+
+var fileChangeListeners []func(FileChange)
+
+// OnFileChange はツールがファイル変更を適用した後に呼び出されるリスナーを登録する
+func OnFileChange(listener func(FileChange)) {
+	fileChangeListeners = append(fileChangeListeners, listener)
+}
+
+// notifyFileChange は登録されたリスナーにファイル変更を通知する
+// スクラッチディレクトリ内の変更はプロジェクトの変更ではないので通知しない
+func notifyFileChange(change FileChange) {
+	if inScratchDir(change.Path) {
+		return
+	}
+	for _, listener := range fileChangeListeners {
+		listener(change)
+	}
+}
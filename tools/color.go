@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"os"
+	"strings"
+)
+
+// ANSIエスケープシーケンスの色
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// colorEnabled は端末への出力に色を付けるかどうか
+// 標準出力が端末で、NO_COLORが設定されておらず、端末がエスケープシーケンスを解釈できる場合に有効にする
+var colorEnabled = detectColorSupport()
+
+// detectColorSupport は標準出力に色を付けられるかを判定する
+func detectColorSupport() bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	return enableVirtualTerminal()
+}
+
+// colorize は色が有効な場合にtextを色で囲む
+func colorize(color, text string) string {
+	if !colorEnabled || text == "" {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// colorizeDiff はユニファイドdiffの追加行を緑、削除行を赤、ハンクの見出しをシアンにする
+// diff以外の行はそのまま返す
+func colorizeDiff(text string) string {
+	if !colorEnabled {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---"):
+			continue
+		case strings.HasPrefix(line, "+"):
+			lines[i] = colorize(colorGreen, line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = colorize(colorRed, line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = colorize(colorCyan, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
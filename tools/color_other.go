@@ -0,0 +1,8 @@
+//go:build !windows
+
+package tools
+
+// enableVirtualTerminal はWindows以外では何もしない。Unix系の端末はエスケープシーケンスをそのまま解釈する
+func enableVirtualTerminal() bool {
+	return true
+}
@@ -1,9 +1,31 @@
 package tools
 
-import "github.com/sashabaranov/go-openai"
+import (
+	"context"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
 
 // ToolDefinition はLLMが呼び出せるツールを表す構造体
+// Functionに渡すコンテキストは、ユーザーが中断したときやタイムアウトしたときにキャンセルされる
 type ToolDefinition struct {
 	Schema   openai.Tool
-	Function func(args string) (string, error)
+	Function func(ctx context.Context, args string) (string, error)
+	ReadOnly bool // ファイルシステムなどに変更を加えないツールかどうか
+	NoCache  bool // 同じ引数でも呼び出すたびに結果が変わるツール（乱数や現在時刻）。ReadOnlyでも結果を使い回さない
+}
+
+// Call はスキーマに基づいて引数を正規化してからツール関数を実行する
+func (t ToolDefinition) Call(ctx context.Context, args string) (string, error) {
+	if t.Schema.Function != nil {
+		if params, ok := t.Schema.Function.Parameters.(jsonschema.Definition); ok {
+			normalized, err := normalizeArguments(params, args)
+			if err != nil {
+				return "", err
+			}
+			args = normalized
+		}
+	}
+	return t.Function(ctx, args)
 }
@@ -0,0 +1,238 @@
+package tools
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultCSVPreviewRows = 10
+	maxCSVPreviewRows     = 100
+	// maxCSVDistinctValues はカラムごとに数える異なる値の上限。超えた場合は「以上」として扱う
+	maxCSVDistinctValues = 1000
+)
+
+// PreviewCSVArgs はpreviewCSVツールの引数を表す構造体
+type PreviewCSVArgs struct {
+	Path      string `json:"path" description:"プレビューするCSV/TSVファイルのパス"`
+	Rows      int    `json:"rows,omitempty" description:"先頭から返す行数（ヘッダーを除く。デフォルトは10、最大100）"`
+	Delimiter string `json:"delimiter,omitempty" description:"区切り文字。省略時は拡張子が.tsvならタブ、それ以外はカンマ"`
+	NoHeader  bool   `json:"noHeader,omitempty" description:"trueの場合、1行目をヘッダーではなくデータとして扱います（デフォルトはfalse）"`
+}
+
+// csvColumn はカラムの推定型と統計
+type csvColumn struct {
+	Name           string `json:"name"`
+	Type           string `json:"type"` // integer, float, boolean, string, empty
+	NonEmpty       int    `json:"nonEmpty"`
+	Distinct       int    `json:"distinct"`
+	DistinctCapped bool   `json:"distinctCapped,omitempty"` // distinctが上限に達したかどうか
+	Min            string `json:"min,omitempty"`
+	Max            string `json:"max,omitempty"`
+}
+
+// PreviewCSVResult はpreviewCSVツールの結果を表す構造体
+type PreviewCSVResult struct {
+	Columns  []csvColumn `json:"columns"`
+	RowCount int         `json:"rowCount"`
+	Rows     [][]string  `json:"rows"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// csvColumnStats はカラムの統計を集計する
+type csvColumnStats struct {
+	values       map[string]bool
+	nonEmpty     int
+	isInt        bool
+	isFloat      bool
+	isBool       bool
+	minNum       float64
+	maxNum       float64
+	minStr       string
+	maxStr       string
+	seenNumber   bool
+	seenNonEmpty bool
+}
+
+func newCSVColumnStats() *csvColumnStats {
+	return &csvColumnStats{values: map[string]bool{}, isInt: true, isFloat: true, isBool: true}
+}
+
+func (s *csvColumnStats) add(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	s.nonEmpty++
+	if len(s.values) < maxCSVDistinctValues {
+		s.values[value] = true
+	}
+
+	if !s.seenNonEmpty || value < s.minStr {
+		s.minStr = value
+	}
+	if !s.seenNonEmpty || value > s.maxStr {
+		s.maxStr = value
+	}
+	s.seenNonEmpty = true
+
+	if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+		s.isInt = false
+	}
+	if _, err := strconv.ParseBool(value); err != nil {
+		s.isBool = false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		s.isFloat = false
+		return
+	}
+	if !s.seenNumber || number < s.minNum {
+		s.minNum = number
+	}
+	if !s.seenNumber || number > s.maxNum {
+		s.maxNum = number
+	}
+	s.seenNumber = true
+}
+
+func (s *csvColumnStats) column(name string) csvColumn {
+	column := csvColumn{
+		Name:           name,
+		NonEmpty:       s.nonEmpty,
+		Distinct:       len(s.values),
+		DistinctCapped: len(s.values) >= maxCSVDistinctValues,
+	}
+	switch {
+	case s.nonEmpty == 0:
+		column.Type = "empty"
+		return column
+	case s.isInt:
+		column.Type = "integer"
+	case s.isFloat:
+		column.Type = "float"
+	case s.isBool:
+		column.Type = "boolean"
+	default:
+		column.Type = "string"
+	}
+	if column.Type == "integer" || column.Type == "float" {
+		column.Min = strconv.FormatFloat(s.minNum, 'g', -1, 64)
+		column.Max = strconv.FormatFloat(s.maxNum, 'g', -1, 64)
+	} else {
+		column.Min = s.minStr
+		column.Max = s.maxStr
+	}
+	return column
+}
+
+// PreviewCSV はCSV/TSVファイルのカラムの推定型と統計、先頭の行を返す
+func PreviewCSV(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてPreviewCSVArgsに変換
+	var previewArgs PreviewCSVArgs
+	if err := json.Unmarshal([]byte(args), &previewArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := PreviewCSVResult{
+			Columns: []csvColumn{},
+			Rows:    [][]string{},
+			Error:   errorMessage,
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	file, err := os.Open(previewArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルを開けませんでした: %v", err)), nil
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // 列数が揃っていない行も読み込む
+	reader.LazyQuotes = true
+	switch {
+	case previewArgs.Delimiter == "\\t" || previewArgs.Delimiter == "\t":
+		reader.Comma = '\t'
+	case previewArgs.Delimiter != "":
+		reader.Comma = []rune(previewArgs.Delimiter)[0]
+	case strings.EqualFold(filepath.Ext(previewArgs.Path), ".tsv"):
+		reader.Comma = '\t'
+	}
+
+	rowLimit := previewArgs.Rows
+	if rowLimit <= 0 {
+		rowLimit = defaultCSVPreviewRows
+	}
+	rowLimit = min(rowLimit, maxCSVPreviewRows)
+
+	var header []string
+	var stats []*csvColumnStats
+	rows := [][]string{}
+	rowCount := 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return genErrorResult(fmt.Sprintf("CSVの解析に失敗しました: %v", err)), nil
+		}
+
+		if header == nil && !previewArgs.NoHeader {
+			header = record
+			continue
+		}
+
+		for len(stats) < len(record) {
+			stats = append(stats, newCSVColumnStats())
+		}
+		for i, value := range record {
+			stats[i].add(value)
+		}
+		if len(rows) < rowLimit {
+			rows = append(rows, record)
+		}
+		rowCount++
+	}
+
+	columns := []csvColumn{}
+	for i := 0; i < max(len(header), len(stats)); i++ {
+		name := fmt.Sprintf("column%d", i+1)
+		if i < len(header) {
+			name = header[i]
+		}
+		columnStats := newCSVColumnStats()
+		if i < len(stats) {
+			columnStats = stats[i]
+		}
+		columns = append(columns, columnStats.column(name))
+	}
+
+	result := PreviewCSVResult{
+		Columns:  columns,
+		RowCount: rowCount,
+		Rows:     rows,
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// GetPreviewCSVTool はpreviewCSVツールの定義を返す
+func GetPreviewCSVTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("previewCSV", "CSV/TSVファイルのカラム（推定型、空でない値の数、異なる値の数、最小値・最大値）と行数、先頭の行を返します", PreviewCSVArgs{}),
+		Function: PreviewCSV,
+		ReadOnly: true,
+	}
+}
@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// CurrentTimeArgs はcurrentTimeツールの引数を表す構造体
+type CurrentTimeArgs struct {
+	Timezone string `json:"timezone,omitempty" description:"ローカル時刻の代わりに使うタイムゾーン（例: America/New_York）。省略時はこのマシンのタイムゾーン"`
+}
+
+// CurrentTimeResult はcurrentTimeツールの結果を表す構造体
+type CurrentTimeResult struct {
+	Local     string            `json:"local,omitempty"` // RFC3339形式の現在時刻
+	UTC       string            `json:"utc,omitempty"`
+	Timezone  string            `json:"timezone,omitempty"`
+	Offset    string            `json:"offset,omitempty"` // UTCからの時差（例: +09:00）
+	Unix      int64             `json:"unix,omitempty"`
+	UnixMilli int64             `json:"unixMilli,omitempty"`
+	Weekday   string            `json:"weekday,omitempty"`
+	ISOWeek   string            `json:"isoWeek,omitempty"` // 例: 2024-W09
+	Formatted map[string]string `json:"formatted,omitempty"`
+	Locale    string            `json:"locale,omitempty"` // LC_ALL、LC_TIME、LANGのうち最初に設定されている値
+	Error     string            `json:"error,omitempty"`
+}
+
+// CurrentTime は現在時刻をいくつかの形式で返す
+func CurrentTime(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてCurrentTimeArgsに変換
+	var currentTimeArgs CurrentTimeArgs
+	if err := json.Unmarshal([]byte(args), &currentTimeArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	now := time.Now()
+	if currentTimeArgs.Timezone != "" {
+		location, err := time.LoadLocation(currentTimeArgs.Timezone)
+		if err != nil {
+			result := CurrentTimeResult{Error: fmt.Sprintf("タイムゾーンが不正です: %v", err)}
+			resultJSON, _ := json.Marshal(result)
+			return string(resultJSON), nil
+		}
+		now = now.In(location)
+	}
+
+	zone, _ := now.Zone()
+	year, week := now.ISOWeek()
+	result := CurrentTimeResult{
+		Local:     now.Format(time.RFC3339),
+		UTC:       now.UTC().Format(time.RFC3339),
+		Timezone:  fmt.Sprintf("%s (%s)", now.Location(), zone),
+		Offset:    now.Format("-07:00"),
+		Unix:      now.Unix(),
+		UnixMilli: now.UnixMilli(),
+		Weekday:   now.Weekday().String(),
+		ISOWeek:   fmt.Sprintf("%d-W%02d", year, week),
+		// 変更履歴やマイグレーションのファイル名でよく使われる形式
+		Formatted: map[string]string{
+			"date":          now.Format("2006-01-02"),
+			"dateTime":      now.Format("2006-01-02 15:04:05"),
+			"compact":       now.Format("20060102150405"),
+			"compactUTC":    now.UTC().Format("20060102150405"),
+			"rfc1123":       now.Format(time.RFC1123Z),
+			"changelogDate": now.Format("January 2, 2006"),
+			"year":          strconv.Itoa(now.Year()),
+		},
+	}
+	for _, name := range []string{"LC_ALL", "LC_TIME", "LANG"} {
+		if locale := os.Getenv(name); locale != "" {
+			result.Locale = locale
+			break
+		}
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// GetCurrentTimeTool はcurrentTimeツールの定義を返す
+func GetCurrentTimeTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("currentTime", "現在の日時をローカル時刻・UTC・タイムゾーン・UNIX時刻やよく使う書式で、ロケールとともに返します。変更履歴の日付やマイグレーションのファイル名など、実際の現在時刻が必要な場合は推測せずにこのツールを使ってください", CurrentTimeArgs{}),
+		Function: CurrentTime,
+		ReadOnly: true,
+		NoCache:  true,
+	}
+}
@@ -0,0 +1,127 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// deleteFilePreviewLines は削除の確認時に表示するファイル先頭の行数
+const deleteFilePreviewLines = 20
+
+// DeleteFileArgs はdeleteFileツールの引数を表す構造体
+type DeleteFileArgs struct {
+	Path string `json:"path" description:"削除するファイルのパス"`
+}
+
+// DeleteFileResult はdeleteFileツールの結果を表す構造体
+type DeleteFileResult struct {
+	Success bool   `json:"success"`
+	Note    string `json:"note,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DeleteFile は指定されたファイルを削除する（ユーザー許可が必要）
+func DeleteFile(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてDeleteFileArgsに変換
+	var deleteFileArgs DeleteFileArgs
+	if err := json.Unmarshal([]byte(args), &deleteFileArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := DeleteFileResult{
+			Success: false,
+			Error:   errorMessage,
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	// プロジェクトの外のファイルは削除しない
+	if err := checkWritablePath(deleteFileArgs.Path); err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+
+	// ファイルが存在するかチェック
+	info, err := os.Stat(deleteFileArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルが存在しません: %v", err)), nil
+	}
+	// 安全性チェック: ディレクトリの削除は扱わない
+	if info.IsDir() {
+		return genErrorResult(fmt.Sprintf("ディレクトリは削除できません: %s", deleteFileArgs.Path)), nil
+	}
+
+	// 削除前の内容を読み込む（スナップショットと確認用のプレビューに使う）
+	oldContentBytes, err := os.ReadFile(deleteFileArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err)), nil
+	}
+	oldContent := string(oldContentBytes)
+
+	request := ApprovalRequest{
+		Tool:     "deleteFile",
+		Kind:     ApprovalDelete,
+		Title:    fmt.Sprintf("ファイルを削除します: %s", deleteFileArgs.Path),
+		Detail:   fmt.Sprintf("--- 内容（先頭%d行） ---\n%s", deleteFilePreviewLines, previewLines(oldContent, deleteFilePreviewLines)),
+		Paths:    []string{deleteFileArgs.Path},
+		Warnings: writePathWarnings(deleteFileArgs.Path),
+	}
+
+	// ドライランでは削除するファイルを表示するだけにする
+	if dryRun {
+		showDryRun(request)
+		resultJSON, _ := json.Marshal(DeleteFileResult{Success: true, Note: dryRunNote})
+		return string(resultJSON), nil
+	}
+
+	// スクラッチディレクトリ内のファイルは承認なしで変更できる
+	if !inScratchDir(deleteFileArgs.Path) {
+		// ユーザー許可の取得
+		approved, err := requestApproval(request)
+		if err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+		if !approved {
+			return genErrorResult("ユーザーによってキャンセルされました"), nil
+		}
+	}
+
+	if err := os.Remove(deleteFileArgs.Path); err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルの削除に失敗しました: %v", err)), nil
+	}
+
+	notifyFileChange(FileChange{
+		Path:       deleteFileArgs.Path,
+		OldContent: &oldContent,
+		OldMode:    info.Mode().Perm(),
+	})
+
+	// 成功時の結果を返却
+	result := DeleteFileResult{
+		Success: true,
+		Error:   "",
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// previewLines は内容の先頭n行を返す。省略した場合はその旨を末尾に付ける
+func previewLines(content string, n int) string {
+	lines := strings.SplitAfter(content, "\n")
+	if len(lines) <= n {
+		return strings.TrimRight(content, "\n")
+	}
+	return strings.Join(lines[:n], "") + fmt.Sprintf("...（残り%d行を省略）", len(lines)-n)
+}
+
+// GetDeleteFileTool はdeleteFileツールの定義を返す
+func GetDeleteFileTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("deleteFile", "指定されたファイルを削除します。削除前に内容のプレビューを表示し、ユーザーの許可を求めます", DeleteFileArgs{}),
+		Function: DeleteFile,
+	}
+}
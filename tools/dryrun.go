@@ -0,0 +1,25 @@
+package tools
+
+import "fmt"
+
+// dryRunNote はドライランで変更を省略したことをモデルに伝えるメッセージ
+const dryRunNote = "ドライランのため、実際にはディスクに書き込んでいません。変更は適用されたものとして作業を続けてください。ただしreadFileなどで読み込む内容は変更前のままです"
+
+// dryRun が有効な場合、ファイルを変更するツールは変更内容を表示するだけで書き込まない
+var dryRun bool
+
+// SetDryRun はファイルの作成・編集・削除を表示だけにするかを設定する
+func SetDryRun(enabled bool) {
+	dryRun = enabled
+}
+
+// showDryRun はドライランで行わなかった変更の内容を表示する
+func showDryRun(request ApprovalRequest) {
+	fmt.Printf("\n[dry-run] %s\n", request.Title)
+	if request.Detail != "" {
+		fmt.Printf("%s\n", colorizeDiff(request.Detail))
+	}
+	for _, warning := range request.Warnings {
+		fmt.Println(colorize(colorYellow, "警告: "+warning))
+	}
+}
@@ -1,7 +1,7 @@
 package tools
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -10,24 +10,32 @@ import (
 	"github.com/hexops/gotextdiff"
 	"github.com/hexops/gotextdiff/myers"
 	"github.com/hexops/gotextdiff/span"
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
 // EditFileArgs はeditFileツールの引数を表す構造体
 type EditFileArgs struct {
-	Path       string `json:"path" description:"編集するファイルのパス"`
-	NewContent string `json:"new_content" description:"ファイルの新しい内容（完全な内容）"`
+	Path       string         `json:"path" description:"編集する既存ファイルのパス"`
+	NewContent *string        `json:"new_content,omitempty" nullable:"true" description:"既存ファイル全体を上書きする新しい完全な内容。editsを指定した場合は無視される"`
+	Edits      []EditFileEdit `json:"edits,omitempty" description:"部分的な置換の一覧。指定した順に適用する"`
+}
+
+// EditFileEdit はeditFileツールの部分的な置換1件を表す構造体
+type EditFileEdit struct {
+	OldString  string `json:"old_string" description:"置換する既存の文字列。インデントや空白も含めてファイルの内容と完全に一致させる"`
+	NewString  string `json:"new_string" description:"置換後の文字列"`
+	ReplaceAll bool   `json:"replace_all,omitempty" description:"trueの場合、一致する箇所をすべて置換する。falseの場合、old_stringはファイル内で一意でなければならない"`
 }
 
 // EditFileResult はeditFileツールの結果を表す構造体
 type EditFileResult struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error,omitempty"`
+	Success   bool            `json:"success"`
+	Note      string          `json:"note,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Conflicts []MergeConflict `json:"conflicts,omitempty"` // 読み込み後の変更と衝突し、解決できなかった箇所
 }
 
 // EditFile は既存ファイルの内容を完全に上書きする（ユーザー許可が必要）
-func EditFile(args string) (string, error) {
+func EditFile(ctx context.Context, args string) (string, error) {
 	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてEditFileArgsに変換
 	var editFileArgs EditFileArgs
 	if err := json.Unmarshal([]byte(args), &editFileArgs); err != nil {
@@ -43,8 +51,19 @@ func EditFile(args string) (string, error) {
 		return string(resultJSON)
 	}
 
+	// new_contentの省略を空文字列として扱うとファイルを空にしてしまうので、どちらも指定がなければエラーにする
+	if len(editFileArgs.Edits) == 0 && editFileArgs.NewContent == nil {
+		return genErrorResult("editsかnew_contentのどちらかを指定してください。ファイルを空にする場合はnew_contentに空文字列を指定してください"), nil
+	}
+
+	// プロジェクトの外のファイルは編集しない
+	if err := checkWritablePath(editFileArgs.Path); err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+
 	// ファイルが存在するかチェック
-	if _, err := os.Stat(editFileArgs.Path); err != nil {
+	info, err := os.Stat(editFileArgs.Path)
+	if err != nil {
 		return genErrorResult(fmt.Sprintf("ファイルが存在しません。新しいファイルの作成にはwriteFileを使用してください。: %v", err)), nil
 	}
 
@@ -55,29 +74,68 @@ func EditFile(args string) (string, error) {
 	}
 	oldContent := string(oldContentBytes)
 
+	// 部分的な置換は現在のディスク上の内容に適用するので、読み込み後の変更とのマージは不要
+	var newContent, note string
+	if len(editFileArgs.Edits) > 0 {
+		newContent, err = applyEdits(oldContent, editFileArgs.Edits)
+		if err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+	} else {
+		newContent = *editFileArgs.NewContent
+		if base, ok := rememberedContent(editFileArgs.Path); ok && base != oldContent && base != newContent {
+			// 読み込み後にファイルが変更されていれば、モデルの変更とディスク上の変更を3-wayマージする
+			merged, unresolved, err := mergeChanges(editFileArgs.Path, base, oldContent, newContent)
+			if err != nil {
+				return genErrorResult(err.Error()), nil
+			}
+			if len(unresolved) > 0 {
+				// 衝突を判断できる人がいなければ書き込まず、衝突した箇所をモデルに返して編集し直させる
+				resultJSON, _ := json.Marshal(EditFileResult{
+					Error:     "ファイルはreadFileで読み込んだ後に変更されていて、その変更とこの編集が衝突したため書き込みませんでした。readFileで最新の内容を読み込み、conflictsのcurrentContent（ディスク上の変更）を考慮して編集し直してください",
+					Conflicts: unresolved,
+				})
+				return string(resultJSON), nil
+			}
+			newContent = merged
+			note = "ファイルが読み込み後に変更されていたため、ディスク上の変更とマージした内容を書き込みました。最新の内容が必要な場合はreadFileで読み込み直してください"
+		}
+	}
+
 	// 差分を計算（ユニファイドdiff形式）
-	diffText := formatUnifiedDiff(oldContent, editFileArgs.NewContent, editFileArgs.Path, editFileArgs.Path)
+	diffText := formatUnifiedDiff(oldContent, newContent, editFileArgs.Path, editFileArgs.Path)
 
 	// 変更がない場合はエラーを返す
 	if diffText == "" {
 		return genErrorResult("ファイルに変更がありません"), nil
 	}
 
-	// ユーザー許可の取得
-	fmt.Println("\nファイルを編集します: ")
-	fmt.Printf("%s\n\n", diffText)
-	fmt.Print("実行してもよろしいですか？(y/N): ")
+	request := ApprovalRequest{
+		Tool:     "editFile",
+		Kind:     ApprovalEdit,
+		Title:    fmt.Sprintf("ファイルを編集します: %s", editFileArgs.Path),
+		Detail:   diffText,
+		Paths:    []string{editFileArgs.Path},
+		Warnings: writePathWarnings(editFileArgs.Path),
+	}
 
-	// ユーザー応答を読み取り
-	scanner := bufio.NewScanner(os.Stdin)
-	if !scanner.Scan() {
-		return genErrorResult("ユーザー応答の読み取りに失敗しました"), nil
+	// ドライランでは差分を表示するだけにする
+	if dryRun {
+		showDryRun(request)
+		resultJSON, _ := json.Marshal(EditFileResult{Success: true, Note: dryRunNote})
+		return string(resultJSON), nil
 	}
 
-	// yまたはY以外はキャンセル扱い
-	response := strings.TrimSpace(scanner.Text())
-	if response != "y" && response != "Y" {
-		return genErrorResult("ユーザーによってキャンセルされました"), nil
+	// スクラッチディレクトリ内のファイルは承認なしで変更できる
+	if !inScratchDir(editFileArgs.Path) {
+		// ユーザー許可の取得
+		approved, err := requestApproval(request)
+		if err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+		if !approved {
+			return genErrorResult("ユーザーによってキャンセルされました"), nil
+		}
 	}
 
 	// ファイルに内容を書き込む
@@ -87,42 +145,58 @@ func EditFile(args string) (string, error) {
 	}
 	defer file.Close()
 
-	if _, err := file.WriteString(editFileArgs.NewContent); err != nil {
+	if _, err := file.WriteString(newContent); err != nil {
 		return genErrorResult(fmt.Sprintf("ファイルへの書き込みに失敗しました: %v", err)), nil
 	}
 
+	notifyFileChange(FileChange{
+		Path:       editFileArgs.Path,
+		OldContent: &oldContent,
+		NewContent: &newContent,
+		OldMode:    info.Mode().Perm(),
+	})
+
+	rememberContent(editFileArgs.Path, newContent)
+
 	result := EditFileResult{
 		Success: true,
+		Note:    note,
 		Error:   "",
 	}
 	resultJSON, _ := json.Marshal(result)
 	return string(resultJSON), nil
 }
 
+// applyEdits は置換を順に適用する。old_stringが見つからない場合や、一意でない場合はエラーを返す
+func applyEdits(content string, edits []EditFileEdit) (string, error) {
+	for i, edit := range edits {
+		if edit.OldString == "" {
+			return "", fmt.Errorf("edits[%d]のold_stringが空です", i)
+		}
+		count := strings.Count(content, edit.OldString)
+		switch {
+		case count == 0:
+			return "", fmt.Errorf("edits[%d]のold_stringがファイル内に見つかりません。readFileで現在の内容を確認し、空白やインデントも含めて正確に指定してください", i)
+		case count > 1 && !edit.ReplaceAll:
+			return "", fmt.Errorf("edits[%d]のold_stringがファイル内の%d箇所に一致します。前後の行を含めて一意になるようにするか、replace_allを指定してください", i, count)
+		}
+		if edit.ReplaceAll {
+			content = strings.ReplaceAll(content, edit.OldString, edit.NewString)
+		} else {
+			content = strings.Replace(content, edit.OldString, edit.NewString, 1)
+		}
+	}
+	return content, nil
+}
+
 // GetEditFileTool はeditFileツールの定義を返す
 func GetEditFileTool() ToolDefinition {
 	return ToolDefinition{
-		Schema: openai.Tool{
-			Type: openai.ToolTypeFunction,
-			Function: &openai.FunctionDefinition{
-				Name:        "editFile",
-				Description: "既存ファイルの内容を完全に上書きします。重要: ファイルを破壊しないために、必ず以下のワークフローに従ってください: 1. 'readFile'を使用して現在の完全な内容を取得する。2. 思考プロセスで、読み取った内容を基に新しいファイルの完全版を構築する。3. このツールを使用して完全な新しい内容を書き込む。部分的な編集には使用しないでください。常にファイル全体の内容を提供してください。",
-				Parameters: jsonschema.Definition{
-					Type: jsonschema.Object,
-					Properties: map[string]jsonschema.Definition{
-						"path": {
-							Type:        jsonschema.String,
-							Description: "編集する既存ファイルのパス",
-						},
-						"new_content": {
-							Type:        jsonschema.String,
-							Description: "既存ファイル全体を上書きする新しい完全な内容",
-						},
-					},
-					Required: []string{"path", "new_content"},
-				},
-			},
-		},
+		Schema: newToolSchema(
+			"editFile",
+			"既存ファイルを編集します。通常はeditsで変更箇所だけを指定してください: 各old_stringは空白やインデントも含めてファイルの内容と完全に一致し、ファイル内で一意でなければなりません（一意にならない場合は前後の行を含めます）。ファイルの大部分を書き換える場合はnew_contentにファイル全体の新しい内容を指定して上書きできます。その場合は必ず先にreadFileで現在の完全な内容を取得し、部分的な内容を渡してファイルを壊さないようにしてください。どちらの場合も、編集前に'readFile'でファイルを読み込んでください。",
+			EditFileArgs{},
+		),
 		Function: EditFile,
 	}
 }
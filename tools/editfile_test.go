@@ -0,0 +1,80 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+func TestEditFileContentArguments(t *testing.T) {
+	const original = "package main\n\nfunc main() {}\n"
+	tests := []struct {
+		name      string
+		args      map[string]any
+		want      string
+		wantError bool
+	}{
+		{
+			name:      "editsもnew_contentもなければファイルを変更しない",
+			args:      map[string]any{},
+			want:      original,
+			wantError: true,
+		},
+		{
+			name: "空文字列のnew_contentはファイルを空にする",
+			args: map[string]any{"new_content": ""},
+			want: "",
+		},
+		{
+			name: "new_contentで上書きする",
+			args: map[string]any{"new_content": "package main\n"},
+			want: "package main\n",
+		},
+		{
+			name: "editsで置換する",
+			args: map[string]any{"edits": []map[string]any{{"old_string": "main() {}", "new_string": "main() {\n}"}}},
+			want: "package main\n\nfunc main() {\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// スクラッチディレクトリ内のファイルは承認なしで編集できる
+			dir := t.TempDir()
+			SetScratchDir(dir)
+			t.Cleanup(func() { SetScratchDir("") })
+			path := filepath.Join(dir, "main.go")
+			if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.args["path"] = path
+			args, err := normalizeArguments(GetEditFileTool().Schema.Function.Parameters.(jsonschema.Definition), mustMarshal(t, tt.args))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resultJSON, err := EditFile(context.Background(), args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result EditFileResult
+			if err := json.Unmarshal([]byte(resultJSON), &result); err != nil {
+				t.Fatal(err)
+			}
+			if (result.Error != "") != tt.wantError {
+				t.Errorf("EditFile(%s) = %s, wantError %v", args, resultJSON, tt.wantError)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
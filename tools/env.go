@@ -0,0 +1,200 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maskedValue はマスクした値の代わりに返す文字列
+const maskedValue = "********"
+
+// defaultEnvAllowlist は秘密情報を含まないため値を返してよい環境変数
+var defaultEnvAllowlist = []string{
+	"PATH", "HOME", "USER", "SHELL", "PWD", "LANG", "LC_ALL", "TERM", "TZ", "EDITOR",
+	"GOPATH", "GOROOT", "GOOS", "GOARCH", "GOFLAGS", "GO111MODULE", "GOPROXY",
+	"NODE_ENV", "CI", "XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_CACHE_HOME",
+}
+
+// envAllowlist は値を返してよい環境変数名の集合
+var envAllowlist = makeEnvAllowlist(nil)
+
+// SetEnvAllowlist はデフォルトに加えて値を返してよい環境変数名を設定する
+func SetEnvAllowlist(names []string) {
+	envAllowlist = makeEnvAllowlist(names)
+}
+
+func makeEnvAllowlist(extra []string) map[string]bool {
+	allowlist := map[string]bool{}
+	for _, name := range append(append([]string{}, defaultEnvAllowlist...), extra...) {
+		allowlist[name] = true
+	}
+	return allowlist
+}
+
+// InspectEnvArgs はinspectEnvツールの引数を表す構造体
+type InspectEnvArgs struct {
+	Prefix  string `json:"prefix,omitempty" description:"この文字列で始まる名前の環境変数だけを返す（例: DATABASE_）。省略時はすべて"`
+	EnvFile string `json:"envFile,omitempty" description:"解析する.envファイルのパス（例: .env.example）。指定すると、ファイルに定義された変数と現在の環境で設定済みかどうかを返す"`
+}
+
+// envVar は環境変数1つを表す構造体
+type envVar struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`            // 許可リストにない変数はマスクする
+	Masked bool   `json:"masked,omitempty"` // 値をマスクしたかどうか
+}
+
+// envFileEntry は.envファイルに定義された変数1つを表す構造体
+type envFileEntry struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`                 // サンプルファイル以外の値はマスクする
+	Masked      bool   `json:"masked,omitempty"`      // 値をマスクしたかどうか
+	Description string `json:"description,omitempty"` // 直前のコメント行
+	Line        int    `json:"line"`
+	SetInEnv    bool   `json:"setInEnv"` // 現在の環境で設定されているかどうか
+}
+
+// InspectEnvResult はinspectEnvツールの結果を表す構造体
+type InspectEnvResult struct {
+	Variables   []envVar       `json:"variables,omitempty"`
+	FileEntries []envFileEntry `json:"fileEntries,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// InspectEnv は環境変数の名前（許可リストにない値はマスク）と.envファイルの定義を返す
+func InspectEnv(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてInspectEnvArgsに変換
+	var inspectEnvArgs InspectEnvArgs
+	if err := json.Unmarshal([]byte(args), &inspectEnvArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := InspectEnvResult{Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	var result InspectEnvResult
+	if inspectEnvArgs.EnvFile != "" {
+		entries, err := parseEnvFile(inspectEnvArgs.EnvFile)
+		if err != nil {
+			return genErrorResult(fmt.Sprintf(".envファイルの解析に失敗しました: %v", err)), nil
+		}
+		result.FileEntries = entries
+	} else {
+		result.Variables = listEnvVars(inspectEnvArgs.Prefix)
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// listEnvVars は現在の環境変数を名前順に返す
+func listEnvVars(prefix string) []envVar {
+	vars := []envVar{}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		v := envVar{Name: name, Value: value}
+		// 空の値は秘密になり得ないのでそのまま返す
+		if !envAllowlist[name] && value != "" {
+			v.Value = maskedValue
+			v.Masked = true
+		}
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+// parseEnvFile は.envファイルの KEY=VALUE の行を解析する
+// .env.exampleなどのサンプルファイルの値はプレースホルダーなのでそのまま返し、それ以外はマスクする
+func parseEnvFile(path string) ([]envFileEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sample := isSampleEnvFile(path)
+	entries := []envFileEntry{}
+	var comments []string
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			comments = nil
+			continue
+		case strings.HasPrefix(line, "#"):
+			comments = append(comments, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+			continue
+		}
+
+		name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			comments = nil
+			continue
+		}
+		entry := envFileEntry{
+			Name:        strings.TrimSpace(name),
+			Value:       unquoteEnvValue(strings.TrimSpace(value)),
+			Description: strings.Join(comments, " "),
+			Line:        lineNumber,
+		}
+		_, entry.SetInEnv = os.LookupEnv(entry.Name)
+		if !sample && !envAllowlist[entry.Name] && entry.Value != "" {
+			entry.Value = maskedValue
+			entry.Masked = true
+		}
+		entries = append(entries, entry)
+		comments = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// isSampleEnvFile は.env.exampleのような値を含まないサンプルファイルかどうかを返す
+func isSampleEnvFile(path string) bool {
+	base := strings.ToLower(filepath.Base(path))
+	for _, marker := range []string{"example", "sample", "template", "dist"} {
+		if strings.Contains(base, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// unquoteEnvValue は引用符で囲まれた値から引用符を外し、囲まれていない値からは行末のコメントを除く
+func unquoteEnvValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
+// GetInspectEnvTool はinspectEnvツールの定義を返す
+func GetInspectEnvTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("inspectEnv", "環境変数の一覧を返します。秘密情報を漏らさないよう、許可リストにない変数の値はマスクされます。envFileに.env.exampleなどを指定すると、定義された変数・説明コメント・現在の環境で設定済みかどうかを返します。設定に関する問題の調査に使います", InspectEnvArgs{}),
+		Function: InspectEnv,
+		ReadOnly: true,
+	}
+}
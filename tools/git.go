@@ -0,0 +1,387 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxGitDiffBytes はgitDiffで返す差分の最大バイト数
+	maxGitDiffBytes = 64 * 1024
+	// defaultGitLogCount はgitLogで返すコミット数のデフォルト値
+	defaultGitLogCount = 20
+	// maxGitLogCount はgitLogのmaxCountに指定できる上限
+	maxGitLogCount = 200
+)
+
+// runGitCommand はdirでgitコマンドを実行し、標準出力を返す
+// 読み取りのためのコマンドでインデックスを更新（ロックを取得）しないよう、--no-optional-locksを付ける
+func runGitCommand(dir string, args ...string) (string, error) {
+	return runGitCommandEnv(dir, nil, args...)
+}
+
+// runGitCommandEnv は環境変数を追加してgitコマンドを実行する
+func runGitCommandEnv(dir string, env []string, args ...string) (string, error) {
+	args = append([]string{"--no-optional-locks", "-c", "core.quotepath=false", "-c", "color.ui=false"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%v: %s", err, message)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// validateGitRevision はオプションとして解釈されてしまう値（-で始まる値）を拒否する
+func validateGitRevision(name, value string) error {
+	if strings.HasPrefix(value, "-") {
+		return fmt.Errorf("%sに-で始まる値は指定できません: %s", name, value)
+	}
+	return nil
+}
+
+// GitStatusArgs はgitStatusツールの引数を表す構造体
+type GitStatusArgs struct {
+	Path string `json:"path,omitempty" description:"リポジトリ内のディレクトリ。省略時はカレントディレクトリ"`
+}
+
+// gitStatusFile は変更のあるファイル1つの状態を表す構造体
+type gitStatusFile struct {
+	Path     string `json:"path"`
+	OrigPath string `json:"origPath,omitempty"` // リネーム・コピー元のパス
+	Staged   string `json:"staged,omitempty"`   // インデックス（ステージ済み）の変更の種類
+	Unstaged string `json:"unstaged,omitempty"` // 作業ツリー（未ステージ）の変更の種類
+}
+
+// GitStatusResult はgitStatusツールの結果を表す構造体
+type GitStatusResult struct {
+	Branch   string          `json:"branch,omitempty"` // detachedの場合は(detached)
+	Commit   string          `json:"commit,omitempty"`
+	Upstream string          `json:"upstream,omitempty"`
+	Ahead    int             `json:"ahead,omitempty"`
+	Behind   int             `json:"behind,omitempty"`
+	Clean    bool            `json:"clean"`
+	Files    []gitStatusFile `json:"files"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// gitStatusCodes はporcelain形式の状態の記号と、モデルに返す変更の種類の対応
+var gitStatusCodes = map[byte]string{
+	'M': "modified",
+	'T': "typechange",
+	'A': "added",
+	'D': "deleted",
+	'R': "renamed",
+	'C': "copied",
+	'U': "unmerged",
+}
+
+// GitStatus は現在のブランチ、上流との差、変更のあるファイルをステージ済みと未ステージに分けて返す
+func GitStatus(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてGitStatusArgsに変換
+	var gitStatusArgs GitStatusArgs
+	if err := json.Unmarshal([]byte(args), &gitStatusArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := GitStatusResult{Files: []gitStatusFile{}, Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	output, err := runGitCommand(gitStatusArgs.Path, "status", "--porcelain=v2", "--branch", "-z")
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("git statusの実行に失敗しました: %v", err)), nil
+	}
+
+	result := parseGitStatus(output)
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// parseGitStatus はgit status --porcelain=v2 --branch -zの出力を解析する
+func parseGitStatus(output string) GitStatusResult {
+	result := GitStatusResult{Files: []gitStatusFile{}}
+	records := strings.Split(output, "\x00")
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if record == "" {
+			continue
+		}
+		switch record[0] {
+		case '#':
+			fields := strings.Fields(record)
+			if len(fields) < 3 {
+				continue
+			}
+			switch fields[1] {
+			case "branch.oid":
+				result.Commit = fields[2]
+			case "branch.head":
+				result.Branch = fields[2]
+			case "branch.upstream":
+				result.Upstream = fields[2]
+			case "branch.ab":
+				result.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "+"))
+				if len(fields) > 3 {
+					result.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+				}
+			}
+		case '1', '2', 'u':
+			// 1: 通常の変更、2: リネーム・コピー、u: コンフリクト。パスより前の項目数が種類ごとに異なる
+			fieldCount := map[byte]int{'1': 9, '2': 10, 'u': 11}[record[0]]
+			fields := strings.SplitN(record, " ", fieldCount)
+			if len(fields) < fieldCount {
+				continue
+			}
+			file := gitStatusFile{
+				Path:     fields[fieldCount-1],
+				Staged:   gitStatusCodes[fields[1][0]],
+				Unstaged: gitStatusCodes[fields[1][1]],
+			}
+			if record[0] == 'u' {
+				file.Staged, file.Unstaged = "unmerged", "unmerged"
+			}
+			// リネーム・コピーの場合は元のパスが次のレコードに入っている
+			if record[0] == '2' && i+1 < len(records) {
+				i++
+				file.OrigPath = records[i]
+			}
+			result.Files = append(result.Files, file)
+		case '?':
+			result.Files = append(result.Files, gitStatusFile{Path: record[2:], Unstaged: "untracked"})
+		}
+	}
+	result.Clean = len(result.Files) == 0
+	return result
+}
+
+// GitDiffArgs はgitDiffツールの引数を表す構造体
+type GitDiffArgs struct {
+	Path     string   `json:"path,omitempty" description:"リポジトリ内のディレクトリ。省略時はカレントディレクトリ"`
+	Staged   bool     `json:"staged,omitempty" description:"trueの場合、ステージ済みの変更（git diff --cached）を返す。デフォルトは未ステージの変更"`
+	Ref      string   `json:"ref,omitempty" description:"比較するコミットや範囲（例: HEAD~1, main...HEAD）。指定した場合はそのコミットと作業ツリー（stagedの場合はインデックス）、または範囲の差分を返す"`
+	Files    []string `json:"files,omitempty" description:"差分を取るファイルやディレクトリ。省略時はすべて"`
+	StatOnly bool     `json:"statOnly,omitempty" description:"trueの場合、ファイルごとの追加・削除行数だけを返す（デフォルトはfalse）"`
+}
+
+// gitDiffStat はファイルごとの追加・削除行数を表す構造体
+type gitDiffStat struct {
+	Path    string `json:"path"`
+	Added   int    `json:"added"`
+	Deleted int    `json:"deleted"`
+	Binary  bool   `json:"binary,omitempty"`
+}
+
+// GitDiffResult はgitDiffツールの結果を表す構造体
+type GitDiffResult struct {
+	Files     []gitDiffStat `json:"files"`
+	Diff      string        `json:"diff,omitempty"` // unified diff形式の差分
+	Truncated bool          `json:"truncated,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// GitDiff は作業ツリー・インデックス・コミット間の差分を返す
+func GitDiff(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてGitDiffArgsに変換
+	var gitDiffArgs GitDiffArgs
+	if err := json.Unmarshal([]byte(args), &gitDiffArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := GitDiffResult{Files: []gitDiffStat{}, Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	if err := validateGitRevision("ref", gitDiffArgs.Ref); err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+	diffArgs := []string{"diff", "--no-ext-diff", "--no-textconv"}
+	if gitDiffArgs.Staged {
+		diffArgs = append(diffArgs, "--cached")
+	}
+	if gitDiffArgs.Ref != "" {
+		diffArgs = append(diffArgs, gitDiffArgs.Ref)
+	}
+	pathspec := append([]string{"--"}, gitDiffArgs.Files...)
+
+	numstat, err := runGitCommand(gitDiffArgs.Path, append(append(append([]string{}, diffArgs...), "--numstat", "-z"), pathspec...)...)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("git diffの実行に失敗しました: %v", err)), nil
+	}
+	result := GitDiffResult{Files: parseGitNumstat(numstat)}
+
+	if !gitDiffArgs.StatOnly {
+		diff, err := runGitCommand(gitDiffArgs.Path, append(diffArgs, pathspec...)...)
+		if err != nil {
+			return genErrorResult(fmt.Sprintf("git diffの実行に失敗しました: %v", err)), nil
+		}
+		// 差分は先頭から読むものなので、上限を超えた分は末尾を省略する
+		if len(diff) > maxGitDiffBytes {
+			cut := strings.LastIndex(diff[:maxGitDiffBytes], "\n") + 1
+			diff = diff[:cut] + fmt.Sprintf("...(残りの%dバイトを省略。filesで対象を絞り込んでください)...\n", len(diff)-cut)
+			result.Truncated = true
+		}
+		result.Diff = diff
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// parseGitNumstat はgit diff --numstat -zの出力を解析する
+func parseGitNumstat(output string) []gitDiffStat {
+	stats := []gitDiffStat{}
+	records := strings.Split(output, "\x00")
+	for i := 0; i < len(records); i++ {
+		fields := strings.SplitN(records[i], "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		stat := gitDiffStat{Path: fields[2]}
+		// リネームの場合はパスが空で、元のパスと新しいパスが続くレコードに入っている
+		if stat.Path == "" && i+2 < len(records) {
+			stat.Path = records[i+2]
+			i += 2
+		}
+		// バイナリファイルは行数の代わりに-が出力される
+		if fields[0] == "-" {
+			stat.Binary = true
+		} else {
+			stat.Added, _ = strconv.Atoi(fields[0])
+			stat.Deleted, _ = strconv.Atoi(fields[1])
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// GitLogArgs はgitLogツールの引数を表す構造体
+type GitLogArgs struct {
+	Path        string `json:"path,omitempty" description:"リポジトリ内のディレクトリ。省略時はカレントディレクトリ"`
+	Ref         string `json:"ref,omitempty" description:"履歴をたどるコミットや範囲（例: main, v1.0..HEAD）。省略時はHEAD"`
+	File        string `json:"file,omitempty" description:"指定した場合、このファイルやディレクトリを変更したコミットだけを返す"`
+	MaxCount    int    `json:"maxCount,omitempty" description:"返すコミットの最大数（デフォルトは20、最大200）"`
+	Author      string `json:"author,omitempty" description:"作者の名前やメールアドレスで絞り込む"`
+	Since       string `json:"since,omitempty" description:"この日時以降のコミットに絞り込む（例: 2024-01-01, 2 weeks ago）"`
+	IncludeBody bool   `json:"includeBody,omitempty" description:"trueの場合、コミットメッセージの本文も返す（デフォルトは件名のみ）"`
+}
+
+// gitLogCommit はコミット1つを表す構造体
+type gitLogCommit struct {
+	Hash    string `json:"hash"`
+	Author  string `json:"author"`
+	Date    string `json:"date"`
+	Subject string `json:"subject"`
+	Body    string `json:"body,omitempty"`
+}
+
+// GitLogResult はgitLogツールの結果を表す構造体
+type GitLogResult struct {
+	Commits []gitLogCommit `json:"commits"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// GitLog はコミット履歴を新しい順に返す
+func GitLog(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてGitLogArgsに変換
+	var gitLogArgs GitLogArgs
+	if err := json.Unmarshal([]byte(args), &gitLogArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := GitLogResult{Commits: []gitLogCommit{}, Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	if err := validateGitRevision("ref", gitLogArgs.Ref); err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+	maxCount := defaultGitLogCount
+	if gitLogArgs.MaxCount > 0 {
+		maxCount = min(gitLogArgs.MaxCount, maxGitLogCount)
+	}
+
+	// 項目は\x1f、コミットは\x1eで区切り、件名や本文に含まれる改行と区別する
+	logArgs := []string{"log", "--format=%H%x1f%an <%ae>%x1f%aI%x1f%s%x1f%b%x1e", fmt.Sprintf("--max-count=%d", maxCount)}
+	if gitLogArgs.Author != "" {
+		logArgs = append(logArgs, "--author="+gitLogArgs.Author)
+	}
+	if gitLogArgs.Since != "" {
+		logArgs = append(logArgs, "--since="+gitLogArgs.Since)
+	}
+	if gitLogArgs.Ref != "" {
+		logArgs = append(logArgs, gitLogArgs.Ref)
+	}
+	logArgs = append(logArgs, "--")
+	if gitLogArgs.File != "" {
+		logArgs = append(logArgs, gitLogArgs.File)
+	}
+
+	output, err := runGitCommand(gitLogArgs.Path, logArgs...)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("git logの実行に失敗しました: %v", err)), nil
+	}
+
+	result := GitLogResult{Commits: []gitLogCommit{}}
+	for _, record := range strings.Split(output, "\x1e") {
+		fields := strings.Split(strings.TrimLeft(record, "\n"), "\x1f")
+		if len(fields) < 5 {
+			continue
+		}
+		commit := gitLogCommit{Hash: fields[0], Author: fields[1], Date: fields[2], Subject: fields[3]}
+		if gitLogArgs.IncludeBody {
+			commit.Body = strings.TrimSpace(fields[4])
+		}
+		result.Commits = append(result.Commits, commit)
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// GetGitStatusTool はgitStatusツールの定義を返す
+func GetGitStatusTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("gitStatus", "gitの現在のブランチ、上流ブランチとの差（ahead/behind）、変更のあるファイルをステージ済み・未ステージ・未追跡に分けて返します。変更を始める前に作業ツリーの状態を確認するために使います", GitStatusArgs{}),
+		Function: GitStatus,
+		ReadOnly: true,
+	}
+}
+
+// GetGitDiffTool はgitDiffツールの定義を返す
+func GetGitDiffTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("gitDiff", "gitの差分をファイルごとの追加・削除行数とunified diff形式で返します。未ステージの変更、ステージ済みの変更、コミットや範囲との差分を取得できます", GitDiffArgs{}),
+		Function: GitDiff,
+		ReadOnly: true,
+	}
+}
+
+// GetGitLogTool はgitLogツールの定義を返す
+func GetGitLogTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("gitLog", "gitのコミット履歴（ハッシュ、作者、日時、件名）を新しい順に返します。ファイル、作者、期間で絞り込めます", GitLogArgs{}),
+		Function: GitLog,
+		ReadOnly: true,
+	}
+}
@@ -0,0 +1,143 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GitCommitArgs はgitCommitツールの引数を表す構造体
+type GitCommitArgs struct {
+	Path    string   `json:"path,omitempty" description:"リポジトリ内のディレクトリ。省略時はカレントディレクトリ"`
+	Files   []string `json:"files" description:"ステージしてコミットに含めるファイルやディレクトリ（削除したファイルも指定できる）"`
+	Message string   `json:"message" description:"コミットメッセージ（1行目に変更の要約、必要なら空行を挟んで本文）"`
+}
+
+// GitCommitResult はgitCommitツールの結果を表す構造体
+type GitCommitResult struct {
+	Success bool          `json:"success"`
+	Commit  string        `json:"commit,omitempty"` // 作成したコミットのハッシュ
+	Branch  string        `json:"branch,omitempty"`
+	Files   []gitDiffStat `json:"files,omitempty"` // コミットに含めたファイル
+	Error   string        `json:"error,omitempty"`
+}
+
+// GitCommit はユーザーの許可を得て、指定したファイルをステージしてコミットを作成する
+func GitCommit(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてGitCommitArgsに変換
+	var gitCommitArgs GitCommitArgs
+	if err := json.Unmarshal([]byte(args), &gitCommitArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := GitCommitResult{Success: false, Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	if len(gitCommitArgs.Files) == 0 {
+		return genErrorResult("コミットするファイルをfilesに指定してください"), nil
+	}
+	if strings.TrimSpace(gitCommitArgs.Message) == "" {
+		return genErrorResult("コミットメッセージを指定してください"), nil
+	}
+	dir := gitCommitArgs.Path
+	addArgs := append([]string{"add", "-A", "--"}, gitCommitArgs.Files...)
+
+	// 承認前にインデックスを変更しないよう、インデックスのコピーにステージしてコミットされる内容を確認する
+	preview, stats, err := previewGitCommit(dir, addArgs)
+	if err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+	if len(stats) == 0 {
+		return genErrorResult("コミットする変更がありません"), nil
+	}
+
+	// ユーザー許可の取得
+	approved, err := requestApproval(ApprovalRequest{
+		Tool:   "gitCommit",
+		Kind:   ApprovalExecute,
+		Title:  fmt.Sprintf("%d件のファイルの変更をコミットします", len(stats)),
+		Detail: fmt.Sprintf("--- コミットメッセージ ---\n%s\n\n--- ステージされる差分 ---\n%s", gitCommitArgs.Message, preview),
+	})
+	if err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+	if !approved {
+		return genErrorResult("ユーザーによってキャンセルされました"), nil
+	}
+
+	if _, err := runGitCommand(dir, addArgs...); err != nil {
+		return genErrorResult(fmt.Sprintf("git addの実行に失敗しました: %v", err)), nil
+	}
+	if _, err := runGitCommand(dir, "commit", "-m", gitCommitArgs.Message); err != nil {
+		return genErrorResult(fmt.Sprintf("git commitの実行に失敗しました: %v", err)), nil
+	}
+
+	result := GitCommitResult{Success: true, Files: stats}
+	if commit, err := runGitCommand(dir, "rev-parse", "HEAD"); err == nil {
+		result.Commit = strings.TrimSpace(commit)
+	}
+	if branch, err := runGitCommand(dir, "branch", "--show-current"); err == nil {
+		result.Branch = strings.TrimSpace(branch)
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// previewGitCommit はインデックスのコピーでgit addを実行し、コミットされる差分とファイルごとの行数を返す
+// 既にステージされている変更もコミットに含まれるので、差分に含める
+func previewGitCommit(dir string, addArgs []string) (string, []gitDiffStat, error) {
+	indexPath, err := runGitCommand(dir, "rev-parse", "--git-path", "index")
+	if err != nil {
+		return "", nil, fmt.Errorf("gitリポジトリではありません: %v", err)
+	}
+	indexPath = strings.TrimSpace(indexPath)
+	if !filepath.IsAbs(indexPath) {
+		indexPath = filepath.Join(dir, indexPath)
+	}
+
+	tmp, err := os.CreateTemp("", "nebula-index-")
+	if err != nil {
+		return "", nil, fmt.Errorf("一時ファイルの作成に失敗しました: %v", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	// まだコミットのないリポジトリなどでインデックスがなければ、空のインデックスから始める
+	if index, err := os.ReadFile(indexPath); err == nil {
+		if err := os.WriteFile(tmp.Name(), index, 0o644); err != nil {
+			return "", nil, fmt.Errorf("インデックスのコピーに失敗しました: %v", err)
+		}
+	} else {
+		os.Remove(tmp.Name())
+	}
+
+	env := []string{"GIT_INDEX_FILE=" + tmp.Name()}
+	if _, err := runGitCommandEnv(dir, env, addArgs...); err != nil {
+		return "", nil, fmt.Errorf("git addの実行に失敗しました: %v", err)
+	}
+	numstat, err := runGitCommandEnv(dir, env, "diff", "--cached", "--no-ext-diff", "--numstat", "-z")
+	if err != nil {
+		return "", nil, fmt.Errorf("git diffの実行に失敗しました: %v", err)
+	}
+	diff, err := runGitCommandEnv(dir, env, "diff", "--cached", "--no-ext-diff", "--stat", "--patch")
+	if err != nil {
+		return "", nil, fmt.Errorf("git diffの実行に失敗しました: %v", err)
+	}
+	if len(diff) > maxGitDiffBytes {
+		diff = diff[:strings.LastIndex(diff[:maxGitDiffBytes], "\n")+1] + "...(以降の差分を省略)...\n"
+	}
+	return diff, parseGitNumstat(numstat), nil
+}
+
+// GetGitCommitTool はgitCommitツールの定義を返す
+func GetGitCommitTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("gitCommit", "指定したファイルをステージし、コミットメッセージでコミットを作成します。既にステージされている変更もコミットに含まれます。実行前にステージされる差分を表示してユーザーの許可を求めます", GitCommitArgs{}),
+		Function: GitCommit,
+	}
+}
@@ -0,0 +1,131 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxGlobResults はglobツールが返すパスの最大数
+const maxGlobResults = 1000
+
+// GlobArgs はglobツールの引数を表す構造体
+type GlobArgs struct {
+	Pattern string `json:"pattern" description:"マッチさせるパターン（例: **/*.go, src/**/test_*.py）。**は0個以上のディレクトリにマッチします"`
+	Path    string `json:"path,omitempty" description:"検索の起点となるディレクトリ。パターンはこのディレクトリからの相対パスに適用されます（デフォルトはカレントディレクトリ）"`
+}
+
+// GlobResult はglobツールの結果を表す構造体
+type GlobResult struct {
+	Files     []string `json:"files"`
+	Truncated bool     `json:"truncated,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Glob はパターンにマッチするファイルのパスを返す
+func Glob(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてGlobArgsに変換
+	var globArgs GlobArgs
+	if err := json.Unmarshal([]byte(args), &globArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := GlobResult{
+			Files: []string{},
+			Error: errorMessage,
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	root := globArgs.Path
+	if root == "" {
+		root = "."
+	}
+	segments := strings.Split(strings.TrimPrefix(filepath.ToSlash(globArgs.Pattern), "./"), "/")
+	// 不正なパターンは走査前に検出する
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return genErrorResult(fmt.Sprintf("パターンが不正です: %s", globArgs.Pattern)), nil
+		}
+	}
+
+	files := []string{}
+	truncated := false
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// 大きなディレクトリでも中断できるようにする
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		// .gitなどの隠しディレクトリは、パターンで明示されない限り辿らない
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") && !strings.HasPrefix(globArgs.Pattern, ".") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		if matchGlobSegments(segments, strings.Split(filepath.ToSlash(rel), "/")) {
+			if len(files) >= maxGlobResults {
+				truncated = true
+				return filepath.SkipAll
+			}
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ディレクトリの走査に失敗しました: %v", err)), nil
+	}
+
+	result := GlobResult{
+		Files:     files,
+		Truncated: truncated,
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// matchGlobSegments はパスの要素がパターンの要素にマッチするかを返す。**は0個以上の要素にマッチする
+func matchGlobSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchGlobSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], parts[0])
+	return ok && matchGlobSegments(pattern[1:], parts[1:])
+}
+
+// GetGlobTool はglobツールの定義を返す
+func GetGlobTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("glob", "パターン（例: **/*.go）にマッチするファイルのパスを一覧します。ディレクトリを1つずつlistするより効率的にファイルを探せます", GlobArgs{}),
+		Function: Glob,
+		ReadOnly: true,
+	}
+}
@@ -0,0 +1,127 @@
+package tools
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileNames は除外パターンを読み込むファイル。.nebulaignoreはgitで管理しないファイルをnebulaだけで除外するために使う
+var ignoreFileNames = []string{".gitignore", ".nebulaignore"}
+
+// ignoreRule は.gitignoreの1行分のパターン
+type ignoreRule struct {
+	base     string   // パターンを書いたファイルがあるディレクトリ（絶対パス）
+	segments []string // /で区切ったパターン。どの階層にもマッチするパターンは先頭が**
+	negate   bool     // !で始まるパターン（除外の取り消し）
+	dirOnly  bool     // /で終わるパターン（ディレクトリにだけマッチ）
+}
+
+// ignoreMatcher は.gitignoreと.nebulaignoreに従ってパスを除外するかを判定する
+type ignoreMatcher struct {
+	rules  []ignoreRule
+	loaded map[string]bool
+}
+
+// newIgnoreMatcher は走査の起点から上位のgitリポジトリのルートまでの除外パターンを読み込む
+func newIgnoreMatcher(root string) *ignoreMatcher {
+	m := &ignoreMatcher{loaded: map[string]bool{}}
+
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return m
+	}
+	// 上位のディレクトリのパターンから順に適用されるよう、リポジトリのルート側から読み込む
+	var dirs []string
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	for _, dir := range dirs {
+		m.loadDir(dir)
+	}
+	return m
+}
+
+// loadDir はディレクトリにある除外パターンのファイルを読み込む。走査中に各ディレクトリに入るときに呼び出す
+func (m *ignoreMatcher) loadDir(dir string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil || m.loaded[abs] {
+		return
+	}
+	m.loaded[abs] = true
+
+	for _, name := range ignoreFileNames {
+		file, err := os.Open(filepath.Join(abs, name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreRule(abs, scanner.Text()); ok {
+				m.rules = append(m.rules, rule)
+			}
+		}
+		file.Close()
+	}
+}
+
+// parseIgnoreRule は.gitignoreの1行を解析する。空行とコメントはokがfalseになる
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, `\`)
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// 途中に/を含むパターンはファイルのあるディレクトリからの相対パス、含まないパターンはどの階層の名前にもマッチする
+	anchored := strings.Contains(line, "/")
+	rule.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	if !anchored {
+		rule.segments = append([]string{"**"}, rule.segments...)
+	}
+	return rule, true
+}
+
+// ignored はパスを除外するかを返す。後に書かれたパターンほど優先する
+func (m *ignoreMatcher) ignored(path string, isDir bool) bool {
+	if isDir && filepath.Base(path) == ".git" {
+		return true
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, abs)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if matchGlobSegments(rule.segments, strings.Split(filepath.ToSlash(rel), "/")) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
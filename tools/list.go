@@ -1,19 +1,18 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
-
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
 // ListArgs はlistツールの引数を表す構造体
 type ListArgs struct {
 	Path      string `json:"path" description:"リストを取得するディレクトリのパス"`
-	Recursive bool   `json:"recursive" description:"再帰的にディレクトリを探索するかどうか"`
+	Recursive bool   `json:"recursive,omitempty" description:"再帰的にリストするかどうか（デフォルトはfalse）"`
+	NoIgnore  bool   `json:"noIgnore,omitempty" description:"trueの場合、.gitignoreと.nebulaignoreで除外されたファイルや.gitディレクトリも含めます（デフォルトはfalse）"`
 }
 
 // ListResult はlistツールの結果を表す構造体
@@ -23,7 +22,7 @@ type ListResult struct {
 }
 
 // List は指定されたパス内のファイルとディレクトリをリストする
-func List(args string) (string, error) {
+func List(ctx context.Context, args string) (string, error) {
 	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてListArgsに変換
 	var listArgs ListArgs
 	if err := json.Unmarshal([]byte(args), &listArgs); err != nil {
@@ -32,12 +31,33 @@ func List(args string) (string, error) {
 
 	var files []string
 
+	// node_modulesやビルド成果物で結果が埋まらないよう、.gitignoreなどで除外されたパスは含めない
+	var ignore *ignoreMatcher
+	if !listArgs.NoIgnore {
+		ignore = newIgnoreMatcher(listArgs.Path)
+	}
+
 	if listArgs.Recursive {
 		// 再帰的な探索
 		err := filepath.Walk(listArgs.Path, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err // エラーが発生した場合は中断
 			}
+			// 大きなディレクトリでも中断できるようにする
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if ignore != nil && path != listArgs.Path {
+				if ignore.ignored(path, info.IsDir()) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if info.IsDir() {
+					ignore.loadDir(path)
+				}
+			}
 			// 見つかったらパスをすべて配列に追加（ファイルもディレクトリも含む）
 			files = append(files, path)
 			return nil
@@ -65,7 +85,11 @@ func List(args string) (string, error) {
 
 		// 各エントリのフルパスを構築して配列に追加
 		for _, entry := range entries {
-			files = append(files, filepath.Join(listArgs.Path, entry.Name()))
+			path := filepath.Join(listArgs.Path, entry.Name())
+			if ignore != nil && ignore.ignored(path, entry.IsDir()) {
+				continue
+			}
+			files = append(files, path)
 		}
 	}
 
@@ -81,27 +105,8 @@ func List(args string) (string, error) {
 // GetListTool はlistツールの定義を返す
 func GetListTool() ToolDefinition {
 	return ToolDefinition{
-		Schema: openai.Tool{
-			Type: openai.ToolTypeFunction,
-			Function: &openai.FunctionDefinition{
-				Name:        "list",
-				Description: "指定したディレクトリ内のファイルとディレクトリの一覧を返します。recursiveがtrueの場合、再帰的にリストします。",
-				Parameters: jsonschema.Definition{
-					Type: jsonschema.Object,
-					Properties: map[string]jsonschema.Definition{
-						"path": {
-							Type:        jsonschema.String,
-							Description: "リストを取得するディレクトリのパス",
-						},
-						"recursive": {
-							Type:        jsonschema.Boolean,
-							Description: "再帰的にリストするかどうか（デフォルトはfalse）",
-						},
-					},
-					Required: []string{"path"},
-				},
-			},
-		},
+		Schema:   newToolSchema("list", "指定したディレクトリ内のファイルとディレクトリの一覧を返します。recursiveがtrueの場合、再帰的にリストします。.gitignoreと.nebulaignoreで除外されたパスはデフォルトで含めません。", ListArgs{}),
 		Function: List,
+		ReadOnly: true,
 	}
 }
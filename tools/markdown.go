@@ -0,0 +1,303 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// markdownHeading はMarkdownの見出し
+type markdownHeading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	Line  int    `json:"line"`
+}
+
+// MarkdownOutlineArgs はmarkdownOutlineツールの引数を表す構造体
+type MarkdownOutlineArgs struct {
+	Path string `json:"path" description:"見出しを一覧するMarkdownファイルのパス"`
+}
+
+// MarkdownOutlineResult はmarkdownOutlineツールの結果を表す構造体
+type MarkdownOutlineResult struct {
+	Headings []markdownHeading `json:"headings"`
+	Error    string            `json:"error,omitempty"`
+}
+
+var (
+	atxHeadingPattern = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+	// [text](target) と ![alt](target)。targetの後ろの "title" は除く
+	inlineLinkPattern = regexp.MustCompile(`!?\[[^\]]*\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)`)
+	// [label]: target
+	referenceLinkPattern = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s*<?(\S+?)>?(?:\s+.*)?$`)
+)
+
+// forEachMarkdownLine はコードブロックの外にある行を行番号（1始まり）とともに順に渡す
+func forEachMarkdownLine(content string, fn func(line string, number int)) {
+	inFence := false
+	fence := ""
+	for i, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if inFence {
+			if strings.HasPrefix(trimmed, fence) {
+				inFence = false
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = true
+			fence = trimmed[:3]
+			continue
+		}
+		fn(line, i+1)
+	}
+}
+
+// markdownHeadings はMarkdownの見出し（ATX形式）を抽出する
+func markdownHeadings(content string) []markdownHeading {
+	headings := []markdownHeading{}
+	forEachMarkdownLine(content, func(line string, number int) {
+		if m := atxHeadingPattern.FindStringSubmatch(line); m != nil {
+			headings = append(headings, markdownHeading{Level: len(m[1]), Text: m[2], Line: number})
+		}
+	})
+	return headings
+}
+
+// headingAnchor はGitHubと同じ規則で見出しのアンカーを作る
+func headingAnchor(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r == ' ':
+			b.WriteRune('-')
+		case r == '-' || r == '_' || isWordRune(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127
+}
+
+// MarkdownOutline はMarkdownファイルの見出しを階層と行番号つきで返す
+func MarkdownOutline(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてMarkdownOutlineArgsに変換
+	var outlineArgs MarkdownOutlineArgs
+	if err := json.Unmarshal([]byte(args), &outlineArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	content, err := os.ReadFile(outlineArgs.Path)
+	if err != nil {
+		result := MarkdownOutlineResult{
+			Headings: []markdownHeading{},
+			Error:    fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err),
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON), nil
+	}
+
+	result := MarkdownOutlineResult{
+		Headings: markdownHeadings(string(content)),
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// CheckLinksArgs はcheckLinksツールの引数を表す構造体
+type CheckLinksArgs struct {
+	Path          string `json:"path" description:"リンクを検査するMarkdownファイルのパス"`
+	CheckExternal bool   `json:"checkExternal,omitempty" description:"trueの場合、http(s)のリンクにもアクセスして確認します（デフォルトはfalse）"`
+}
+
+// brokenLink は壊れているリンク
+type brokenLink struct {
+	Line   int    `json:"line"`
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+// CheckLinksResult はcheckLinksツールの結果を表す構造体
+type CheckLinksResult struct {
+	Checked int          `json:"checked"`
+	Broken  []brokenLink `json:"broken"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// CheckLinks はMarkdown内のリンク先（相対パス、見出しのアンカー、必要に応じて外部URL）が存在するかを検査する
+func CheckLinks(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてCheckLinksArgsに変換
+	var checkLinksArgs CheckLinksArgs
+	if err := json.Unmarshal([]byte(args), &checkLinksArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	content, err := os.ReadFile(checkLinksArgs.Path)
+	if err != nil {
+		result := CheckLinksResult{
+			Broken: []brokenLink{},
+			Error:  fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err),
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON), nil
+	}
+
+	type link struct {
+		line   int
+		target string
+	}
+	var links []link
+	forEachMarkdownLine(string(content), func(line string, number int) {
+		for _, m := range inlineLinkPattern.FindAllStringSubmatch(line, -1) {
+			links = append(links, link{line: number, target: m[1]})
+		}
+		if m := referenceLinkPattern.FindStringSubmatch(line); m != nil {
+			links = append(links, link{line: number, target: m[1]})
+		}
+	})
+
+	client := newPublicHTTPClient(10 * time.Second)
+	broken := []brokenLink{}
+	checked := 0
+	for _, l := range links {
+		if err := ctx.Err(); err != nil {
+			result := CheckLinksResult{
+				Broken: []brokenLink{},
+				Error:  fmt.Sprintf("リンクの検査が中断されました: %v", err),
+			}
+			resultJSON, _ := json.Marshal(result)
+			return string(resultJSON), nil
+		}
+		reason, ok := checkLinkTarget(ctx, client, checkLinksArgs.Path, string(content), l.target, checkLinksArgs.CheckExternal)
+		if !ok {
+			continue
+		}
+		checked++
+		if reason != "" {
+			broken = append(broken, brokenLink{Line: l.line, Target: l.target, Reason: reason})
+		}
+	}
+
+	result := CheckLinksResult{
+		Checked: checked,
+		Broken:  broken,
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// requestLink は外部のリンク先にリクエストを送る。ctxがキャンセルされると中断する
+func requestLink(ctx context.Context, client *http.Client, method, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// checkLinkTarget はリンク先を検査し、壊れていればその理由を返す。検査しなかったリンクはcheckedがfalseになる
+func checkLinkTarget(ctx context.Context, client *http.Client, docPath, docContent, target string, checkExternal bool) (reason string, checked bool) {
+	switch {
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		if !checkExternal {
+			return "", false
+		}
+		resp, err := requestLink(ctx, client, http.MethodHead, target)
+		if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+			resp.Body.Close()
+			resp, err = requestLink(ctx, client, http.MethodGet, target)
+		}
+		if err != nil {
+			return fmt.Sprintf("アクセスできません: %v", err), true
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Sprintf("HTTP %d", resp.StatusCode), true
+		}
+		return "", true
+	case strings.Contains(target, "://") || strings.HasPrefix(target, "mailto:"):
+		return "", false
+	}
+
+	file, anchor, _ := strings.Cut(target, "#")
+	anchorContent := docContent
+	if file != "" {
+		path := filepath.Join(filepath.Dir(docPath), filepath.FromSlash(file))
+		info, err := os.Stat(path)
+		if err != nil {
+			return "リンク先のファイルが存在しません", true
+		}
+		if anchor == "" || info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".md") {
+			return "", true
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Sprintf("リンク先のファイルを読み込めません: %v", err), true
+		}
+		anchorContent = string(data)
+	}
+	if anchor == "" {
+		return "", true
+	}
+
+	// 同じ見出しが複数ある場合、GitHubは2つ目以降に -1, -2 ... を付ける
+	seen := map[string]int{}
+	for _, heading := range markdownHeadings(anchorContent) {
+		slug := headingAnchor(heading.Text)
+		if n := seen[slug]; n > 0 {
+			seen[slug]++
+			slug = fmt.Sprintf("%s-%d", slug, n)
+		} else {
+			seen[slug] = 1
+		}
+		if slug == strings.ToLower(anchor) {
+			return "", true
+		}
+	}
+	return "リンク先の見出しが見つかりません", true
+}
+
+// readWordList はファイルから1行1語の単語リストを読み込む
+func readWordList(path string, words map[string]bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" && !strings.HasPrefix(word, "#") {
+			words[strings.ToLower(word)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// GetMarkdownOutlineTool はmarkdownOutlineツールの定義を返す
+func GetMarkdownOutlineTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("markdownOutline", "Markdownファイルの見出しを階層と行番号つきで一覧します。コードブロック内は除きます", MarkdownOutlineArgs{}),
+		Function: MarkdownOutline,
+		ReadOnly: true,
+	}
+}
+
+// GetCheckLinksTool はcheckLinksツールの定義を返す
+func GetCheckLinksTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("checkLinks", "Markdownファイル内のリンクを検査し、存在しないファイルや見出しへのリンクを報告します。外部URLの確認は任意です", CheckLinksArgs{}),
+		Function: CheckLinks,
+		ReadOnly: true,
+	}
+}
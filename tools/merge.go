@@ -0,0 +1,293 @@
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxMergeLCSCells は行のLCSを計算する表の最大サイズ。超える場合は差分全体を1つの衝突として扱う
+const maxMergeLCSCells = 4_000_000
+
+// mergeChunk は3-wayマージの1区間
+type mergeChunk struct {
+	base, ours, theirs []string
+}
+
+// conflicting は両者がbaseから異なる変更をした区間かどうかを返す
+func (c mergeChunk) conflicting() bool {
+	return !equalLines(c.ours, c.base) && !equalLines(c.theirs, c.base) && !equalLines(c.ours, c.theirs)
+}
+
+// resolved は衝突しない区間の結果を返す
+func (c mergeChunk) resolved() []string {
+	if equalLines(c.ours, c.base) {
+		return c.theirs
+	}
+	return c.ours
+}
+
+// MergeConflict は3-wayマージで、読み込み後のディスク上の変更とモデルの変更が衝突した区間
+type MergeConflict struct {
+	Path   string   `json:"-"`
+	Index  int      `json:"index"` // 1から数えた衝突の番号
+	Total  int      `json:"-"`
+	Base   []string `json:"-"`
+	Ours   []string `json:"-"`
+	Theirs []string `json:"-"`
+	// モデルに返すときの内容
+	BaseText   string `json:"readContent"`
+	OursText   string `json:"currentContent"`
+	TheirsText string `json:"proposedContent"`
+}
+
+// MergeChoice は衝突した区間にどの内容を使うか
+type MergeChoice int
+
+const (
+	MergeAbort      MergeChoice = iota // 編集を中止する
+	MergeOurs                          // 現在のディスク上の内容
+	MergeTheirs                        // モデルの変更
+	MergeBoth                          // 両方（ディスク上の内容の後にモデルの変更）
+	MergeUnresolved                    // 判断できる人がいないので、衝突をモデルに返す
+)
+
+// ConflictResolver は衝突した区間にどの内容を使うかを決める
+type ConflictResolver func(conflict MergeConflict) (MergeChoice, error)
+
+// conflictResolver は現在の衝突の解決方法。デフォルトは端末で尋ねる
+var conflictResolver ConflictResolver = terminalConflictResolver
+
+// SetConflictResolver は衝突の解決方法を設定する。nilの場合は尋ねずに衝突をモデルに返す
+// 承認方法（SetApprover）と組み合わせて、エディタやサーバーなど端末以外でも衝突を解決できるようにする
+func SetConflictResolver(r ConflictResolver) {
+	conflictResolver = r
+}
+
+// errMergeAborted はユーザーが衝突の解決を中止したことを表す
+var errMergeAborted = errors.New("ファイルが読み込み後に変更されていたため、ユーザーによって編集が中止されました。readFileで最新の内容を読み込み直してください")
+
+// mergeChanges はbase（読み込み時の内容）・ours（現在のディスク上の内容）・theirs（モデルの変更）を3-wayマージする
+// 片方だけが変更した区間は自動で取り込み、両方が変更した区間はconflictResolverにどちらを使うか決めさせる
+// 決められない衝突があればunresolvedに返す。中止された場合はエラーを返す
+func mergeChanges(path, base, ours, theirs string) (merged string, unresolved []MergeConflict, err error) {
+	chunks := diff3(splitLines(base), splitLines(ours), splitLines(theirs))
+
+	total := 0
+	for _, chunk := range chunks {
+		if chunk.conflicting() {
+			total++
+		}
+	}
+
+	var result []string
+	index := 0
+	for _, chunk := range chunks {
+		if !chunk.conflicting() {
+			result = append(result, chunk.resolved()...)
+			continue
+		}
+
+		index++
+		conflict := MergeConflict{
+			Path: path, Index: index, Total: total,
+			Base: chunk.base, Ours: chunk.ours, Theirs: chunk.theirs,
+			BaseText: strings.Join(chunk.base, ""), OursText: strings.Join(chunk.ours, ""), TheirsText: strings.Join(chunk.theirs, ""),
+		}
+		choice := MergeUnresolved
+		if conflictResolver != nil {
+			if choice, err = conflictResolver(conflict); err != nil {
+				return "", nil, err
+			}
+		}
+		switch choice {
+		case MergeOurs:
+			result = append(result, chunk.ours...)
+		case MergeTheirs:
+			result = append(result, chunk.theirs...)
+		case MergeBoth:
+			result = append(result, chunk.ours...)
+			result = append(result, chunk.theirs...)
+		case MergeUnresolved:
+			unresolved = append(unresolved, conflict)
+		default:
+			return "", nil, errMergeAborted
+		}
+	}
+
+	return strings.Join(result, ""), unresolved, nil
+}
+
+// terminalConflictResolver は衝突した区間を表示し、標準入力からどちらを使うかを選ばせる
+func terminalConflictResolver(conflict MergeConflict) (MergeChoice, error) {
+	if conflict.Index == 1 {
+		fmt.Printf("\nファイルはモデルが読み込んだ後に変更されています: %s\n", conflict.Path)
+		fmt.Println("両方の変更をマージします。衝突する箇所はどちらを使うか選んでください。")
+	}
+	fmt.Printf("\n--- 衝突 %d/%d ---\n", conflict.Index, conflict.Total)
+	fmt.Print(formatConflict(conflict))
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("どちらを使いますか？ (o)現在の内容 / (t)モデルの変更 / (b)両方 / (q)中止: ")
+		if !scanner.Scan() {
+			return MergeAbort, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+		case "o":
+			return MergeOurs, nil
+		case "t":
+			return MergeTheirs, nil
+		case "b":
+			return MergeBoth, nil
+		case "q":
+			return MergeAbort, nil
+		}
+	}
+}
+
+// ApprovalConflictResolver は承認方法（SetApprover）で衝突した区間を示し、
+// 承認されればモデルの変更を使い、拒否されれば編集を中止する。エディタやSlackなど端末以外から解決するために使う
+func ApprovalConflictResolver(conflict MergeConflict) (MergeChoice, error) {
+	approved, err := approver(ApprovalRequest{
+		Tool:   "editFile",
+		Kind:   ApprovalEdit,
+		Title:  fmt.Sprintf("%sはモデルが読み込んだ後に変更されています。衝突 %d/%d でモデルの変更を使いますか？（拒否すると編集を中止します）", conflict.Path, conflict.Index, conflict.Total),
+		Detail: formatConflict(conflict),
+		Paths:  []string{conflict.Path},
+	})
+	if err != nil {
+		return MergeAbort, err
+	}
+	if !approved {
+		return MergeAbort, nil
+	}
+	return MergeTheirs, nil
+}
+
+// formatConflict は衝突した区間の3つの内容を表示用に整形する
+func formatConflict(conflict MergeConflict) string {
+	return fmt.Sprintf("[読み込み時の内容]\n%s[現在のディスク上の内容]\n%s[モデルの変更]\n%s",
+		formatMergeLines(conflict.Base), formatMergeLines(conflict.Ours), formatMergeLines(conflict.Theirs))
+}
+
+// diff3 は3つの版を、全員が一致する行で区切った区間の列に分割する
+func diff3(base, ours, theirs []string) []mergeChunk {
+	matchOurs := matchLines(base, ours)
+	matchTheirs := matchLines(base, theirs)
+
+	var chunks []mergeChunk
+	i, o, t := 0, 0, 0
+	for k := 0; k <= len(base); k++ {
+		// 末尾または3者が一致する行で区間を確定させる
+		if k < len(base) && (matchOurs[k] < 0 || matchTheirs[k] < 0) {
+			continue
+		}
+		oEnd, tEnd := len(ours), len(theirs)
+		if k < len(base) {
+			oEnd, tEnd = matchOurs[k], matchTheirs[k]
+		}
+		if i < k || o < oEnd || t < tEnd {
+			chunks = append(chunks, mergeChunk{base: base[i:k], ours: ours[o:oEnd], theirs: theirs[t:tEnd]})
+		}
+		if k < len(base) {
+			line := []string{base[k]}
+			chunks = append(chunks, mergeChunk{base: line, ours: line, theirs: line})
+		}
+		i, o, t = k+1, oEnd+1, tEnd+1
+	}
+	return chunks
+}
+
+// matchLines はbaseの各行に対応するotherの行番号を返す。対応がない行は-1
+func matchLines(base, other []string) []int {
+	match := make([]int, len(base))
+	for i := range match {
+		match[i] = -1
+	}
+
+	// 共通の先頭と末尾を除いてからLCSを計算する
+	prefix := 0
+	for prefix < len(base) && prefix < len(other) && base[prefix] == other[prefix] {
+		match[prefix] = prefix
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(base)-prefix && suffix < len(other)-prefix &&
+		base[len(base)-1-suffix] == other[len(other)-1-suffix] {
+		match[len(base)-1-suffix] = len(other) - 1 - suffix
+		suffix++
+	}
+
+	a := base[prefix : len(base)-suffix]
+	b := other[prefix : len(other)-suffix]
+	if len(a) == 0 || len(b) == 0 || len(a)*len(b) > maxMergeLCSCells {
+		return match
+	}
+
+	// lcs[i][j] はa[i:]とb[j:]のLCSの長さ
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			match[prefix+i] = prefix + j
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return match
+}
+
+// splitLines は改行を保持したまま行に分割する
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// formatMergeLines は区間の行を表示用に整形する。空の場合はその旨を表示する
+func formatMergeLines(lines []string) string {
+	if len(lines) == 0 {
+		return "  (なし)\n"
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString("  " + line)
+		if !strings.HasSuffix(line, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
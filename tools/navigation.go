@@ -0,0 +1,195 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxNavigationResults はfindDefinition/findReferencesで返す場所の最大数
+const maxNavigationResults = 200
+
+// CodePosition は言語サーバーとやり取りするファイル内の位置
+// 行は0始まり、CharacterはLSPと同じくUTF-16のコード単位で数える
+type CodePosition struct {
+	Path      string
+	Line      int
+	Character int
+}
+
+// CodeNavigator は言語サーバーを使ってシンボルの定義や参照を探す
+type CodeNavigator interface {
+	Definition(pos CodePosition) ([]CodePosition, error)
+	References(pos CodePosition, includeDeclaration bool) ([]CodePosition, error)
+}
+
+// codeNavigator は現在の検索方法。設定されていない場合はnil
+var codeNavigator CodeNavigator
+
+// SetCodeNavigator はfindDefinition/findReferencesで使う言語サーバーを設定する
+func SetCodeNavigator(n CodeNavigator) {
+	codeNavigator = n
+}
+
+// codeLocation はfindDefinition/findReferencesの結果の場所（行と列は1始まり）
+type codeLocation struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Text   string `json:"text,omitempty"` // その行の内容
+}
+
+// FindSymbolArgs はfindDefinition/findReferencesツールの引数を表す構造体
+type FindSymbolArgs struct {
+	Path               string `json:"path" description:"シンボルが書かれているファイルのパス"`
+	Line               int    `json:"line" description:"シンボルが書かれている行番号（1始まり）"`
+	Symbol             string `json:"symbol" description:"調べるシンボルの名前（関数名、型名、変数名など）。行内で最初に現れる位置を使う"`
+	Occurrence         int    `json:"occurrence,omitempty" description:"同じ行にsymbolが複数ある場合に何番目を使うか（1始まり、デフォルトは1）"`
+	IncludeDeclaration bool   `json:"includeDeclaration,omitempty" description:"findReferencesで、定義そのものも結果に含めるか（デフォルトはfalse）"`
+}
+
+// FindSymbolResult はfindDefinition/findReferencesツールの結果を表す構造体
+type FindSymbolResult struct {
+	Locations []codeLocation `json:"locations"`
+	Truncated bool           `json:"truncated,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// FindDefinition は言語サーバーでシンボルの定義の場所を探す
+func FindDefinition(ctx context.Context, args string) (string, error) {
+	return findSymbol(args, func(pos CodePosition, findArgs FindSymbolArgs) ([]CodePosition, error) {
+		return codeNavigator.Definition(pos)
+	})
+}
+
+// FindReferences は言語サーバーでシンボルを参照している場所を探す
+func FindReferences(ctx context.Context, args string) (string, error) {
+	return findSymbol(args, func(pos CodePosition, findArgs FindSymbolArgs) ([]CodePosition, error) {
+		return codeNavigator.References(pos, findArgs.IncludeDeclaration)
+	})
+}
+
+// findSymbol は引数のシンボルの位置を求めてfindを呼び出し、見つかった場所を行の内容とともに返す
+func findSymbol(args string, find func(CodePosition, FindSymbolArgs) ([]CodePosition, error)) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてFindSymbolArgsに変換
+	var findArgs FindSymbolArgs
+	if err := json.Unmarshal([]byte(args), &findArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := FindSymbolResult{Locations: []codeLocation{}, Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	if codeNavigator == nil {
+		return genErrorResult("言語サーバーが利用できません。設定ファイルのlanguage_serversを設定するか、searchInDirectoryを使ってください"), nil
+	}
+	if findArgs.Symbol == "" || findArgs.Line < 1 {
+		return genErrorResult("pathとline（1始まり）とsymbolを指定してください"), nil
+	}
+	path, err := filepath.Abs(findArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("パスの解決に失敗しました: %v", err)), nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err)), nil
+	}
+	lines := strings.Split(string(content), "\n")
+	if findArgs.Line > len(lines) {
+		return genErrorResult(fmt.Sprintf("行番号がファイルの行数（%d行）を超えています", len(lines))), nil
+	}
+	line := lines[findArgs.Line-1]
+	index := symbolIndex(line, findArgs.Symbol, max(findArgs.Occurrence, 1))
+	if index < 0 {
+		return genErrorResult(fmt.Sprintf("%d行目に%sが見つかりません: %s", findArgs.Line, findArgs.Symbol, strings.TrimSpace(line))), nil
+	}
+
+	positions, err := find(CodePosition{Path: path, Line: findArgs.Line - 1, Character: utf16Len(line[:index])}, findArgs)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("言語サーバーでの検索に失敗しました: %v", err)), nil
+	}
+
+	result := FindSymbolResult{Locations: []codeLocation{}}
+	if len(positions) > maxNavigationResults {
+		positions = positions[:maxNavigationResults]
+		result.Truncated = true
+	}
+	// 同じファイルを何度も読まないようにする
+	fileLines := map[string][]string{path: lines}
+	cwd, _ := os.Getwd()
+	for _, pos := range positions {
+		location := codeLocation{Path: pos.Path, Line: pos.Line + 1, Column: pos.Character + 1}
+		if _, ok := fileLines[pos.Path]; !ok {
+			if data, err := os.ReadFile(pos.Path); err == nil {
+				fileLines[pos.Path] = strings.Split(string(data), "\n")
+			} else {
+				fileLines[pos.Path] = nil
+			}
+		}
+		if target := fileLines[pos.Path]; pos.Line < len(target) {
+			location.Text = strings.TrimSpace(target[pos.Line])
+		}
+		if rel, err := filepath.Rel(cwd, pos.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			location.Path = rel
+		}
+		result.Locations = append(result.Locations, location)
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// symbolIndex は行内でsymbolがoccurrence番目に単語として現れるバイト位置を返す。見つからなければ-1を返す
+func symbolIndex(line, symbol string, occurrence int) int {
+	isIdentRune := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	count := 0
+	for offset := 0; offset <= len(line)-len(symbol); {
+		i := strings.Index(line[offset:], symbol)
+		if i < 0 {
+			return -1
+		}
+		start := offset + i
+		end := start + len(symbol)
+		before, _ := utf8.DecodeLastRuneInString(line[:start])
+		after, _ := utf8.DecodeRuneInString(line[end:])
+		if (start == 0 || !isIdentRune(before)) && (end == len(line) || !isIdentRune(after)) {
+			count++
+			if count == occurrence {
+				return start
+			}
+		}
+		offset = start + 1
+	}
+	return -1
+}
+
+// utf16Len は文字列をUTF-16で表したときのコード単位の数を返す
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// GetFindDefinitionTool はfindDefinitionツールの定義を返す
+func GetFindDefinitionTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("findDefinition", "言語サーバー（Goではgopls）を使って、ファイルの指定した行にあるシンボルの定義の場所を探します。文字列検索と違い、同名の別のシンボルやコメントに惑わされずに、実際に参照している定義へ移動できます", FindSymbolArgs{}),
+		Function: FindDefinition,
+		ReadOnly: true,
+	}
+}
+
+// GetFindReferencesTool はfindReferencesツールの定義を返す
+func GetFindReferencesTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("findReferences", "言語サーバー（Goではgopls）を使って、ファイルの指定した行にあるシンボルを参照しているすべての場所を探します。関数やフィールドの変更前に影響範囲を調べるのに使います", FindSymbolArgs{}),
+		Function: FindReferences,
+		ReadOnly: true,
+	}
+}
@@ -0,0 +1,248 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// normalizeArguments はツールのスキーマに基づいて引数のJSONを正規化する
+//   - 省略された任意の引数には型に応じたデフォルト値（false, 0, "", []）を補う
+//   - "true"/"false"や"10"のような文字列を、スキーマの型に合わせて変換する
+//   - スキーマに定義されていない引数や、必須の引数の欠落はエラーにする
+//   - パスを表す引数は~を展開して整理し、カレントディレクトリ（プロジェクトのルート）内であれば相対パスにする
+//   - RestrictPathsで制限している場合、扱えない場所のパスはエラーにする
+func normalizeArguments(schema jsonschema.Definition, args string) (string, error) {
+	if strings.TrimSpace(args) == "" {
+		args = "{}"
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(args), &value); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	normalized, err := normalizeValue(schema, value, "")
+	if err != nil {
+		return "", err
+	}
+	if obj, ok := normalized.(map[string]any); ok {
+		if err := checkArgumentPaths(obj); err != nil {
+			return "", err
+		}
+	}
+
+	normalizedJSON, err := json.Marshal(normalized)
+	if err != nil {
+		return "", fmt.Errorf("引数の変換に失敗しました: %v", err)
+	}
+	return string(normalizedJSON), nil
+}
+
+// normalizeValue はスキーマの型に合わせて値を変換する。pathはエラーメッセージ用の引数名
+func normalizeValue(schema jsonschema.Definition, value any, path string) (any, error) {
+	switch schema.Type {
+	case jsonschema.Object:
+		return normalizeObject(schema, value, path)
+	case jsonschema.Array:
+		return normalizeArray(schema, value, path)
+	case jsonschema.Boolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, nil
+			}
+		}
+	case jsonschema.Integer, jsonschema.Number:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, nil
+			}
+		}
+	case jsonschema.String:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64, bool:
+			return fmt.Sprint(v), nil
+		}
+	default:
+		return value, nil
+	}
+
+	return nil, fmt.Errorf("引数 %s は%s型である必要があります（実際の値: %v）", displayPath(path), schema.Type, value)
+}
+
+// normalizeObject はオブジェクトの各プロパティを変換し、未定義のプロパティを拒否してデフォルト値を補う
+func normalizeObject(schema jsonschema.Definition, value any, path string) (any, error) {
+	obj, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("引数 %s はオブジェクトである必要があります", displayPath(path))
+	}
+	if len(schema.Properties) == 0 {
+		return obj, nil
+	}
+
+	var unknown []string
+	for key := range obj {
+		if _, defined := schema.Properties[key]; !defined {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("未定義の引数があります: %s", strings.Join(unknown, ", "))
+	}
+
+	required := map[string]bool{}
+	for _, key := range schema.Required {
+		required[key] = true
+	}
+
+	normalized := map[string]any{}
+	for key, propSchema := range schema.Properties {
+		propValue, exists := obj[key]
+		if !exists || propValue == nil {
+			if required[key] {
+				return nil, fmt.Errorf("必須の引数 %s がありません", joinPath(path, key))
+			}
+			// nullableな引数は省略されたことをツールが区別できるよう、デフォルト値を補わない
+			if propSchema.Nullable {
+				continue
+			}
+			normalized[key] = defaultValue(propSchema)
+			continue
+		}
+
+		v, err := normalizeValue(propSchema, propValue, joinPath(path, key))
+		if err != nil {
+			return nil, err
+		}
+		if relativize, isPath := pathArguments[key]; isPath {
+			v = normalizePathValue(v, relativize)
+		}
+		normalized[key] = v
+	}
+	return normalized, nil
+}
+
+// normalizeArray は配列の各要素を変換する。単一の値やJSON配列の文字列も配列として扱う
+func normalizeArray(schema jsonschema.Definition, value any, path string) (any, error) {
+	var items []any
+	switch v := value.(type) {
+	case []any:
+		items = v
+	case string:
+		if err := json.Unmarshal([]byte(v), &items); err != nil {
+			items = []any{v}
+		}
+	default:
+		items = []any{v}
+	}
+
+	if schema.Items == nil {
+		return items, nil
+	}
+
+	normalized := make([]any, 0, len(items))
+	for i, item := range items {
+		v, err := normalizeValue(*schema.Items, item, fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, v)
+	}
+	return normalized, nil
+}
+
+// pathArguments はファイルシステムのパスを表す引数名と、カレントディレクトリからの相対パスにしてよいかどうか
+// gitのfile・filesは引数pathのディレクトリからの相対パスとして扱われるので、相対パスには変換しない
+var pathArguments = map[string]bool{
+	"path":             true,
+	"envFile":          true,
+	"wordList":         true,
+	"workingDirectory": true,
+	"excludePaths":     true,
+	"file":             false,
+	"files":            false,
+}
+
+// normalizePathValue はパスの文字列、またはパスの文字列の配列を正規化する
+func normalizePathValue(value any, relativize bool) any {
+	switch v := value.(type) {
+	case string:
+		return normalizePath(v, relativize)
+	case []any:
+		for i, item := range v {
+			if s, ok := item.(string); ok {
+				v[i] = normalizePath(s, relativize)
+			}
+		}
+	}
+	return value
+}
+
+// normalizePath はモデルが書いたパスの~を展開し、末尾の/や余分な./を取り除く
+// relativizeがtrueの場合、カレントディレクトリ内の絶対パスは相対パスにして、同じファイルが別のパスで扱われないようにする
+func normalizePath(path string, relativize bool) string {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return path
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	path = filepath.Clean(path)
+
+	if relativize && filepath.IsAbs(path) {
+		if cwd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(cwd, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				path = rel
+			}
+		}
+	}
+	return path
+}
+
+// defaultValue はスキーマの型に応じたデフォルト値を返す
+func defaultValue(schema jsonschema.Definition) any {
+	switch schema.Type {
+	case jsonschema.Boolean:
+		return false
+	case jsonschema.Integer, jsonschema.Number:
+		return 0
+	case jsonschema.String:
+		return ""
+	case jsonschema.Array:
+		return []any{}
+	default:
+		return nil
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "全体"
+	}
+	return path
+}
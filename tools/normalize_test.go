@@ -0,0 +1,256 @@
+package tools
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// normalizeTestSchema はnormalizeArgumentsのテストに使う、ファイル系のツールに似たスキーマ
+var normalizeTestSchema = jsonschema.Definition{
+	Type: jsonschema.Object,
+	Properties: map[string]jsonschema.Definition{
+		"path":      {Type: jsonschema.String},
+		"recursive": {Type: jsonschema.Boolean},
+		"limit":     {Type: jsonschema.Integer},
+		"patterns":  {Type: jsonschema.Array, Items: &jsonschema.Definition{Type: jsonschema.String}},
+		"files":     {Type: jsonschema.Array, Items: &jsonschema.Definition{Type: jsonschema.String}},
+	},
+	Required: []string{"path"},
+}
+
+func TestNormalizeArguments(t *testing.T) {
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "省略した引数にはデフォルト値を補う",
+			args: `{"path": "a.txt"}`,
+			want: `{"files":[],"limit":0,"path":"a.txt","patterns":[],"recursive":false}`,
+		},
+		{
+			name: "文字列の真偽値と数値を変換する",
+			args: `{"path": "a.txt", "recursive": "true", "limit": " 10 "}`,
+			want: `{"files":[],"limit":10,"path":"a.txt","patterns":[],"recursive":true}`,
+		},
+		{
+			name: "単一の値とJSON配列の文字列を配列にする",
+			args: `{"path": "a.txt", "patterns": "*.go", "files": "[\"x.go\", \"y.go\"]"}`,
+			want: `{"files":["x.go","y.go"],"limit":0,"path":"a.txt","patterns":["*.go"],"recursive":false}`,
+		},
+		{
+			name: "数値を文字列にする",
+			args: `{"path": 1}`,
+			want: `{"files":[],"limit":0,"path":"1","patterns":[],"recursive":false}`,
+		},
+		{
+			name: "nullは省略と同じように扱う",
+			args: `{"path": "a.txt", "limit": null}`,
+			want: `{"files":[],"limit":0,"path":"a.txt","patterns":[],"recursive":false}`,
+		},
+		{
+			name: "パスの末尾の/と余分な./を取り除く",
+			args: `{"path": "./src/./pkg/"}`,
+			want: mustMarshal(t, map[string]any{"files": []any{}, "limit": 0, "path": filepath.Join("src", "pkg"), "patterns": []any{}, "recursive": false}),
+		},
+		{
+			name: "カレントディレクトリ内の絶対パスは相対パスにする",
+			args: mustMarshal(t, map[string]any{"path": filepath.Join(cwd, "src", "main.go")}),
+			want: mustMarshal(t, map[string]any{"files": []any{}, "limit": 0, "path": filepath.Join("src", "main.go"), "patterns": []any{}, "recursive": false}),
+		},
+		{
+			name: "カレントディレクトリの外の絶対パスはそのまま",
+			args: mustMarshal(t, map[string]any{"path": filepath.Join(filepath.Dir(cwd), "other.txt")}),
+			want: mustMarshal(t, map[string]any{"files": []any{}, "limit": 0, "path": filepath.Join(filepath.Dir(cwd), "other.txt"), "patterns": []any{}, "recursive": false}),
+		},
+		{
+			name: "~をホームディレクトリに展開する",
+			args: `{"path": "~/notes.txt"}`,
+			want: mustMarshal(t, map[string]any{"files": []any{}, "limit": 0, "path": filepath.Join(home, "notes.txt"), "patterns": []any{}, "recursive": false}),
+		},
+		{
+			name: "filesはpathからの相対パスなので相対パスにしない",
+			args: mustMarshal(t, map[string]any{"path": ".", "files": []string{filepath.Join(cwd, "a.go")}}),
+			want: mustMarshal(t, map[string]any{"files": []any{filepath.Join(cwd, "a.go")}, "limit": 0, "path": ".", "patterns": []any{}, "recursive": false}),
+		},
+		{
+			name:    "空の引数でも必須の引数は省略できない",
+			args:    ``,
+			wantErr: true,
+		},
+		{
+			name:    "未定義の引数はエラー",
+			args:    `{"path": "a.txt", "unknown": 1}`,
+			wantErr: true,
+		},
+		{
+			name:    "変換できない値はエラー",
+			args:    `{"path": "a.txt", "limit": "ten"}`,
+			wantErr: true,
+		},
+		{
+			name:    "JSONでない引数はエラー",
+			args:    `{"path": `,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeArguments(normalizeTestSchema, tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("normalizeArguments(%s) = %s, want error", tt.args, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeArguments(%s) returned error: %v", tt.args, err)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeArguments(%s) = %s, want %s", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNormalizePathSeparators はWindowsとそれ以外で異なるパスの扱いを確認する。CIではWindowsでも実行する
+func TestNormalizePathSeparators(t *testing.T) {
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		relativize  bool
+		want        string
+		wantWindows string // Windowsで結果が異なる場合の期待値
+		windowsOnly bool
+	}{
+		{
+			name: "/区切りのパスはOSの区切り文字にする",
+			path: "src/pkg/",
+			want: filepath.Join("src", "pkg"),
+		},
+		{
+			name: "~/をホームディレクトリに展開する",
+			path: "~/notes.txt",
+			want: filepath.Join(home, "notes.txt"),
+		},
+		{
+			name: "~だけのパスはホームディレクトリ",
+			path: "~",
+			want: home,
+		},
+		{
+			name: "~で始まる名前のファイルは展開しない",
+			path: "~notes.txt",
+			want: "~notes.txt",
+		},
+		{
+			// Windowsでは\も区切り文字なので/と同じように扱い、それ以外ではファイル名の一部なのでそのまま残す
+			name:        "\\区切りのパス",
+			path:        `src\pkg\`,
+			want:        `src\pkg\`,
+			wantWindows: filepath.Join("src", "pkg"),
+		},
+		{
+			name:        "カレントディレクトリ内の\\区切りの絶対パス",
+			path:        cwd + `\src\main.go`,
+			relativize:  true,
+			want:        cwd + `\src\main.go`,
+			wantWindows: filepath.Join("src", "main.go"),
+		},
+		{
+			name:        "~\\をホームディレクトリに展開する",
+			path:        `~\notes.txt`,
+			want:        filepath.Join(home, "notes.txt"),
+			windowsOnly: true,
+		},
+		{
+			name:        "大文字と小文字が異なるカレントディレクトリ内の絶対パスも相対パスにする",
+			path:        strings.ToUpper(cwd) + `\src\main.go`,
+			relativize:  true,
+			want:        filepath.Join("src", "main.go"),
+			windowsOnly: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if runtime.GOOS == "windows" {
+				if tt.wantWindows != "" {
+					want = tt.wantWindows
+				}
+			} else if tt.windowsOnly {
+				t.Skip("Windowsでのみ確認する")
+			}
+			if got := normalizePath(tt.path, tt.relativize); got != want {
+				t.Errorf("normalizePath(%q, %v) = %q, want %q", tt.path, tt.relativize, got, want)
+			}
+		})
+	}
+}
+
+func TestNormalizeArgumentsRestrictedPaths(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "project")
+	data := filepath.Join(project, ".nebula")
+	if err := os.MkdirAll(data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(project)
+	RestrictPaths([]string{data})
+	t.Cleanup(func() {
+		pathsRestricted = false
+		deniedDirs = nil
+	})
+
+	tests := []struct {
+		name    string
+		args    map[string]any
+		wantErr bool
+	}{
+		{name: "プロジェクト内", args: map[string]any{"path": "src/main.go"}},
+		{name: "プロジェクトの外", args: map[string]any{"path": filepath.Join(root, "other.txt")}, wantErr: true},
+		{name: "..でプロジェクトの外", args: map[string]any{"path": filepath.Join("..", "other.txt")}, wantErr: true},
+		{name: "データディレクトリ", args: map[string]any{"path": filepath.Join(".nebula", "memory.db")}, wantErr: true},
+		{name: "filesはpathからの相対パスで確認する", args: map[string]any{"path": "src", "files": []any{filepath.Join("..", "..", "other.txt")}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := normalizeArguments(normalizeTestSchema, mustMarshal(t, tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("normalizeArguments(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// mustMarshal はテストの引数や期待値をJSONにする。Windowsの\を含むパスもエスケープできるようにする
+func mustMarshal(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,375 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxNotebookOutputLength はreadNotebookで返すセル出力の最大文字数
+const maxNotebookOutputLength = 2000
+
+// ReadNotebookArgs はreadNotebookツールの引数を表す構造体
+type ReadNotebookArgs struct {
+	Path           string `json:"path" description:"読み込むJupyter Notebook（.ipynb）のパス"`
+	IncludeOutputs bool   `json:"includeOutputs,omitempty" description:"trueの場合、コードセルの出力（テキストのみ）も含めます（デフォルトはfalse）"`
+}
+
+// notebookCellView はモデルに返すセルの内容
+type notebookCellView struct {
+	Index    int    `json:"index"`
+	CellType string `json:"cellType"`
+	Source   string `json:"source"`
+	Outputs  string `json:"outputs,omitempty"`
+}
+
+// ReadNotebookResult はreadNotebookツールの結果を表す構造体
+type ReadNotebookResult struct {
+	Language string             `json:"language,omitempty"`
+	Cells    []notebookCellView `json:"cells"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// EditNotebookCellArgs はeditNotebookCellツールの引数を表す構造体
+type EditNotebookCellArgs struct {
+	Path      string `json:"path" description:"編集するJupyter Notebook（.ipynb）のパス"`
+	CellIndex int    `json:"cellIndex" description:"対象のセルの番号（0始まり）。insertの場合は新しいセルを挿入する位置"`
+	Action    string `json:"action,omitempty" enum:"replace,insert,delete" description:"replace: セルの内容を置き換える（デフォルト）、insert: 新しいセルを挿入する、delete: セルを削除する"`
+	CellType  string `json:"cellType,omitempty" enum:"code,markdown,raw" description:"insertするセルの種類（デフォルトはcode）"`
+	Source    string `json:"source,omitempty" description:"セルの新しい内容の全体（replaceとinsertで使用）"`
+}
+
+// EditNotebookCellResult はeditNotebookCellツールの結果を表す構造体
+type EditNotebookCellResult struct {
+	Success bool   `json:"success"`
+	Note    string `json:"note,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// notebook はJSONの未知のフィールドを保ったままセルを扱うための表現
+type notebook struct {
+	raw   map[string]any
+	cells []any
+}
+
+func parseNotebook(content []byte) (*notebook, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("Notebookとして解析できません: %v", err)
+	}
+	cells, ok := raw["cells"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("cellsが見つかりません。nbformat 4形式のNotebookのみ対応しています")
+	}
+	return &notebook{raw: raw, cells: cells}, nil
+}
+
+// marshal はJupyterと同じ形式（インデント1、キーはソート済み）で書き出す
+func (n *notebook) marshal() (string, error) {
+	n.raw["cells"] = n.cells
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetIndent("", " ")
+	// セル内のHTMLやコードの < > & をエスケープせずにそのまま書き出す
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(n.raw); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (n *notebook) language() string {
+	metadata, _ := n.raw["metadata"].(map[string]any)
+	if info, ok := metadata["language_info"].(map[string]any); ok {
+		if name, ok := info["name"].(string); ok {
+			return name
+		}
+	}
+	if kernel, ok := metadata["kernelspec"].(map[string]any); ok {
+		if language, ok := kernel["language"].(string); ok {
+			return language
+		}
+	}
+	return ""
+}
+
+// cellField はセルのフィールドを返す
+func cellField(cell any, name string) any {
+	if m, ok := cell.(map[string]any); ok {
+		return m[name]
+	}
+	return nil
+}
+
+// multilineText はnbformatの複数行テキスト（文字列または文字列の配列）を1つの文字列にする
+func multilineText(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []any:
+		var b strings.Builder
+		for _, line := range v {
+			if s, ok := line.(string); ok {
+				b.WriteString(s)
+			}
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// toMultilineText は文字列をnbformatの行の配列にする
+func toMultilineText(text string) []any {
+	lines := []any{}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// cellOutputsText はコードセルの出力のうちテキストをまとめる。画像などは種類だけを示す
+func cellOutputsText(cell any) string {
+	outputs, _ := cellField(cell, "outputs").([]any)
+	var b strings.Builder
+	for _, output := range outputs {
+		switch cellField(output, "output_type") {
+		case "stream":
+			b.WriteString(multilineText(cellField(output, "text")))
+		case "error":
+			fmt.Fprintf(&b, "%v: %v\n", cellField(output, "ename"), cellField(output, "evalue"))
+		default:
+			data, _ := cellField(output, "data").(map[string]any)
+			if text, ok := data["text/plain"]; ok {
+				b.WriteString(multilineText(text))
+				b.WriteString("\n")
+				continue
+			}
+			var types []string
+			for mimeType := range data {
+				types = append(types, mimeType)
+			}
+			sort.Strings(types)
+			if len(types) > 0 {
+				fmt.Fprintf(&b, "[%s]\n", strings.Join(types, ", "))
+			}
+		}
+	}
+	text := b.String()
+	if len(text) > maxNotebookOutputLength {
+		text = cutAtRuneBoundary(text, maxNotebookOutputLength) + "\n...（出力を省略）"
+	}
+	return text
+}
+
+// ReadNotebook はNotebookをセル単位で読み込む
+func ReadNotebook(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてReadNotebookArgsに変換
+	var readNotebookArgs ReadNotebookArgs
+	if err := json.Unmarshal([]byte(args), &readNotebookArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := ReadNotebookResult{
+			Cells: []notebookCellView{},
+			Error: errorMessage,
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	content, err := os.ReadFile(readNotebookArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err)), nil
+	}
+	nb, err := parseNotebook(content)
+	if err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+
+	// 編集時に読み込み後の変更を検出できるよう、モデルに渡した内容を記録する
+	rememberContent(readNotebookArgs.Path, string(content))
+
+	result := ReadNotebookResult{
+		Language: nb.language(),
+		Cells:    []notebookCellView{},
+	}
+	for i, cell := range nb.cells {
+		cellType, _ := cellField(cell, "cell_type").(string)
+		view := notebookCellView{
+			Index:    i,
+			CellType: cellType,
+			Source:   multilineText(cellField(cell, "source")),
+		}
+		if readNotebookArgs.IncludeOutputs && cellType == "code" {
+			view.Outputs = cellOutputsText(cell)
+		}
+		result.Cells = append(result.Cells, view)
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// EditNotebookCell はNotebookのセルを1つ置き換え・挿入・削除する（ユーザー許可が必要）
+func EditNotebookCell(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてEditNotebookCellArgsに変換
+	var editArgs EditNotebookCellArgs
+	if err := json.Unmarshal([]byte(args), &editArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := EditNotebookCellResult{
+			Success: false,
+			Error:   errorMessage,
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	// プロジェクトの外のNotebookは編集しない
+	if err := checkWritablePath(editArgs.Path); err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+
+	info, err := os.Stat(editArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err)), nil
+	}
+	oldContentBytes, err := os.ReadFile(editArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err)), nil
+	}
+	oldContent := string(oldContentBytes)
+	nb, err := parseNotebook(oldContentBytes)
+	if err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+
+	action := editArgs.Action
+	if action == "" {
+		action = "replace"
+	}
+	maxIndex := len(nb.cells) - 1
+	if action == "insert" {
+		maxIndex = len(nb.cells)
+	}
+	if editArgs.CellIndex < 0 || editArgs.CellIndex > maxIndex {
+		return genErrorResult(fmt.Sprintf("cellIndexが範囲外です（0〜%d）", maxIndex)), nil
+	}
+
+	// セル単位の差分を作りながら変更を適用する
+	label := fmt.Sprintf("%s [cell %d]", editArgs.Path, editArgs.CellIndex)
+	var diffText string
+	switch action {
+	case "replace":
+		cell, ok := nb.cells[editArgs.CellIndex].(map[string]any)
+		if !ok {
+			return genErrorResult("セルの形式が不正です"), nil
+		}
+		oldSource := multilineText(cell["source"])
+		diffText = formatUnifiedDiff(oldSource, editArgs.Source, label, label)
+		if diffText == "" {
+			return genErrorResult("セルに変更がありません"), nil
+		}
+		cell["source"] = toMultilineText(editArgs.Source)
+		// 内容が変わったコードセルの出力は古くなるので消す
+		if cell["cell_type"] == "code" {
+			cell["outputs"] = []any{}
+			cell["execution_count"] = nil
+		}
+	case "insert":
+		cellType := editArgs.CellType
+		if cellType == "" {
+			cellType = "code"
+		}
+		cell := map[string]any{
+			"cell_type": cellType,
+			"metadata":  map[string]any{},
+			"source":    toMultilineText(editArgs.Source),
+		}
+		if cellType == "code" {
+			cell["outputs"] = []any{}
+			cell["execution_count"] = nil
+		}
+		diffText = fmt.Sprintf("新しい%sセルを挿入します\n%s", cellType, formatUnifiedDiff("", editArgs.Source, label, label))
+		nb.cells = append(nb.cells[:editArgs.CellIndex], append([]any{cell}, nb.cells[editArgs.CellIndex:]...)...)
+	case "delete":
+		oldSource := multilineText(cellField(nb.cells[editArgs.CellIndex], "source"))
+		diffText = fmt.Sprintf("セルを削除します\n%s", formatUnifiedDiff(oldSource, "", label, label))
+		nb.cells = append(nb.cells[:editArgs.CellIndex], nb.cells[editArgs.CellIndex+1:]...)
+	default:
+		return genErrorResult(fmt.Sprintf("actionが不正です: %s", action)), nil
+	}
+
+	newContent, err := nb.marshal()
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("Notebookの書き出しに失敗しました: %v", err)), nil
+	}
+
+	request := ApprovalRequest{
+		Tool:     "editNotebookCell",
+		Kind:     ApprovalEdit,
+		Title:    fmt.Sprintf("Notebookを編集します: %s", editArgs.Path),
+		Detail:   diffText,
+		Paths:    []string{editArgs.Path},
+		Warnings: writePathWarnings(editArgs.Path),
+	}
+
+	// ドライランではセルの差分を表示するだけにする
+	if dryRun {
+		showDryRun(request)
+		resultJSON, _ := json.Marshal(EditNotebookCellResult{Success: true, Note: dryRunNote})
+		return string(resultJSON), nil
+	}
+
+	// ユーザー許可の取得
+	approved, err := requestApproval(request)
+	if err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+	if !approved {
+		return genErrorResult("ユーザーによってキャンセルされました"), nil
+	}
+
+	if err := os.WriteFile(editArgs.Path, []byte(newContent), 0644); err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルへの書き込みに失敗しました: %v", err)), nil
+	}
+
+	notifyFileChange(FileChange{
+		Path:       editArgs.Path,
+		OldContent: &oldContent,
+		NewContent: &newContent,
+		OldMode:    info.Mode().Perm(),
+	})
+	rememberContent(editArgs.Path, newContent)
+
+	result := EditNotebookCellResult{
+		Success: true,
+		Error:   "",
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// GetReadNotebookTool はreadNotebookツールの定義を返す
+func GetReadNotebookTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("readNotebook", "Jupyter Notebook（.ipynb）をセル単位で読み込みます。.ipynbにはreadFileではなくこのツールを使ってください", ReadNotebookArgs{}),
+		Function: ReadNotebook,
+		ReadOnly: true,
+	}
+}
+
+// GetEditNotebookCellTool はeditNotebookCellツールの定義を返す
+func GetEditNotebookCellTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("editNotebookCell", "Jupyter Notebook（.ipynb）のセルを1つ置き換え・挿入・削除します。.ipynbの編集にはeditFileではなくこのツールを使ってください", EditNotebookCellArgs{}),
+		Function: EditNotebookCell,
+	}
+}
@@ -0,0 +1,350 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxOutlineSymbols は1ファイルから返すシンボルの最大数
+const maxOutlineSymbols = 500
+
+// outlineSymbol はファイル内のシンボル（関数、型、メソッド、クラスなど）
+type outlineSymbol struct {
+	Kind      string   `json:"kind"` // function, method, type, struct, interface, class, const, varなど
+	Name      string   `json:"name"`
+	Parent    string   `json:"parent,omitempty"`    // メソッドのレシーバーや所属するクラス
+	Signature string   `json:"signature,omitempty"` // 本体を除いた宣言
+	Doc       string   `json:"doc,omitempty"`       // ドキュメントコメントの1行目
+	Members   []string `json:"members,omitempty"`   // 構造体のフィールドやインターフェースのメソッド
+	Line      int      `json:"line"`
+	EndLine   int      `json:"endLine,omitempty"`
+}
+
+// CodeOutlineArgs はcodeOutlineツールの引数を表す構造体
+type CodeOutlineArgs struct {
+	Path string `json:"path" description:"シンボルを一覧するソースファイルのパス"`
+}
+
+// CodeOutlineResult はcodeOutlineツールの結果を表す構造体
+type CodeOutlineResult struct {
+	Language  string          `json:"language,omitempty"`
+	Symbols   []outlineSymbol `json:"symbols"`
+	Truncated bool            `json:"truncated,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// CodeOutline はソースファイルの関数や型などのシンボルを、本体を含めずに行番号つきで返す
+func CodeOutline(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてCodeOutlineArgsに変換
+	var outlineArgs CodeOutlineArgs
+	if err := json.Unmarshal([]byte(args), &outlineArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := CodeOutlineResult{Symbols: []outlineSymbol{}, Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	content, err := os.ReadFile(outlineArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err)), nil
+	}
+	if isBinary(content) {
+		return genErrorResult("バイナリファイルのシンボルは一覧できません"), nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(outlineArgs.Path))
+	var result CodeOutlineResult
+	if ext == ".go" {
+		result.Language = "go"
+		result.Symbols, err = goOutline(outlineArgs.Path, content)
+		if err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+	} else {
+		language, ok := outlineLanguages[ext]
+		if !ok {
+			return genErrorResult(fmt.Sprintf("未対応の言語です: %s。readFileやsearchInDirectoryを使ってください", ext)), nil
+		}
+		result.Language = language.Name
+		result.Symbols = patternOutline(language, string(content))
+	}
+
+	if len(result.Symbols) > maxOutlineSymbols {
+		result.Symbols = result.Symbols[:maxOutlineSymbols]
+		result.Truncated = true
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// goOutline はGoのソースをgo/parserで解析してシンボルを返す
+// 構文エラーがあっても解析できた部分のシンボルは返す
+func goOutline(path string, content []byte) ([]outlineSymbol, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.ParseComments|parser.SkipObjectResolution)
+	if file == nil {
+		return nil, fmt.Errorf("Goのソースの解析に失敗しました: %v", err)
+	}
+
+	format := func(node any) string {
+		var buf bytes.Buffer
+		printer.Fprint(&buf, fset, node)
+		return buf.String()
+	}
+	docLine := func(doc *ast.CommentGroup) string {
+		if doc == nil {
+			return ""
+		}
+		text, _, _ := strings.Cut(strings.TrimSpace(doc.Text()), "\n")
+		return text
+	}
+
+	symbols := []outlineSymbol{}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			symbol := outlineSymbol{
+				Kind:    "function",
+				Name:    decl.Name.Name,
+				Doc:     docLine(decl.Doc),
+				Line:    fset.Position(decl.Pos()).Line,
+				EndLine: fset.Position(decl.End()).Line,
+			}
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				symbol.Kind = "method"
+				symbol.Parent = format(decl.Recv.List[0].Type)
+			}
+			// 本体を除いた宣言だけを表示する
+			signature := *decl
+			signature.Doc = nil
+			signature.Body = nil
+			symbol.Signature = format(&signature)
+			symbols = append(symbols, symbol)
+
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					doc := spec.Doc
+					if doc == nil && len(decl.Specs) == 1 {
+						doc = decl.Doc
+					}
+					symbol := outlineSymbol{
+						Kind:    "type",
+						Name:    spec.Name.Name,
+						Doc:     docLine(doc),
+						Line:    fset.Position(spec.Pos()).Line,
+						EndLine: fset.Position(spec.End()).Line,
+					}
+					switch typ := spec.Type.(type) {
+					case *ast.StructType:
+						symbol.Kind = "struct"
+						symbol.Signature = fmt.Sprintf("type %s struct", spec.Name.Name)
+						for _, field := range typ.Fields.List {
+							symbol.Members = append(symbol.Members, goFieldString(field, format))
+						}
+					case *ast.InterfaceType:
+						symbol.Kind = "interface"
+						symbol.Signature = fmt.Sprintf("type %s interface", spec.Name.Name)
+						for _, method := range typ.Methods.List {
+							symbol.Members = append(symbol.Members, goFieldString(method, format))
+						}
+					default:
+						symbol.Signature = "type " + format(spec)
+					}
+					symbols = append(symbols, symbol)
+
+				case *ast.ValueSpec:
+					kind := "var"
+					if decl.Tok == token.CONST {
+						kind = "const"
+					}
+					doc := spec.Doc
+					if doc == nil && len(decl.Specs) == 1 {
+						doc = decl.Doc
+					}
+					for _, name := range spec.Names {
+						if name.Name == "_" {
+							continue
+						}
+						symbol := outlineSymbol{
+							Kind: kind,
+							Name: name.Name,
+							Doc:  docLine(doc),
+							Line: fset.Position(name.Pos()).Line,
+						}
+						if spec.Type != nil {
+							symbol.Signature = fmt.Sprintf("%s %s %s", kind, name.Name, format(spec.Type))
+						}
+						symbols = append(symbols, symbol)
+					}
+				}
+			}
+		}
+	}
+	return symbols, nil
+}
+
+// goFieldString は構造体のフィールドやインターフェースのメソッドをタグやコメントを除いて1行で表す
+func goFieldString(field *ast.Field, format func(any) string) string {
+	var names []string
+	for _, name := range field.Names {
+		names = append(names, name.Name)
+	}
+	typ := format(field.Type)
+	if len(names) == 0 {
+		return typ
+	}
+	// インターフェースのメソッドは型がfunc(...)になるので、名前の後ろにつなげる
+	if _, ok := field.Type.(*ast.FuncType); ok {
+		return names[0] + strings.TrimPrefix(typ, "func")
+	}
+	return strings.Join(names, ", ") + " " + typ
+}
+
+// outlinePattern は行ごとにシンボルを見つける正規表現
+// 正規表現はindentとnameの名前付きグループを持つ
+type outlinePattern struct {
+	Kind      string
+	Container bool // クラスのように、より深いインデントのシンボルの親になるか
+	Pattern   *regexp.Regexp
+}
+
+// outlineLanguage はGo以外の言語で使う、行単位の正規表現によるシンボルの抽出方法
+type outlineLanguage struct {
+	Name     string
+	Patterns []outlinePattern
+}
+
+var (
+	pythonOutline = outlineLanguage{Name: "python", Patterns: []outlinePattern{
+		{Kind: "class", Container: true, Pattern: regexp.MustCompile(`^(?P<indent>\s*)class\s+(?P<name>\w+)`)},
+		{Kind: "function", Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:async\s+)?def\s+(?P<name>\w+)\s*\(`)},
+	}}
+	javascriptOutline = outlineLanguage{Name: "javascript", Patterns: []outlinePattern{
+		{Kind: "class", Container: true, Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class\s+(?P<name>\w+)`)},
+		{Kind: "interface", Container: true, Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:export\s+)?(?:declare\s+)?interface\s+(?P<name>\w+)`)},
+		{Kind: "type", Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:export\s+)?(?:declare\s+)?(?:type|enum)\s+(?P<name>\w+)`)},
+		{Kind: "function", Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:export\s+)?(?:default\s+)?(?:async\s+)?function\s*\*?\s*(?P<name>\w+)`)},
+		{Kind: "function", Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:export\s+)?(?:const|let|var)\s+(?P<name>\w+)\s*(?::[^=]+)?=\s*(?:async\s+)?(?:\([^)]*\)|\w+)\s*(?::[^=]+)?=>`)},
+		{Kind: "method", Pattern: regexp.MustCompile(`^(?P<indent>\s+)(?:(?:public|private|protected|static|async|readonly|override|get|set)\s+)*(?P<name>[A-Za-z_$][\w$]*)\s*(?:<[^>]*>)?\([^)]*\)\s*(?::\s*[^{;]+)?\{\s*$`)},
+	}}
+	javaOutline = outlineLanguage{Name: "java", Patterns: []outlinePattern{
+		{Kind: "class", Container: true, Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:(?:public|private|protected|static|final|abstract|sealed|internal|open|data)\s+)*(?:class|interface|enum|record|object)\s+(?P<name>\w+)`)},
+		{Kind: "method", Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:(?:public|private|protected|static|final|abstract|synchronized|native|default|override|suspend|internal|open)\s+)+(?:<[^>]*>\s+)?(?:fun\s+)?[\w<>\[\],.?\s]*?\b(?P<name>\w+)\s*\(`)},
+	}}
+	rubyOutline = outlineLanguage{Name: "ruby", Patterns: []outlinePattern{
+		{Kind: "class", Container: true, Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:class|module)\s+(?P<name>[\w:]+)`)},
+		{Kind: "method", Pattern: regexp.MustCompile(`^(?P<indent>\s*)def\s+(?:self\.)?(?P<name>\w+[?!=]?)`)},
+	}}
+	rustOutline = outlineLanguage{Name: "rust", Patterns: []outlinePattern{
+		{Kind: "impl", Container: true, Pattern: regexp.MustCompile(`^(?P<indent>\s*)impl(?:<[^>]*>)?\s+(?:[\w:<>, ]+\s+for\s+)?(?P<name>\w+)`)},
+		{Kind: "trait", Container: true, Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:pub(?:\([^)]*\))?\s+)?(?:unsafe\s+)?trait\s+(?P<name>\w+)`)},
+		{Kind: "type", Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:pub(?:\([^)]*\))?\s+)?(?:struct|enum|union|type)\s+(?P<name>\w+)`)},
+		{Kind: "module", Container: true, Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:pub(?:\([^)]*\))?\s+)?mod\s+(?P<name>\w+)\s*\{`)},
+		{Kind: "function", Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:pub(?:\([^)]*\))?\s+)?(?:const\s+)?(?:async\s+)?(?:unsafe\s+)?(?:extern\s+"[^"]*"\s+)?fn\s+(?P<name>\w+)`)},
+	}}
+	phpOutline = outlineLanguage{Name: "php", Patterns: []outlinePattern{
+		{Kind: "class", Container: true, Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:(?:abstract|final|readonly)\s+)*(?:class|interface|trait|enum)\s+(?P<name>\w+)`)},
+		{Kind: "function", Pattern: regexp.MustCompile(`^(?P<indent>\s*)(?:(?:public|private|protected|static|abstract|final)\s+)*function\s+&?(?P<name>\w+)`)},
+	}}
+)
+
+// outlineLanguages は拡張子ごとのシンボルの抽出方法
+var outlineLanguages = map[string]outlineLanguage{
+	".py":   pythonOutline,
+	".js":   javascriptOutline,
+	".jsx":  javascriptOutline,
+	".mjs":  javascriptOutline,
+	".cjs":  javascriptOutline,
+	".ts":   javascriptOutline,
+	".tsx":  javascriptOutline,
+	".java": javaOutline,
+	".kt":   javaOutline,
+	".cs":   javaOutline,
+	".rb":   rubyOutline,
+	".rs":   rustOutline,
+	".php":  phpOutline,
+}
+
+// outlineKeywords はメソッドの正規表現にマッチしても制御構文なのでシンボルとして扱わない名前
+var outlineKeywords = map[string]bool{
+	"if": true, "for": true, "while": true, "switch": true, "catch": true, "return": true,
+	"function": true, "else": true, "do": true, "try": true, "new": true, "synchronized": true,
+}
+
+// patternOutline は行ごとに正規表現でシンボルを探し、インデントから所属するクラスなどを決める
+func patternOutline(language outlineLanguage, content string) []outlineSymbol {
+	type container struct {
+		indent int
+		name   string
+	}
+	var stack []container
+	symbols := []outlineSymbol{}
+
+	for i, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "*") {
+			continue
+		}
+		for _, pattern := range language.Patterns {
+			match := pattern.Pattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			name := match[pattern.Pattern.SubexpIndex("name")]
+			if outlineKeywords[name] {
+				break
+			}
+			indent := len(strings.ReplaceAll(match[pattern.Pattern.SubexpIndex("indent")], "\t", "    "))
+
+			// 同じかより浅いインデントのコンテナはここで終わっている
+			for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+			symbol := outlineSymbol{
+				Kind:      pattern.Kind,
+				Name:      name,
+				Signature: strings.TrimSuffix(strings.TrimSpace(strings.TrimSuffix(trimmed, "{")), ":"),
+				Line:      i + 1,
+			}
+			if len(stack) > 0 {
+				symbol.Parent = stack[len(stack)-1].name
+				if symbol.Kind == "function" {
+					symbol.Kind = "method"
+				}
+			} else if symbol.Kind == "method" && indent > 0 {
+				// クラスの外でインデントされたメソッドらしい行は、ブロック内の関数呼び出しであることが多い
+				break
+			}
+			if pattern.Container {
+				stack = append(stack, container{indent: indent, name: name})
+			}
+			symbols = append(symbols, symbol)
+			break
+		}
+	}
+	return symbols
+}
+
+// GetCodeOutlineTool はcodeOutlineツールの定義を返す
+func GetCodeOutlineTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("codeOutline", "ソースファイルの関数・型・メソッド・クラスなどのシンボルを、本体を含めずにシグネチャと行番号つきで返します。大きなファイルの構造を把握してから、readFileのstartLine/endLineで必要な部分だけを読むために使います。Goはgo/parserで解析し、Python・JavaScript/TypeScript・Java/Kotlin/C#・Ruby・Rust・PHPは行単位のパターンで抽出します", CodeOutlineArgs{}),
+		Function: CodeOutline,
+		ReadOnly: true,
+	}
+}
@@ -0,0 +1,59 @@
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// writePathWarnings は書き込み先のパスがプロジェクト構成の誤解を示唆する場所でないかを確認し、警告メッセージを返す
+func writePathWarnings(path string) []string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+
+	// .git配下はgitの管理領域なので、通常エージェントが書き込む場所ではない
+	if isInsideGitDir(absPath) {
+		return []string{".gitディレクトリ内への書き込みです。gitの内部データを破壊する可能性があります。"}
+	}
+
+	if isGitIgnored(absPath) {
+		return []string{".gitignoreで無視されているパスへの書き込みです。ビルド成果物やvendorなど、手で編集すべきでないファイルの可能性があります。"}
+	}
+
+	return nil
+}
+
+// isInsideGitDir はパスが.gitディレクトリ配下かどうかを返す
+func isInsideGitDir(absPath string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(foldPathCase(absPath)), "/") {
+		if part == ".git" {
+			return true
+		}
+	}
+	return false
+}
+
+// isGitIgnored はパスがgitignoreのルールで無視されるかどうかを返す
+// gitリポジトリ外やgitコマンドが使えない場合はfalseを返す
+func isGitIgnored(absPath string) bool {
+	// まだ存在しないファイルの場合もあるので、存在する最も近い親ディレクトリでgitを実行する
+	dir := filepath.Dir(absPath)
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+
+	// 終了コード0は無視対象、1は無視対象外、それ以外はgitリポジトリ外などのエラー
+	cmd := exec.Command("git", "check-ignore", "-q", "--", absPath)
+	cmd.Dir = dir
+	return cmd.Run() == nil
+}
@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PermissionAction は承認が必要な操作に対するルールの判断
+type PermissionAction string
+
+const (
+	PermissionAllow PermissionAction = "allow" // 確認せずに許可する
+	PermissionDeny  PermissionAction = "deny"  // 確認せずに拒否する
+	PermissionAsk   PermissionAction = "ask"   // 承認方法で確認する
+)
+
+// PermissionRule は承認が必要な操作を、ツール名・操作の種類・パスで判断するルール
+type PermissionRule struct {
+	Tool   string       // 空または"*"の場合はすべてのツール
+	Kind   ApprovalKind // 空の場合はすべての種類
+	Paths  []string     // パスのパターン。空の場合はすべての操作
+	Action PermissionAction
+}
+
+// permissionRules は設定されたルール。上から順に評価し、最初にマッチしたルールを使う
+var permissionRules []PermissionRule
+
+// SetPermissionRules は承認が必要な操作に適用するルールを設定する
+func SetPermissionRules(rules []PermissionRule) {
+	permissionRules = rules
+}
+
+// decidePermission は操作に最初にマッチしたルールの判断を返す。マッチしなければPermissionAskを返す
+func decidePermission(request ApprovalRequest) (PermissionAction, *PermissionRule) {
+	for i := range permissionRules {
+		rule := &permissionRules[i]
+		if rule.matches(request) {
+			return rule.Action, rule
+		}
+	}
+	return PermissionAsk, nil
+}
+
+// matches はルールが操作にマッチするかを返す
+func (r *PermissionRule) matches(request ApprovalRequest) bool {
+	if r.Tool != "" && r.Tool != "*" && r.Tool != request.Tool {
+		return false
+	}
+	if r.Kind != "" && r.Kind != request.Kind {
+		return false
+	}
+	if len(r.Paths) == 0 {
+		return true
+	}
+	// パスのないコマンドの実行などには、パスを指定したルールは使わない
+	if len(request.Paths) == 0 {
+		return false
+	}
+	for _, path := range request.Paths {
+		if !r.matchesPath(path) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesPath はパスがルールのパターンのいずれかにマッチするかを返す
+// パターンがディレクトリにマッチする場合は、その下のパスもマッチしたものとする
+func (r *PermissionRule) matchesPath(path string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(foldPathCase(absPath)), "/")
+	for _, pattern := range r.Paths {
+		absPattern, err := filepath.Abs(normalizePath(pattern, false))
+		if err != nil {
+			continue
+		}
+		segments := strings.Split(filepath.ToSlash(foldPathCase(absPattern)), "/")
+		for i := len(parts); i > 0; i-- {
+			if matchGlobSegments(segments, parts[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describe はエラーメッセージに使うルールの説明を返す
+func (r *PermissionRule) describe() string {
+	var conditions []string
+	if r.Tool != "" && r.Tool != "*" {
+		conditions = append(conditions, "tool: "+r.Tool)
+	}
+	if r.Kind != "" {
+		conditions = append(conditions, "kind: "+string(r.Kind))
+	}
+	if len(r.Paths) > 0 {
+		conditions = append(conditions, "paths: "+strings.Join(r.Paths, ", "))
+	}
+	if len(conditions) == 0 {
+		return fmt.Sprintf("%sのルール", r.Action)
+	}
+	return fmt.Sprintf("%sのルール（%s）", r.Action, strings.Join(conditions, "、"))
+}
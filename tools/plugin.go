@@ -0,0 +1,249 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// defaultPluginTimeout はプラグインの実行時間の上限のデフォルト
+const defaultPluginTimeout = 60 * time.Second
+
+// pluginNamePattern はツール名として使える名前（OpenAIのfunction名の制約）
+var pluginNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// PluginSpec は外部コマンドをツールとして公開するための定義
+type PluginSpec struct {
+	Name        string
+	Command     []string
+	Description string
+	Parameters  json.RawMessage // 引数のJSON Schema。空の場合は任意のオブジェクト
+	ReadOnly    bool
+	Timeout     time.Duration // 0の場合はdefaultPluginTimeout
+}
+
+// pluginManifest はプラグインディレクトリの実行ファイルの隣に置く<名前>.jsonの内容
+type pluginManifest struct {
+	Description    string          `json:"description"`
+	Parameters     json.RawMessage `json:"parameters,omitempty"`
+	ReadOnly       bool            `json:"read_only,omitempty"`
+	TimeoutSeconds int             `json:"timeout_seconds,omitempty"`
+}
+
+// PluginResult はJSON以外を出力したプラグインの結果を表す構造体
+type PluginResult struct {
+	Success  bool   `json:"success"`
+	Output   string `json:"output,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	ExitCode int    `json:"exitCode,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// pluginTools は読み込んだプラグインのツール
+var pluginTools = map[string]ToolDefinition{}
+
+// LoadPlugins は設定で宣言されたプラグインとdir内の実行ファイルをツールとして登録する
+// 同じ名前がある場合は設定の宣言を優先する。登録できなかったプラグインはエラーとして返す
+func LoadPlugins(dir string, specs []PluginSpec) []error {
+	var errs []error
+	register := func(spec PluginSpec) {
+		tool, err := newPluginTool(spec)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		pluginTools[spec.Name] = tool
+	}
+
+	for _, spec := range specs {
+		if _, exists := pluginTools[spec.Name]; exists {
+			errs = append(errs, fmt.Errorf("plugin %s is declared more than once", spec.Name))
+			continue
+		}
+		register(spec)
+	}
+
+	dirSpecs, err := discoverPlugins(dir)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, spec := range dirSpecs {
+		if _, exists := pluginTools[spec.Name]; exists {
+			continue
+		}
+		register(spec)
+	}
+	return errs
+}
+
+// discoverPlugins はディレクトリ内の実行ファイルをプラグインとして読み込む
+// <名前>.jsonがあれば説明・引数のスキーマ・read_onlyを読み込む
+func discoverPlugins(dir string) ([]PluginSpec, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+
+	var specs []PluginSpec
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !isExecutable(entry.Name(), info.Mode()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		spec := PluginSpec{
+			Name:        name,
+			Command:     []string{path},
+			Description: fmt.Sprintf("外部ツール%s", name),
+		}
+		if data, err := os.ReadFile(filepath.Join(dir, name+".json")); err == nil {
+			var manifest pluginManifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return specs, fmt.Errorf("failed to parse %s.json: %w", name, err)
+			}
+			if manifest.Description != "" {
+				spec.Description = manifest.Description
+			}
+			spec.Parameters = manifest.Parameters
+			spec.ReadOnly = manifest.ReadOnly
+			spec.Timeout = time.Duration(manifest.TimeoutSeconds) * time.Second
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// isExecutable はファイルが実行できるかを返す。Windowsでは拡張子で判断する
+func isExecutable(name string, mode os.FileMode) bool {
+	if runtime.GOOS == "windows" {
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".exe", ".bat", ".cmd", ".com":
+			return true
+		}
+		return false
+	}
+	return mode.IsRegular() && mode&0o111 != 0
+}
+
+// newPluginTool はプラグインの定義からツールを作る
+func newPluginTool(spec PluginSpec) (ToolDefinition, error) {
+	if !pluginNamePattern.MatchString(spec.Name) {
+		return ToolDefinition{}, fmt.Errorf("invalid plugin name %q: use only letters, digits, _ and -", spec.Name)
+	}
+	if _, exists := GetBuiltinTools()[spec.Name]; exists {
+		return ToolDefinition{}, fmt.Errorf("plugin %s conflicts with a built-in tool", spec.Name)
+	}
+	if len(spec.Command) == 0 {
+		return ToolDefinition{}, fmt.Errorf("plugin %s has no command", spec.Name)
+	}
+
+	params := jsonschema.Definition{Type: jsonschema.Object, Properties: map[string]jsonschema.Definition{}}
+	if len(spec.Parameters) > 0 {
+		if err := json.Unmarshal(spec.Parameters, &params); err != nil {
+			return ToolDefinition{}, fmt.Errorf("invalid parameters schema for plugin %s: %w", spec.Name, err)
+		}
+	}
+	if spec.Timeout <= 0 {
+		spec.Timeout = defaultPluginTimeout
+	}
+
+	return ToolDefinition{
+		Schema: openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        spec.Name,
+				Description: spec.Description,
+				Parameters:  params,
+			},
+		},
+		Function: func(ctx context.Context, args string) (string, error) {
+			return runPlugin(ctx, spec, args)
+		},
+		ReadOnly: spec.ReadOnly,
+	}, nil
+}
+
+// runPlugin はプラグインのコマンドに引数のJSONを標準入力で渡して実行し、標準出力を結果として返す
+// 標準出力がJSONであればそのまま返し、それ以外はPluginResultに包む
+func runPlugin(ctx context.Context, spec PluginSpec, args string) (string, error) {
+	genErrorResult := func(errorMessage string) string {
+		result := PluginResult{Success: false, Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	// 外部のコマンドが何をするかは分からないので、read_onlyでなければ実行前に許可を得る
+	if !spec.ReadOnly {
+		approved, err := requestApproval(ApprovalRequest{
+			Tool:   spec.Name,
+			Kind:   ApprovalExecute,
+			Title:  fmt.Sprintf("外部ツール%sを実行します", spec.Name),
+			Detail: fmt.Sprintf("コマンド: %s\n引数: %s", strings.Join(spec.Command, " "), args),
+		})
+		if err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+		if !approved {
+			return genErrorResult("ユーザーによってキャンセルされました"), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, spec.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, spec.Command[0], spec.Command[1:]...)
+	cmd.Stdin = strings.NewReader(args)
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	output := strings.TrimSpace(stdout.String())
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return genErrorResult(fmt.Sprintf("外部ツール%sが%sでタイムアウトしました", spec.Name, spec.Timeout)), nil
+	case errors.Is(ctx.Err(), context.Canceled):
+		return genErrorResult(fmt.Sprintf("外部ツール%sの実行が中断されました", spec.Name)), nil
+	case errors.As(err, &exitErr):
+		result := PluginResult{
+			Success:  false,
+			Output:   truncateCommandOutput(output),
+			Stderr:   truncateCommandOutput(strings.TrimSpace(stderr.String())),
+			ExitCode: exitErr.ExitCode(),
+			Error:    fmt.Sprintf("外部ツール%sが終了コード%dで失敗しました", spec.Name, exitErr.ExitCode()),
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON), nil
+	case err != nil:
+		return genErrorResult(fmt.Sprintf("外部ツール%sの実行に失敗しました: %v", spec.Name, err)), nil
+	}
+
+	if len(output) <= maxCommandOutputBytes && json.Valid([]byte(output)) {
+		return output, nil
+	}
+	result := PluginResult{Success: true, Output: truncateCommandOutput(output)}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
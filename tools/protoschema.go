@@ -0,0 +1,416 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// protoSummary は.protoファイルの構造の要約
+type protoSummary struct {
+	Syntax   string         `json:"syntax,omitempty"`
+	Package  string         `json:"package,omitempty"`
+	Imports  []string       `json:"imports,omitempty"`
+	Services []protoService `json:"services"`
+	Messages []protoMessage `json:"messages"`
+	Enums    []protoEnum    `json:"enums"`
+}
+
+type protoService struct {
+	Name    string        `json:"name"`
+	Methods []protoMethod `json:"methods"`
+}
+
+type protoMethod struct {
+	Name            string `json:"name"`
+	Request         string `json:"request"`
+	Response        string `json:"response"`
+	ClientStreaming bool   `json:"clientStreaming,omitempty"`
+	ServerStreaming bool   `json:"serverStreaming,omitempty"`
+}
+
+type protoMessage struct {
+	Name   string       `json:"name"` // 入れ子のメッセージは Outer.Inner の形式
+	Fields []protoField `json:"fields"`
+}
+
+type protoField struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Number string `json:"number"`
+	Label  string `json:"label,omitempty"` // repeated, optional, required
+	Oneof  string `json:"oneof,omitempty"`
+}
+
+type protoEnum struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// protoParser は.protoファイルの宣言を読み取る簡易パーサー
+// オプションの値や拡張などの詳細は読み飛ばし、API変更に必要な構造だけを抽出する
+type protoParser struct {
+	tokens  []string
+	pos     int
+	summary *protoSummary
+}
+
+// parseProto は.protoファイルの内容を解析して要約を返す
+func parseProto(content string) (*protoSummary, error) {
+	p := &protoParser{
+		tokens: tokenizeProto(content),
+		summary: &protoSummary{
+			Services: []protoService{},
+			Messages: []protoMessage{},
+			Enums:    []protoEnum{},
+		},
+	}
+	for !p.done() {
+		if err := p.parseTopLevel(); err != nil {
+			return nil, err
+		}
+	}
+	return p.summary, nil
+}
+
+// tokenizeProto はコメントを除いて識別子・文字列・記号に分割する
+func tokenizeProto(content string) []string {
+	var tokens []string
+	runes := []rune(content)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			tokens = append(tokens, string(runes[start:min(i, len(runes))]))
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-' || r == '+':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || strings.ContainsRune("_.-+", runes[i])) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens
+}
+
+func (p *protoParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *protoParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *protoParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *protoParser) expect(token string) error {
+	if got := p.next(); got != token {
+		return fmt.Errorf("'%s'が必要な位置に'%s'があります", token, got)
+	}
+	return nil
+}
+
+// skipStatement は ; までを読み飛ばす。途中の [...] や {...} も含める
+func (p *protoParser) skipStatement() {
+	depth := 0
+	for !p.done() {
+		switch p.next() {
+		case "{", "[", "(":
+			depth++
+		case "}", "]", ")":
+			depth--
+		case ";":
+			if depth <= 0 {
+				return
+			}
+		}
+	}
+}
+
+// skipBlock は { から対応する } までを読み飛ばす
+func (p *protoParser) skipBlock() {
+	for !p.done() && p.peek() != "{" {
+		p.next()
+	}
+	depth := 0
+	for !p.done() {
+		switch p.next() {
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+func (p *protoParser) parseTopLevel() error {
+	switch p.peek() {
+	case "syntax", "edition":
+		p.next()
+		p.expect("=")
+		p.summary.Syntax = strings.Trim(p.next(), `"'`)
+		p.skipStatement()
+	case "package":
+		p.next()
+		p.summary.Package = p.next()
+		p.skipStatement()
+	case "import":
+		p.next()
+		if token := p.peek(); token == "public" || token == "weak" {
+			p.next()
+		}
+		p.summary.Imports = append(p.summary.Imports, strings.Trim(p.next(), `"'`))
+		p.skipStatement()
+	case "message":
+		p.next()
+		return p.parseMessage("")
+	case "enum":
+		p.next()
+		return p.parseEnum("")
+	case "service":
+		p.next()
+		return p.parseService()
+	case "extend":
+		p.skipBlock()
+	case ";":
+		p.next()
+	default:
+		p.skipStatement()
+	}
+	return nil
+}
+
+func (p *protoParser) parseMessage(parent string) error {
+	name := p.next()
+	if parent != "" {
+		name = parent + "." + name
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	message := protoMessage{Name: name, Fields: []protoField{}}
+	index := len(p.summary.Messages)
+	p.summary.Messages = append(p.summary.Messages, message)
+
+	if err := p.parseMessageBody(name, &message, ""); err != nil {
+		return err
+	}
+	p.summary.Messages[index] = message
+	return nil
+}
+
+// parseMessageBody はメッセージ（またはoneof）の本体を } まで読む
+func (p *protoParser) parseMessageBody(name string, message *protoMessage, oneof string) error {
+	for !p.done() {
+		switch token := p.peek(); token {
+		case "}":
+			p.next()
+			return nil
+		case ";":
+			p.next()
+		case "message":
+			p.next()
+			if err := p.parseMessage(name); err != nil {
+				return err
+			}
+		case "enum":
+			p.next()
+			if err := p.parseEnum(name); err != nil {
+				return err
+			}
+		case "oneof":
+			p.next()
+			oneofName := p.next()
+			if err := p.expect("{"); err != nil {
+				return err
+			}
+			if err := p.parseMessageBody(name, message, oneofName); err != nil {
+				return err
+			}
+		case "option", "reserved", "extensions":
+			p.skipStatement()
+		case "extend":
+			p.skipBlock()
+		default:
+			field, err := p.parseField()
+			if err != nil {
+				return err
+			}
+			field.Oneof = oneof
+			message.Fields = append(message.Fields, field)
+		}
+	}
+	return fmt.Errorf("メッセージ%sの終わりの'}'が見つかりません", name)
+}
+
+// parseField はフィールドの宣言を読む（例: repeated string names = 1 [deprecated = true];）
+func (p *protoParser) parseField() (protoField, error) {
+	var field protoField
+	if token := p.peek(); token == "repeated" || token == "optional" || token == "required" {
+		field.Label = p.next()
+	}
+
+	if p.peek() == "map" {
+		p.next()
+		if err := p.expect("<"); err != nil {
+			return field, err
+		}
+		key := p.next()
+		if err := p.expect(","); err != nil {
+			return field, err
+		}
+		value := p.next()
+		if err := p.expect(">"); err != nil {
+			return field, err
+		}
+		field.Type = fmt.Sprintf("map<%s, %s>", key, value)
+	} else if p.peek() == "group" {
+		// proto2のgroupは入れ子のメッセージとして扱わず読み飛ばす
+		p.skipBlock()
+		return protoField{Name: "(group)", Type: "group"}, nil
+	} else {
+		field.Type = p.next()
+	}
+
+	field.Name = p.next()
+	if err := p.expect("="); err != nil {
+		return field, fmt.Errorf("フィールド%sの解析に失敗しました: %w", field.Name, err)
+	}
+	field.Number = p.next()
+	p.skipStatement()
+	return field, nil
+}
+
+func (p *protoParser) parseEnum(parent string) error {
+	name := p.next()
+	if parent != "" {
+		name = parent + "." + name
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	enum := protoEnum{Name: name, Values: []string{}}
+	for !p.done() {
+		switch token := p.peek(); token {
+		case "}":
+			p.next()
+			p.summary.Enums = append(p.summary.Enums, enum)
+			return nil
+		case ";":
+			p.next()
+		case "option", "reserved":
+			p.skipStatement()
+		default:
+			valueName := p.next()
+			if err := p.expect("="); err != nil {
+				return fmt.Errorf("enum %sの解析に失敗しました: %w", name, err)
+			}
+			enum.Values = append(enum.Values, fmt.Sprintf("%s = %s", valueName, p.next()))
+			p.skipStatement()
+		}
+	}
+	return fmt.Errorf("enum %sの終わりの'}'が見つかりません", name)
+}
+
+func (p *protoParser) parseService() error {
+	service := protoService{Name: p.next(), Methods: []protoMethod{}}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	for !p.done() {
+		switch token := p.peek(); token {
+		case "}":
+			p.next()
+			p.summary.Services = append(p.summary.Services, service)
+			return nil
+		case ";":
+			p.next()
+		case "rpc":
+			p.next()
+			method, err := p.parseRPC()
+			if err != nil {
+				return err
+			}
+			service.Methods = append(service.Methods, method)
+		default:
+			p.skipStatement()
+		}
+	}
+	return fmt.Errorf("service %sの終わりの'}'が見つかりません", service.Name)
+}
+
+// parseRPC はrpcの宣言を読む（例: rpc Get (stream GetRequest) returns (GetResponse) {}）
+func (p *protoParser) parseRPC() (protoMethod, error) {
+	method := protoMethod{Name: p.next()}
+
+	readType := func() (string, bool, error) {
+		if err := p.expect("("); err != nil {
+			return "", false, err
+		}
+		streaming := false
+		if p.peek() == "stream" {
+			p.next()
+			streaming = true
+		}
+		typ := p.next()
+		if err := p.expect(")"); err != nil {
+			return "", false, err
+		}
+		return typ, streaming, nil
+	}
+
+	var err error
+	if method.Request, method.ClientStreaming, err = readType(); err != nil {
+		return method, fmt.Errorf("rpc %sの解析に失敗しました: %w", method.Name, err)
+	}
+	if err := p.expect("returns"); err != nil {
+		return method, fmt.Errorf("rpc %sの解析に失敗しました: %w", method.Name, err)
+	}
+	if method.Response, method.ServerStreaming, err = readType(); err != nil {
+		return method, fmt.Errorf("rpc %sの解析に失敗しました: %w", method.Name, err)
+	}
+
+	if p.peek() == "{" {
+		p.skipBlock()
+	} else {
+		p.skipStatement()
+	}
+	return method, nil
+}
@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// sharedAddressSpace はキャリアグレードNATで使われる100.64.0.0/10。net/netipのIsPrivateには含まれない
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// newPublicHTTPClient はインターネット上の公開アドレスにだけ接続するHTTPクライアントを作る
+// webFetchなどは承認なしで使えるので、ループバックやクラウドのメタデータ（169.254.169.254）、
+// 社内ネットワークなどのアドレスにモデルやサーバーのユーザーがアクセスできないようにする
+// 接続のたびに名前解決後のアドレスを検査するので、リダイレクト先やDNSの応答を変える攻撃も防げる
+// 検査を迂回しないよう、環境変数のプロキシは使わない
+func newPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: publicAddressOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// publicAddressOnly は接続先が公開アドレスでなければエラーを返すnet.DialerのControl
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(ip) {
+		return fmt.Errorf("公開されていないアドレス（%s）には接続できません", ip)
+	}
+	return nil
+}
+
+// isPublicAddr はipがインターネット上の公開アドレスかを返す
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
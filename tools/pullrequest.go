@@ -0,0 +1,159 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CreatePullRequestArgs はcreatePullRequestツールの引数を表す構造体
+type CreatePullRequestArgs struct {
+	Path   string `json:"path,omitempty" description:"リポジトリ内のディレクトリ。省略時はカレントディレクトリ"`
+	Title  string `json:"title" description:"Pull Requestのタイトル"`
+	Body   string `json:"body" description:"Pull Requestの本文（Markdown）。変更の内容、理由、確認方法を書く"`
+	Branch string `json:"branch,omitempty" description:"pushするブランチ名。省略時は現在のブランチ。指定した場合は現在のHEADをこの名前でpushする"`
+	Base   string `json:"base,omitempty" description:"マージ先のブランチ。省略時はリポジトリのデフォルトブランチ"`
+	Remote string `json:"remote,omitempty" description:"pushするリモート。省略時はorigin"`
+	Draft  bool   `json:"draft,omitempty" description:"trueの場合、ドラフトのPull Requestとして作成する（デフォルトはfalse）"`
+}
+
+// CreatePullRequestResult はcreatePullRequestツールの結果を表す構造体
+type CreatePullRequestResult struct {
+	Success bool   `json:"success"`
+	URL     string `json:"url,omitempty"`
+	Branch  string `json:"branch,omitempty"`
+	Base    string `json:"base,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// CreatePullRequest はユーザーの許可を得て、ブランチをpushしてghコマンドでPull Requestを作成する
+func CreatePullRequest(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてCreatePullRequestArgsに変換
+	var prArgs CreatePullRequestArgs
+	if err := json.Unmarshal([]byte(args), &prArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := CreatePullRequestResult{Success: false, Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	if strings.TrimSpace(prArgs.Title) == "" {
+		return genErrorResult("titleを指定してください"), nil
+	}
+	if _, err := exec.LookPath("gh"); err != nil {
+		return genErrorResult("ghコマンドが見つかりません。GitHub CLIをインストールしてgh auth loginで認証してください"), nil
+	}
+	remote := prArgs.Remote
+	if remote == "" {
+		remote = "origin"
+	}
+	for name, value := range map[string]string{"branch": prArgs.Branch, "base": prArgs.Base, "remote": remote} {
+		if err := validateGitRevision(name, value); err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+	}
+	dir := prArgs.Path
+
+	// pushするブランチとマージ先を決める
+	branch := prArgs.Branch
+	if branch == "" {
+		current, err := runGitCommand(dir, "branch", "--show-current")
+		if err != nil {
+			return genErrorResult(fmt.Sprintf("現在のブランチを取得できません: %v", err)), nil
+		}
+		branch = strings.TrimSpace(current)
+		if branch == "" {
+			return genErrorResult("HEADがブランチを指していません。branchでpushするブランチ名を指定してください"), nil
+		}
+	}
+	base := prArgs.Base
+	if base == "" {
+		base = defaultRemoteBranch(dir, remote)
+	}
+	if base != "" && branch == base {
+		return genErrorResult(fmt.Sprintf("マージ先と同じブランチ（%s）からはPull Requestを作成できません。branchで新しいブランチ名を指定してください", base)), nil
+	}
+
+	// Pull Requestに含まれるコミットを確認できるようにする
+	detail := fmt.Sprintf("リモート: %s\nブランチ: %s → %s\n", remote, branch, base)
+	if base != "" {
+		if commits, err := runGitCommand(dir, "log", "--oneline", fmt.Sprintf("%s/%s..HEAD", remote, base)); err == nil {
+			if strings.TrimSpace(commits) == "" {
+				return genErrorResult(fmt.Sprintf("%s/%sに含まれていないコミットがありません。先に変更をコミットしてください", remote, base)), nil
+			}
+			detail += fmt.Sprintf("\n--- 含まれるコミット ---\n%s", commits)
+		}
+	}
+	if prArgs.Draft {
+		detail += "\n（ドラフト）"
+	}
+	detail += fmt.Sprintf("\n--- タイトル ---\n%s\n\n--- 本文 ---\n%s", prArgs.Title, prArgs.Body)
+
+	// ユーザー許可の取得
+	approved, err := requestApproval(ApprovalRequest{
+		Tool:   "createPullRequest",
+		Kind:   ApprovalExecute,
+		Title:  fmt.Sprintf("ブランチ%sをpushしてPull Requestを作成します", branch),
+		Detail: detail,
+	})
+	if err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+	if !approved {
+		return genErrorResult("ユーザーによってキャンセルされました"), nil
+	}
+
+	if _, err := runGitCommand(dir, "push", "--set-upstream", remote, "HEAD:refs/heads/"+branch); err != nil {
+		return genErrorResult(fmt.Sprintf("git pushに失敗しました: %v", err)), nil
+	}
+
+	ghArgs := []string{"pr", "create", "--head", branch, "--title", prArgs.Title, "--body", prArgs.Body}
+	if base != "" {
+		ghArgs = append(ghArgs, "--base", base)
+	}
+	if prArgs.Draft {
+		ghArgs = append(ghArgs, "--draft")
+	}
+	cmd := exec.CommandContext(ctx, "gh", ghArgs...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return genErrorResult(fmt.Sprintf("ブランチはpushしましたが、Pull Requestの作成に失敗しました: %v: %s", err, strings.TrimSpace(stderr.String()))), nil
+	}
+
+	// ghは作成したPull RequestのURLを最後の行に出力する
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	result := CreatePullRequestResult{
+		Success: true,
+		URL:     lines[len(lines)-1],
+		Branch:  branch,
+		Base:    base,
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// defaultRemoteBranch はリモートのデフォルトブランチ名（例: main）を返す。分からない場合は空文字列を返す
+func defaultRemoteBranch(dir, remote string) string {
+	ref, err := runGitCommand(dir, "symbolic-ref", "--short", fmt.Sprintf("refs/remotes/%s/HEAD", remote))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(ref), remote+"/")
+}
+
+// GetCreatePullRequestTool はcreatePullRequestツールの定義を返す
+func GetCreatePullRequestTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("createPullRequest", "コミット済みの変更をブランチとしてリモートにpushし、GitHub CLI（gh）でPull Requestを作成します。実行前に含まれるコミットとタイトル・本文を表示してユーザーの許可を求めます。変更を完了してgitCommitでコミットした後に使います", CreatePullRequestArgs{}),
+		Function: CreatePullRequest,
+	}
+}
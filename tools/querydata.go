@@ -0,0 +1,375 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxQueryResultBytes はqueryDataが返す結果の最大サイズ（JSONでのバイト数）
+const maxQueryResultBytes = 64 * 1024
+
+// QueryDataArgs はqueryDataツールの引数を表す構造体
+type QueryDataArgs struct {
+	Path  string `json:"path" description:"JSONまたはYAMLファイルのパス"`
+	Query string `json:"query,omitempty" description:"jq風のクエリ（例: .dependencies, .items[0].name, .jobs[].steps | length, .spec | keys）。パス（.key, .[\"key\"], [0], [-1], []）と、|でつないだkeys・length・typeに対応。省略時は構文の検証のみを行う"`
+}
+
+// QueryDataResult はqueryDataツールの結果を表す構造体
+type QueryDataResult struct {
+	Valid     bool   `json:"valid"`
+	Format    string `json:"format,omitempty"` // json または yaml
+	Results   []any  `json:"results,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"` // 結果が大きすぎて一部を省略したかどうか
+	Line      int    `json:"line,omitempty"`      // 構文エラーの行（1始まり）
+	Column    int    `json:"column,omitempty"`    // 構文エラーの列（1始まり）
+	Error     string `json:"error,omitempty"`
+}
+
+// QueryData はJSON・YAMLファイルを検証し、クエリで値を取り出す
+func QueryData(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてQueryDataArgsに変換
+	var queryDataArgs QueryDataArgs
+	if err := json.Unmarshal([]byte(args), &queryDataArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	toJSON := func(result QueryDataResult) string {
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	content, err := os.ReadFile(queryDataArgs.Path)
+	if err != nil {
+		return toJSON(QueryDataResult{Error: fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err)}), nil
+	}
+
+	format := "yaml"
+	if strings.EqualFold(filepath.Ext(queryDataArgs.Path), ".json") {
+		format = "json"
+	}
+
+	var document any
+	var line, column int
+	if format == "json" {
+		document, line, column, err = parseJSONDocument(content)
+	} else {
+		document, line, err = parseYAMLDocument(content)
+	}
+	if err != nil {
+		return toJSON(QueryDataResult{
+			Valid:  false,
+			Format: format,
+			Line:   line,
+			Column: column,
+			Error:  fmt.Sprintf("構文エラー: %v", err),
+		}), nil
+	}
+
+	result := QueryDataResult{Valid: true, Format: format}
+	if strings.TrimSpace(queryDataArgs.Query) == "" {
+		return toJSON(result), nil
+	}
+
+	values, err := runDataQuery(queryDataArgs.Query, document)
+	if err != nil {
+		result.Error = fmt.Sprintf("クエリの実行に失敗しました: %v", err)
+		return toJSON(result), nil
+	}
+
+	// 大きな値で会話が埋まらないよう、上限に収まる分だけ返す
+	size := 0
+	for _, value := range values {
+		valueJSON, _ := json.Marshal(value)
+		size += len(valueJSON)
+		if size > maxQueryResultBytes {
+			result.Truncated = true
+			break
+		}
+		result.Results = append(result.Results, value)
+	}
+	if result.Truncated && len(result.Results) == 0 {
+		result.Error = "結果が大きすぎます。クエリで対象を絞り込んでください"
+	}
+	return toJSON(result), nil
+}
+
+// parseJSONDocument はJSONを解析する。構文エラーの場合はその位置の行と列を返す
+func parseJSONDocument(content []byte) (any, int, int, error) {
+	if err := json.Unmarshal(content, new(any)); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, column := offsetToLineColumn(content, syntaxErr.Offset)
+			return nil, line, column, err
+		}
+		return nil, 0, 0, err
+	}
+
+	// 大きな整数の精度を失わないよう、数値はjson.Numberのまま扱う
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, 0, 0, err
+	}
+	return document, 0, 0, nil
+}
+
+// offsetToLineColumn はバイト位置を1始まりの行と列に変換する
+func offsetToLineColumn(content []byte, offset int64) (int, int) {
+	offset = min(offset, int64(len(content)))
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - (bytes.LastIndexByte(before, '\n') + 1)
+	return line, column
+}
+
+// yamlErrorLinePattern はyaml.v3のエラーメッセージに含まれる行番号
+var yamlErrorLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// parseYAMLDocument はYAMLの最初のドキュメントを解析する。構文エラーの場合はその行を返す
+func parseYAMLDocument(content []byte) (any, int, error) {
+	var document any
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		line := 0
+		if m := yamlErrorLinePattern.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		return nil, line, err
+	}
+	return document, 0, nil
+}
+
+// runDataQuery は|で区切られた各段を順に適用する。各段は値の列を受け取り値の列を返す
+func runDataQuery(query string, document any) ([]any, error) {
+	values := []any{document}
+	for _, stage := range splitQueryStages(query) {
+		var next []any
+		for _, value := range values {
+			results, err := applyQueryStage(strings.TrimSpace(stage), value)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, results...)
+		}
+		values = next
+	}
+	return values, nil
+}
+
+// splitQueryStages はクエリを括弧や引用符の外にある|で区切る
+func splitQueryStages(query string) []string {
+	var stages []string
+	depth, start := 0, 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '"' && (i == 0 || query[i-1] != '\\'):
+			inString = !inString
+		case inString:
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == '|' && depth == 0:
+			stages = append(stages, query[start:i])
+			start = i + 1
+		}
+	}
+	return append(stages, query[start:])
+}
+
+func applyQueryStage(stage string, value any) ([]any, error) {
+	switch stage {
+	case "keys":
+		switch v := value.(type) {
+		case map[string]any:
+			keys := make([]any, 0, len(v))
+			for _, key := range sortedKeys(v) {
+				keys = append(keys, key)
+			}
+			return []any{keys}, nil
+		case []any:
+			keys := make([]any, len(v))
+			for i := range v {
+				keys[i] = i
+			}
+			return []any{keys}, nil
+		}
+		return nil, fmt.Errorf("%sにはkeysを使えません", dataTypeName(value))
+	case "length":
+		switch v := value.(type) {
+		case map[string]any:
+			return []any{len(v)}, nil
+		case []any:
+			return []any{len(v)}, nil
+		case string:
+			return []any{len([]rune(v))}, nil
+		case nil:
+			return []any{0}, nil
+		}
+		return nil, fmt.Errorf("%sにはlengthを使えません", dataTypeName(value))
+	case "type":
+		return []any{dataTypeName(value)}, nil
+	}
+
+	steps, err := parseQueryPath(stage)
+	if err != nil {
+		return nil, err
+	}
+	values := []any{value}
+	for _, step := range steps {
+		var next []any
+		for _, v := range values {
+			results, err := step(v)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, results...)
+		}
+		values = next
+	}
+	return values, nil
+}
+
+// queryStep はパスの1要素（.key、[0]、[]など）を値に適用する
+type queryStep func(value any) ([]any, error)
+
+// queryKeyPattern は.の後に続くキー名
+var queryKeyPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$-]*`)
+
+// parseQueryPath は.a.b[0]["c d"][]のようなパスを要素に分解する
+func parseQueryPath(path string) ([]queryStep, error) {
+	if !strings.HasPrefix(path, ".") {
+		return nil, fmt.Errorf("クエリは.で始めてください: %s", path)
+	}
+
+	var steps []queryStep
+	rest := path
+	for rest != "" {
+		switch {
+		case rest == ".":
+			rest = ""
+		case strings.HasPrefix(rest, ".["):
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "."):
+			key := queryKeyPattern.FindString(rest[1:])
+			if key == "" {
+				return nil, fmt.Errorf("キー名を解釈できません: %s", rest)
+			}
+			steps = append(steps, keyStep(key))
+			rest = rest[1+len(key):]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("]がありません: %s", rest)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			switch {
+			case inner == "":
+				steps = append(steps, iterateStep)
+			case strings.HasPrefix(inner, `"`):
+				key, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, fmt.Errorf("キー名を解釈できません: %s", inner)
+				}
+				steps = append(steps, keyStep(key))
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("インデックスを解釈できません: %s", inner)
+				}
+				steps = append(steps, indexStep(index))
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("クエリを解釈できません: %s", rest)
+		}
+	}
+	return steps, nil
+}
+
+// keyStep はオブジェクトのキーの値を返す。キーがない場合はjqと同じくnullを返す
+func keyStep(key string) queryStep {
+	return func(value any) ([]any, error) {
+		switch v := value.(type) {
+		case map[string]any:
+			return []any{v[key]}, nil
+		case nil:
+			return []any{nil}, nil
+		}
+		return nil, fmt.Errorf("%sからキー%qを取り出せません", dataTypeName(value), key)
+	}
+}
+
+// indexStep は配列の要素を返す。負のインデックスは末尾から数える
+func indexStep(index int) queryStep {
+	return func(value any) ([]any, error) {
+		switch v := value.(type) {
+		case []any:
+			i := index
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return []any{nil}, nil
+			}
+			return []any{v[i]}, nil
+		case nil:
+			return []any{nil}, nil
+		}
+		return nil, fmt.Errorf("%sにインデックス[%d]は使えません", dataTypeName(value), index)
+	}
+}
+
+// iterateStep は配列の要素またはオブジェクトの値をすべて返す
+func iterateStep(value any) ([]any, error) {
+	switch v := value.(type) {
+	case []any:
+		return v, nil
+	case map[string]any:
+		keys := sortedKeys(v)
+		values := make([]any, 0, len(keys))
+		for _, key := range keys {
+			values = append(values, v[key])
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%sには[]を使えません", dataTypeName(value))
+}
+
+// dataTypeName はjqのtypeと同じ名前で値の型を返す
+func dataTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return "number"
+	}
+}
+
+// GetQueryDataTool はqueryDataツールの定義を返す
+func GetQueryDataTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("queryData", "JSON・YAMLファイルの構文を検証し、jq風のクエリで値を取り出します。構文エラーの場合は行と列を返します。設定ファイルの編集前後の確認や、大きな設定ファイルから必要な値だけを読むために使います", QueryDataArgs{}),
+		Function: QueryData,
+		ReadOnly: true,
+	}
+}
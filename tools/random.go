@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxRandomCount は一度に生成できる値の最大数
+	maxRandomCount = 100
+	// maxRandomLength は生成する値の長さの上限
+	maxRandomLength = 1024
+	// defaultRandomLength はlengthを省略した場合の長さ
+	defaultRandomLength = 32
+)
+
+// randomAlphabet はalphanumericで使う文字
+const randomAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// GenerateRandomArgs はgenerateRandomツールの引数を表す構造体
+type GenerateRandomArgs struct {
+	Kind   string `json:"kind" enum:"uuid,uuidv7,hex,token,alphanumeric" description:"生成する値の種類。uuid: UUID v4、uuidv7: 時刻順に並ぶUUID v7、hex: 16進数文字列、token: URLセーフなbase64のトークン、alphanumeric: 英数字の文字列"`
+	Length int    `json:"length,omitempty" description:"hex・token・alphanumericの文字数（デフォルトは32、最大1024）。uuidでは無視される"`
+	Count  int    `json:"count,omitempty" description:"生成する個数（デフォルトは1、最大100）"`
+}
+
+// GenerateRandomResult はgenerateRandomツールの結果を表す構造体
+type GenerateRandomResult struct {
+	Values []string `json:"values"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// GenerateRandom は暗号学的に安全な乱数でUUIDやランダムな文字列を生成する
+func GenerateRandom(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてGenerateRandomArgsに変換
+	var generateRandomArgs GenerateRandomArgs
+	if err := json.Unmarshal([]byte(args), &generateRandomArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := GenerateRandomResult{
+			Values: []string{},
+			Error:  errorMessage,
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	count := generateRandomArgs.Count
+	if count <= 0 {
+		count = 1
+	}
+	if count > maxRandomCount {
+		return genErrorResult(fmt.Sprintf("countは%d以下にしてください", maxRandomCount)), nil
+	}
+	length := generateRandomArgs.Length
+	if length <= 0 {
+		length = defaultRandomLength
+	}
+	if length > maxRandomLength {
+		return genErrorResult(fmt.Sprintf("lengthは%d以下にしてください", maxRandomLength)), nil
+	}
+
+	values := make([]string, 0, count)
+	for range count {
+		value, err := generateRandomValue(generateRandomArgs.Kind, length)
+		if err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+		values = append(values, value)
+	}
+
+	result := GenerateRandomResult{Values: values}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// generateRandomValue は種類に応じたランダムな値を1つ生成する
+func generateRandomValue(kind string, length int) (string, error) {
+	switch kind {
+	case "uuid":
+		id, err := uuid.NewRandom()
+		return id.String(), err
+	case "uuidv7":
+		id, err := uuid.NewV7()
+		return id.String(), err
+	case "hex":
+		b := make([]byte, (length+1)/2)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(b)[:length], nil
+	case "token":
+		b := make([]byte, (length*3+3)/4)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(b)[:length], nil
+	case "alphanumeric":
+		b := make([]byte, length)
+		for i := range b {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(randomAlphabet))))
+			if err != nil {
+				return "", err
+			}
+			b[i] = randomAlphabet[n.Int64()]
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("kindが不正です: %s", kind)
+	}
+}
+
+// GetGenerateRandomTool はgenerateRandomツールの定義を返す
+func GetGenerateRandomTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("generateRandom", "暗号学的に安全な乱数で、UUID、16進数文字列、トークン、英数字の文字列を生成します。コードやテストデータにIDや秘密の値が必要な場合は、自分で考えた値ではなくこのツールで生成した値を使ってください", GenerateRandomArgs{}),
+		Function: GenerateRandom,
+		ReadOnly: true,
+		NoCache:  true,
+	}
+}
@@ -1,28 +1,57 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
+	"strings"
+	"unicode/utf8"
 )
 
+// defaultReadFileMaxBytes はreadFileが一度に返す内容の上限のデフォルト値（バイト）
+const defaultReadFileMaxBytes = 256 * 1024
+
+// readFileMaxBytes はreadFileが一度に返す内容の上限（バイト）。0の場合は上限なし
+var readFileMaxBytes = defaultReadFileMaxBytes
+
+// SetReadFileMaxBytes はreadFileが一度に返す内容の上限を設定する
+// 0の場合はデフォルト値、負の値の場合は上限なしになる
+func SetReadFileMaxBytes(maxBytes int) {
+	switch {
+	case maxBytes == 0:
+		readFileMaxBytes = defaultReadFileMaxBytes
+	case maxBytes < 0:
+		readFileMaxBytes = 0
+	default:
+		readFileMaxBytes = maxBytes
+	}
+}
+
 // ReadFileArgs はreadFileツールの引数を表す構造体
 type ReadFileArgs struct {
-	Path string `json:"path" description:"読み込むファイルのパス"`
+	Path      string `json:"path" description:"読み込むファイルのパス"`
+	StartLine int    `json:"startLine,omitempty" description:"読み込みを開始する行番号（1始まり）。省略時はファイルの先頭から"`
+	EndLine   int    `json:"endLine,omitempty" description:"読み込みを終了する行番号（この行を含む）。省略時はファイルの末尾まで"`
 }
 
 // ReadFileResult はreadFileツールの結果を表す構造体
 type ReadFileResult struct {
-	Content string `json:"content"`
-	Error   string `json:"error,omitempty"`
+	Content    string `json:"content"`
+	StartLine  int    `json:"startLine,omitempty"`  // 範囲を指定した場合に実際に返した最初の行
+	EndLine    int    `json:"endLine,omitempty"`    // 範囲を指定した場合に実際に返した最後の行
+	TotalLines int    `json:"totalLines,omitempty"` // 範囲を指定した場合のファイル全体の行数
+	TotalBytes int    `json:"totalBytes,omitempty"` // 切り詰めた場合やバイナリの場合のファイル全体のサイズ
+	Truncated  bool   `json:"truncated,omitempty"`  // 上限を超えたため内容を切り詰めたかどうか
+	Note       string `json:"note,omitempty"`       // 切り詰めた場合の続きの読み方
+	Binary     bool   `json:"binary,omitempty"`     // バイナリファイルのため読み込まなかったかどうか
+	MIMEType   string `json:"mimeType,omitempty"`   // バイナリファイルの場合の推定MIMEタイプ
+	Error      string `json:"error,omitempty"`
 }
 
 // ReadFile は指定されたパスのファイル内容を読み込む
-func ReadFile(args string) (string, error) {
+func ReadFile(ctx context.Context, args string) (string, error) {
 	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてReadFileArgsに変換
 	var readFileArgs ReadFileArgs
 	if err := json.Unmarshal([]byte(args), &readFileArgs); err != nil {
@@ -50,6 +79,39 @@ func ReadFile(args string) (string, error) {
 		return string(resultJSON), nil
 	}
 
+	// バイナリファイルの中身は意味のあるテキストにならず、コンテキストを浪費するだけなので返さない
+	if isBinary(content) {
+		result := ReadFileResult{
+			Binary:     true,
+			MIMEType:   detectMIMEType(content),
+			TotalBytes: len(content),
+			Error:      "バイナリファイルのため読み込めません。内容を調べる必要がある場合はrunCommandでfileやhexdumpなどを使ってください",
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON), nil
+	}
+
+	// 範囲が指定された場合はその行だけを返す
+	if readFileArgs.StartLine > 0 || readFileArgs.EndLine > 0 {
+		result := readLineRange(string(content), readFileArgs.StartLine, readFileArgs.EndLine)
+		if result.Error == "" && readFileMaxBytes > 0 && len(result.Content) > readFileMaxBytes {
+			result = truncateReadResult(result, len(content), readFileMaxBytes)
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON), nil
+	}
+
+	// 大きすぎるファイルは会話を埋め尽くさないよう、先頭から上限までの行だけを返す
+	if readFileMaxBytes > 0 && len(content) > readFileMaxBytes {
+		result := truncateReadResult(readLineRange(string(content), 1, 0), len(content), readFileMaxBytes)
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON), nil
+	}
+
+	// 編集時に読み込み後の変更を検出できるよう、モデルに渡した内容を記録する
+	// 一部だけを読んだ場合はファイル全体を把握していないので記録しない
+	rememberContent(readFileArgs.Path, string(content))
+
 	result := ReadFileResult{
 		Content: string(content),
 		Error:   "",
@@ -58,26 +120,77 @@ func ReadFile(args string) (string, error) {
 	return string(resultJSON), nil
 }
 
+// readLineRange はstartLine行目からendLine行目まで（1始まり、endLineを含む）を返す
+func readLineRange(content string, startLine, endLine int) ReadFileResult {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	total := len(lines)
+
+	if startLine <= 0 {
+		startLine = 1
+	}
+	if endLine <= 0 || endLine > total {
+		endLine = total
+	}
+	if startLine > total {
+		return ReadFileResult{
+			TotalLines: total,
+			Error:      fmt.Sprintf("startLineがファイルの行数（%d行）を超えています", total),
+		}
+	}
+	if startLine > endLine {
+		return ReadFileResult{
+			TotalLines: total,
+			Error:      "startLineはendLine以下にしてください",
+		}
+	}
+
+	return ReadFileResult{
+		Content:    strings.Join(lines[startLine-1:endLine], ""),
+		StartLine:  startLine,
+		EndLine:    endLine,
+		TotalLines: total,
+	}
+}
+
+// truncateReadResult は読み込んだ範囲のうち、maxBytesに収まる行だけを残す
+func truncateReadResult(result ReadFileResult, totalBytes, maxBytes int) ReadFileResult {
+	lines := strings.SplitAfter(result.Content, "\n")
+
+	size, n := 0, 0
+	for n < len(lines) && size+len(lines[n]) <= maxBytes {
+		size += len(lines[n])
+		n++
+	}
+
+	result.TotalBytes = totalBytes
+	result.Truncated = true
+	if n == 0 {
+		// 1行だけで上限を超える場合（minifyされたファイルなど）は、その行の先頭だけを返す
+		// マルチバイト文字の途中で切らないようにする
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(lines[0][cut]) {
+			cut--
+		}
+		result.Content = lines[0][:cut]
+		result.EndLine = result.StartLine
+		result.Note = fmt.Sprintf("%d行目が長すぎるため、先頭の%dバイトのみを返しました。この行の続きが必要な場合はsearchInDirectoryなどで必要な箇所を探してください", result.StartLine, cut)
+		return result
+	}
+
+	result.Content = strings.Join(lines[:n], "")
+	result.EndLine = result.StartLine + n - 1
+	result.Note = fmt.Sprintf("ファイルが大きいため（%dバイト、全%d行）、%d〜%d行目のみを返しました。続きはstartLine=%dを指定して読み込んでください", totalBytes, result.TotalLines, result.StartLine, result.EndLine, result.EndLine+1)
+	return result
+}
+
 // GetReadFileTool はreadFileツールの定義を返す
 func GetReadFileTool() ToolDefinition {
 	return ToolDefinition{
-		Schema: openai.Tool{
-			Type: openai.ToolTypeFunction,
-			Function: &openai.FunctionDefinition{
-				Name:        "readFile",
-				Description: "指定されたファイルの内容全体を読み込みます。",
-				Parameters: jsonschema.Definition{
-					Type: jsonschema.Object,
-					Properties: map[string]jsonschema.Definition{
-						"path": {
-							Type:        jsonschema.String,
-							Description: "読み込むファイルのパス",
-						},
-					},
-					Required: []string{"path"},
-				},
-			},
-		},
+		Schema:   newToolSchema("readFile", "指定されたファイルの内容を読み込みます。startLine・endLineを指定すると、その範囲の行だけを読み込みます。大きなファイルはsearchInDirectoryで位置を確認してから範囲を指定して読むと効率的です。一度に返す内容には上限があり、超えた場合は切り詰めて続きの読み方を返します。", ReadFileArgs{}),
 		Function: ReadFile,
+		ReadOnly: true,
 	}
 }
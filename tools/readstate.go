@@ -0,0 +1,35 @@
+package tools
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// readContents はモデルが最後に読み込んだ（または書き込んだ）時点のファイル内容
+// 編集時に、その後ディスク上で変更されていないかを検出するために使う
+var (
+	readContentsMu sync.Mutex
+	readContents   = map[string]string{}
+)
+
+// rememberContent はモデルが把握しているファイル内容を記録する
+func rememberContent(path, content string) {
+	readContentsMu.Lock()
+	defer readContentsMu.Unlock()
+	readContents[readStateKey(path)] = content
+}
+
+// rememberedContent はモデルが最後に把握したファイル内容を返す
+func rememberedContent(path string) (string, bool) {
+	readContentsMu.Lock()
+	defer readContentsMu.Unlock()
+	content, ok := readContents[readStateKey(path)]
+	return content, ok
+}
+
+func readStateKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
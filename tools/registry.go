@@ -1,11 +1,50 @@
 package tools
 
+// GetAvailableTools は組み込みのツールと読み込んだプラグインのツールを返す
 func GetAvailableTools() map[string]ToolDefinition {
+	available := GetBuiltinTools()
+	for name, tool := range pluginTools {
+		available[name] = tool
+	}
+	return available
+}
+
+// GetBuiltinTools は組み込みのツールを返す
+func GetBuiltinTools() map[string]ToolDefinition {
 	return map[string]ToolDefinition{
 		"readFile":          GetReadFileTool(),
 		"list":              GetListTool(),
 		"searchInDirectory": GetSearchInDirectoryTool(),
+		"glob":              GetGlobTool(),
+		"markdownOutline":   GetMarkdownOutlineTool(),
+		"codeOutline":       GetCodeOutlineTool(),
+		"findDefinition":    GetFindDefinitionTool(),
+		"findReferences":    GetFindReferencesTool(),
+		"checkLinks":        GetCheckLinksTool(),
+		"spellcheck":        GetSpellcheckTool(),
+		"previewCSV":        GetPreviewCSVTool(),
+		"querySQLite":       GetQuerySQLiteTool(),
+		"inspectAPISchema":  GetInspectAPISchemaTool(),
+		"queryData":         GetQueryDataTool(),
+		"inspectArchive":    GetInspectArchiveTool(),
+		"gitStatus":         GetGitStatusTool(),
+		"gitDiff":           GetGitDiffTool(),
+		"gitLog":            GetGitLogTool(),
+		"inspectEnv":        GetInspectEnvTool(),
+		"calculate":         GetCalculateTool(),
+		"currentTime":       GetCurrentTimeTool(),
+		"generateRandom":    GetGenerateRandomTool(),
+		"webFetch":          GetWebFetchTool(),
+		"webSearch":         GetWebSearchTool(),
 		"writeFile":         GetWriteFileTool(),
 		"editFile":          GetEditFileTool(),
+		"deleteFile":        GetDeleteFileTool(),
+		"applyChanges":      GetApplyChangesTool(),
+		"gitCommit":         GetGitCommitTool(),
+		"createPullRequest": GetCreatePullRequestTool(),
+		"readNotebook":      GetReadNotebookTool(),
+		"editNotebookCell":  GetEditNotebookCellTool(),
+		"runCommand":        GetRunCommandTool(),
+		"runSnippet":        GetRunSnippetTool(),
 	}
 }
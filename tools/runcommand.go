@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRunCommandTimeout = 60 * time.Second
+	maxRunCommandTimeout     = 10 * time.Minute
+	// maxCommandOutputBytes はstdout・stderrそれぞれでモデルに返す最大バイト数
+	maxCommandOutputBytes = 64 * 1024
+)
+
+// RunCommandArgs はrunCommandツールの引数を表す構造体
+type RunCommandArgs struct {
+	Command          string `json:"command" description:"実行するシェルコマンド"`
+	WorkingDirectory string `json:"workingDirectory,omitempty" description:"コマンドを実行するディレクトリ。省略時はカレントディレクトリ"`
+	TimeoutSeconds   int    `json:"timeoutSeconds,omitempty" description:"タイムアウト（秒）。省略時は60秒、最大600秒"`
+}
+
+// RunCommandResult はrunCommandツールの結果を表す構造体
+type RunCommandResult struct {
+	Success  bool   `json:"success"`
+	ExitCode int    `json:"exitCode"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	TimedOut bool   `json:"timedOut,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RunCommand はユーザーの許可を得てシェルコマンドを実行し、出力と終了コードを返す
+func RunCommand(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてRunCommandArgsに変換
+	var runCommandArgs RunCommandArgs
+	if err := json.Unmarshal([]byte(args), &runCommandArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := RunCommandResult{
+			Success:  false,
+			ExitCode: -1,
+			Error:    errorMessage,
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	if strings.TrimSpace(runCommandArgs.Command) == "" {
+		return genErrorResult("commandが空です"), nil
+	}
+
+	timeout := defaultRunCommandTimeout
+	if runCommandArgs.TimeoutSeconds > 0 {
+		timeout = min(time.Duration(runCommandArgs.TimeoutSeconds)*time.Second, maxRunCommandTimeout)
+	}
+
+	// ユーザー許可の取得
+	detail := ""
+	if runCommandArgs.WorkingDirectory != "" {
+		detail += fmt.Sprintf("ディレクトリ: %s\n", runCommandArgs.WorkingDirectory)
+	}
+	detail += fmt.Sprintf("タイムアウト: %s", timeout)
+	approved, err := requestApproval(ApprovalRequest{
+		Tool:   "runCommand",
+		Kind:   ApprovalExecute,
+		Title:  fmt.Sprintf("コマンドを実行します: %s", runCommandArgs.Command),
+		Detail: detail,
+	})
+	if err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+	if !approved {
+		return genErrorResult("ユーザーによってキャンセルされました"), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	shell := shellCommand(runCommandArgs.Command)
+	cmd := exec.CommandContext(ctx, shell[0], shell[1:]...)
+	cmd.Dir = runCommandArgs.WorkingDirectory
+	// 子プロセスが出力を握ったままでも、タイムアウト後に待ち続けないようにする
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+
+	result := RunCommandResult{
+		Success:  err == nil,
+		ExitCode: 0,
+		Stdout:   truncateCommandOutput(stdout.String()),
+		Stderr:   truncateCommandOutput(stderr.String()),
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Success = false
+		result.ExitCode = -1
+		result.TimedOut = true
+		result.Error = fmt.Sprintf("コマンドが%sでタイムアウトしました", timeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		result.Success = false
+		result.ExitCode = -1
+		result.Error = "コマンドの実行が中断されました"
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		result.ExitCode = -1
+		result.Error = fmt.Sprintf("コマンドの実行に失敗しました: %v", err)
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// shellCommand はコマンド文字列をシェルで実行するための引数を返す。Windowsではcmd.exeを使う
+func shellCommand(command string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C", command}
+	}
+	return []string{"sh", "-c", command}
+}
+
+// truncateCommandOutput は長すぎる出力の先頭を省略し、末尾（エラーやテスト結果が出やすい部分）を残す
+func truncateCommandOutput(output string) string {
+	if len(output) <= maxCommandOutputBytes {
+		return output
+	}
+	omitted := len(output) - maxCommandOutputBytes
+	return fmt.Sprintf("...(先頭の%dバイトを省略)...\n", omitted) + output[omitted:]
+}
+
+// GetRunCommandTool はrunCommandツールの定義を返す
+func GetRunCommandTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("runCommand", "シェルコマンドを実行し、標準出力・標準エラー出力・終了コードを返します。ビルドやテストの実行に使います。実行にはユーザーの許可が必要です", RunCommandArgs{}),
+		Function: RunCommand,
+	}
+}
@@ -0,0 +1,251 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSnippetTimeout = 10 * time.Second
+	maxSnippetTimeout     = 60 * time.Second
+	// snippetBuildTimeout はGoのコンパイルにかける時間の上限。実行時間の上限とは別に数える
+	snippetBuildTimeout = 60 * time.Second
+
+	defaultSnippetMemoryMB = 256
+	maxSnippetMemoryMB     = 2048
+)
+
+// snippetLanguage は言語ごとのファイル名・コマンド・コンテナイメージ
+type snippetLanguage struct {
+	fileName string
+	build    []string // コンパイルが必要な場合のコマンド（メモリ制限なしで実行する）
+	run      []string
+	image    string
+}
+
+var snippetLanguages = map[string]snippetLanguage{
+	"go": {
+		fileName: "main.go",
+		build:    []string{"go", "build", "-o", "snippet", "main.go"},
+		run:      []string{"./snippet"},
+		image:    "golang:1.23",
+	},
+	"python": {
+		fileName: "main.py",
+		run:      []string{"python3", "main.py"},
+		image:    "python:3.12-slim",
+	},
+	"javascript": {
+		fileName: "main.js",
+		run:      []string{"node", "main.js"},
+		image:    "node:22-slim",
+	},
+}
+
+// RunSnippetArgs はrunSnippetツールの引数を表す構造体
+type RunSnippetArgs struct {
+	Language       string `json:"language" enum:"go,python,javascript" description:"コードの言語"`
+	Code           string `json:"code" description:"実行するコード。goの場合はpackage mainとfunc mainを含む完全なファイル"`
+	Stdin          string `json:"stdin,omitempty" description:"標準入力に渡す内容"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty" description:"実行時間の上限（秒）。省略時は10秒、最大60秒。goのコンパイル時間は含まない"`
+	MemoryMB       int    `json:"memoryMB,omitempty" description:"メモリの上限（MB）。省略時は256MB、最大2048MB"`
+	Container      bool   `json:"container,omitempty" description:"trueの場合、ネットワークを切断したDockerコンテナ内で実行する（Dockerが必要）"`
+}
+
+// RunSnippetResult はrunSnippetツールの結果を表す構造体
+type RunSnippetResult struct {
+	Success  bool   `json:"success"`
+	ExitCode int    `json:"exitCode"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	TimedOut bool   `json:"timedOut,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RunSnippet は短いコードを一時ディレクトリで実行し、出力と終了コードを返す（ユーザー許可が必要）
+func RunSnippet(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてRunSnippetArgsに変換
+	var runSnippetArgs RunSnippetArgs
+	if err := json.Unmarshal([]byte(args), &runSnippetArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := RunSnippetResult{
+			Success:  false,
+			ExitCode: -1,
+			Error:    errorMessage,
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	language, ok := snippetLanguages[runSnippetArgs.Language]
+	if !ok {
+		return genErrorResult(fmt.Sprintf("対応していない言語です: %s（go, python, javascriptのいずれかを指定してください）", runSnippetArgs.Language)), nil
+	}
+	if strings.TrimSpace(runSnippetArgs.Code) == "" {
+		return genErrorResult("codeが空です"), nil
+	}
+
+	timeout := defaultSnippetTimeout
+	if runSnippetArgs.TimeoutSeconds > 0 {
+		timeout = min(time.Duration(runSnippetArgs.TimeoutSeconds)*time.Second, maxSnippetTimeout)
+	}
+	memoryMB := defaultSnippetMemoryMB
+	if runSnippetArgs.MemoryMB > 0 {
+		memoryMB = min(runSnippetArgs.MemoryMB, maxSnippetMemoryMB)
+	}
+
+	// ユーザー許可の取得
+	where := "一時ディレクトリ"
+	if runSnippetArgs.Container {
+		where = fmt.Sprintf("Dockerコンテナ（%s、ネットワークなし）", language.image)
+	}
+	approved, err := requestApproval(ApprovalRequest{
+		Tool:   "runSnippet",
+		Kind:   ApprovalExecute,
+		Title:  fmt.Sprintf("%sのコードを%sで実行します（タイムアウト: %s、メモリ: %dMB）", runSnippetArgs.Language, where, timeout, memoryMB),
+		Detail: fmt.Sprintf("--- コード ---\n%s", runSnippetArgs.Code),
+	})
+	if err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+	if !approved {
+		return genErrorResult("ユーザーによってキャンセルされました"), nil
+	}
+
+	// プロジェクトのファイルに触れないよう、空の一時ディレクトリで実行する
+	dir, err := os.MkdirTemp("", "nebula-snippet-")
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("一時ディレクトリの作成に失敗しました: %v", err)), nil
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, language.fileName), []byte(runSnippetArgs.Code), 0o644); err != nil {
+		return genErrorResult(fmt.Sprintf("コードの書き込みに失敗しました: %v", err)), nil
+	}
+
+	var build, run, stop []string
+	if runSnippetArgs.Container {
+		// docker CLIを終了してもコンテナは動き続けるので、名前を付けておきキャンセル時にdocker killで止める
+		// 一時ディレクトリ名はランダムなので、同時に実行しても名前が衝突しない
+		name := filepath.Base(dir)
+		run = containerSnippetCommand(dir, name, language, memoryMB)
+		stop = []string{"docker", "kill", name}
+	} else {
+		build = language.build
+		run = limitSnippetMemory(language.run, memoryMB)
+	}
+
+	// コンパイルエラーは実行結果と同じ形式で返し、モデルが修正できるようにする
+	if build != nil {
+		result := runSnippetCommand(ctx, dir, build, "", snippetBuildTimeout, nil)
+		if !result.Success {
+			resultJSON, _ := json.Marshal(result)
+			return string(resultJSON), nil
+		}
+	}
+
+	result := runSnippetCommand(ctx, dir, run, runSnippetArgs.Stdin, timeout, stop)
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// limitSnippetMemory はコマンドにメモリ制限をかける
+// Goのランタイムは起動時に大きな仮想アドレス空間を予約するため、ulimit -vではなくデータ領域の上限で制限する
+// Windowsにはulimitがないので制限しない
+func limitSnippetMemory(command []string, memoryMB int) []string {
+	if runtime.GOOS == "windows" {
+		return command
+	}
+	return append([]string{"sh", "-c", `ulimit -d "$0" && exec "$@"`, fmt.Sprint(memoryMB * 1024)}, command...)
+}
+
+// containerSnippetCommand はコードをnameという名前のDockerコンテナ内で実行するコマンドを返す
+func containerSnippetCommand(dir, name string, language snippetLanguage, memoryMB int) []string {
+	command := strings.Join(language.run, " ")
+	if language.build != nil {
+		command = strings.Join(language.build, " ") + " && " + command
+	}
+	return []string{
+		"docker", "run", "--rm", "-i",
+		"--name", name,
+		"--network", "none",
+		"--memory", fmt.Sprintf("%dm", memoryMB),
+		"--cpus", "1",
+		"--pids-limit", "256",
+		"-v", dir + ":/snippet",
+		"-w", "/snippet",
+		language.image,
+		"sh", "-c", command,
+	}
+}
+
+// runSnippetCommand はコマンドを実行し、出力と終了コードを結果にまとめる
+// stopを指定した場合は、タイムアウトやキャンセルでコマンドを止める前にstopを実行する
+func runSnippetCommand(ctx context.Context, dir string, command []string, stdin string, timeout time.Duration, stop []string) RunSnippetResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	if stop != nil {
+		cmd.Cancel = func() error {
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer stopCancel()
+			_ = exec.CommandContext(stopCtx, stop[0], stop[1:]...).Run()
+			return cmd.Process.Kill()
+		}
+	}
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(stdin)
+	// 子プロセスが出力を握ったままでも、タイムアウト後に待ち続けないようにする
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	result := RunSnippetResult{
+		Success:  err == nil,
+		ExitCode: 0,
+		Stdout:   truncateCommandOutput(stdout.String()),
+		Stderr:   truncateCommandOutput(stderr.String()),
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Success = false
+		result.ExitCode = -1
+		result.TimedOut = true
+		result.Error = fmt.Sprintf("%sでタイムアウトしました", timeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		result.Success = false
+		result.ExitCode = -1
+		result.Error = "実行が中断されました"
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		result.ExitCode = -1
+		result.Error = fmt.Sprintf("実行に失敗しました: %v", err)
+	}
+	return result
+}
+
+// GetRunSnippetTool はrunSnippetツールの定義を返す
+func GetRunSnippetTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("runSnippet", "Go・Python・JavaScriptの短いコードを空の一時ディレクトリで実行し、標準出力・標準エラー出力・終了コードを返します。時間とメモリに上限があります。プロジェクトのコードを編集する前に、アルゴリズムや標準ライブラリの挙動を確かめるために使います。実行にはユーザーの許可が必要です", RunSnippetArgs{}),
+		Function: RunSnippet,
+	}
+}
@@ -0,0 +1,56 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// newToolSchema は引数の構造体のタグからツールのスキーマを生成する
+// json タグの名前を引数名、description タグを説明として使い、omitempty 付きのフィールドは任意の引数になる
+func newToolSchema(name, description string, args any) openai.Tool {
+	params, err := jsonschema.GenerateSchemaForType(args)
+	if err != nil {
+		// 引数の構造体はコンパイル時に決まるので、失敗はプログラムの誤り
+		panic(fmt.Sprintf("failed to generate schema for tool %s: %v", name, err))
+	}
+
+	return openai.Tool{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  inlineSchemaRefs(*params, params.Defs),
+		},
+	}
+}
+
+// inlineSchemaRefs は入れ子の構造体の $ref を展開し、$defs を含まない自己完結したスキーマにする
+func inlineSchemaRefs(def jsonschema.Definition, defs map[string]jsonschema.Definition) jsonschema.Definition {
+	if def.Ref != "" {
+		if resolved, ok := defs[strings.TrimPrefix(def.Ref, "#/$defs/")]; ok {
+			description := def.Description
+			def = resolved
+			if description != "" {
+				def.Description = description
+			}
+		}
+		def.Ref = ""
+	}
+	def.Defs = nil
+
+	if def.Items != nil {
+		items := inlineSchemaRefs(*def.Items, defs)
+		def.Items = &items
+	}
+	if len(def.Properties) > 0 {
+		properties := make(map[string]jsonschema.Definition, len(def.Properties))
+		for key, prop := range def.Properties {
+			properties[key] = inlineSchemaRefs(prop, defs)
+		}
+		def.Properties = properties
+	}
+	return def
+}
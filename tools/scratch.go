@@ -0,0 +1,34 @@
+package tools
+
+import (
+	"path/filepath"
+)
+
+// scratchDir はセッション専用のスクラッチディレクトリ（絶対パス）。空の場合は未設定
+var scratchDir string
+
+// SetScratchDir はセッション専用のスクラッチディレクトリを設定する
+// このディレクトリ内のファイルは承認なしで作成・編集・削除でき、変更の通知も行わない
+func SetScratchDir(dir string) {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	scratchDir = dir
+}
+
+// inScratchDir はパスがスクラッチディレクトリ内（ディレクトリ自身は除く）かどうかを返す
+// ..で始まる名前のファイルを含められるよう、またシンボリックリンクで外を指すパスを含めないよう、実際のパスで比較する
+func inScratchDir(path string) bool {
+	if scratchDir == "" {
+		return false
+	}
+	dir, err := resolveExistingPath(scratchDir)
+	if err != nil {
+		return false
+	}
+	target, err := resolveExistingPath(path)
+	if err != nil {
+		return false
+	}
+	return foldPathCase(target) != foldPathCase(dir) && isWithinDir(dir, target)
+}
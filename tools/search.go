@@ -2,44 +2,93 @@ package tools
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
-
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
+	"sync"
+	"unicode/utf8"
 )
 
 // SearchInDirectoryArgs はsearchInDirectoryツールの引数を表す構造体
 type SearchInDirectoryArgs struct {
 	Path         string   `json:"path" description:"検索するディレクトリのパス"`
 	Keyword      string   `json:"keyword" description:"検索するキーワード"`
-	ExcludePaths []string `json:"excludePaths,omitempty" description:"除外するパスのパターン（先頭一致）"`
+	ExcludePaths []string `json:"excludePaths,omitempty" description:"除外するパスのパターン（先頭一致）。指定されたパターンで始まるパスは検索対象から除外されます。"`
+	Regex        bool     `json:"regex,omitempty" description:"trueの場合、keywordを正規表現（Goのregexp構文）として扱います（デフォルトはfalse）"`
+	IgnoreCase   bool     `json:"ignoreCase,omitempty" description:"trueの場合、大文字と小文字を区別せずに検索します（デフォルトはfalse）"`
+	ContextLines int      `json:"contextLines,omitempty" description:"マッチした行の前後に含める行数（デフォルトは0、最大10）"`
+	NoIgnore     bool     `json:"noIgnore,omitempty" description:"trueの場合、.gitignoreと.nebulaignoreで除外されたファイルや.gitディレクトリも検索します（デフォルトはfalse）"`
+}
+
+// SearchMatch はキーワードにマッチした1行を表す構造体
+type SearchMatch struct {
+	File   string   `json:"file"`
+	Line   int      `json:"line"` // 1始まりの行番号
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"` // マッチした行の直前の行
+	After  []string `json:"after,omitempty"`  // マッチした行の直後の行
 }
 
 // SearchInDirectoryResult はsearchInDirectoryツールの結果を表す構造体
 type SearchInDirectoryResult struct {
-	Files []string `json:"files"`
-	Error string   `json:"error,omitempty"`
+	Files     []string      `json:"files"`
+	Matches   []SearchMatch `json:"matches"`
+	Truncated bool          `json:"truncated,omitempty"` // マッチが多すぎて一部を省略したかどうか
+	Error     string        `json:"error,omitempty"`
 }
 
+const (
+	// maxSearchMatches は結果に含めるマッチした行の最大数
+	maxSearchMatches = 200
+	// maxSearchContextLines は前後に含められる行数の上限
+	maxSearchContextLines = 10
+	// maxSearchLineLength は結果に含める1行の最大文字数（バイト）
+	maxSearchLineLength = 300
+)
+
 // SearchInDirectory は指定されたディレクトリ配下を再帰的に検索し、キーワードを含むファイルを見つける
-func SearchInDirectory(args string) (string, error) {
+func SearchInDirectory(ctx context.Context, args string) (string, error) {
 	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてSearchInDirectoryArgsに変換
 	var searchInDirectoryArgs SearchInDirectoryArgs
 	if err := json.Unmarshal([]byte(args), &searchInDirectoryArgs); err != nil {
 		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
 	}
 
-	var files []string
+	match, err := newLineMatcher(searchInDirectoryArgs.Keyword, searchInDirectoryArgs.Regex, searchInDirectoryArgs.IgnoreCase)
+	if err != nil {
+		result := SearchInDirectoryResult{
+			Files:   []string{},
+			Matches: []SearchMatch{},
+			Error:   fmt.Sprintf("正規表現が不正です: %v", err),
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON), nil
+	}
+
+	contextLines := min(max(searchInDirectoryArgs.ContextLines, 0), maxSearchContextLines)
+
+	var paths []string
+
+	// node_modulesやビルド成果物で結果が埋まらないよう、.gitignoreなどで除外されたパスは検索しない
+	var ignore *ignoreMatcher
+	if !searchInDirectoryArgs.NoIgnore {
+		ignore = newIgnoreMatcher(searchInDirectoryArgs.Path)
+	}
 
 	// ディレクトリ以下のすべてのファイルを走査
-	err := filepath.Walk(searchInDirectoryArgs.Path, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(searchInDirectoryArgs.Path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err // エラーが発生した場合は中断
 		}
+		// 大きなディレクトリでも中断できるようにする
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		// excludePathsによる除外チェック
 		if len(searchInDirectoryArgs.ExcludePaths) > 0 {
@@ -55,83 +104,197 @@ func SearchInDirectory(args string) (string, error) {
 			}
 		}
 
-		// ディレクトリは検索対象外
-		if info.IsDir() {
-			return nil
+		if ignore != nil && path != searchInDirectoryArgs.Path {
+			if ignore.ignored(path, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				ignore.loadDir(path)
+			}
 		}
 
-		// ファイルを開いて読み込み
-		file, err := os.Open(path)
-		if err != nil {
-			// バイナリファイルや権限なしファイルは静かにスキップ
-			// エラーを返すと全体の検索が止まってしまう
+		// ディレクトリは検索対象外
+		if info.IsDir() {
 			return nil
 		}
-		defer file.Close()
-
-		// ファイルの内容を読み込んでキーワードを検索
-		// bufio.Scannerを使って効率的に読み込み
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			if strings.Contains(scanner.Text(), searchInDirectoryArgs.Keyword) {
-				files = append(files, path)
-				break // 1つのファイルで複数行マッチしても1回だけ記録
-			}
-		}
 
+		paths = append(paths, path)
 		return nil
 	})
 
 	// 検索処理でエラーが発生した場合はJSON形式で結果を返す
 	if err != nil {
 		result := SearchInDirectoryResult{
-			Files: []string{},
-			Error: fmt.Sprintf("検索処理中にエラーが発生しました: %v", err),
+			Files:   []string{},
+			Matches: []SearchMatch{},
+			Error:   fmt.Sprintf("検索処理中にエラーが発生しました: %v", err),
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON), nil
+	}
+
+	// ファイルの読み込みと検索は並列に行い、結果は走査した順に並べる
+	fileResults := searchFiles(ctx, paths, match, contextLines)
+	if err := ctx.Err(); err != nil {
+		result := SearchInDirectoryResult{
+			Files:   []string{},
+			Matches: []SearchMatch{},
+			Error:   fmt.Sprintf("検索が中断されました: %v", err),
 		}
 		resultJSON, _ := json.Marshal(result)
 		return string(resultJSON), nil
 	}
+	var files []string
+	matches := []SearchMatch{}
+	truncated := false
+	for i, fileMatches := range fileResults {
+		if len(fileMatches) == 0 {
+			continue
+		}
+		files = append(files, paths[i])
+
+		// マッチした行は上限まで記録し、ファイル一覧は最後まで集める
+		if remaining := maxSearchMatches - len(matches); len(fileMatches) > remaining {
+			fileMatches = fileMatches[:remaining]
+			truncated = true
+		}
+		matches = append(matches, fileMatches...)
+	}
 
 	// 成功時の結果をJSON形式で返す
 	result := SearchInDirectoryResult{
-		Files: files,
-		Error: "",
+		Files:     files,
+		Matches:   matches,
+		Truncated: truncated,
+		Error:     "",
 	}
 	resultJSON, _ := json.Marshal(result)
 	return string(resultJSON), nil
 }
 
+// searchFiles は複数のファイルをワーカーで並列に検索し、pathsと同じ順に結果を返す
+// ctxがキャンセルされると残りのファイルは検索しない
+func searchFiles(ctx context.Context, paths []string, match func(line string) bool, contextLines int) [][]SearchMatch {
+	results := make([][]SearchMatch, len(paths))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	// ファイルの読み込み待ちがあるので、CPU数より多めのワーカーで処理する
+	workers := min(runtime.NumCPU()*2, len(paths))
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = searchFile(paths[i], match, contextLines)
+			}
+		}()
+	}
+	for i := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// searchFile はファイル内でマッチした行を、前後contextLines行とともに返す
+func searchFile(path string, match func(line string) bool, contextLines int) []SearchMatch {
+	// ファイルを開いて読み込み
+	file, err := os.Open(path)
+	if err != nil {
+		// バイナリファイルや権限なしファイルは静かにスキップ
+		// エラーを返すと全体の検索が止まってしまう
+		return nil
+	}
+	defer file.Close()
+
+	// バイナリファイルは行として意味をなさないのでスキップする
+	reader := bufio.NewReader(file)
+	head, _ := reader.Peek(binarySniffLen)
+	if isBinary(head) {
+		return nil
+	}
+
+	// 前後の行を返せるように、bufio.Scannerで全行を読み込んでから検索する
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	var matches []SearchMatch
+	for i, line := range lines {
+		if !match(line) {
+			continue
+		}
+		m := SearchMatch{
+			File: path,
+			Line: i + 1,
+			Text: truncateSearchLine(line),
+		}
+		for j := max(i-contextLines, 0); j < i; j++ {
+			m.Before = append(m.Before, truncateSearchLine(lines[j]))
+		}
+		for j := i + 1; j <= min(i+contextLines, len(lines)-1); j++ {
+			m.After = append(m.After, truncateSearchLine(lines[j]))
+		}
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+// truncateSearchLine は長すぎる行（minifyされたファイルなど）を切り詰める
+func truncateSearchLine(line string) string {
+	if len(line) <= maxSearchLineLength {
+		return line
+	}
+	return cutAtRuneBoundary(line, maxSearchLineLength) + "..."
+}
+
+// cutAtRuneBoundary は文字列を最大nバイトに切り詰める。マルチバイト文字の途中では切らない
+func cutAtRuneBoundary(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// newLineMatcher はキーワードの指定方法に応じて、行がマッチするかを判定する関数を返す
+func newLineMatcher(keyword string, regex, ignoreCase bool) (func(line string) bool, error) {
+	if !regex {
+		if ignoreCase {
+			lowerKeyword := strings.ToLower(keyword)
+			return func(line string) bool { return strings.Contains(strings.ToLower(line), lowerKeyword) }, nil
+		}
+		return func(line string) bool { return strings.Contains(line, keyword) }, nil
+	}
+
+	if ignoreCase {
+		keyword = "(?i)" + keyword
+	}
+	re, err := regexp.Compile(keyword)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString, nil
+}
+
 // GetSearchInDirectoryTool はsearchInDirectoryツールの定義を返す
 func GetSearchInDirectoryTool() ToolDefinition {
 	return ToolDefinition{
-		Schema: openai.Tool{
-			Type: openai.ToolTypeFunction,
-			Function: &openai.FunctionDefinition{
-				Name:        "searchInDirectory",
-				Description: "指定したディレクトリ内を再帰的に検索し、キーワードを含むファイルを見つけます。",
-				Parameters: jsonschema.Definition{
-					Type: jsonschema.Object,
-					Properties: map[string]jsonschema.Definition{
-						"path": {
-							Type:        jsonschema.String,
-							Description: "検索するディレクトリのパス",
-						},
-						"keyword": {
-							Type:        jsonschema.String,
-							Description: "検索するキーワード",
-						},
-						"excludePaths": {
-							Type:        jsonschema.Array,
-							Description: "除外するパスのパターン（先頭一致）。指定されたパターンで始まるパスは検索対象から除外されます。",
-							Items: &jsonschema.Definition{
-								Type: jsonschema.String,
-							},
-						},
-					},
-					Required: []string{"path", "keyword"},
-				},
-			},
-		},
+		Schema:   newToolSchema("searchInDirectory", "指定したディレクトリ内を再帰的に検索し、キーワードを含む行をファイルパス・行番号・行の内容（必要に応じて前後の行）とともに返します。バイナリファイルと、.gitignore・.nebulaignoreで除外されたファイルはデフォルトで検索対象外です。正規表現や大文字小文字を区別しない検索にも対応しています。", SearchInDirectoryArgs{}),
 		Function: SearchInDirectory,
+		ReadOnly: true,
 	}
 }
@@ -0,0 +1,194 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// defaultSystemWordList は単語リストが指定されない場合に使う辞書
+	defaultSystemWordList = "/usr/share/dict/words"
+	// projectWordList はプロジェクト固有の用語を登録する単語リスト（カレントディレクトリに置く）
+	projectWordList = ".nebula-wordlist"
+	// maxMisspellings は報告する綴りの誤りの最大数
+	maxMisspellings = 200
+)
+
+// SpellcheckArgs はspellcheckツールの引数を表す構造体
+type SpellcheckArgs struct {
+	Path     string `json:"path" description:"検査するMarkdownやテキストファイルのパス"`
+	WordList string `json:"wordList,omitempty" description:"辞書として使う単語リスト（1行1語）のパス。省略時は/usr/share/dict/words"`
+}
+
+// misspelling は辞書にない単語
+type misspelling struct {
+	Word        string   `json:"word"`
+	Lines       []int    `json:"lines"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// SpellcheckResult はspellcheckツールの結果を表す構造体
+type SpellcheckResult struct {
+	Misspellings []misspelling `json:"misspellings"`
+	Truncated    bool          `json:"truncated,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+var (
+	// インラインコード、リンク先、URLは検査しない
+	inlineCodePattern = regexp.MustCompile("`[^`]*`")
+	linkTargetPattern = regexp.MustCompile(`\]\([^)]*\)`)
+	urlPattern        = regexp.MustCompile(`\w+://\S+`)
+	wordPattern       = regexp.MustCompile(`[A-Za-z]+(?:'[A-Za-z]+)*`)
+)
+
+// Spellcheck は単語リストに載っていない英単語を行番号と修正候補つきで報告する
+func Spellcheck(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてSpellcheckArgsに変換
+	var spellcheckArgs SpellcheckArgs
+	if err := json.Unmarshal([]byte(args), &spellcheckArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := SpellcheckResult{
+			Misspellings: []misspelling{},
+			Error:        errorMessage,
+		}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	content, err := os.ReadFile(spellcheckArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルの読み込みに失敗しました: %v", err)), nil
+	}
+
+	dictionary := map[string]bool{}
+	wordListPath := spellcheckArgs.WordList
+	if wordListPath == "" {
+		wordListPath = defaultSystemWordList
+	}
+	if err := readWordList(wordListPath, dictionary); err != nil {
+		return genErrorResult(fmt.Sprintf("単語リストを読み込めません。wordListで辞書のパスを指定してください: %v", err)), nil
+	}
+	// プロジェクト固有の用語は辞書に追加する
+	if err := readWordList(projectWordList, dictionary); err != nil && !os.IsNotExist(err) {
+		return genErrorResult(fmt.Sprintf("%sの読み込みに失敗しました: %v", projectWordList, err)), nil
+	}
+
+	found := map[string]*misspelling{}
+	var order []string
+	forEachMarkdownLine(string(content), func(line string, number int) {
+		line = inlineCodePattern.ReplaceAllString(line, " ")
+		line = linkTargetPattern.ReplaceAllString(line, "] ")
+		line = urlPattern.ReplaceAllString(line, " ")
+		for _, word := range wordPattern.FindAllString(line, -1) {
+			if !shouldSpellcheck(word) || knownWord(dictionary, word) {
+				continue
+			}
+			key := strings.ToLower(word)
+			m, ok := found[key]
+			if !ok {
+				m = &misspelling{Word: word}
+				found[key] = m
+				order = append(order, key)
+			}
+			if len(m.Lines) == 0 || m.Lines[len(m.Lines)-1] != number {
+				m.Lines = append(m.Lines, number)
+			}
+		}
+	})
+
+	result := SpellcheckResult{Misspellings: []misspelling{}}
+	for _, key := range order {
+		if len(result.Misspellings) >= maxMisspellings {
+			result.Truncated = true
+			break
+		}
+		m := found[key]
+		m.Suggestions = spellingSuggestions(dictionary, key)
+		result.Misspellings = append(result.Misspellings, *m)
+	}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// shouldSpellcheck は検査対象の単語かを返す。略語（全て大文字）やcamelCaseの識別子、1文字の単語は対象外
+func shouldSpellcheck(word string) bool {
+	if len(word) <= 1 {
+		return false
+	}
+	for _, r := range word[1:] {
+		if unicode.IsUpper(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// knownWord は単語（所有格や複数形の語尾を除いた形も含む）が辞書にあるかを返す
+func knownWord(dictionary map[string]bool, word string) bool {
+	lower := strings.ToLower(word)
+	if dictionary[lower] {
+		return true
+	}
+	for _, suffix := range []string{"'s", "s", "es", "ed", "ing"} {
+		if stem, ok := strings.CutSuffix(lower, suffix); ok && dictionary[stem] {
+			return true
+		}
+	}
+	return false
+}
+
+// spellingSuggestions は編集距離が1の辞書の単語を最大3つ返す
+func spellingSuggestions(dictionary map[string]bool, word string) []string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	candidates := map[string]bool{}
+	for i := 0; i <= len(word); i++ {
+		head, tail := word[:i], word[i:]
+		if tail != "" {
+			candidates[head+tail[1:]] = true // 削除
+		}
+		if len(tail) > 1 {
+			candidates[head+tail[1:2]+tail[:1]+tail[2:]] = true // 隣接する文字の入れ替え
+		}
+		for _, c := range letters {
+			if tail != "" {
+				candidates[head+string(c)+tail[1:]] = true // 置換
+			}
+			candidates[head+string(c)+tail] = true // 挿入
+		}
+	}
+
+	var suggestions []string
+	for candidate := range candidates {
+		if candidate != word && dictionary[candidate] {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	sort.Strings(suggestions)
+	if len(suggestions) > 3 {
+		suggestions = suggestions[:3]
+	}
+	return suggestions
+}
+
+// GetSpellcheckTool はspellcheckツールの定義を返す
+func GetSpellcheckTool() ToolDefinition {
+	return ToolDefinition{
+		Schema: newToolSchema(
+			"spellcheck",
+			"Markdownやテキストファイルの英単語を単語リストで検査し、辞書にない単語を行番号と修正候補つきで報告します。コードブロック、インラインコード、URL、略語、camelCaseは除外します。プロジェクト固有の用語は.nebula-wordlistに追加できます",
+			SpellcheckArgs{},
+		),
+		Function: Spellcheck,
+		ReadOnly: true,
+	}
+}
@@ -0,0 +1,188 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	defaultSQLiteRowLimit = 100
+	maxSQLiteRowLimit     = 1000
+)
+
+// QuerySQLiteArgs はquerySQLiteツールの引数を表す構造体
+type QuerySQLiteArgs struct {
+	Path  string `json:"path" description:"SQLiteデータベースファイルのパス"`
+	Query string `json:"query,omitempty" description:"実行するSELECT文（WITHで始まるものも可）。省略するとテーブルとビューのスキーマを返します"`
+	Limit int    `json:"limit,omitempty" description:"返す行数の上限（デフォルトは100、最大1000）"`
+}
+
+// sqliteTable はテーブルやビューの定義
+type sqliteTable struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	SQL  string `json:"sql"`
+}
+
+// QuerySQLiteResult はquerySQLiteツールの結果を表す構造体
+type QuerySQLiteResult struct {
+	Tables    []sqliteTable `json:"tables,omitempty"`
+	Columns   []string      `json:"columns,omitempty"`
+	Rows      [][]any       `json:"rows,omitempty"`
+	Truncated bool          `json:"truncated,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// QuerySQLite はSQLiteファイルを読み取り専用で開き、SELECT文の結果またはスキーマを返す
+func QuerySQLite(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてQuerySQLiteArgsに変換
+	var queryArgs QuerySQLiteArgs
+	if err := json.Unmarshal([]byte(args), &queryArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := QuerySQLiteResult{Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	// 存在しないファイルを開くと空のデータベースが作られてしまうので先に確認する
+	if _, err := os.Stat(queryArgs.Path); err != nil {
+		return genErrorResult(fmt.Sprintf("ファイルが存在しません: %v", err)), nil
+	}
+
+	query := strings.TrimSpace(queryArgs.Query)
+	if query != "" && !isSelectOnly(query) {
+		return genErrorResult("SELECT文（またはWITHで始まるSELECT文）の1文のみ実行できます"), nil
+	}
+
+	db, err := openSQLiteReadOnly(queryArgs.Path)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("データベースを開けませんでした: %v", err)), nil
+	}
+	defer db.Close()
+
+	if query == "" {
+		tables, err := sqliteSchema(db)
+		if err != nil {
+			return genErrorResult(fmt.Sprintf("スキーマの取得に失敗しました: %v", err)), nil
+		}
+		resultJSON, _ := json.Marshal(QuerySQLiteResult{Tables: tables})
+		return string(resultJSON), nil
+	}
+
+	limit := queryArgs.Limit
+	if limit <= 0 {
+		limit = defaultSQLiteRowLimit
+	}
+	limit = min(limit, maxSQLiteRowLimit)
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("クエリの実行に失敗しました: %v", err)), nil
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("カラムの取得に失敗しました: %v", err)), nil
+	}
+
+	result := QuerySQLiteResult{Columns: columns, Rows: [][]any{}}
+	for rows.Next() {
+		if len(result.Rows) >= limit {
+			result.Truncated = true
+			break
+		}
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return genErrorResult(fmt.Sprintf("行の読み込みに失敗しました: %v", err)), nil
+		}
+		// BLOBはJSONでbase64になると読みにくいので、文字列として扱えるものは文字列にする
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return genErrorResult(fmt.Sprintf("クエリの実行に失敗しました: %v", err)), nil
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// openSQLiteReadOnly はデータベースを読み取り専用かつquery_onlyで開き、誤って変更しないようにする
+func openSQLiteReadOnly(path string) (*sql.DB, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	dsn := (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs), RawQuery: "mode=ro&_pragma=query_only(1)"}).String()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// isSelectOnly はクエリがSELECTまたはWITHで始まる1文かどうかを返す
+func isSelectOnly(query string) bool {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	if strings.Contains(query, ";") {
+		return false
+	}
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return false
+	}
+	keyword := strings.ToUpper(fields[0])
+	return keyword == "SELECT" || keyword == "WITH"
+}
+
+// sqliteSchema はテーブルとビューの定義を返す
+func sqliteSchema(db *sql.DB) ([]sqliteTable, error) {
+	rows, err := db.Query(`SELECT name, type, COALESCE(sql, '') FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := []sqliteTable{}
+	for rows.Next() {
+		var table sqliteTable
+		if err := rows.Scan(&table.Name, &table.Type, &table.SQL); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// GetQuerySQLiteTool はquerySQLiteツールの定義を返す
+func GetQuerySQLiteTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("querySQLite", "ローカルのSQLiteファイルを読み取り専用で開き、SELECT文を実行して結果を返します。queryを省略するとスキーマを返します", QuerySQLiteArgs{}),
+		Function: QuerySQLite,
+		ReadOnly: true,
+	}
+}
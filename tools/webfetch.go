@@ -0,0 +1,391 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// defaultWebFetchMaxBytes はwebFetchが一度に返す内容の上限のデフォルト値（バイト）
+	defaultWebFetchMaxBytes = 100 * 1024
+	// maxWebFetchMaxBytes はmaxBytesに指定できる上限
+	maxWebFetchMaxBytes = 1024 * 1024
+	// maxWebFetchBodyBytes はダウンロードするレスポンスの上限
+	maxWebFetchBodyBytes = 10 * 1024 * 1024
+	webFetchTimeout      = 30 * time.Second
+)
+
+// WebFetchArgs はwebFetchツールの引数を表す構造体
+type WebFetchArgs struct {
+	URL      string `json:"url" description:"取得するURL（httpまたはhttps）"`
+	Raw      bool   `json:"raw,omitempty" description:"trueの場合、HTMLをMarkdownに変換せずにそのまま返す（デフォルトはfalse）"`
+	Offset   int    `json:"offset,omitempty" description:"返す内容の開始位置（バイト）。前回の結果が切り詰められた場合に続きを読むために使う"`
+	MaxBytes int    `json:"maxBytes,omitempty" description:"返す内容の上限（バイト）。省略時は102400、最大1048576"`
+}
+
+// WebFetchResult はwebFetchツールの結果を表す構造体
+type WebFetchResult struct {
+	URL         string `json:"url,omitempty"` // リダイレクト後の最終的なURL
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Content     string `json:"content"`
+	TotalBytes  int    `json:"totalBytes,omitempty"` // 変換後の内容全体のサイズ
+	Truncated   bool   `json:"truncated,omitempty"`
+	Note        string `json:"note,omitempty"` // 切り詰めた場合の続きの読み方
+	Error       string `json:"error,omitempty"`
+}
+
+// WebFetch はURLの内容を取得し、HTMLの場合はMarkdownに変換して返す
+func WebFetch(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてWebFetchArgsに変換
+	var webFetchArgs WebFetchArgs
+	if err := json.Unmarshal([]byte(args), &webFetchArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := WebFetchResult{Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	u, err := url.Parse(webFetchArgs.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return genErrorResult(fmt.Sprintf("httpまたはhttpsのURLを指定してください: %s", webFetchArgs.URL)), nil
+	}
+	maxBytes := defaultWebFetchMaxBytes
+	if webFetchArgs.MaxBytes > 0 {
+		maxBytes = min(webFetchArgs.MaxBytes, maxWebFetchMaxBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("リクエストの作成に失敗しました: %v", err)), nil
+	}
+	req.Header.Set("User-Agent", "nebula")
+	req.Header.Set("Accept", "text/html, text/markdown, text/plain, application/json;q=0.9, */*;q=0.5")
+
+	client := newPublicHTTPClient(webFetchTimeout)
+	resp, err := client.Do(req)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("取得に失敗しました: %v", err)), nil
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxWebFetchBodyBytes))
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("レスポンスの読み込みに失敗しました: %v", err)), nil
+	}
+
+	result := WebFetchResult{
+		URL:         resp.Request.URL.String(),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if resp.StatusCode >= 400 {
+		result.Error = fmt.Sprintf("HTTPステータス%dが返されました", resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(result.ContentType)
+	content := string(body)
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		if !webFetchArgs.Raw {
+			result.Title, content = htmlToMarkdown(body, resp.Request.URL)
+		}
+	case strings.HasPrefix(mediaType, "text/") || strings.Contains(mediaType, "json") || strings.Contains(mediaType, "xml") || mediaType == "":
+		if isBinary(body) {
+			return genErrorResult(fmt.Sprintf("バイナリの内容のため返せません（Content-Type: %s）", result.ContentType)), nil
+		}
+	default:
+		return genErrorResult(fmt.Sprintf("テキストではない内容のため返せません（Content-Type: %s）", result.ContentType)), nil
+	}
+
+	// 大きなページで会話が埋まらないよう、offsetからmaxBytes分だけ返す
+	result.TotalBytes = len(content)
+	offset := min(max(webFetchArgs.Offset, 0), len(content))
+	for offset > 0 && offset < len(content) && !utf8.RuneStart(content[offset]) {
+		offset--
+	}
+	end := len(content)
+	if end-offset > maxBytes {
+		end = offset + maxBytes
+		for end > offset && !utf8.RuneStart(content[end]) {
+			end--
+		}
+		result.Truncated = true
+		result.Note = fmt.Sprintf("内容が大きいため（%dバイト）、%dバイト目から%dバイト目までを返しました。続きはoffset=%dを指定して取得してください", len(content), offset, end, end)
+	}
+	result.Content = content[offset:end]
+
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// markdownWriter はHTMLのノードをMarkdownのテキストに変換する
+type markdownWriter struct {
+	base      *url.URL
+	sb        strings.Builder
+	title     string
+	listStack []int // 入れ子のリストごとの番号。順序なしリストは-1
+	pre       bool
+	tableRows int // 現在の表で出力した行数
+}
+
+// skippedElements は内容を出力しない要素
+var skippedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Svg: true,
+	atom.Template: true, atom.Iframe: true, atom.Form: true, atom.Button: true,
+}
+
+// htmlToMarkdown はHTMLをMarkdownに変換し、タイトルと本文を返す
+func htmlToMarkdown(body []byte, base *url.URL) (string, string) {
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return "", string(body)
+	}
+	w := &markdownWriter{base: base}
+	w.walk(doc)
+	return w.title, cleanMarkdown(w.sb.String())
+}
+
+func (w *markdownWriter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+
+	if skippedElements[n.DataAtom] {
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Title:
+		w.title = collapseSpaces(nodeText(n))
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		w.block()
+		w.sb.WriteString(strings.Repeat("#", level) + " " + collapseSpaces(nodeText(n)))
+		w.block()
+	case atom.Table:
+		w.block()
+		w.tableRows = 0
+		w.children(n)
+		w.block()
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Header, atom.Footer, atom.Nav, atom.Aside, atom.Dl:
+		w.block()
+		w.children(n)
+		w.block()
+	case atom.Br:
+		w.sb.WriteString("\n")
+	case atom.Hr:
+		w.block()
+		w.sb.WriteString("---")
+		w.block()
+	case atom.A:
+		text := collapseSpaces(nodeText(n))
+		href := w.resolve(attr(n, "href"))
+		if href == "" || strings.HasPrefix(href, "javascript:") || text == "" {
+			w.children(n)
+		} else {
+			w.sb.WriteString(fmt.Sprintf("[%s](%s)", text, href))
+		}
+	case atom.Img:
+		if alt := attr(n, "alt"); alt != "" {
+			w.sb.WriteString(fmt.Sprintf("![%s](%s)", alt, w.resolve(attr(n, "src"))))
+		}
+	case atom.Strong, atom.B:
+		w.wrap(n, "**")
+	case atom.Em, atom.I:
+		w.wrap(n, "*")
+	case atom.Code:
+		if w.pre {
+			w.children(n)
+		} else {
+			w.wrap(n, "`")
+		}
+	case atom.Pre:
+		w.block()
+		w.sb.WriteString("```\n")
+		w.pre = true
+		w.children(n)
+		w.pre = false
+		w.sb.WriteString("\n```")
+		w.block()
+	case atom.Blockquote:
+		inner := &markdownWriter{base: w.base}
+		inner.children(n)
+		w.block()
+		for _, line := range strings.Split(cleanMarkdown(inner.sb.String()), "\n") {
+			w.sb.WriteString("> " + line + "\n")
+		}
+		w.block()
+	case atom.Ul, atom.Ol:
+		number := -1
+		if n.DataAtom == atom.Ol {
+			number = 1
+		}
+		w.listStack = append(w.listStack, number)
+		w.line()
+		w.children(n)
+		w.listStack = w.listStack[:len(w.listStack)-1]
+		if len(w.listStack) == 0 {
+			w.block()
+		}
+	case atom.Li:
+		w.line()
+		depth := max(len(w.listStack)-1, 0)
+		marker := "- "
+		if len(w.listStack) > 0 && w.listStack[depth] > 0 {
+			marker = fmt.Sprintf("%d. ", w.listStack[depth])
+			w.listStack[depth]++
+		}
+		w.sb.WriteString(strings.Repeat("  ", depth) + marker)
+		w.children(n)
+		w.line()
+	case atom.Tr:
+		w.line()
+		var cells []string
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Td || c.DataAtom == atom.Th {
+				cells = append(cells, strings.ReplaceAll(collapseSpaces(nodeText(c)), "|", `\|`))
+			}
+		}
+		w.sb.WriteString("| " + strings.Join(cells, " | ") + " |")
+		// Markdownの表として読めるよう、最初の行の後に区切りを入れる
+		if w.tableRows == 0 {
+			w.sb.WriteString("\n|" + strings.Repeat(" --- |", len(cells)))
+		}
+		w.tableRows++
+		w.line()
+	case atom.Dt:
+		w.line()
+		w.wrap(n, "**")
+		w.line()
+	case atom.Dd:
+		w.line()
+		w.sb.WriteString(": ")
+		w.children(n)
+		w.line()
+	default:
+		w.children(n)
+	}
+}
+
+func (w *markdownWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.walk(c)
+	}
+}
+
+func (w *markdownWriter) wrap(n *html.Node, marker string) {
+	text := collapseSpaces(nodeText(n))
+	if text != "" {
+		w.sb.WriteString(marker + text + marker)
+	}
+}
+
+func (w *markdownWriter) text(data string) {
+	if w.pre {
+		w.sb.WriteString(data)
+		return
+	}
+	w.sb.WriteString(spacePattern.ReplaceAllString(data, " "))
+}
+
+// block は段落の区切り（空行）を入れる
+func (w *markdownWriter) block() {
+	w.sb.WriteString("\n\n")
+}
+
+// line は行の途中であれば改行する
+func (w *markdownWriter) line() {
+	if s := w.sb.String(); s != "" && !strings.HasSuffix(s, "\n") {
+		w.sb.WriteString("\n")
+	}
+}
+
+// resolve は相対URLをページのURLを基準に絶対URLにする
+func (w *markdownWriter) resolve(ref string) string {
+	if ref == "" || w.base == nil {
+		return ref
+	}
+	u, err := w.base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// nodeText は要素内のテキストを連結して返す
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		if n.Type == html.ElementNode && skippedElements[n.DataAtom] {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+var (
+	spacePattern      = regexp.MustCompile(`\s+`)
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+func collapseSpaces(s string) string {
+	return strings.TrimSpace(spacePattern.ReplaceAllString(s, " "))
+}
+
+// cleanMarkdown は行末の空白と連続する空行を取り除く
+func cleanMarkdown(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// GetWebFetchTool はwebFetchツールの定義を返す
+func GetWebFetchTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("webFetch", "URLの内容を取得して返します。HTMLはMarkdownに変換します。ユーザーが示したドキュメントやIssueのページを読むために使います。大きなページは切り詰められるので、必要に応じてoffsetを指定して続きを取得してください。ローカルホストやプライベートネットワークのアドレスは取得できません", WebFetchArgs{}),
+		Function: WebFetch,
+		ReadOnly: true,
+	}
+}
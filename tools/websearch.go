@@ -0,0 +1,233 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultWebSearchCount は返す検索結果の数のデフォルト値
+	defaultWebSearchCount = 5
+	// maxWebSearchCount はcountに指定できる上限
+	maxWebSearchCount = 20
+	webSearchTimeout  = 15 * time.Second
+)
+
+// webSearchBackend は検索エンジンへの問い合わせ方
+type webSearchBackend struct {
+	provider string
+	baseURL  string
+	apiKey   string
+}
+
+// currentWebSearchBackend は設定された検索エンジン。nilの場合webSearchは使えない
+var currentWebSearchBackend *webSearchBackend
+
+// SetWebSearchBackend はwebSearchツールで使う検索エンジン（bing, brave, searxng）を設定する
+func SetWebSearchBackend(provider, baseURL, apiKey string) {
+	currentWebSearchBackend = &webSearchBackend{provider: provider, baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey}
+}
+
+// WebSearchArgs はwebSearchツールの引数を表す構造体
+type WebSearchArgs struct {
+	Query string `json:"query" description:"検索クエリ（例: ライブラリ名と知りたいこと、エラーメッセージ）"`
+	Count int    `json:"count,omitempty" description:"返す検索結果の数（デフォルトは5、最大20）"`
+}
+
+// webSearchHit は検索結果の1件を表す構造体
+type webSearchHit struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// WebSearchResult はwebSearchツールの結果を表す構造体
+type WebSearchResult struct {
+	Provider string         `json:"provider,omitempty"`
+	Results  []webSearchHit `json:"results"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// WebSearch は設定された検索エンジンでWebを検索し、タイトル・URL・抜粋を返す
+func WebSearch(ctx context.Context, args string) (string, error) {
+	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてWebSearchArgsに変換
+	var webSearchArgs WebSearchArgs
+	if err := json.Unmarshal([]byte(args), &webSearchArgs); err != nil {
+		return "", fmt.Errorf("引数の解析に失敗しました: %v", err)
+	}
+
+	genErrorResult := func(errorMessage string) string {
+		result := WebSearchResult{Results: []webSearchHit{}, Error: errorMessage}
+		resultJSON, _ := json.Marshal(result)
+		return string(resultJSON)
+	}
+
+	backend := currentWebSearchBackend
+	if backend == nil {
+		return genErrorResult("検索エンジンが設定されていません。設定ファイルのweb_searchを設定してください"), nil
+	}
+	if strings.TrimSpace(webSearchArgs.Query) == "" {
+		return genErrorResult("queryを指定してください"), nil
+	}
+	count := defaultWebSearchCount
+	if webSearchArgs.Count > 0 {
+		count = min(webSearchArgs.Count, maxWebSearchCount)
+	}
+
+	hits, err := backend.search(ctx, webSearchArgs.Query, count)
+	if err != nil {
+		return genErrorResult(fmt.Sprintf("検索に失敗しました: %v", err)), nil
+	}
+	if len(hits) > count {
+		hits = hits[:count]
+	}
+
+	result := WebSearchResult{Provider: backend.provider, Results: hits}
+	resultJSON, _ := json.Marshal(result)
+	return string(resultJSON), nil
+}
+
+// search は検索エンジンごとのAPIで検索し、結果を共通の形に変換する
+func (b *webSearchBackend) search(ctx context.Context, query string, count int) ([]webSearchHit, error) {
+	switch b.provider {
+	case "bing":
+		var resp struct {
+			WebPages struct {
+				Value []struct {
+					Name    string `json:"name"`
+					URL     string `json:"url"`
+					Snippet string `json:"snippet"`
+				} `json:"value"`
+			} `json:"webPages"`
+		}
+		endpoint := b.endpoint("https://api.bing.microsoft.com/v7.0/search")
+		params := url.Values{"q": {query}, "count": {strconv.Itoa(count)}}
+		if err := b.get(ctx, endpoint, params, map[string]string{"Ocp-Apim-Subscription-Key": b.apiKey}, &resp); err != nil {
+			return nil, err
+		}
+		hits := []webSearchHit{}
+		for _, v := range resp.WebPages.Value {
+			hits = append(hits, webSearchHit{Title: v.Name, URL: v.URL, Snippet: v.Snippet})
+		}
+		return hits, nil
+	case "brave":
+		var resp struct {
+			Web struct {
+				Results []struct {
+					Title       string `json:"title"`
+					URL         string `json:"url"`
+					Description string `json:"description"`
+				} `json:"results"`
+			} `json:"web"`
+		}
+		endpoint := b.endpoint("https://api.search.brave.com/res/v1/web/search")
+		params := url.Values{"q": {query}, "count": {strconv.Itoa(count)}}
+		if err := b.get(ctx, endpoint, params, map[string]string{"X-Subscription-Token": b.apiKey}, &resp); err != nil {
+			return nil, err
+		}
+		hits := []webSearchHit{}
+		for _, r := range resp.Web.Results {
+			hits = append(hits, webSearchHit{Title: r.Title, URL: r.URL, Snippet: stripHTMLTags(r.Description)})
+		}
+		return hits, nil
+	case "searxng":
+		if b.baseURL == "" {
+			return nil, fmt.Errorf("searxngではweb_search.base_urlを設定してください")
+		}
+		var resp struct {
+			Results []struct {
+				Title   string `json:"title"`
+				URL     string `json:"url"`
+				Content string `json:"content"`
+			} `json:"results"`
+		}
+		// SearXNGのJSON形式の出力は設定（search.formats）で有効にしておく必要がある
+		params := url.Values{"q": {query}, "format": {"json"}}
+		if err := b.get(ctx, b.baseURL+"/search", params, nil, &resp); err != nil {
+			return nil, err
+		}
+		hits := []webSearchHit{}
+		for _, r := range resp.Results {
+			hits = append(hits, webSearchHit{Title: r.Title, URL: r.URL, Snippet: r.Content})
+		}
+		return hits, nil
+	default:
+		return nil, fmt.Errorf("対応していない検索エンジンです: %s（bing, brave, searxngに対応）", b.provider)
+	}
+}
+
+// endpoint はbase_urlが設定されていればそれを、なければ公式のエンドポイントを返す
+func (b *webSearchBackend) endpoint(official string) string {
+	if b.baseURL != "" {
+		return b.baseURL
+	}
+	return official
+}
+
+// get はAPIにGETリクエストを送り、JSONのレスポンスをoutにデコードする
+func (b *webSearchBackend) get(ctx context.Context, endpoint string, params url.Values, headers map[string]string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "nebula")
+	req.Header.Set("Accept", "application/json")
+	for key, value := range headers {
+		if value == "" {
+			return fmt.Errorf("%sのAPIキーが設定されていません。web_search.api_key_envで指定した環境変数に設定してください", b.provider)
+		}
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: webSearchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxWebFetchBodyBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTPステータス%dが返されました: %s", resp.StatusCode, truncateSearchLine(strings.TrimSpace(string(body))))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("レスポンスの解析に失敗しました: %v", err)
+	}
+	return nil
+}
+
+// stripHTMLTags は抜粋に含まれる強調用のタグ（<strong>など）を取り除く
+func stripHTMLTags(s string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// GetWebSearchTool はwebSearchツールの定義を返す
+func GetWebSearchTool() ToolDefinition {
+	return ToolDefinition{
+		Schema:   newToolSchema("webSearch", "Webを検索し、タイトル・URL・抜粋を返します。手元のコードだけでは分からないライブラリのドキュメントやエラーメッセージを調べるときに使います。詳しい内容は結果のURLをwebFetchで取得してください", WebSearchArgs{}),
+		Function: WebSearch,
+		ReadOnly: true,
+	}
+}
@@ -0,0 +1,169 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// extraWritableDirs はプロジェクトのルート以外に書き込みを許可するディレクトリ（絶対パス）
+var extraWritableDirs []string
+
+// SetWritableDirs はプロジェクトのルート（カレントディレクトリ）とスクラッチディレクトリ以外に、
+// ファイルの作成・編集・削除を許可するディレクトリを設定する
+func SetWritableDirs(dirs []string) {
+	extraWritableDirs = nil
+	for _, dir := range dirs {
+		if abs, err := filepath.Abs(normalizePath(dir, false)); err == nil {
+			extraWritableDirs = append(extraWritableDirs, abs)
+		}
+	}
+}
+
+// pathsRestricted はファイルを読むだけのツールも含め、引数のパスをプロジェクトの中に限るかどうか
+var pathsRestricted bool
+
+// deniedDirs はpathsRestrictedのときに、プロジェクトの中などであっても読み書きを拒否するディレクトリ（絶対パス）
+var deniedDirs []string
+
+// RestrictPaths はツールの引数のパスを、プロジェクトのルート（カレントディレクトリ）、スクラッチディレクトリ、
+// SetWritableDirsで許可したディレクトリの中に限る。読み込みだけのツールも対象にし、deniedの下はそれらの中でも拒否する
+// サーバーモードで、サーバーのホストのファイルやnebula自身のデータベース（他のユーザーのセッション）を読めないようにする
+func RestrictPaths(denied []string) {
+	pathsRestricted = true
+	deniedDirs = nil
+	for _, dir := range denied {
+		if abs, err := filepath.Abs(dir); err == nil {
+			deniedDirs = append(deniedDirs, abs)
+		}
+	}
+}
+
+// checkAccessiblePath はRestrictPathsで制限している場合に、パスがツールで扱ってよい場所にあるかを確認する
+func checkAccessiblePath(path string) error {
+	if !pathsRestricted || inScratchDir(path) {
+		return nil
+	}
+	target, err := resolveExistingPath(path)
+	if err != nil {
+		return fmt.Errorf("パスの解決に失敗しました: %v", err)
+	}
+	for _, dir := range deniedDirs {
+		if resolved, err := resolveExistingPath(dir); err == nil && isWithinDir(resolved, target) {
+			return fmt.Errorf("nebulaのデータディレクトリは扱えません: %s", path)
+		}
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("カレントディレクトリの取得に失敗しました: %v", err)
+	}
+	for _, dir := range append([]string{root}, extraWritableDirs...) {
+		if resolved, err := resolveExistingPath(dir); err == nil && isWithinDir(resolved, target) {
+			return nil
+		}
+	}
+	return fmt.Errorf("プロジェクトの外のパスは扱えません: %s（扱えるのは%s以下とスクラッチディレクトリです）", path, root)
+}
+
+// checkArgumentPaths はツールの引数のうちパスを表すものがすべてcheckAccessiblePathを満たすかを確認する
+// gitのfile・filesは引数pathのディレクトリからの相対パスとして確認する
+func checkArgumentPaths(args map[string]any) error {
+	if !pathsRestricted {
+		return nil
+	}
+	base, _ := args["path"].(string)
+	for key, relativize := range pathArguments {
+		var paths []string
+		switch v := args[key].(type) {
+		case string:
+			paths = []string{v}
+		case []any:
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					paths = append(paths, s)
+				}
+			}
+		}
+		for _, path := range paths {
+			if path == "" {
+				continue
+			}
+			if !relativize && base != "" && !filepath.IsAbs(path) {
+				path = filepath.Join(base, path)
+			}
+			if err := checkAccessiblePath(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkWritablePath はパスがファイルを変更してよい場所にあるかを確認する
+// 絶対パスや../でプロジェクトの外を指すパスは、スクラッチディレクトリと設定で許可したディレクトリを除いて拒否する
+// シンボリックリンクで外を指すパスも拒否できるよう、実際のパスで比較する
+func checkWritablePath(path string) error {
+	if inScratchDir(path) {
+		return nil
+	}
+	if err := checkAccessiblePath(path); err != nil {
+		return err
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("カレントディレクトリの取得に失敗しました: %v", err)
+	}
+	target, err := resolveExistingPath(path)
+	if err != nil {
+		return fmt.Errorf("パスの解決に失敗しました: %v", err)
+	}
+
+	for _, dir := range append([]string{root}, extraWritableDirs...) {
+		resolved, err := resolveExistingPath(dir)
+		if err != nil {
+			continue
+		}
+		if isWithinDir(resolved, target) {
+			return nil
+		}
+	}
+	return fmt.Errorf("プロジェクトの外には書き込めません: %s（書き込めるのは%s以下とスクラッチディレクトリです。他のディレクトリは設定ファイルのwritable_dirsで許可できます）", path, root)
+}
+
+// resolveExistingPath はパスを絶対パスにし、存在する部分のシンボリックリンクを解決する
+// まだ存在しないファイルの場合は、存在する最も近い親ディレクトリを解決して残りを連結する
+func resolveExistingPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var rest []string
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) || filepath.Dir(dir) == dir {
+			return abs, nil
+		}
+		rest = append([]string{filepath.Base(dir)}, rest...)
+	}
+}
+
+// foldPathCase はパスを比較するための形にする
+// Windowsのファイルシステムは大文字と小文字を区別しないので小文字にそろえ、それ以外ではそのまま返す
+func foldPathCase(path string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+// isWithinDir はpathがdir自身またはその下にあるかを返す
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
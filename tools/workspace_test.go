@@ -0,0 +1,139 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckWritablePath(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "project")
+	extra := filepath.Join(root, "extra")
+	scratch := filepath.Join(root, "scratch")
+	for _, dir := range []string{filepath.Join(project, "src"), extra, scratch} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(project)
+	SetWritableDirs([]string{extra})
+	SetScratchDir(scratch)
+	t.Cleanup(func() {
+		SetWritableDirs(nil)
+		SetScratchDir("")
+	})
+
+	// シンボリックリンクはWindowsでは権限がないと作れないので、作れた場合だけ確認する
+	symlinks := os.Symlink(root, filepath.Join(project, "outside")) == nil
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+		symlink bool
+	}{
+		{name: "プロジェクト内の既存のディレクトリ", path: filepath.Join("src", "main.go")},
+		{name: "プロジェクト内の新しいディレクトリ", path: filepath.Join("new", "dir", "file.go")},
+		{name: "プロジェクト内の絶対パス", path: filepath.Join(project, "main.go")},
+		{name: "..で始まる名前のファイル", path: "..env"},
+		{name: "プロジェクトの外", path: filepath.Join("..", "other.txt"), wantErr: true},
+		{name: "プロジェクトの外の絶対パス", path: filepath.Join(root, "other.txt"), wantErr: true},
+		{name: "名前が同じ接頭辞の別のディレクトリ", path: filepath.Join(root, "project2", "a.txt"), wantErr: true},
+		{name: "writable_dirsで許可したディレクトリ", path: filepath.Join(extra, "a.txt")},
+		{name: "スクラッチディレクトリ", path: filepath.Join(scratch, "a.txt")},
+		{name: "シンボリックリンクで外を指すパス", path: filepath.Join("outside", "other.txt"), wantErr: true, symlink: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.symlink && !symlinks {
+				t.Skip("シンボリックリンクを作成できません")
+			}
+			err := checkWritablePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkWritablePath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsWithinDir(t *testing.T) {
+	dir := filepath.Join(string(filepath.Separator)+"home", "user", "project")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: dir, want: true},
+		{path: filepath.Join(dir, "main.go"), want: true},
+		{path: filepath.Join(dir, "..env"), want: true},
+		{path: filepath.Join(dir, "..", "other"), want: false},
+		{path: filepath.Dir(dir), want: false},
+		{path: dir + "2", want: false},
+	}
+	for _, tt := range tests {
+		if got := isWithinDir(dir, tt.path); got != tt.want {
+			t.Errorf("isWithinDir(%q, %q) = %v, want %v", dir, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFoldPathCase(t *testing.T) {
+	tests := []struct {
+		path        string
+		want        string
+		wantWindows string
+	}{
+		{path: `C:\Users\Me\Project`, want: `C:\Users\Me\Project`, wantWindows: `c:\users\me\project`},
+		{path: "/Home/User/.SSH", want: "/Home/User/.SSH", wantWindows: "/home/user/.ssh"},
+		{path: "already/lower", want: "already/lower", wantWindows: "already/lower"},
+	}
+	for _, tt := range tests {
+		want := tt.want
+		if runtime.GOOS == "windows" {
+			want = tt.wantWindows
+		}
+		if got := foldPathCase(tt.path); got != want {
+			t.Errorf("foldPathCase(%q) = %q, want %q", tt.path, got, want)
+		}
+	}
+}
+
+// TestCheckWritablePathSeparators はWindowsの\区切りと大文字小文字の違いでサンドボックスを抜けられないことを確認する
+func TestCheckWritablePathSeparators(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "project")
+	if err := os.MkdirAll(project, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(project)
+	windows := runtime.GOOS == "windows"
+
+	tests := []struct {
+		name        string
+		path        string
+		wantErr     bool
+		windowsOnly bool
+	}{
+		{name: "/区切りのプロジェクト内のパス", path: "src/main.go"},
+		// Windowsでは..\で親ディレクトリを指すが、それ以外では..\other.txtという名前のプロジェクト内のファイル
+		{name: "..\\で始まるパス", path: `..\other.txt`, wantErr: windows},
+		{name: "途中の..\\でプロジェクトの外を指すパス", path: `src\..\..\other.txt`, wantErr: windows},
+		{name: "\\区切りのプロジェクト内のパス", path: `src\main.go`},
+		{name: "大文字と小文字が異なるプロジェクト内の絶対パス", path: strings.ToUpper(project) + `\main.go`, windowsOnly: true},
+		{name: "大文字と小文字が異なるプロジェクトの外の絶対パス", path: strings.ToUpper(root) + `\other.txt`, wantErr: true, windowsOnly: true},
+		{name: "/区切りのプロジェクトの外の絶対パス", path: filepath.ToSlash(root) + "/other.txt", wantErr: true, windowsOnly: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.windowsOnly && !windows {
+				t.Skip("Windowsでのみ確認する")
+			}
+			err := checkWritablePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkWritablePath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
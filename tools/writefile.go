@@ -1,31 +1,28 @@
 package tools
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
-
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
 // WriteFileArgs はwriteFileツールの引数を表す構造体
 type WriteFileArgs struct {
-	Path    string `json:"path" description:"書き込むファイルのパス"`
-	Content string `json:"content" description:"書き込む内容"`
+	Path    string `json:"path" description:"作成するファイルの完全なパス"`
+	Content string `json:"content" description:"ファイルに書き込む内容"`
 }
 
 // WriteFileResult はwriteFileツールの結果を表す構造体
 type WriteFileResult struct {
 	Success bool   `json:"success"`
+	Note    string `json:"note,omitempty"`
 	Error   string `json:"error,omitempty"`
 }
 
 // WriteFile は指定されたパスに新しいファイルを作成する（ユーザー許可が必要）
-func WriteFile(args string) (string, error) {
+func WriteFile(ctx context.Context, args string) (string, error) {
 	// argsにはどのツールでもJSONが入ってくるはずなので、JSONをパースしてWriteFileArgsに変換
 	var writeFileArgs WriteFileArgs
 	if err := json.Unmarshal([]byte(args), &writeFileArgs); err != nil {
@@ -41,25 +38,42 @@ func WriteFile(args string) (string, error) {
 		return string(resultJSON)
 	}
 
+	// プロジェクトの外には書き込まない
+	if err := checkWritablePath(writeFileArgs.Path); err != nil {
+		return genErrorResult(err.Error()), nil
+	}
+
 	// 安全性チェック: 既存ファイルの上書きを防止
 	if _, err := os.Stat(writeFileArgs.Path); err == nil {
 		return genErrorResult(fmt.Sprintf("ファイルが既に存在します。既存ファイルの編集にはeditFileを使用してください: %s", writeFileArgs.Path)), nil
 	}
 
-	// ユーザー許可の取得
-	fmt.Printf("\n新しいファイルを作成します: %s\n", writeFileArgs.Path)
-	fmt.Printf("--- 内容 ---\n%s\n\n", writeFileArgs.Content)
-	fmt.Print("実行してもよろしいですか？(y/N): ")
+	request := ApprovalRequest{
+		Tool:     "writeFile",
+		Kind:     ApprovalEdit,
+		Title:    fmt.Sprintf("新しいファイルを作成します: %s", writeFileArgs.Path),
+		Detail:   fmt.Sprintf("--- 内容 ---\n%s", writeFileArgs.Content),
+		Paths:    []string{writeFileArgs.Path},
+		Warnings: writePathWarnings(writeFileArgs.Path),
+	}
 
-	// ユーザー応答を読み取り
-	scanner := bufio.NewScanner(os.Stdin)
-	if !scanner.Scan() {
-		return genErrorResult("ユーザー応答の読み取りに失敗しました"), nil
+	// ドライランでは作成する内容を表示するだけにする
+	if dryRun {
+		showDryRun(request)
+		resultJSON, _ := json.Marshal(WriteFileResult{Success: true, Note: dryRunNote})
+		return string(resultJSON), nil
 	}
-	// yまたはY以外はキャンセル扱い
-	response := strings.TrimSpace(scanner.Text())
-	if response != "y" && response != "Y" {
-		return genErrorResult("ユーザーによってキャンセルされました"), nil
+
+	// スクラッチディレクトリ内のファイルは承認なしで変更できる
+	if !inScratchDir(writeFileArgs.Path) {
+		// ユーザー許可の取得
+		approved, err := requestApproval(request)
+		if err != nil {
+			return genErrorResult(err.Error()), nil
+		}
+		if !approved {
+			return genErrorResult("ユーザーによってキャンセルされました"), nil
+		}
 	}
 
 	// 親ディレクトリの自動作成
@@ -79,6 +93,12 @@ func WriteFile(args string) (string, error) {
 		return genErrorResult(fmt.Sprintf("ファイルへの書き込みに失敗しました: %v", err)), nil
 	}
 
+	notifyFileChange(FileChange{
+		Path:       writeFileArgs.Path,
+		NewContent: &writeFileArgs.Content,
+	})
+	rememberContent(writeFileArgs.Path, writeFileArgs.Content)
+
 	// 成功時の結果を返却
 	result := WriteFileResult{
 		Success: true,
@@ -91,27 +111,7 @@ func WriteFile(args string) (string, error) {
 // GetWriteFileTool はwriteFileツールの定義を返す
 func GetWriteFileTool() ToolDefinition {
 	return ToolDefinition{
-		Schema: openai.Tool{
-			Type: openai.ToolTypeFunction,
-			Function: &openai.FunctionDefinition{
-				Name:        "writeFile",
-				Description: "指定されたパスに新しいファイルを作成し、内容を書き込みます",
-				Parameters: jsonschema.Definition{
-					Type: jsonschema.Object,
-					Properties: map[string]jsonschema.Definition{
-						"path": {
-							Type:        jsonschema.String,
-							Description: "作成するファイルの完全なパス",
-						},
-						"content": {
-							Type:        jsonschema.String,
-							Description: "ファイルに書き込む内容",
-						},
-					},
-					Required: []string{"path", "content"},
-				},
-			},
-		},
+		Schema:   newToolSchema("writeFile", "指定されたパスに新しいファイルを作成し、内容を書き込みます", WriteFileArgs{}),
 		Function: WriteFile,
 	}
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/shibayu36/nebula/tools"
+)
+
+const (
+	toolSelectionAll       = "all"
+	toolSelectionHeuristic = "heuristic"
+
+	requestToolsName = "requestTools"
+)
+
+// writeIntentKeywords はユーザー入力がファイルの変更を求めていそうかを判定するキーワード
+var writeIntentKeywords = []string{
+	"add", "change", "create", "delete", "edit", "fix", "implement", "modify", "refactor",
+	"remove", "rename", "replace", "update", "write", "run", "build", "test",
+	"追加", "変更", "作成", "削除", "編集", "修正", "実装", "書き換", "置き換", "リファクタ", "実行", "ビルド", "テスト",
+}
+
+// turnTools は1ターンの間にモデルへ送るツールのスキーマを管理する
+// 選択から外したツールも、requestToolsで要求されればターンの途中から追加する
+type turnTools struct {
+	schemas []openai.Tool
+	omitted map[string]openai.Tool
+}
+
+// selectTurnTools は設定に応じて、ユーザー入力に関係しそうなツールだけを選ぶ
+func selectTurnTools(selection string, available map[string]tools.ToolDefinition, userInput string) *turnTools {
+	t := &turnTools{omitted: map[string]openai.Tool{}}
+
+	wantsWrite := selection != toolSelectionHeuristic || hasWriteIntent(userInput)
+	for _, name := range sortedToolNames(available) {
+		tool := available[name]
+		// 読み取り専用ツールは調査に常に必要なので必ず含める
+		if tool.ReadOnly || wantsWrite {
+			t.schemas = append(t.schemas, tool.Schema)
+		} else {
+			t.omitted[name] = tool.Schema
+		}
+	}
+
+	if len(t.omitted) > 0 {
+		t.schemas = append(t.schemas, t.requestToolsSchema())
+	}
+	return t
+}
+
+// hasWriteIntent はユーザー入力に変更を求めるキーワードが含まれるかを返す
+func hasWriteIntent(userInput string) bool {
+	lower := strings.ToLower(userInput)
+	for _, keyword := range writeIntentKeywords {
+		if strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestToolsSchema は選択から外したツールを要求するためのメタツールのスキーマを返す
+func (t *turnTools) requestToolsSchema() openai.Tool {
+	var names []string
+	for name := range t.omitted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return openai.Tool{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        requestToolsName,
+			Description: "Make additional tools available for the rest of this turn. Available on request: " + strings.Join(names, ", "),
+			Parameters: jsonschema.Definition{
+				Type: jsonschema.Object,
+				Properties: map[string]jsonschema.Definition{
+					"names": {
+						Type:        jsonschema.Array,
+						Description: "Names of the tools to enable",
+						Items:       &jsonschema.Definition{Type: jsonschema.String},
+					},
+				},
+				Required: []string{"names"},
+			},
+		},
+	}
+}
+
+// Request はrequestToolsの呼び出しを処理し、要求されたツールをスキーマに追加する
+func (t *turnTools) Request(args string) string {
+	var request struct {
+		Names []string `json:"names"`
+	}
+	if err := json.Unmarshal([]byte(args), &request); err != nil {
+		return toolErrorResult(fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	var enabled, unknown []string
+	for _, name := range request.Names {
+		schema, ok := t.omitted[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		t.schemas = append(t.schemas, schema)
+		delete(t.omitted, name)
+		enabled = append(enabled, name)
+	}
+
+	resultJSON, _ := json.Marshal(map[string]any{
+		"enabled": enabled,
+		"unknown": unknown,
+	})
+	return string(resultJSON)
+}
+
+// sortedToolNames はツール名をソートして返す
+func sortedToolNames(available map[string]tools.ToolDefinition) []string {
+	var names []string
+	for name := range available {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
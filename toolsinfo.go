@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/tools"
+)
+
+// enabledTools は登録済みのツールのうち、設定で無効化されていないものを返す
+func enabledTools(cfg *config.Config) map[string]tools.ToolDefinition {
+	enabled := map[string]tools.ToolDefinition{}
+	for name, tool := range tools.GetAvailableTools() {
+		if cfg.ToolEnabled(name) {
+			enabled[name] = tool
+		}
+	}
+	return enabled
+}
+
+// runToolsCommand は登録されている全ツールの説明・引数・承認の要否・有効状態を表示する
+func runToolsCommand(args []string) error {
+	fs := flag.NewFlagSet("tools", flag.ExitOnError)
+	fs.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	loadPlugins(cfg)
+	registered := tools.GetAvailableTools()
+	builtin := tools.GetBuiltinTools()
+	var names []string
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		tool := registered[name]
+
+		status := "enabled"
+		if !cfg.ToolEnabled(name) {
+			status = "disabled (config)"
+		}
+		approval := "no approval"
+		if !tool.ReadOnly {
+			approval = "requires approval"
+		}
+		source := "built-in"
+		if _, ok := builtin[name]; !ok {
+			source = "plugin"
+		}
+		fmt.Printf("%s [%s] %s, %s\n", name, source, status, approval)
+
+		if tool.Schema.Function != nil {
+			fmt.Printf("  %s\n", tool.Schema.Function.Description)
+			if params, ok := tool.Schema.Function.Parameters.(jsonschema.Definition); ok {
+				printToolParameters(params)
+			}
+		}
+
+		var modes []string
+		for _, modeName := range modeNames() {
+			if agentModes[modeName].AllowTool(tool) {
+				modes = append(modes, modeName)
+			}
+		}
+		fmt.Printf("  Modes: %s\n\n", strings.Join(modes, ", "))
+	}
+	return nil
+}
+
+// printToolParameters はツールの引数を名前順に表示する
+func printToolParameters(params jsonschema.Definition) {
+	if len(params.Properties) == 0 {
+		return
+	}
+
+	required := map[string]bool{}
+	for _, name := range params.Required {
+		required[name] = true
+	}
+
+	var names []string
+	for name := range params.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println("  Parameters:")
+	for _, name := range names {
+		prop := params.Properties[name]
+		typeName := string(prop.Type)
+		if prop.Type == jsonschema.Array && prop.Items != nil {
+			typeName = fmt.Sprintf("%s[]", prop.Items.Type)
+		}
+		requirement := "optional"
+		if required[name] {
+			requirement = "required"
+		}
+		fmt.Printf("    %s (%s, %s): %s\n", name, typeName, requirement, prop.Description)
+	}
+}
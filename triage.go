@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shibayu36/nebula/config"
+)
+
+const (
+	// triageMaxInputBytes はモデルに渡すIssueの本文の合計の目安。Issueが多い場合は1件あたりの本文を短くする
+	triageMaxInputBytes = 200 * 1024
+	// triageMaxBodyBytes はIssue1件あたりの本文の最大バイト数
+	triageMaxBodyBytes = 4000
+)
+
+// triageIssue はエクスポートされたIssue1件
+type triageIssue struct {
+	ID     string // Issue番号。番号がない場合はファイル名
+	Title  string
+	Body   string
+	Labels []string
+	URL    string
+}
+
+// triagePromptExtension はIssueのトリアージでのシステムプロンプトへの追記
+const triagePromptExtension = `
+
+# Issue triage
+You are triaging a batch of exported issues against the actual codebase in the current directory. This overrides the Implementation step of the Execution Protocol.
+- Use the read-only tools to find the code each issue is about; cite file paths in the report and never guess them
+- Label each issue (e.g. bug, feature, docs, question, performance, security) and prioritize it: P0 (broken core functionality, security, data loss), P1 (important, should be next), P2 (normal), P3 (nice to have)
+- Detect duplicates and closely related issues, and note issues that appear already fixed or no longer apply to the current code
+- Do NOT modify any files; write tools are unavailable
+Reply with the triage report in markdown only, with these sections:
+1. "## Summary": counts by priority and label, and the most urgent issues
+2. "## Issues": a table with columns Issue, Title, Labels, Priority, Duplicate of, Related code, Notes, sorted by priority
+3. "## Duplicates and related issues": groups of issues that should be merged or linked
+4. "## Possibly resolved": issues that the current code seems to address already, with the evidence`
+
+// runTriage はディレクトリ内のエクスポートされたIssueを読み込み、コードベースと照らし合わせたトリアージのレポートを書き出す
+func runTriage(args []string) error {
+	fs := flag.NewFlagSet("triage", flag.ExitOnError)
+	output := fs.String("o", "triage-report.md", "File to write the triage report to")
+	labels := fs.String("labels", "", "Comma-separated list of labels to choose from (default: let the model choose)")
+	modelFlag := fs.String("model", "", "Model to use (default: config model or "+defaultModel+")")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: nebula triage [flags] <directory of exported issues (.json / .md)>")
+	}
+	issues, err := loadTriageIssues(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(issues) == 0 {
+		return fmt.Errorf("no issues found in %s", fs.Arg(0))
+	}
+	fmt.Printf("Loaded %d issue(s) from %s\n", len(issues), fs.Arg(0))
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	oneShot, err := newOneShotAgent(cfg, resolveModel(*modelFlag, cfg))
+	if err != nil {
+		return err
+	}
+	defer oneShot.close()
+
+	promptExtension := triagePromptExtension
+	if *labels != "" {
+		promptExtension += fmt.Sprintf("\nOnly use these labels: %s", *labels)
+	}
+	report, err := oneShot.run(context.Background(), promptExtension, buildTriageInput(issues))
+	if err != nil {
+		return err
+	}
+
+	report = fmt.Sprintf("# Issue triage report\n\nSource: %s (%d issues)\n\n%s\n", fs.Arg(0), len(issues), strings.TrimSpace(report))
+	if err := os.WriteFile(*output, []byte(report), 0o644); err != nil {
+		return fmt.Errorf("failed to write triage report: %w", err)
+	}
+	fmt.Printf("Wrote triage report to %s\n", *output)
+	return nil
+}
+
+// buildTriageInput はIssueの一覧をモデルへの入力にする。合計が大きくなりすぎないよう本文を切り詰める
+func buildTriageInput(issues []triageIssue) string {
+	bodyLimit := min(triageMaxBodyBytes, triageMaxInputBytes/len(issues))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Triage these %d issues.\n", len(issues))
+	for _, issue := range issues {
+		fmt.Fprintf(&b, "\n### Issue %s: %s\n", issue.ID, issue.Title)
+		if len(issue.Labels) > 0 {
+			fmt.Fprintf(&b, "Existing labels: %s\n", strings.Join(issue.Labels, ", "))
+		}
+		if issue.URL != "" {
+			fmt.Fprintf(&b, "URL: %s\n", issue.URL)
+		}
+		body := strings.TrimSpace(issue.Body)
+		if len(body) > bodyLimit {
+			body = strings.ToValidUTF8(body[:bodyLimit], "") + "\n...(truncated)"
+		}
+		if body != "" {
+			fmt.Fprintf(&b, "\n%s\n", body)
+		}
+	}
+	return b.String()
+}
+
+// loadTriageIssues はディレクトリ内の.json（Issue1件または配列）と.md（1ファイル1件）を読み込む
+func loadTriageIssues(dir string) ([]triageIssue, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read issue directory: %w", err)
+	}
+
+	var issues []triageIssue
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		stem := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".json":
+			loaded, err := loadJSONIssues(path, stem)
+			if err != nil {
+				return nil, err
+			}
+			issues = append(issues, loaded...)
+		case ".md", ".markdown":
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			issues = append(issues, parseMarkdownIssue(string(content), stem))
+		}
+	}
+
+	// 番号順に並べる。番号のないものは名前順で後ろに置く
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, aErr := strconv.Atoi(issues[i].ID)
+		b, bErr := strconv.Atoi(issues[j].ID)
+		switch {
+		case aErr == nil && bErr == nil:
+			return a < b
+		case aErr == nil || bErr == nil:
+			return aErr == nil
+		default:
+			return issues[i].ID < issues[j].ID
+		}
+	})
+	return issues, nil
+}
+
+// jsonIssue はGitHubのAPIや`gh issue list --json`の形式のIssue
+type jsonIssue struct {
+	Number  int               `json:"number"`
+	ID      json.RawMessage   `json:"id"`
+	Title   string            `json:"title"`
+	Body    string            `json:"body"`
+	Labels  []json.RawMessage `json:"labels"` // 文字列または{"name": ...}
+	URL     string            `json:"url"`
+	HTMLURL string            `json:"html_url"`
+}
+
+// loadJSONIssues はIssue1件のオブジェクトまたはIssueの配列を読み込む
+func loadJSONIssues(path, stem string) ([]triageIssue, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var raw []jsonIssue
+	if trimmed := strings.TrimSpace(string(content)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(content, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	} else {
+		var single jsonIssue
+		if err := json.Unmarshal(content, &single); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		raw = []jsonIssue{single}
+	}
+
+	issues := make([]triageIssue, 0, len(raw))
+	for i, r := range raw {
+		issue := triageIssue{Title: r.Title, Body: r.Body, URL: r.HTMLURL}
+		if issue.URL == "" {
+			issue.URL = r.URL
+		}
+		switch {
+		case r.Number != 0:
+			issue.ID = strconv.Itoa(r.Number)
+		case len(r.ID) > 0:
+			issue.ID = strings.Trim(string(r.ID), `"`)
+		case len(raw) == 1:
+			issue.ID = stem
+		default:
+			issue.ID = fmt.Sprintf("%s-%d", stem, i+1)
+		}
+		for _, label := range r.Labels {
+			var name string
+			if json.Unmarshal(label, &name) != nil {
+				var object struct {
+					Name string `json:"name"`
+				}
+				json.Unmarshal(label, &object)
+				name = object.Name
+			}
+			if name != "" {
+				issue.Labels = append(issue.Labels, name)
+			}
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// parseMarkdownIssue はMarkdownのIssueを読み込む。最初の"# "見出しをタイトルとし、なければファイル名を使う
+func parseMarkdownIssue(content, stem string) triageIssue {
+	issue := triageIssue{ID: stem, Title: stem, Body: content}
+	for i, line := range strings.Split(content, "\n") {
+		if title, ok := strings.CutPrefix(line, "# "); ok {
+			issue.Title = strings.TrimSpace(title)
+			lines := strings.Split(content, "\n")
+			issue.Body = strings.Join(append(lines[:i:i], lines[i+1:]...), "\n")
+			break
+		}
+		if strings.TrimSpace(line) != "" {
+			break
+		}
+	}
+	return issue
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shibayu36/nebula/tools"
+)
+
+// turnSnapshot は書き込み系ツールを使う前の作業ツリーの状態
+type turnSnapshot struct {
+	label string
+	// gitリポジトリの場合、作業ツリー全体（無視されたファイルを除く）を記録したツリー
+	tree string
+	// gitリポジトリでない場合、このターンで変更されたファイルの変更前の状態（作成されたファイルはnil）
+	files map[string]*snapshotFile
+}
+
+// snapshotFile はgitリポジトリでない場合に記録する、変更前のファイルの内容とパーミッション
+type snapshotFile struct {
+	content string
+	mode    os.FileMode
+}
+
+// turnSnapshotter は危険なターンの前に作業ツリーを記録し、/restoreで元に戻せるようにする
+type turnSnapshotter struct {
+	repoRoot  string // gitリポジトリのルート。gitリポジトリでない場合は空
+	snapshots []*turnSnapshot
+}
+
+// newTurnSnapshotter はカレントディレクトリがgitリポジトリかどうかに応じたスナップショットの方式を選ぶ
+func newTurnSnapshotter() *turnSnapshotter {
+	repoRoot, err := runGit(nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		repoRoot = ""
+	}
+	return &turnSnapshotter{repoRoot: repoRoot}
+}
+
+// Take はターン中の最初の書き込みの前に作業ツリーの状態を記録する
+func (s *turnSnapshotter) Take(label string) error {
+	snapshot := &turnSnapshot{label: label}
+	if s.repoRoot != "" {
+		tree, err := s.writeWorkingTree()
+		if err != nil {
+			return fmt.Errorf("failed to snapshot working tree: %w", err)
+		}
+		snapshot.tree = tree
+	} else {
+		snapshot.files = map[string]*snapshotFile{}
+	}
+	s.snapshots = append(s.snapshots, snapshot)
+	return nil
+}
+
+// Record はgitリポジトリでない場合に、ツールが変更したファイルの変更前の内容とパーミッションを最新のスナップショットに記録する
+func (s *turnSnapshotter) Record(change tools.FileChange) {
+	if s.repoRoot != "" || len(s.snapshots) == 0 {
+		return
+	}
+	path, err := filepath.Abs(change.Path)
+	if err != nil {
+		path = change.Path
+	}
+	files := s.snapshots[len(s.snapshots)-1].files
+	if _, ok := files[path]; ok {
+		return
+	}
+	if change.OldContent == nil {
+		files[path] = nil
+		return
+	}
+	// 実行ビットなどを失わないよう、パーミッションも記録する。わからない場合は通常のファイルとして戻す
+	mode := change.OldMode
+	if mode == 0 {
+		mode = 0644
+	}
+	files[path] = &snapshotFile{content: *change.OldContent, mode: mode}
+}
+
+// Restore は最新のスナップショットの状態に作業ツリーを戻す。ユーザーが確認しなかった場合は何もしない
+func (s *turnSnapshotter) Restore() error {
+	if len(s.snapshots) == 0 {
+		return fmt.Errorf("no snapshot to restore")
+	}
+	snapshot := s.snapshots[len(s.snapshots)-1]
+
+	var restore func() error
+	var paths []string
+	if s.repoRoot != "" {
+		changes, err := s.changesSince(snapshot.tree)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			paths = append(paths, change.path)
+		}
+		restore = func() error { return s.restoreTree(snapshot.tree, changes) }
+	} else {
+		for path := range snapshot.files {
+			paths = append(paths, path)
+		}
+		restore = func() error { return restoreFiles(snapshot.files) }
+	}
+
+	if len(paths) == 0 {
+		fmt.Println("No changes since the snapshot.")
+		s.snapshots = s.snapshots[:len(s.snapshots)-1]
+		return nil
+	}
+
+	fmt.Printf("Restoring the working tree to before: %s\n", snapshot.label)
+	for _, path := range paths {
+		fmt.Printf("  %s\n", path)
+	}
+	if !confirm("Restore these files? (y/N): ") {
+		return nil
+	}
+	if err := restore(); err != nil {
+		return err
+	}
+	s.snapshots = s.snapshots[:len(s.snapshots)-1]
+	fmt.Printf("Restored %d file(s).\n", len(paths))
+	return nil
+}
+
+// writeWorkingTree はユーザーのインデックスに触れずに、作業ツリー全体をツリーオブジェクトとして書き出す
+func (s *turnSnapshotter) writeWorkingTree() (string, error) {
+	indexDir, err := os.MkdirTemp("", "nebula-index-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary index: %w", err)
+	}
+	defer os.RemoveAll(indexDir)
+	indexPath := filepath.Join(indexDir, "index")
+	env := []string{"GIT_INDEX_FILE=" + indexPath}
+
+	// ユーザーのインデックスのコピーから始めると、git add -Aは更新日時の変わったファイルだけを読み直せばよい
+	// コピーできない場合はHEADから始め、すべてのファイルを読み直す
+	if err := s.copyIndex(indexPath); err != nil {
+		if _, err := runGit(nil, "-C", s.repoRoot, "rev-parse", "--verify", "-q", "HEAD"); err == nil {
+			if _, err := runGit(env, "-C", s.repoRoot, "read-tree", "HEAD"); err != nil {
+				return "", err
+			}
+		}
+	}
+	if _, err := runGit(env, "-C", s.repoRoot, "add", "-A"); err != nil {
+		return "", err
+	}
+	return runGit(env, "-C", s.repoRoot, "write-tree")
+}
+
+// copyIndex はユーザーのインデックスをpathにコピーする
+func (s *turnSnapshotter) copyIndex(path string) error {
+	indexPath, err := runGit(nil, "-C", s.repoRoot, "rev-parse", "--git-path", "index")
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(indexPath) {
+		indexPath = filepath.Join(s.repoRoot, indexPath)
+	}
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// treeChange はスナップショット以降に変化したファイル
+type treeChange struct {
+	status string // A: スナップショット後に作成, D: 削除, M: 変更
+	path   string // リポジトリのルートからの相対パス
+}
+
+// changesSince はスナップショットのツリーと現在の作業ツリーの差分を返す
+func (s *turnSnapshotter) changesSince(tree string) ([]treeChange, error) {
+	current, err := s.writeWorkingTree()
+	if err != nil {
+		return nil, err
+	}
+	// -zを指定し、特殊文字を含むパスがクォートされずにそのまま出力されるようにする
+	output, err := runGit(nil, "-C", s.repoRoot, "diff-tree", "-r", "--no-renames", "--name-status", "-z", tree, current)
+	if err != nil {
+		return nil, err
+	}
+
+	// 出力はステータスとパスがNUL区切りで交互に並ぶ
+	fields := strings.Split(strings.TrimSuffix(output, "\x00"), "\x00")
+	var changes []treeChange
+	for i := 0; i+1 < len(fields); i += 2 {
+		changes = append(changes, treeChange{status: fields[i], path: fields[i+1]})
+	}
+	return changes, nil
+}
+
+// restoreTree は差分のあるファイルをスナップショットの内容に戻し、スナップショット後に作成されたファイルを削除する
+func (s *turnSnapshotter) restoreTree(tree string, changes []treeChange) error {
+	var restorePaths []string
+	for _, change := range changes {
+		if change.status == "A" {
+			if err := os.Remove(filepath.Join(s.repoRoot, change.path)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", change.path, err)
+			}
+			continue
+		}
+		restorePaths = append(restorePaths, change.path)
+	}
+	if len(restorePaths) == 0 {
+		return nil
+	}
+
+	// --worktreeのみを指定し、ユーザーのインデックスは変更しない
+	args := append([]string{"-C", s.repoRoot, "restore", "--source=" + tree, "--worktree", "--"}, restorePaths...)
+	_, err := runGit(nil, args...)
+	return err
+}
+
+// restoreFiles はファイルを記録した内容とパーミッションに戻す。記録がnilのファイルはターン中に作成されたものなので削除する
+func restoreFiles(files map[string]*snapshotFile) error {
+	for path, file := range files {
+		if file == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		if err := os.WriteFile(path, []byte(file.content), file.mode); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		// 既存のファイルに書き込んだ場合はパーミッションが変わらないので、記録したものに戻す
+		if err := os.Chmod(path, file.mode); err != nil {
+			return fmt.Errorf("failed to restore the permissions of %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/shibayu36/nebula/tools"
+)
+
+// TestRestoreFiles はgitリポジトリでない場合に、変更前の内容とパーミッションに戻すことを確認する
+func TestRestoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "run.sh")
+	deleted := filepath.Join(dir, "deleted.sh")
+	created := filepath.Join(dir, "created.txt")
+
+	s := &turnSnapshotter{}
+	if err := s.Take("test"); err != nil {
+		t.Fatal(err)
+	}
+	original := "#!/bin/sh\necho hello\n"
+	s.Record(tools.FileChange{Path: script, OldContent: &original, OldMode: 0o755})
+	s.Record(tools.FileChange{Path: deleted, OldContent: &original, OldMode: 0o755})
+	s.Record(tools.FileChange{Path: created})
+	// 同じファイルの2回目以降の変更では最初の状態を残す
+	changed := "changed"
+	s.Record(tools.FileChange{Path: script, OldContent: &changed, OldMode: 0o644})
+
+	// ターン中の変更後の状態
+	if err := os.WriteFile(script, []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(created, []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreFiles(s.snapshots[0].files); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{script, deleted} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != original {
+			t.Errorf("%s = %q, want %q", filepath.Base(path), content, original)
+		}
+		// Windowsには実行ビットがない
+		if runtime.GOOS == "windows" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o755 {
+			t.Errorf("%s mode = %v, want %v", filepath.Base(path), info.Mode().Perm(), os.FileMode(0o755))
+		}
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("created.txt still exists: %v", err)
+	}
+}
+
+// TestTurnSnapshotterGit はgitリポジトリの場合に、スナップショット後の変更を検出して元に戻せることを確認する
+func TestTurnSnapshotterGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("gitがありません")
+	}
+	repo := t.TempDir()
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	files := map[string]string{"committed.txt": "committed\n", "uncommitted.txt": "uncommitted\n"}
+	if err := os.WriteFile(filepath.Join(repo, "committed.txt"), []byte(files["committed.txt"]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-C", repo, "init", "-q"},
+		{"-C", repo, "add", "-A"},
+		{"-C", repo, "commit", "-q", "-m", "initial"},
+	} {
+		if _, err := runGit(nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// コミットしていない変更もスナップショットに含める
+	if err := os.WriteFile(filepath.Join(repo, "uncommitted.txt"), []byte(files["uncommitted.txt"]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &turnSnapshotter{repoRoot: repo}
+	if err := s.Take("test"); err != nil {
+		t.Fatal(err)
+	}
+	for name := range files {
+		if err := os.WriteFile(filepath.Join(repo, name), []byte("changed\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(repo, "created.txt"), []byte("new\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := s.snapshots[0].tree
+	changes, err := s.changesSince(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"committed.txt": "M", "uncommitted.txt": "M", "created.txt": "A"}
+	if len(changes) != len(want) {
+		t.Fatalf("changesSince() = %+v, want %v", changes, want)
+	}
+	for _, change := range changes {
+		if want[change.path] != change.status {
+			t.Errorf("change %s status = %q, want %q", change.path, change.status, want[change.path])
+		}
+	}
+
+	if err := s.restoreTree(tree, changes); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(repo, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(repo, "created.txt")); !os.IsNotExist(err) {
+		t.Errorf("created.txt still exists: %v", err)
+	}
+	// ユーザーのインデックスは変更しない
+	if status, err := runGit(nil, "-C", repo, "status", "--porcelain"); err != nil || status != "?? uncommitted.txt" {
+		t.Errorf("git status = %q, %v, want only the untracked file", status, err)
+	}
+}
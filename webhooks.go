@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/shibayu36/nebula/config"
+	"github.com/shibayu36/nebula/memory"
+	"github.com/shibayu36/nebula/tools"
+)
+
+const (
+	// defaultWebhookTimeout は1回の送信の時間の上限のデフォルト
+	defaultWebhookTimeout = 10 * time.Second
+	// webhookDrainTimeout は終了時に送信中の通知を待つ時間の上限
+	webhookDrainTimeout = 5 * time.Second
+	// webhookQueueSize は送信を待たせておける通知の数
+	webhookQueueSize = 100
+	// webhookContentMaxBytes はturn.completeに含める本文の最大バイト数
+	webhookContentMaxBytes = 4000
+)
+
+// webhookPayload はWebhookにPOSTするJSON
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	SessionID string    `json:"session_id"`
+	Project   string    `json:"project"`
+	Text      string    `json:"text"` // Slackなどにそのまま表示できる要約
+	Data      any       `json:"data"`
+}
+
+// turnSummary はturn.completeのdata
+type turnSummary struct {
+	Status        string   `json:"status"` // completed, interrupted, error
+	Error         string   `json:"error,omitempty"`
+	DurationMs    int64    `json:"duration_ms"`
+	ToolCalls     []string `json:"tool_calls"`
+	EstimatedCost float64  `json:"estimated_cost"`
+	Input         string   `json:"input,omitempty"`    // include_contentの場合だけ
+	Response      string   `json:"response,omitempty"` // include_contentの場合だけ
+}
+
+// webhookNotifier はセッションのイベントを設定されたWebhookに送る
+// 受け取る側で順序が入れ替わらないよう、1つのgoroutineが発生順に送る
+// 送信はエージェントを待たせないようバックグラウンドで行い、失敗しても警告を表示するだけにする
+type webhookNotifier struct {
+	hooks   []config.Webhook
+	client  *http.Client
+	manager *memory.Manager
+	queue   chan webhookDelivery
+	done    chan struct{}
+	mu      sync.Mutex
+	closed  bool
+}
+
+// webhookDelivery は送信を待っている通知
+type webhookDelivery struct {
+	hook  config.Webhook
+	event string
+	body  []byte
+}
+
+// newWebhookNotifier はWebhookが設定されていなければnilを返す
+func newWebhookNotifier(hooks []config.Webhook, manager *memory.Manager) *webhookNotifier {
+	if len(hooks) == 0 {
+		return nil
+	}
+	n := &webhookNotifier{
+		hooks:   hooks,
+		client:  &http.Client{},
+		manager: manager,
+		queue:   make(chan webhookDelivery, webhookQueueSize),
+		done:    make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// run はキューの通知を順に送る
+func (n *webhookNotifier) run() {
+	defer close(n.done)
+	for delivery := range n.queue {
+		if err := n.deliver(delivery.hook, delivery.body); err != nil {
+			fmt.Printf("Warning: failed to send %s webhook: %v\n", delivery.event, err)
+		}
+	}
+}
+
+// sessionStarted はsession.startを送る
+func (n *webhookNotifier) sessionStarted(resumed bool) {
+	session := n.manager.GetCurrentSession()
+	verb := "Started"
+	if resumed {
+		verb = "Resumed"
+	}
+	n.send(config.WebhookSessionStart, fmt.Sprintf("%s session %s in %s (model: %s)", verb, session.ID, session.ProjectPath, session.ModelUsed), map[string]any{
+		"model":     session.ModelUsed,
+		"branch":    session.Branch,
+		"resumed":   resumed,
+		"incognito": session.Incognito,
+	})
+}
+
+// sessionEnded はsession.endを送り、送信中の通知を待つ。セッションを終了する前に呼ぶ
+func (n *webhookNotifier) sessionEnded() {
+	session := n.manager.GetCurrentSession()
+	data := map[string]any{
+		"duration_ms":    time.Since(session.StartedAt).Milliseconds(),
+		"estimated_cost": session.EstimatedCost,
+	}
+	if usage, err := n.manager.GetSessionUsage(session.ID); err == nil {
+		data["usage"] = usage
+		data["total_tokens"] = usage.TotalTokens()
+	}
+	n.send(config.WebhookSessionEnd, fmt.Sprintf("Ended session %s in %s (estimated cost: $%.4f)", session.ID, session.ProjectPath, session.EstimatedCost), data)
+	n.wait()
+}
+
+// turnCompleted はturn.completeを送る。messagesはターンで追加されたメッセージ
+func (n *webhookNotifier) turnCompleted(input string, messages []openai.ChatCompletionMessage, started time.Time, cost float64, turnErr error) {
+	summary := turnSummary{
+		Status:        "completed",
+		DurationMs:    time.Since(started).Milliseconds(),
+		ToolCalls:     []string{},
+		EstimatedCost: cost,
+	}
+	switch {
+	case errors.Is(turnErr, errTurnInterrupted):
+		summary.Status = "interrupted"
+	case turnErr != nil:
+		summary.Status = "error"
+		summary.Error = turnErr.Error()
+	}
+	var response string
+	for _, msg := range messages {
+		if msg.Role != openai.ChatMessageRoleAssistant {
+			continue
+		}
+		for _, call := range msg.ToolCalls {
+			summary.ToolCalls = append(summary.ToolCalls, call.Function.Name)
+		}
+		if strings.TrimSpace(msg.Content) != "" {
+			response = msg.Content
+		}
+	}
+
+	session := n.manager.GetCurrentSession()
+	text := fmt.Sprintf("Turn %s in session %s (%d tool calls, estimated cost: $%.4f)", summary.Status, session.ID, len(summary.ToolCalls), cost)
+	for _, hook := range n.hooks {
+		if !hook.Wants(config.WebhookTurnComplete) {
+			continue
+		}
+		data := summary
+		if hook.IncludeContent && !session.Incognito {
+			data.Input = truncateWebhookContent(input, webhookContentMaxBytes)
+			data.Response = truncateWebhookContent(response, webhookContentMaxBytes)
+		}
+		n.post(hook, n.payload(config.WebhookTurnComplete, text, data))
+	}
+}
+
+// approvalDecided はapprovalを送る
+func (n *webhookNotifier) approvalDecided(request tools.ApprovalRequest, approved bool) {
+	decision := "Denied"
+	if approved {
+		decision = "Approved"
+	}
+	n.send(config.WebhookApproval, fmt.Sprintf("%s: %s", decision, request.Title), map[string]any{
+		"tool":     request.Tool,
+		"kind":     string(request.Kind),
+		"title":    request.Title,
+		"paths":    request.Paths,
+		"warnings": request.Warnings,
+		"approved": approved,
+	})
+}
+
+// send はeventを通知する設定のWebhookすべてにペイロードを送る
+func (n *webhookNotifier) send(event, text string, data any) {
+	payload := n.payload(event, text, data)
+	for _, hook := range n.hooks {
+		if hook.Wants(event) {
+			n.post(hook, payload)
+		}
+	}
+}
+
+func (n *webhookNotifier) payload(event, text string, data any) webhookPayload {
+	payload := webhookPayload{Event: event, Timestamp: time.Now().UTC(), Text: text, Data: data}
+	if session := n.manager.GetCurrentSession(); session != nil {
+		payload.SessionID = session.ID
+		payload.Project = session.ProjectPath
+	}
+	return payload
+}
+
+// post はペイロードを送信のキューに入れる
+func (n *webhookNotifier) post(hook config.Webhook, payload webhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("Warning: failed to encode webhook payload: %v\n", err)
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.queue <- webhookDelivery{hook: hook, event: payload.Event, body: body}:
+	default:
+		// 通知先が応答しない間にエージェントを止めないよう、溢れた通知は捨てる
+		fmt.Printf("Warning: dropped %s webhook because too many are waiting to be sent\n", payload.Event)
+	}
+}
+
+func (n *webhookNotifier) deliver(hook config.Webhook, body []byte) error {
+	timeout := defaultWebhookTimeout
+	if hook.TimeoutSeconds > 0 {
+		timeout = time.Duration(hook.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nebula")
+	if hook.SecretEnv != "" {
+		secret := os.Getenv(hook.SecretEnv)
+		if secret == "" {
+			return fmt.Errorf("%s is not set, so the payload cannot be signed", hook.SecretEnv)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Nebula-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s returned %s", hook.URL, resp.Status)
+	}
+	return nil
+}
+
+// wait は新しい通知を受け付けるのをやめ、送信を待っている通知が終わるのをwebhookDrainTimeoutまで待つ
+func (n *webhookNotifier) wait() {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	select {
+	case <-n.done:
+	case <-time.After(webhookDrainTimeout):
+		fmt.Println("Warning: gave up waiting for webhooks to be delivered")
+	}
+}
+
+// truncateWebhookContent はsをmaxBytes以内に文字の境界で切り詰める
+func truncateWebhookContent(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxBytes], "") + "..."
+}
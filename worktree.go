@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// worktree はセッション中の編集やコマンドを隔離するための一時的なgit worktree
+type worktree struct {
+	repoRoot string // 元のリポジトリのルート
+	dir      string // worktreeのルート
+	origDir  string // worktree作成前のカレントディレクトリ
+	workDir  string // worktree内で元のカレントディレクトリに対応するディレクトリ
+}
+
+// newWorktree は現在のHEADから一時的なworktreeを作成し、カレントディレクトリをその中に移す
+func newWorktree() (*worktree, error) {
+	origDir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+	repoRoot, err := runGit(nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("--worktree requires a git repository: %w", err)
+	}
+	prefix, err := runGit(nil, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+
+	if status, err := runGit(nil, "status", "--porcelain"); err == nil && status != "" {
+		fmt.Println("Warning: uncommitted changes in the current checkout are not included in the worktree")
+	}
+
+	dir, err := os.MkdirTemp("", "nebula-worktree-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create worktree directory: %w", err)
+	}
+	// macOSなどで一時ディレクトリがシンボリックリンクの場合でもパスの変換が効くよう実体のパスを使う
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if _, err := runGit(nil, "worktree", "add", "--detach", dir, "HEAD"); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	w := &worktree{
+		repoRoot: repoRoot,
+		dir:      dir,
+		origDir:  origDir,
+		workDir:  filepath.Join(dir, prefix),
+	}
+	if err := os.Chdir(w.workDir); err != nil {
+		w.remove()
+		return nil, fmt.Errorf("failed to enter worktree: %w", err)
+	}
+	return w, nil
+}
+
+// promptExtension はworktreeで作業していることをモデルに伝えるシステムプロンプトの追記
+func (w *worktree) promptExtension() string {
+	return fmt.Sprintf(`
+
+# Isolated worktree
+You are working in an isolated git worktree at %s, created from the current HEAD of %s.
+All file changes and commands must happen inside the worktree: use relative paths and never modify files under the original repository.
+The user reviews the aggregate diff and decides whether to merge it back at the end of the session.`, w.dir, w.repoRoot)
+}
+
+// originalPath はworktree内のパスを元のリポジトリ内の対応するパスに変換する
+func (w *worktree) originalPath(path string) string {
+	rel, err := filepath.Rel(w.dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.Join(w.repoRoot, rel)
+}
+
+// diff はworktreeでの変更全体をgit applyできるパッチとして返す
+func (w *worktree) diff() (string, error) {
+	if _, err := runGit(nil, "-C", w.dir, "add", "-A"); err != nil {
+		return "", err
+	}
+	// 末尾の空白だけのコンテキスト行を失うとパッチが壊れるので、出力はそのまま使う
+	return runGitRaw(nil, "-C", w.dir, "diff", "--cached", "--binary", "HEAD")
+}
+
+// finish は変更の差分を表示し、承認されれば元のリポジトリに適用してworktreeを削除する
+func (w *worktree) finish() error {
+	if err := os.Chdir(w.origDir); err != nil {
+		return fmt.Errorf("failed to leave worktree: %w", err)
+	}
+
+	patch, err := w.diff()
+	if err != nil {
+		return fmt.Errorf("failed to compute worktree diff: %w", err)
+	}
+	if patch == "" {
+		fmt.Println("No changes were made in the worktree.")
+		return w.remove()
+	}
+
+	if stat, err := runGit(nil, "-C", w.dir, "diff", "--cached", "--stat", "HEAD"); err == nil {
+		fmt.Println("Changes made in the worktree:")
+		fmt.Println(stat)
+	}
+	fmt.Print(patch)
+
+	if confirm(fmt.Sprintf("Apply these changes to %s? (y/N): ", w.repoRoot)) {
+		if err := w.apply(patch); err != nil {
+			fmt.Printf("Failed to apply the changes: %v\n", err)
+			fmt.Printf("The worktree is kept at %s so the changes are not lost.\n", w.dir)
+			return nil
+		}
+		fmt.Println("Applied the changes.")
+		return w.remove()
+	}
+
+	if confirm("Discard the worktree and its changes? (y/N): ") {
+		return w.remove()
+	}
+	fmt.Printf("The worktree is kept at %s. Remove it later with: git worktree remove --force %s\n", w.dir, w.dir)
+	return nil
+}
+
+// apply はパッチを元のリポジトリの作業ツリーに適用する
+func (w *worktree) apply(patch string) error {
+	file, err := os.CreateTemp("", "nebula-worktree-*.patch")
+	if err != nil {
+		return fmt.Errorf("failed to create patch file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(patch); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write patch file: %w", err)
+	}
+	file.Close()
+
+	_, err = runGit(nil, "-C", w.repoRoot, "apply", "--binary", file.Name())
+	return err
+}
+
+// remove はworktreeを削除する
+func (w *worktree) remove() error {
+	if _, err := runGit(nil, "-C", w.repoRoot, "worktree", "remove", "--force", w.dir); err != nil {
+		return fmt.Errorf("failed to remove worktree: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestWorktreeDiffApply はworktreeの差分を元のリポジトリにそのまま適用できることを確認する
+func TestWorktreeDiffApply(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("gitがありません")
+	}
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	dir := filepath.Join(root, "worktree")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	// 末尾が空白だけの行で終わるファイルは、差分の最後のコンテキスト行が" "になる
+	original := "first\nsecond\nthird\n \n"
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "a.txt"), []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-C", repo, "init", "-q"},
+		{"-C", repo, "add", "-A"},
+		{"-C", repo, "commit", "-q", "-m", "initial"},
+		{"-C", repo, "worktree", "add", "-q", "--detach", dir, "HEAD"},
+	} {
+		if _, err := runGit(nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed := "first\nsecond changed\nthird\n \n"
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := &worktree{repoRoot: repo, dir: dir}
+	patch, err := w.diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.apply(patch); err != nil {
+		t.Fatalf("apply() returned error: %v\npatch:\n%s", err, patch)
+	}
+	got, err := os.ReadFile(filepath.Join(repo, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != changed {
+		t.Errorf("a.txt = %q, want %q", got, changed)
+	}
+}